@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/config"
+)
+
+const usage = `usage:
+  audit verify [-from <id>] [-checkpoints <file>] [-pubkey <hex> | -key <seed file>]
+  audit keygen -out <seed file>
+  audit archive
+  audit verify-archive -manifest <manifest file>`
+
+// offline tooling for the audit log, exits non-zero when the chain is broken
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal(usage)
+	}
+
+	switch os.Args[1] {
+	case "verify":
+		verify(os.Args[2:])
+	case "keygen":
+		keygen(os.Args[2:])
+	case "archive":
+		archive()
+	case "verify-archive":
+		verifyArchive(os.Args[2:])
+	default:
+		log.Fatal(usage)
+	}
+}
+
+func verify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	from := fs.Int64("from", 0, "first audit log id to check")
+	checkpointFile := fs.String("checkpoints", "", "checkpoint file to check against the chain")
+	pubHex := fs.String("pubkey", "", "hex ed25519 public key the checkpoints were signed with")
+	keyFile := fs.String("key", "", "seed file, the public key is derived from it")
+	fs.Parse(args)
+
+	db, err := config.InitDatabase()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.DB.Close()
+
+	if err := db.DB.Ping(); err != nil {
+		log.Fatalf("can't connect to database: %s", err)
+	}
+
+	ctx := context.Background()
+
+	report, err := audit.Verify(db.DB, *from, ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if report.Broken == nil && *checkpointFile != "" {
+		pub, err := publicKey(*pubHex, *keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		checkpoints, err := audit.ReadCheckpoints(*checkpointFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		report.Broken, err = audit.VerifyCheckpoints(db.DB, checkpoints, pub, ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+
+	if report.Broken != nil {
+		os.Exit(1)
+	}
+}
+
+func publicKey(pubHex, keyFile string) (ed25519.PublicKey, error) {
+	if pubHex != "" {
+		pub, err := hex.DecodeString(pubHex)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("public key must be %d hex encoded bytes", ed25519.PublicKeySize)
+		}
+		return ed25519.PublicKey(pub), nil
+	}
+
+	if keyFile == "" {
+		return nil, fmt.Errorf("-pubkey or -key is required to check checkpoints")
+	}
+
+	key, err := config.LoadCheckpointKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.Public().(ed25519.PublicKey), nil
+}
+
+// writes a new signing seed and prints the public key to hand to the auditors
+func keygen(args []string) {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	out := fs.String("out", "", "file to write the hex seed to, must not exist")
+	fs.Parse(args)
+
+	if *out == "" {
+		log.Fatal(usage)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.OpenFile(*out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintln(f, hex.EncodeToString(priv.Seed())); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(hex.EncodeToString(pub))
+}
+
+// one retention pass with the AUDIT_* settings, same as the server runs on its interval
+func archive() {
+	cfg, err := config.InitAudit()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.ArchiveDir == "" {
+		log.Fatal("AUDIT_ARCHIVE_DIR is required to archive partitions")
+	}
+
+	db, err := config.InitDatabase()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.DB.Close()
+
+	if err := db.DB.Ping(); err != nil {
+		log.Fatalf("can't connect to database: %s", err)
+	}
+
+	archived, err := audit.NewRetention(db.DB, cfg).RunOnce(context.Background())
+	for _, m := range archived {
+		out, _ := json.Marshal(m)
+		fmt.Println(string(out))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// offline, only needs the manifest and the archive file next to it
+func verifyArchive(args []string) {
+	fs := flag.NewFlagSet("verify-archive", flag.ExitOnError)
+	manifest := fs.String("manifest", "", "manifest written next to the archive file")
+	fs.Parse(args)
+
+	if *manifest == "" {
+		log.Fatal(usage)
+	}
+
+	report, err := audit.VerifyArchive(*manifest)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+
+	if report.Broken != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/auth"
+	"github.com/achsanalfitra/gopayslip/internal/config"
+)
+
+// creates the first admin, refuses to run once any admin exists
+func main() {
+	username := flag.String("username", "", "username of the bootstrap admin")
+	flag.Parse()
+
+	if *username == "" {
+		log.Fatal("usage: bootstrap -username <name>, password is read from BOOTSTRAP_ADMIN_PASSWORD or stdin")
+	}
+
+	// avoid leaking the password through shell history, env or stdin only
+	password := os.Getenv("BOOTSTRAP_ADMIN_PASSWORD")
+	if password == "" {
+		fmt.Fprint(os.Stderr, "password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatal("failed to read password from stdin")
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	db, err := config.InitDatabase()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.DB.Close()
+
+	if err := db.DB.Ping(); err != nil {
+		log.Fatalf("can't connect to database: %s", err)
+	}
+
+	authConfig, err := config.InitAuth()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), app.PQ, db.DB)
+
+	if err := auth.NewAuthService(authConfig, audit.NewWriter()).BootstrapAdmin(*username, password, ctx); err != nil {
+		log.Fatalf("bootstrap failed: %v", err)
+	}
+
+	log.Printf("bootstrap admin %s created", *username)
+}
@@ -4,11 +4,17 @@ import (
 	"context"
 	"database/sql"
 	"log"
+	"net/http"
 	"time"
 
 	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/auth"
 	"github.com/achsanalfitra/gopayslip/internal/config"
+	"github.com/achsanalfitra/gopayslip/internal/handlers"
 	"github.com/achsanalfitra/gopayslip/internal/router"
+	"github.com/achsanalfitra/gopayslip/internal/services/admin"
+	"github.com/achsanalfitra/gopayslip/internal/services/empl"
 )
 
 func main() {
@@ -22,15 +28,57 @@ func main() {
 		log.Fatalf("can't connect to database: %s", err)
 	}
 
-	serverConfig := config
+	authConfig, err := config.InitAuth()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	oidcConfig, err := config.InitOIDC()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	proxyConfig, err := config.InitProxy()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	payrollConfig, err := config.InitPayroll()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	auditConfig, err := config.InitAudit()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// READ entries go through the async pipeline when it's enabled, mutations always write in their own transaction
+	var pipeline *audit.Pipeline
+	if auditConfig.AsyncWALDir != "" {
+		pipeline, err = audit.OpenPipeline(db.DB, auditConfig, context.Background())
+		if err != nil {
+			log.Fatal(err)
+		}
+		go pipeline.Run(context.Background())
+	}
+
+	auditWriter := audit.NewWriter()
+	readAuditor, err := audit.NewReadAuditor(auditWriter, pipeline, auditConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	appConfig := app.AppConfig{
 		DB:         db.DB,
-		InitStates: make(map[string]interface{}),
-		Server: config.CreateServer(
-			addr,
-			router.NewRouter().ServeHTTP(),
-		),
+		Auth:       authConfig,
+		OIDC:       oidcConfig,
+		Proxy:      proxyConfig,
+		Payroll:    payrollConfig,
+		Audit:      auditWriter,
+		Reads:      readAuditor,
+		Pipeline:   pipeline,
+		InitStates: make(map[string]any),
 	}
 
 	// get the initial states
@@ -49,9 +97,73 @@ func main() {
 		log.Printf("InitStates populated with latest payroll period: Start=%v, End=%v", startPeriod, endPeriod)
 	}
 
-	// get InitStates
+	a := app.NewApp(appConfig)
 
-	appConfig := app.AppConfig{
-		DB: db.DB,
+	// signed checkpoints of the audit chain tip, for external auditors
+	if auditConfig.CheckpointFile != "" {
+		checkpointer := audit.NewCheckpointer(a.DB, auditConfig.CheckpointFile, auditConfig.CheckpointKey, auditConfig.CheckpointInterval)
+		go checkpointer.Run(context.Background())
 	}
+
+	// keeps partitions ahead and moves old months out to archive files when AUDIT_ARCHIVE_DIR is set
+	go audit.NewRetention(a.DB, auditConfig).Run(context.Background())
+
+	// auth routes are registered by the router itself
+	rtr := router.NewRouter(a)
+
+	// employee routes, integrations can reach them with a scoped API key
+	emplHandler := handlers.NewEmplHandler(empl.NewEmplServices(a.Reads, a.Payroll), empl.NewUserServices(a.Audit), a)
+	rtr.RegisterScopedRoute(http.MethodPost, "/api/attendance", auth.ScopeAttendanceWrite, emplHandler.AttendanceHandler)
+	rtr.RegisterScopedRoute(http.MethodPost, "/api/overtime", auth.ScopeOvertimeWrite, emplHandler.OvertimeHandler)
+	rtr.RegisterScopedRoute(http.MethodPost, "/api/reimbursement", auth.ScopeReimbursementWrite, emplHandler.ReimbursementHandler)
+	rtr.RegisterScopedRoute(http.MethodGet, "/api/payslip", auth.ScopePayslipRead, emplHandler.PayslipHandler)
+	rtr.RegisterScopedRoute(http.MethodGet, "/api/payslip/pdf", auth.ScopePayslipRead, emplHandler.PayslipPDFHandler)
+	// session only, an API key never learns an employee's PDF password
+	rtr.RegisterRoute(http.MethodGet, "/api/payslip/pdf/password", emplHandler.PayslipPasswordHandler)
+	// public, answers for a payslip's verification code, throttled per client since anyone can call it
+	verifyHandler := emplHandler.VerifyPayslipHandler
+	if v := a.Payroll.Verification; v != nil {
+		verifyHandler = router.Throttle(v.RateLimit, time.Minute, verifyHandler)
+	}
+	rtr.RegisterRoute(http.MethodGet, "/api/payslip/verify", verifyHandler)
+
+	// payroll lifecycle, admin only
+	adminService := admin.NewAdminServices(a.Audit, a.Reads, a.Payroll)
+
+	// runs left RUNNING by a crash are marked failed, their keys can be retried
+	recovered, err := adminService.RecoverPayrollRuns(context.WithValue(context.Background(), app.PQ, a.DB))
+	if err != nil {
+		log.Fatalf("Failed to recover payroll runs: %v", err)
+	}
+	if recovered > 0 {
+		log.Printf("Marked %d abandoned payroll runs as failed", recovered)
+	}
+
+	payrollHandler := handlers.NewPayrollHandler(adminService, a)
+	rtr.RegisterRoute(http.MethodPost, "/api/admin/payroll", payrollHandler.DefineHandler)
+	rtr.RegisterRoute(http.MethodPost, "/api/admin/payroll/transition", payrollHandler.TransitionHandler)
+	rtr.RegisterRoute(http.MethodGet, "/api/admin/payroll/preview", payrollHandler.PreviewHandler)
+	rtr.RegisterRoute(http.MethodGet, "/api/admin/payroll/payslips/pdf", payrollHandler.PrintPayslipsHandler)
+	// scheduled jobs run payroll and collect the reports with a scoped API key, approvals stay with people
+	rtr.RegisterScopedRoute(http.MethodGet, "/api/admin/payroll/summary", auth.ScopePayrollRead, payrollHandler.SummaryHandler)
+	rtr.RegisterScopedRoute(http.MethodGet, "/api/admin/payroll/export/summary", auth.ScopePayrollRead, payrollHandler.ExportSummaryHandler)
+	rtr.RegisterScopedRoute(http.MethodGet, "/api/admin/payroll/export/payslip", auth.ScopePayrollRead, payrollHandler.ExportPayslipHandler)
+	rtr.RegisterScopedRoute(http.MethodPost, "/api/admin/payroll/run", auth.ScopePayrollWrite, payrollHandler.RunHandler)
+	rtr.RegisterScopedRoute(http.MethodGet, "/api/admin/payroll/runs", auth.ScopePayrollRead, payrollHandler.RunsHandler)
+	rtr.RegisterRoute(http.MethodGet, "/api/admin/payroll/history", payrollHandler.HistoryHandler)
+	rtr.RegisterRoute(http.MethodPost, "/api/admin/payroll/submit", payrollHandler.SubmitHandler)
+	rtr.RegisterRoute(http.MethodPost, "/api/admin/payroll/approve", payrollHandler.ApproveHandler)
+	rtr.RegisterRoute(http.MethodPost, "/api/admin/payroll/reject", payrollHandler.RejectHandler)
+	rtr.RegisterRoute(http.MethodGet, "/api/admin/payroll/approvals", payrollHandler.ApprovalsHandler)
+
+	// audit, SIEM tooling can read it with a scoped API key
+	auditHandler := handlers.NewAuditHandler(admin.NewAuditServices(), a)
+	rtr.RegisterScopedRoute(http.MethodGet, "/api/admin/audit/events", auth.ScopeAuditRead, auditHandler.CatalogueHandler)
+	rtr.RegisterScopedRoute(http.MethodGet, "/api/admin/audit/logs", auth.ScopeAuditRead, auditHandler.QueryHandler)
+	rtr.RegisterScopedRoute(http.MethodGet, "/api/admin/audit/logs/export", auth.ScopeAuditRead, auditHandler.ExportHandler)
+	rtr.RegisterScopedRoute(http.MethodGet, "/api/admin/audit/history", auth.ScopeAuditRead, auditHandler.HistoryHandler)
+	rtr.RegisterScopedRoute(http.MethodGet, "/api/admin/audit/pipeline", auth.ScopeAuditRead, auditHandler.PipelineHandler)
+
+	a.Server = config.CreateServer(config.ServerAddr(), rtr)
+	a.Run()
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/config"
+	"github.com/achsanalfitra/gopayslip/internal/export"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/services/admin"
+)
+
+const usage = `usage:
+  export summary -payroll <id> -actor <admin id> [-format csv|xlsx] [-columns <key,key:Header,...>] [-out <dir>]
+  export payslip -payroll <id> -user <id> -actor <admin id> [-format csv|xlsx] [-columns <key,key:Header,...>] [-out <dir>]
+  export pdf -payroll <id> -actor <admin id> [-out <dir> | -zip <file>]`
+
+// writes the export and a sha256sum sidecar next to it, from the payslips the run issued
+// pdf renders every payslip of the run into a directory or zip with a SHA256SUMS manifest
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal(usage)
+	}
+
+	payslip, pdf := false, false
+	switch os.Args[1] {
+	case "summary":
+	case "payslip":
+		payslip = true
+	case "pdf":
+		pdf = true
+	default:
+		log.Fatal(usage)
+	}
+
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	payrollID := fs.Int64("payroll", 0, "payroll id, the payroll must have been run")
+	userID := fs.Int64("user", 0, "employee whose payslip is exported")
+	actorID := fs.Int64("actor", 0, "admin the export is audited under")
+	formatFlag := fs.String("format", string(export.CSV), "csv or xlsx")
+	columns := fs.String("columns", "", "columns in order, empty uses PAYROLL_EXPORT_*_COLUMNS")
+	out := fs.String("out", ".", "directory to write the file and its checksum to")
+	zipPath := fs.String("zip", "", "zip archive to write the PDFs to instead of -out")
+	fs.Parse(os.Args[2:])
+
+	if *payrollID <= 0 || *actorID <= 0 || (payslip && *userID <= 0) || (!pdf && *zipPath != "") {
+		log.Fatal(usage)
+	}
+
+	format, err := export.ParseFormat(*formatFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	payrollConfig, err := config.InitPayroll()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	auditConfig, err := config.InitAudit()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := config.InitDatabase()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.DB.Close()
+
+	if err := db.DB.Ping(); err != nil {
+		log.Fatalf("can't connect to database: %s", err)
+	}
+
+	ctx := context.WithValue(context.Background(), app.PQ, db.DB)
+
+	if err := requireAdmin(*actorID, db.DB, ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	// reads are written synchronously, the CLI exits before a pipeline would flush
+	auditWriter := audit.NewWriter()
+	readAuditor, err := audit.NewReadAuditor(auditWriter, nil, auditConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	service := admin.NewAdminServices(auditWriter, readAuditor, payrollConfig)
+
+	if pdf {
+		path, err := printPayslips(service, *actorID, *payrollID, *out, *zipPath, ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(path)
+		return
+	}
+
+	var file export.File
+	if payslip {
+		file, err = service.ExportPayslip(*actorID, *payrollID, *userID, format, *columns, ctx)
+	} else {
+		file, err = service.ExportSummary(*actorID, *payrollID, format, *columns, ctx)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	path := filepath.Join(*out, file.Name)
+	if err := os.WriteFile(path, file.Data, 0o600); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(path+".sha256", file.Sidecar(), 0o600); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(path)
+}
+
+// a half written archive is removed, a directory keeps what was rendered before the failure
+func printPayslips(service admin.Admin, actorID, payrollID int64, dir, zipPath string, ctx context.Context) (string, error) {
+	if zipPath == "" {
+		bundle, err := export.NewDirBundle(dir)
+		if err != nil {
+			return "", err
+		}
+		n, err := service.PrintPayslips(actorID, payrollID, bundle, ctx)
+		if err != nil {
+			return "", err
+		}
+		log.Printf("rendered %d payslips", n)
+		return dir, nil
+	}
+
+	f, err := os.OpenFile(zipPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+
+	n, err := service.PrintPayslips(actorID, payrollID, export.NewZipBundle(f), ctx)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(zipPath)
+		return "", err
+	}
+
+	log.Printf("rendered %d payslips", n)
+	return zipPath, nil
+}
+
+func requireAdmin(actorID int64, db *sql.DB, ctx context.Context) error {
+	var role model.Role
+	err := db.QueryRowContext(ctx, `SELECT role FROM users WHERE id = $1`, actorID).Scan(&role)
+	if err == sql.ErrNoRows || (err == nil && role != model.ADMIN) {
+		return fmt.Errorf("user %d is not an admin", actorID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query user %d: %w", actorID, err)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"os"
 
+	"github.com/achsanalfitra/gopayslip/internal/audit"
 	"github.com/achsanalfitra/gopayslip/internal/config"
 )
 
@@ -21,6 +22,13 @@ var (
 type AppConfig struct {
 	DB         *sql.DB
 	Server     *config.Server
+	Auth       *config.Auth
+	OIDC       *config.OIDC
+	Proxy      *config.Proxy
+	Payroll    *config.Payroll
+	Audit      audit.Writer
+	Reads      audit.ReadAuditor
+	Pipeline   *audit.Pipeline
 	InitStates map[string]any
 }
 
@@ -28,15 +36,34 @@ type AppConfig struct {
 type App struct {
 	DB         *sql.DB
 	Server     *config.Server
-	InitStates map[string]any // data init
+	Auth       *config.Auth
+	OIDC       *config.OIDC
+	Proxy      *config.Proxy
+	Payroll    *config.Payroll // currency and rounding rules
+	Audit      audit.Writer
+	Reads      audit.ReadAuditor // salary disclosure, nil disables read auditing
+	Pipeline   *audit.Pipeline   // async READ auditing, nil when disabled
+	InitStates map[string]any    // data init
 	// declare other app-dependencies here
 }
 
 func NewApp(cfg AppConfig) *App {
+	initStates := cfg.InitStates
+	if initStates == nil {
+		initStates = make(map[string]any)
+	}
+
 	return &App{
 		DB:         cfg.DB,
 		Server:     cfg.Server,
-		InitStates: make(map[string]any),
+		Auth:       cfg.Auth,
+		OIDC:       cfg.OIDC,
+		Proxy:      cfg.Proxy,
+		Payroll:    cfg.Payroll,
+		Audit:      cfg.Audit,
+		Reads:      cfg.Reads,
+		Pipeline:   cfg.Pipeline,
+		InitStates: initStates,
 		// don't forget to instantiate them
 	}
 }
@@ -0,0 +1,28 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type actorKey string
+
+const ctxActorKey actorKey = "auditactor"
+
+// who is behind the current request, injected by the router
+type Actor struct {
+	UserID    int64 // 0 on public paths, services set Entry.ActorID instead
+	RequestID uuid.UUID
+	IP        string
+	Session   string // stable per login session or API key, used to dedupe read entries
+}
+
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, ctxActorKey, a)
+}
+
+func ActorFrom(ctx context.Context) (Actor, bool) {
+	a, ok := ctx.Value(ctxActorKey).(Actor)
+	return a, ok
+}
@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/model"
+)
+
+// postgres keeps microseconds, hashing anything finer would never verify
+const chainTimePrecision = time.Microsecond
+
+// fixed field order, this is what row_hash is computed over
+type chainContent struct {
+	ID               int64            `json:"id"`
+	RequestID        string           `json:"request_id"`
+	CreatedAt        string           `json:"created_at"`
+	EventType        model.EventType  `json:"event_type"`
+	ActionType       model.ActionType `json:"action_type"`
+	AffectedTable    model.Table      `json:"affected_table"`
+	AffectedRecordID int64            `json:"affected_record_id"`
+	CreatedBy        int64            `json:"created_by"`
+	IPAddress        string           `json:"ip_address"`
+	OldData          string           `json:"old_data"`
+	NewData          string           `json:"new_data"`
+	PrevHash         string           `json:"prev_hash"`
+}
+
+// sha256 over the row content including the previous hash, editing any column or
+// removing a row breaks every hash after it
+func ChainHash(log model.AuditLog) string {
+	content := chainContent{
+		ID:               log.ID,
+		RequestID:        log.RequestId.String(),
+		CreatedAt:        log.CreatedAt.UTC().Truncate(chainTimePrecision).Format(time.RFC3339Nano),
+		EventType:        log.EventType,
+		ActionType:       log.ActionType,
+		AffectedTable:    log.AffectedRecord,
+		AffectedRecordID: log.AffectedRecordID,
+		CreatedBy:        log.CreatedBy,
+		IPAddress:        log.IPAddress,
+		OldData:          log.OldData,
+		NewData:          log.NewData,
+		PrevHash:         log.PrevHash,
+	}
+
+	// only strings and integers, marshaling can't fail
+	b, _ := json.Marshal(content)
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// locks the chain tip until the surrounding transaction ends, then links the entry to it
+func appendToChain(ctx context.Context, tx *sql.Tx, log *model.AuditLog) error {
+	head, err := lockChain(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	ids, err := reserveIDs(ctx, tx, 1)
+	if err != nil {
+		return err
+	}
+
+	log.ID = ids[0]
+	link(&head, log)
+
+	return advanceChain(ctx, tx, head)
+}
+
+// every writer goes through this lock, so the chain has exactly one tip
+func lockChain(ctx context.Context, tx *sql.Tx) (model.AuditChain, error) {
+	var head model.AuditChain
+	var lastCreatedAt sql.NullTime
+	err := tx.QueryRowContext(ctx, "SELECT last_id, last_hash, last_created_at FROM audit_chain WHERE id = 1 FOR UPDATE").Scan(&head.LastID, &head.LastHash, &lastCreatedAt)
+	if err != nil {
+		return model.AuditChain{}, errors.New("failed to lock audit chain")
+	}
+	head.LastCreatedAt = lastCreatedAt.Time
+
+	return head, nil
+}
+
+// the id is part of the hash, so ids are reserved up front
+func reserveIDs(ctx context.Context, tx *sql.Tx, n int) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT nextval(pg_get_serial_sequence('audit_log', 'id')) FROM generate_series(1, $1)", n)
+	if err != nil {
+		return nil, errors.New("failed to reserve audit log id")
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0, n)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.New("failed to reserve audit log id")
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil || len(ids) != n {
+		return nil, errors.New("failed to reserve audit log id")
+	}
+
+	return ids, nil
+}
+
+// created_at never goes before the previous entry, so id and created_at order agree
+// and each monthly partition holds one contiguous stretch of the chain
+func link(head *model.AuditChain, log *model.AuditLog) {
+	log.CreatedAt = log.CreatedAt.Truncate(chainTimePrecision)
+	if log.CreatedAt.IsZero() {
+		log.CreatedAt = time.Now().Truncate(chainTimePrecision)
+	}
+	if log.CreatedAt.Before(head.LastCreatedAt) {
+		log.CreatedAt = head.LastCreatedAt
+	}
+
+	log.PrevHash = head.LastHash
+	log.RowHash = ChainHash(*log)
+
+	head.LastID = log.ID
+	head.LastHash = log.RowHash
+	head.LastCreatedAt = log.CreatedAt
+}
+
+func advanceChain(ctx context.Context, tx *sql.Tx, head model.AuditChain) error {
+	_, err := tx.ExecContext(ctx, "UPDATE audit_chain SET last_id=$1, last_hash=$2, last_created_at=$3, updated_at=$4 WHERE id = 1", head.LastID, head.LastHash, head.LastCreatedAt, time.Now())
+	if err != nil {
+		return errors.New("failed to advance audit chain")
+	}
+
+	return nil
+}
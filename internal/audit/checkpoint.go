@@ -0,0 +1,178 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/model"
+)
+
+var ErrCheckpointSignature = errors.New("invalid checkpoint signature")
+
+// signed snapshot of the chain tip, a rewritten chain can't match an old checkpoint
+// without the signing key
+type Checkpoint struct {
+	LastID    int64     `json:"last_id"`
+	LastHash  string    `json:"last_hash"`
+	CreatedAt time.Time `json:"created_at"`
+	Signature string    `json:"signature"` // base64 ed25519 over the other fields
+}
+
+func (c Checkpoint) message() []byte {
+	return []byte(fmt.Sprintf("gopayslip-audit-checkpoint:%d:%s:%s", c.LastID, c.LastHash, c.CreatedAt.UTC().Format(time.RFC3339Nano)))
+}
+
+func (c Checkpoint) Verify(pub ed25519.PublicKey) error {
+	sig, err := base64.StdEncoding.DecodeString(c.Signature)
+	if err != nil || !ed25519.Verify(pub, c.message(), sig) {
+		return ErrCheckpointSignature
+	}
+	return nil
+}
+
+// appends a checkpoint to a local file on every tick while the tip moved
+type Checkpointer struct {
+	db       *sql.DB
+	path     string
+	key      ed25519.PrivateKey
+	interval time.Duration
+	lastID   int64
+}
+
+func NewCheckpointer(db *sql.DB, path string, key ed25519.PrivateKey, interval time.Duration) *Checkpointer {
+	return &Checkpointer{
+		db:       db,
+		path:     path,
+		key:      key,
+		interval: interval,
+	}
+}
+
+// blocks until ctx is done, failures are logged and retried on the next tick
+func (c *Checkpointer) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.Write(ctx); err != nil {
+			log.Printf("audit checkpoint failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// signs the current tip, returns false when nothing was appended since the last checkpoint
+func (c *Checkpointer) Write(ctx context.Context) (bool, error) {
+	var head model.AuditChain
+	if err := c.db.QueryRowContext(ctx, "SELECT last_id, last_hash FROM audit_chain WHERE id = 1").Scan(&head.LastID, &head.LastHash); err != nil {
+		return false, errors.New("failed to query audit chain")
+	}
+
+	if head.LastID == 0 || head.LastID == c.lastID {
+		return false, nil
+	}
+
+	cp := Checkpoint{
+		LastID:    head.LastID,
+		LastHash:  head.LastHash,
+		CreatedAt: time.Now().UTC(),
+	}
+	cp.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(c.key, cp.message()))
+
+	line, err := json.Marshal(cp)
+	if err != nil {
+		return false, err
+	}
+
+	f, err := os.OpenFile(c.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return false, err
+	}
+	if err := f.Sync(); err != nil {
+		return false, err
+	}
+
+	c.lastID = head.LastID
+
+	return true, nil
+}
+
+// one JSON checkpoint per line, as written by the Checkpointer
+func ReadCheckpoints(path string) ([]Checkpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var checkpoints []Checkpoint
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var cp Checkpoint
+		if err := json.Unmarshal(scanner.Bytes(), &cp); err != nil {
+			return nil, fmt.Errorf("checkpoint file line %d: %w", line, err)
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+
+	return checkpoints, scanner.Err()
+}
+
+// every checkpoint has to be signed by the key and still match the row it points at
+func VerifyCheckpoints(db *sql.DB, checkpoints []Checkpoint, pub ed25519.PublicKey, ctx context.Context) (*Break, error) {
+	for _, cp := range checkpoints {
+		if err := cp.Verify(pub); err != nil {
+			return &Break{ID: cp.LastID, Reason: "checkpoint signature is invalid"}, nil
+		}
+
+		var rowHash string
+		err := db.QueryRowContext(ctx, "SELECT COALESCE(row_hash, '') FROM audit_log WHERE id=$1", cp.LastID).Scan(&rowHash)
+		if errors.Is(err, sql.ErrNoRows) {
+			// archived rows are checked against the archive file, only the last one is known here
+			var archive model.AuditArchive
+			err := db.QueryRowContext(ctx, "SELECT last_id, last_row_hash FROM audit_archive WHERE first_id <= $1 AND last_id >= $1", cp.LastID).Scan(&archive.LastID, &archive.LastRowHash)
+			if errors.Is(err, sql.ErrNoRows) {
+				return &Break{ID: cp.LastID, Reason: "checkpointed row no longer exists"}, nil
+			}
+			if err != nil {
+				return nil, errors.New("failed to query audit archive")
+			}
+			if archive.LastID == cp.LastID && archive.LastRowHash != cp.LastHash {
+				return &Break{ID: cp.LastID, Reason: "archived row hash differs from the signed checkpoint, the chain was rewritten"}, nil
+			}
+			continue
+		}
+		if err != nil {
+			return nil, errors.New("failed to query audit log")
+		}
+
+		if rowHash != cp.LastHash {
+			return &Break{ID: cp.LastID, Reason: "row hash differs from the signed checkpoint, the chain was rewritten"}, nil
+		}
+	}
+
+	return nil, nil
+}
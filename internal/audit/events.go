@@ -0,0 +1,174 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/achsanalfitra/gopayslip/internal/model"
+)
+
+var (
+	ErrUnknownEvent    = errors.New("unknown audit event type")
+	ErrPayloadMismatch = errors.New("audit payload doesn't match the event schema")
+)
+
+const (
+	// employee
+	EventAttendanceCheckedIn   model.EventType = "ATTENDANCE_CHECKED_IN"
+	EventOvertimeProposed      model.EventType = "OVERTIME_PROPOSED"
+	EventReimbursementProposed model.EventType = "REIMBURSEMENT_PROPOSED"
+
+	// payroll
+	EventPayrollDefined model.EventType = "PAYROLL_DEFINED"
+	EventPayrollRun     model.EventType = "PAYROLL_RUN"
+	EventPayslipsIssued model.EventType = "PAYSLIPS_ISSUED"
+	EventPayrollStatus  model.EventType = "PAYROLL_STATUS_CHANGED"
+	EventPayrollStep    model.EventType = "PAYROLL_TRANSITION_RECORDED"
+	EventPayrollSubmit  model.EventType = "PAYROLL_SUBMITTED"
+	EventPayrollApprove model.EventType = "PAYROLL_APPROVED"
+	EventPayrollReject  model.EventType = "PAYROLL_REJECTED"
+	EventPayrollRunFail model.EventType = "PAYROLL_RUN_FAILED"
+
+	// salary disclosure, written by the ReadAuditor
+	EventPayslipViewed        model.EventType = "PAYSLIP_VIEWED"
+	EventPayrollSummaryViewed model.EventType = "PAYROLL_SUMMARY_VIEWED"
+	EventPayrollPreviewed     model.EventType = "PAYROLL_PREVIEWED"
+	EventPayrollExported      model.EventType = "PAYROLL_EXPORTED"
+	EventPayslipPrinted       model.EventType = "PAYSLIP_PRINTED"
+	EventPayslipsPrinted      model.EventType = "PAYSLIPS_PRINTED"
+	EventPayslipVerified      model.EventType = "PAYSLIP_VERIFIED"
+	EventUserSalaryRead       model.EventType = "USER_SALARY_READ"
+
+	// authentication
+	EventLoginSucceeded model.EventType = "LOGIN_SUCCEEDED"
+	EventLoginFailed    model.EventType = "LOGIN_FAILED"
+
+	// users and credentials
+	EventUserRegistered        model.EventType = "USER_REGISTERED"
+	EventUserInvited           model.EventType = "USER_INVITED"
+	EventUserActivated         model.EventType = "USER_ACTIVATED"
+	EventAdminBootstrapped     model.EventType = "ADMIN_BOOTSTRAPPED"
+	EventUserProvisionedByIdP  model.EventType = "USER_PROVISIONED_BY_IDP"
+	EventUserRoleSyncedByIdP   model.EventType = "USER_ROLE_SYNCED_BY_IDP"
+	EventPasswordChanged       model.EventType = "PASSWORD_CHANGED"
+	EventPasswordRehashed      model.EventType = "PASSWORD_REHASHED"
+	EventPasswordResetIssued   model.EventType = "PASSWORD_RESET_ISSUED"
+	EventPasswordReset         model.EventType = "PASSWORD_RESET"
+	EventMFAEnrollmentStarted  model.EventType = "MFA_ENROLLMENT_STARTED"
+	EventMFAEnabled            model.EventType = "MFA_ENABLED"
+	EventMFADisabled           model.EventType = "MFA_DISABLED"
+	EventMFARecoveryCodeUsed   model.EventType = "MFA_RECOVERY_CODE_USED"
+	EventServiceAccountCreated model.EventType = "SERVICE_ACCOUNT_CREATED"
+	EventAPIKeyIssued          model.EventType = "API_KEY_ISSUED"
+	EventAPIKeyRevoked         model.EventType = "API_KEY_REVOKED"
+)
+
+// catalogue entry, the action, table and payload types are fixed per event
+type Event struct {
+	Type        model.EventType  `json:"event_type"`
+	Action      model.ActionType `json:"action_type"`
+	Table       model.Table      `json:"affected_table"`
+	Description string           `json:"description"`
+	Anonymous   bool             `json:"anonymous"` // may be written without an actor
+	OldData     *Schema          `json:"old_data"`  // nil means the entry carries no old data
+	NewData     *Schema          `json:"new_data"`
+
+	oldType reflect.Type
+	newType reflect.Type
+}
+
+var catalogue = map[model.EventType]Event{}
+
+func register(ev model.EventType, action model.ActionType, table model.Table, anonymous bool, desc string, oldData, newData any) {
+	if _, exists := catalogue[ev]; exists {
+		panic(fmt.Sprintf("audit event %s registered twice", ev))
+	}
+
+	e := Event{
+		Type:        ev,
+		Action:      action,
+		Table:       table,
+		Description: desc,
+		Anonymous:   anonymous,
+		oldType:     reflect.TypeOf(oldData),
+		newType:     reflect.TypeOf(newData),
+	}
+	e.OldData = schemaOf(e.oldType)
+	e.NewData = schemaOf(e.newType)
+
+	catalogue[ev] = e
+}
+
+func init() {
+	register(EventAttendanceCheckedIn, model.CREATE, model.ATTENDANCE, false, "employee checked in, directly or through an integration", nil, model.Attendance{})
+	register(EventOvertimeProposed, model.CREATE, model.OVERTIME, false, "employee proposed overtime", nil, model.Overtime{})
+	register(EventReimbursementProposed, model.CREATE, model.REIMBURSEMENT, false, "employee proposed a reimbursement", nil, model.Reimbursement{})
+
+	register(EventPayrollDefined, model.CREATE, model.PAYROLL, false, "admin defined a payroll period", nil, model.Payroll{})
+	register(EventPayrollRun, model.UPDATE, model.PAYROLL, false, "admin ran the payroll, payslips are issued for the new revision", model.Payroll{}, model.Payroll{})
+	register(EventPayslipsIssued, model.CREATE, model.PAYSLIP, false, "payslips frozen for every employee while the payroll was run, the record is the payroll", nil, PayslipBatch{})
+	register(EventPayrollStatus, model.UPDATE, model.PAYROLL, false, "payroll moved through its lifecycle, running is logged as PAYROLL_RUN instead", model.Payroll{}, model.Payroll{})
+	register(EventPayrollStep, model.CREATE, model.PAYROLLTRANS, false, "a lifecycle step of the payroll recorded with the admin's reason", nil, model.PayrollTransition{})
+	register(EventPayrollSubmit, model.CREATE, model.PAYROLLAPPR, false, "admin submitted a run payroll for approval", nil, model.PayrollApproval{})
+	register(EventPayrollApprove, model.UPDATE, model.PAYROLLAPPR, false, "a second admin approved the submitted payroll, payslips become visible", model.PayrollApproval{}, model.PayrollApproval{})
+	register(EventPayrollReject, model.UPDATE, model.PAYROLLAPPR, false, "a second admin rejected the submitted payroll and reopened it", model.PayrollApproval{}, model.PayrollApproval{})
+	register(EventPayrollRunFail, model.UPDATE, model.PAYROLLRUNS, false, "a payroll run failed or was abandoned by a crashed process, nothing was issued", model.PayrollRun{}, model.PayrollRun{})
+
+	register(EventPayslipViewed, model.READ, model.USERS, false, "payslip generated for the record's user", nil, PayslipRead{})
+	register(EventPayrollSummaryViewed, model.READ, model.PAYROLL, false, "payroll summary with every employee's take home pay generated", nil, SummaryRead{})
+	register(EventPayrollExported, model.READ, model.PAYROLL, false, "issued payslips of a run exported to CSV or XLSX, the summary or one employee's", nil, ExportRead{})
+	register(EventPayslipPrinted, model.READ, model.USERS, false, "issued payslip rendered as a PDF for the record's user", nil, PrintRead{})
+	register(EventPayslipsPrinted, model.READ, model.PAYROLL, false, "every issued payslip of a run rendered as PDFs into a directory or zip", nil, PrintBatchRead{})
+	register(EventPayslipVerified, model.READ, model.PAYSLIP, true, "someone holding a payslip's verification code confirmed it without logging in", nil, VerifyRead{})
+	register(EventPayrollPreviewed, model.READ, model.PAYROLL, false, "dry run of a locked payroll with every employee's take home pay, viewed or exported", nil, PreviewRead{})
+	register(EventUserSalaryRead, model.READ, model.USERS, false, "someone other than the user read their salary", nil, SalaryRead{})
+
+	register(EventLoginSucceeded, model.READ, model.USERS, false, "credentials accepted, a second factor may still be required", nil, LoginAttempt{})
+	register(EventLoginFailed, model.READ, model.USERS, true, "credentials or second factor rejected, the actor is empty for unknown usernames", nil, LoginAttempt{})
+
+	register(EventUserRegistered, model.CREATE, model.USERS, false, "admin created an active user", nil, model.User{})
+	register(EventUserInvited, model.CREATE, model.USERS, false, "admin created a pending user with an invitation", nil, model.User{})
+	register(EventUserActivated, model.UPDATE, model.USERS, false, "invited user accepted the invitation", Activation{}, Activation{})
+	register(EventAdminBootstrapped, model.CREATE, model.USERS, false, "first admin created from the command line", nil, model.User{})
+	register(EventUserProvisionedByIdP, model.CREATE, model.USERS, false, "user created just-in-time on the first identity provider login", nil, model.User{})
+	register(EventUserRoleSyncedByIdP, model.UPDATE, model.USERS, false, "role changed to match the identity provider groups", RoleChange{}, RoleChange{})
+	register(EventPasswordChanged, model.UPDATE, model.USERS, false, "user changed their password", nil, PasswordChange{})
+	register(EventPasswordRehashed, model.UPDATE, model.USERS, false, "password hash upgraded to the configured bcrypt cost", BcryptCost{}, BcryptCost{})
+	register(EventPasswordResetIssued, model.CREATE, model.PWDRESET, false, "admin issued a password reset token", nil, model.PasswordReset{})
+	register(EventPasswordReset, model.UPDATE, model.USERS, false, "user set a new password with a reset token", nil, PasswordChange{})
+	register(EventMFAEnrollmentStarted, model.UPDATE, model.USERS, false, "pending two-factor secret stored", MFAState{}, MFAState{})
+	register(EventMFAEnabled, model.UPDATE, model.USERS, false, "two-factor authentication confirmed and recovery codes issued", MFAState{}, MFAState{})
+	register(EventMFADisabled, model.UPDATE, model.USERS, false, "two-factor authentication turned off", MFAState{}, MFAState{})
+	register(EventMFARecoveryCodeUsed, model.UPDATE, model.RECOVERYCODE, false, "recovery code redeemed instead of a TOTP code", nil, model.RecoveryCode{})
+	register(EventServiceAccountCreated, model.CREATE, model.USERS, false, "admin created a service account", nil, model.User{})
+	register(EventAPIKeyIssued, model.CREATE, model.APIKEY, false, "admin issued an API key", nil, model.APIKey{})
+	register(EventAPIKeyRevoked, model.UPDATE, model.APIKEY, false, "admin revoked an API key", model.APIKey{}, model.APIKey{})
+}
+
+func Lookup(ev model.EventType) (Event, bool) {
+	e, ok := catalogue[ev]
+	return e, ok
+}
+
+// sorted by event type so the listing is stable
+func Catalogue() []Event {
+	events := make([]Event, 0, len(catalogue))
+	for _, e := range catalogue {
+		events = append(events, e)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Type < events[j].Type })
+	return events
+}
+
+// the payload must be exactly the registered type, nil only when the event has none
+func (e Event) validate(oldData, newData any) error {
+	if reflect.TypeOf(oldData) != e.oldType {
+		return fmt.Errorf("%w: %s old data is %T", ErrPayloadMismatch, e.Type, oldData)
+	}
+	if reflect.TypeOf(newData) != e.newType {
+		return fmt.Errorf("%w: %s new data is %T", ErrPayloadMismatch, e.Type, newData)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/model"
+)
+
+// payloads for events that don't log a whole model row, secrets and hashes never appear here
+
+type PasswordChange struct {
+	ChangedAt time.Time `json:"password_changed_at"`
+	ResetID   int64     `json:"password_reset_id,omitempty"`
+}
+
+type BcryptCost struct {
+	Cost int `json:"bcrypt_cost"`
+}
+
+type Activation struct {
+	ActivatedAt sql.NullTime `json:"activated_at"`
+	InviteID    int64        `json:"invite_id,omitempty"`
+}
+
+type MFAState struct {
+	Enabled       bool `json:"totp_enabled"`
+	Pending       bool `json:"totp_pending,omitempty"`
+	RecoveryCodes int  `json:"recovery_codes,omitempty"`
+}
+
+type RoleChange struct {
+	Role model.Role `json:"user_role"`
+}
+
+// Method is password, totp, recovery_code or oidc
+type LoginAttempt struct {
+	Username string     `json:"username,omitempty"`
+	Role     model.Role `json:"user_role,omitempty"`
+	Method   string     `json:"method"`
+	Reason   string     `json:"reason,omitempty"`
+}
+
+type PayslipRead struct {
+	UserID      int64     `json:"user_id"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+}
+
+type SummaryRead struct {
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Users       int       `json:"users"`
+}
+
+type PreviewRead struct {
+	PayrollID int64  `json:"payroll_id"`
+	Format    string `json:"format"`
+	Users     int    `json:"users"`
+}
+
+// UserID is zero for a summary export, Checksum is the sha256 of the file handed out
+type ExportRead struct {
+	PayrollID int64  `json:"payroll_id"`
+	Revision  int    `json:"revision"`
+	UserID    int64  `json:"user_id"`
+	Format    string `json:"format"`
+	Rows      int    `json:"rows"`
+	Checksum  string `json:"checksum"`
+}
+
+// Checksum is the sha256 of the PDF handed out, a protected PDF is encrypted anew on every render
+type PrintRead struct {
+	PayrollID int64  `json:"payroll_id"`
+	Revision  int    `json:"revision"`
+	UserID    int64  `json:"user_id"`
+	Protected bool   `json:"protected"`
+	Checksum  string `json:"checksum"`
+}
+
+// Destination is dir or zip
+type PrintBatchRead struct {
+	PayrollID   int64  `json:"payroll_id"`
+	Revision    int    `json:"revision"`
+	Payslips    int    `json:"payslips"`
+	Protected   bool   `json:"protected"`
+	Destination string `json:"destination"`
+}
+
+// Disclosed is amount or hash, how the take-home pay was shown
+type VerifyRead struct {
+	PayslipID int64  `json:"payslip_id"`
+	PayrollID int64  `json:"payroll_id"`
+	Revision  int    `json:"revision"`
+	UserID    int64  `json:"user_id"`
+	Disclosed string `json:"disclosed"`
+}
+
+type SalaryRead struct {
+	UserID int64 `json:"user_id"`
+}
+
+// amounts stay in the payslip table, the entry only says who was paid in the run
+type PayslipBatch struct {
+	PayrollID int64   `json:"payroll_id"`
+	Payslips  int     `json:"payslips"`
+	UserIDs   []int64 `json:"user_ids"`
+}
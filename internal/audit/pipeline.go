@@ -0,0 +1,443 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/config"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrAsyncMutation  = errors.New("only READ events may be audited asynchronously")
+	ErrPipelineFull   = errors.New("audit queue is full")
+	ErrPipelineClosed = errors.New("audit pipeline is closed")
+)
+
+const walFileName = "audit.wal"
+
+// one line of the write-ahead file, the entry is fully built before it's queued
+type walRecord struct {
+	Seq int64          `json:"seq"`
+	Log model.AuditLog `json:"log"`
+}
+
+type PipelineStats struct {
+	Instance          string    `json:"instance"`
+	QueueDepth        int       `json:"queue_depth"`
+	QueueCapacity     int       `json:"queue_capacity"`
+	OldestPending     float64   `json:"oldest_pending_seconds"` // how far the database lags behind
+	Enqueued          int64     `json:"enqueued"`
+	Flushed           int64     `json:"flushed"`
+	Batches           int64     `json:"batches"`
+	Rejected          int64     `json:"rejected"` // queue stayed full past the timeout, written synchronously instead
+	FlushErrors       int64     `json:"flush_errors"`
+	WALBytes          int64     `json:"wal_bytes"`
+	LastBatchSize     int64     `json:"last_batch_size"`
+	LastFlushMillis   int64     `json:"last_flush_ms"`
+	LastFlushAt       time.Time `json:"last_flush_at"`
+	ReplayedOnStartup int64     `json:"replayed_on_startup"`
+}
+
+// bounded queue in front of the chain for READ entries, batches are written with COPY
+// an entry is fsynced to the write-ahead file before Enqueue returns, so a crash loses nothing,
+// the committed position is stored with each batch, so a replay doesn't duplicate anything
+type Pipeline struct {
+	db             *sql.DB
+	instance       string
+	batchSize      int
+	interval       time.Duration
+	enqueueTimeout time.Duration
+
+	slots chan struct{} // one per queued entry, full means backpressure
+	queue chan walRecord
+
+	mu       sync.Mutex // orders seq assignment, WAL appends and queue pushes
+	wal      *os.File
+	seq      int64
+	pending  int // appended to the WAL but not committed yet
+	closed   bool
+	queuedAt map[int64]time.Time
+
+	enqueued, flushed, batches, rejected, flushErrors  int64
+	walBytes, lastBatchSize, lastFlushMillis, replayed int64
+	lastFlushAt                                        atomic.Value
+}
+
+// replays whatever a previous run left uncommitted in the WAL before accepting new entries
+func OpenPipeline(db *sql.DB, cfg *config.Audit, ctx context.Context) (*Pipeline, error) {
+	if err := os.MkdirAll(cfg.AsyncWALDir, 0o700); err != nil {
+		return nil, err
+	}
+
+	p := &Pipeline{
+		db:             db,
+		instance:       cfg.AsyncInstance,
+		batchSize:      cfg.AsyncBatchSize,
+		interval:       cfg.AsyncFlushInterval,
+		enqueueTimeout: cfg.AsyncEnqueueTimeout,
+		slots:          make(chan struct{}, cfg.AsyncQueueSize),
+		queue:          make(chan walRecord, cfg.AsyncQueueSize),
+		queuedAt:       make(map[int64]time.Time),
+	}
+	p.lastFlushAt.Store(time.Time{})
+
+	path := filepath.Join(cfg.AsyncWALDir, walFileName)
+	backlog, lastSeq, err := readWAL(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var committed int64
+	err = db.QueryRowContext(ctx, "SELECT seq FROM audit_wal_position WHERE instance=$1", p.instance).Scan(&committed)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("failed to query audit wal position")
+	}
+
+	var replay []walRecord
+	for _, rec := range backlog {
+		if rec.Seq > committed {
+			replay = append(replay, rec)
+		}
+	}
+
+	for start := 0; start < len(replay); start += p.batchSize {
+		end := min(start+p.batchSize, len(replay))
+		if err := p.flush(replay[start:end], ctx); err != nil {
+			return nil, fmt.Errorf("replaying audit wal: %w", err)
+		}
+	}
+	p.replayed = int64(len(replay))
+
+	// everything in it is committed now
+	p.wal, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.wal.Sync(); err != nil {
+		return nil, err
+	}
+	p.seq = max(lastSeq, committed)
+
+	return p, nil
+}
+
+// a torn last line is what a crash mid-append looks like, that entry never returned to its caller
+func readWAL(path string) ([]walRecord, int64, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var records []walRecord
+	var lastSeq int64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec walRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			log.Printf("audit wal: skipping unreadable record after seq %d", lastSeq)
+			continue
+		}
+		records = append(records, rec)
+		lastSeq = max(lastSeq, rec.Seq)
+	}
+
+	return records, lastSeq, scanner.Err()
+}
+
+// the entry is durable once this returns nil, ErrPipelineFull and ErrPipelineClosed mean
+// nothing was queued and the caller should write synchronously
+func (p *Pipeline) Enqueue(e Entry, ctx context.Context) error {
+	ev, ok := Lookup(e.EventType)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownEvent, e.EventType)
+	}
+	// mutations must commit or roll back together with their audit row
+	if ev.Action != model.READ {
+		return fmt.Errorf("%w: %s", ErrAsyncMutation, e.EventType)
+	}
+
+	entry, err := buildLog(ctx, e)
+	if err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(p.enqueueTimeout)
+	defer timer.Stop()
+	select {
+	case p.slots <- struct{}{}:
+	case <-timer.C:
+		atomic.AddInt64(&p.rejected, 1)
+		return ErrPipelineFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		<-p.slots
+		return ErrPipelineClosed
+	}
+
+	rec := walRecord{Seq: p.seq + 1, Log: entry}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		<-p.slots
+		return errors.New("failed to marshal audit wal record")
+	}
+
+	n, err := p.wal.Write(append(line, '\n'))
+	if err == nil {
+		err = p.wal.Sync()
+	}
+	if err != nil {
+		<-p.slots
+		return fmt.Errorf("failed to append to audit wal: %w", err)
+	}
+
+	p.seq = rec.Seq
+	p.pending++
+	p.queuedAt[rec.Seq] = time.Now()
+	atomic.AddInt64(&p.walBytes, int64(n))
+	atomic.AddInt64(&p.enqueued, 1)
+
+	// there is a slot for it, this never blocks
+	p.queue <- rec
+
+	return nil
+}
+
+// flushes a batch when it's full or the interval passed, blocks until ctx is done
+// a failed batch is retried, the entries stay in the WAL until it commits
+func (p *Pipeline) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	batch := make([]walRecord, 0, p.batchSize)
+	for {
+		select {
+		case rec := <-p.queue:
+			batch = append(batch, rec)
+			if len(batch) < p.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-ctx.Done():
+			p.close(batch)
+			return
+		}
+
+		for {
+			err := p.flush(batch, ctx)
+			if err == nil {
+				break
+			}
+
+			atomic.AddInt64(&p.flushErrors, 1)
+			log.Printf("audit pipeline flush of %d entries failed: %v", len(batch), err)
+
+			select {
+			case <-ctx.Done():
+				p.close(batch)
+				return
+			case <-ticker.C:
+			}
+		}
+
+		p.committed(len(batch))
+		batch = batch[:0]
+	}
+}
+
+// stops accepting entries and makes a last attempt at what's queued, the WAL covers the rest
+func (p *Pipeline) close(batch []walRecord) {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+drain:
+	for {
+		select {
+		case rec := <-p.queue:
+			batch = append(batch, rec)
+		default:
+			break drain
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for start := 0; start < len(batch); start += p.batchSize {
+		end := min(start+p.batchSize, len(batch))
+		if err := p.flush(batch[start:end], ctx); err != nil {
+			log.Printf("audit pipeline closed with %d entries left in the wal: %v", len(batch)-start, err)
+			return
+		}
+		p.committed(end - start)
+	}
+}
+
+// links the batch to the chain and writes it with COPY, the WAL position moves in the same transaction
+func (p *Pipeline) flush(batch []walRecord, ctx context.Context) error {
+	started := time.Now()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	head, err := lockChain(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	ids, err := reserveIDs(ctx, tx, len(batch))
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("audit_log", "id", "request_id", "created_at", "event_type", "action_type", "affected_table",
+		"affected_record_id", "created_by", "ip_address", "old_data", "new_data", "prev_hash", "row_hash"))
+	if err != nil {
+		return errors.New("failed to start audit log copy")
+	}
+	defer stmt.Close()
+
+	// the WAL copy keeps its original values, a retried batch is linked again from scratch
+	for i, rec := range batch {
+		entry := rec.Log
+		entry.ID = ids[i]
+		link(&head, &entry)
+
+		_, err := stmt.ExecContext(ctx,
+			entry.ID,
+			entry.RequestId,
+			entry.CreatedAt,
+			entry.EventType,
+			entry.ActionType,
+			entry.AffectedRecord,
+			nullInt(entry.AffectedRecordID),
+			nullInt(entry.CreatedBy),
+			nullString(entry.IPAddress),
+			nullString(entry.OldData),
+			nullString(entry.NewData),
+			entry.PrevHash,
+			entry.RowHash,
+		)
+		if err != nil {
+			return errors.New("failed to copy audit log")
+		}
+	}
+
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return errors.New("failed to copy audit log")
+	}
+
+	if err := advanceChain(ctx, tx, head); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO audit_wal_position (instance, seq, updated_at) VALUES ($1, $2, $3)
+              ON CONFLICT (instance) DO UPDATE SET seq = EXCLUDED.seq, updated_at = EXCLUDED.updated_at`
+	if _, err := tx.ExecContext(ctx, query, p.instance, batch[len(batch)-1].Seq, time.Now()); err != nil {
+		return errors.New("failed to advance audit wal position")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("commit failed")
+	}
+
+	atomic.AddInt64(&p.flushed, int64(len(batch)))
+	atomic.AddInt64(&p.batches, 1)
+	atomic.StoreInt64(&p.lastBatchSize, int64(len(batch)))
+	atomic.StoreInt64(&p.lastFlushMillis, time.Since(started).Milliseconds())
+	p.lastFlushAt.Store(time.Now())
+
+	p.mu.Lock()
+	for _, rec := range batch {
+		delete(p.queuedAt, rec.Seq)
+	}
+	p.mu.Unlock()
+
+	return nil
+}
+
+// frees the slots, the WAL is truncated once nothing in it is waiting for the database
+func (p *Pipeline) committed(n int) {
+	for i := 0; i < n; i++ {
+		<-p.slots
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending -= n
+	if p.pending > 0 {
+		return
+	}
+
+	if err := p.wal.Truncate(0); err != nil {
+		log.Printf("audit wal truncate failed: %v", err)
+		return
+	}
+	if _, err := p.wal.Seek(0, 0); err != nil {
+		log.Printf("audit wal seek failed: %v", err)
+		return
+	}
+	atomic.StoreInt64(&p.walBytes, 0)
+}
+
+func (p *Pipeline) Stats() PipelineStats {
+	p.mu.Lock()
+	var oldest time.Time
+	for _, at := range p.queuedAt {
+		if oldest.IsZero() || at.Before(oldest) {
+			oldest = at
+		}
+	}
+	p.mu.Unlock()
+
+	stats := PipelineStats{
+		Instance:          p.instance,
+		QueueDepth:        len(p.slots),
+		QueueCapacity:     cap(p.slots),
+		Enqueued:          atomic.LoadInt64(&p.enqueued),
+		Flushed:           atomic.LoadInt64(&p.flushed),
+		Batches:           atomic.LoadInt64(&p.batches),
+		Rejected:          atomic.LoadInt64(&p.rejected),
+		FlushErrors:       atomic.LoadInt64(&p.flushErrors),
+		WALBytes:          atomic.LoadInt64(&p.walBytes),
+		LastBatchSize:     atomic.LoadInt64(&p.lastBatchSize),
+		LastFlushMillis:   atomic.LoadInt64(&p.lastFlushMillis),
+		LastFlushAt:       p.lastFlushAt.Load().(time.Time),
+		ReplayedOnStartup: p.replayed,
+	}
+	if !oldest.IsZero() {
+		stats.OldestPending = time.Since(oldest).Seconds()
+	}
+
+	return stats
+}
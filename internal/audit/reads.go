@@ -0,0 +1,184 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/config"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+)
+
+var ErrNotReadEvent = errors.New("not a read audit event")
+
+// only these go through the ReadAuditor, logins are audited unconditionally
+var readEvents = map[model.EventType]bool{
+	EventPayslipViewed:        true,
+	EventPayrollSummaryViewed: true,
+	EventPayrollPreviewed:     true,
+	EventPayrollExported:      true,
+	EventPayslipPrinted:       true,
+	EventPayslipsPrinted:      true,
+	EventPayslipVerified:      true,
+	EventUserSalaryRead:       true,
+}
+
+// reads have no business transaction, Record writes the entry in one of its own or hands it
+// to the async pipeline, the policy decides whether the read is written at all
+type ReadAuditor interface {
+	Record(db *sql.DB, e Entry, ctx context.Context) error
+}
+
+type readAuditorImpl struct {
+	writer     Writer
+	pipeline   *Pipeline // nil writes synchronously
+	enabled    map[model.EventType]bool
+	mode       string
+	sampleRate float64
+	window     time.Duration
+
+	mu         sync.Mutex
+	seen       map[string]time.Time // dedupe key -> last written
+	lastPruned time.Time
+	rnd        *rand.Rand
+}
+
+func NewReadAuditor(w Writer, pipeline *Pipeline, cfg *config.Audit) (ReadAuditor, error) {
+	enabled := make(map[model.EventType]bool)
+	switch {
+	case len(cfg.ReadEvents) == 0:
+		for ev := range readEvents {
+			enabled[ev] = true
+		}
+	case len(cfg.ReadEvents) == 1 && strings.EqualFold(cfg.ReadEvents[0], "none"):
+	default:
+		for _, name := range cfg.ReadEvents {
+			ev := model.EventType(name)
+			if !readEvents[ev] {
+				return nil, fmt.Errorf("%w: %s", ErrNotReadEvent, name)
+			}
+			enabled[ev] = true
+		}
+	}
+
+	return &readAuditorImpl{
+		writer:     w,
+		pipeline:   pipeline,
+		enabled:    enabled,
+		mode:       cfg.ReadMode,
+		sampleRate: cfg.ReadSampleRate,
+		window:     cfg.ReadDedupWindow,
+		seen:       make(map[string]time.Time),
+		lastPruned: time.Now(),
+		rnd:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+func (r *readAuditorImpl) Record(db *sql.DB, e Entry, ctx context.Context) error {
+	if !readEvents[e.EventType] {
+		return fmt.Errorf("%w: %s", ErrNotReadEvent, e.EventType)
+	}
+
+	if !r.enabled[e.EventType] || !r.admit(e, ctx) {
+		return nil
+	}
+
+	// a full or stopped queue falls back to the synchronous write below, reads are never dropped
+	if r.pipeline != nil {
+		err := r.pipeline.Enqueue(e, ctx)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrPipelineFull) && !errors.Is(err, ErrPipelineClosed) {
+			r.forget(e, ctx)
+			return err
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	if err := r.writer.Write(ctx, tx, e); err != nil {
+		r.forget(e, ctx)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		r.forget(e, ctx)
+		return errors.New("commit failed")
+	}
+
+	return nil
+}
+
+// applies the volume policy, dedupe remembers the read before it's written so
+// concurrent identical reads only produce one entry
+func (r *readAuditorImpl) admit(e Entry, ctx context.Context) bool {
+	switch r.mode {
+	case config.ReadModeAll:
+		return true
+	case config.ReadModeSample:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.rnd.Float64() < r.sampleRate
+	}
+
+	now := time.Now()
+	key := dedupeKey(e, ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.lastPruned) > r.window {
+		for k, at := range r.seen {
+			if now.Sub(at) > r.window {
+				delete(r.seen, k)
+			}
+		}
+		r.lastPruned = now
+	}
+
+	if at, ok := r.seen[key]; ok && now.Sub(at) <= r.window {
+		return false
+	}
+	r.seen[key] = now
+
+	return true
+}
+
+// a failed write must not suppress the next attempt
+func (r *readAuditorImpl) forget(e Entry, ctx context.Context) {
+	if r.mode != config.ReadModeDedupe {
+		return
+	}
+
+	r.mu.Lock()
+	delete(r.seen, dedupeKey(e, ctx))
+	r.mu.Unlock()
+}
+
+// same session reading the same thing, public reads are keyed by the client address instead
+func dedupeKey(e Entry, ctx context.Context) string {
+	actor, _ := ActorFrom(ctx)
+
+	session := actor.Session
+	switch {
+	case session != "":
+	case actor.IP != "":
+		session = "ip:" + actor.IP
+	default:
+		session = actor.RequestID.String()
+	}
+
+	payload, _ := marshalData(e.NewData)
+
+	return fmt.Sprintf("%s|%d|%s|%d|%s", session, e.ActorID, e.EventType, e.RecordID, payload)
+}
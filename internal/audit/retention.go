@@ -0,0 +1,430 @@
+package audit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/config"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/lib/pq"
+)
+
+var ErrArchiveChecksum = errors.New("archive file doesn't match its manifest checksum")
+
+// only partitions created by audit_log_create_partition, the default partition is never archived
+var partitionName = regexp.MustCompile(`^audit_log_p(\d{4})(\d{2})$`)
+
+const archiveFormat = "jsonl+gzip, one audit_log row per line in id order"
+
+// written next to the archive file before the partition is dropped
+type Manifest struct {
+	Format        string    `json:"format"`
+	PartitionName string    `json:"partition_name"`
+	RangeStart    time.Time `json:"range_start"`
+	RangeEnd      time.Time `json:"range_end"`
+	RowCount      int64     `json:"row_count"`
+	FirstID       int64     `json:"first_id"`
+	LastID        int64     `json:"last_id"`
+	FirstPrevHash string    `json:"first_prev_hash"`
+	LastRowHash   string    `json:"last_row_hash"`
+	FileName      string    `json:"file_name"` // relative to the manifest
+	Checksum      string    `json:"checksum"`  // sha256 of the archive file
+	ArchivedAt    time.Time `json:"archived_at"`
+	RetainUntil   time.Time `json:"retain_until"`
+}
+
+type partition struct {
+	name     string
+	month    time.Time // first instant of the UTC month
+	attached bool
+}
+
+// keeps partitions ahead of time and moves the ones past the hot window out of the database
+type Retention struct {
+	db  *sql.DB
+	cfg *config.Audit
+}
+
+func NewRetention(db *sql.DB, cfg *config.Audit) *Retention {
+	return &Retention{db: db, cfg: cfg}
+}
+
+// blocks until ctx is done, failures are logged and retried on the next tick
+func (r *Retention) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.RetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		archived, err := r.RunOnce(ctx)
+		for _, m := range archived {
+			log.Printf("audit partition %s archived to %s, %d rows", m.PartitionName, m.FileName, m.RowCount)
+		}
+		if err != nil {
+			log.Printf("audit retention failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// oldest first, stops at the first partition that fails so the archives stay in chain order
+func (r *Retention) RunOnce(ctx context.Context) ([]Manifest, error) {
+	now := time.Now().UTC()
+	if err := r.ensurePartitions(now, ctx); err != nil {
+		return nil, err
+	}
+
+	if r.cfg.ArchiveDir == "" {
+		return nil, nil
+	}
+
+	partitions, err := r.partitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := monthStart(now).AddDate(0, -r.cfg.HotMonths, 0)
+
+	var archived []Manifest
+	for _, p := range partitions {
+		if !p.month.Before(cutoff) {
+			break
+		}
+
+		m, err := r.archive(p, ctx)
+		if err != nil {
+			return archived, fmt.Errorf("archiving %s: %w", p.name, err)
+		}
+		archived = append(archived, m)
+	}
+
+	return archived, nil
+}
+
+// rows landing in the default partition can't be archived by month, so it's kept empty
+func (r *Retention) ensurePartitions(now time.Time, ctx context.Context) error {
+	for i := 0; i <= r.cfg.PartitionsAhead; i++ {
+		day := monthStart(now).AddDate(0, i, 0)
+		if _, err := r.db.ExecContext(ctx, "SELECT audit_log_create_partition($1::date)", day.Format(time.DateOnly)); err != nil {
+			return errors.New("failed to create audit log partition")
+		}
+	}
+	return nil
+}
+
+// attached partitions and detached ones a previous run didn't finish archiving, oldest first
+func (r *Retention) partitions(ctx context.Context) ([]partition, error) {
+	query := `SELECT c.relname, EXISTS (SELECT 1 FROM pg_inherits i WHERE i.inhrelid = c.oid)
+              FROM pg_class c WHERE c.relkind = 'r' AND c.relnamespace = current_schema()::regnamespace AND c.relname LIKE 'audit_log_p%'`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.New("failed to list audit log partitions")
+	}
+	defer rows.Close()
+
+	var partitions []partition
+	for rows.Next() {
+		var p partition
+		if err := rows.Scan(&p.name, &p.attached); err != nil {
+			return nil, errors.New("failed to scan audit log partition")
+		}
+
+		match := partitionName.FindStringSubmatch(p.name)
+		if match == nil {
+			continue
+		}
+		p.month, err = time.Parse("200601", match[1]+match[2])
+		if err != nil {
+			continue
+		}
+		partitions = append(partitions, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("error during audit log partition iteration")
+	}
+
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].month.Before(partitions[j].month) })
+
+	return partitions, nil
+}
+
+// detach, export with manifest, then record and drop in one transaction
+// a crash anywhere leaves the detached table behind and the next run picks it up again
+func (r *Retention) archive(p partition, ctx context.Context) (Manifest, error) {
+	table := pq.QuoteIdentifier(p.name)
+
+	if p.attached {
+		if _, err := r.db.ExecContext(ctx, "ALTER TABLE audit_log DETACH PARTITION "+table); err != nil {
+			return Manifest{}, errors.New("failed to detach partition")
+		}
+	}
+
+	m, err := r.export(p, ctx)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Manifest{}, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO audit_archive (partition_name, range_start, range_end, row_count, first_id, last_id, first_prev_hash, last_row_hash, file_name, checksum, archived_at, retain_until)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	_, err = tx.ExecContext(ctx, query, m.PartitionName, m.RangeStart, m.RangeEnd, m.RowCount, m.FirstID, m.LastID, m.FirstPrevHash, m.LastRowHash, m.FileName, m.Checksum, m.ArchivedAt, m.RetainUntil)
+	if err != nil {
+		return Manifest{}, errors.New("failed to record audit archive")
+	}
+
+	if _, err := tx.ExecContext(ctx, "DROP TABLE "+table); err != nil {
+		return Manifest{}, errors.New("failed to drop archived partition")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Manifest{}, errors.New("commit failed")
+	}
+
+	return m, nil
+}
+
+// the rows are checked against their hashes on the way out, a tampered partition is never archived
+func (r *Retention) export(p partition, ctx context.Context) (Manifest, error) {
+	m := Manifest{
+		Format:        archiveFormat,
+		PartitionName: p.name,
+		RangeStart:    p.month,
+		RangeEnd:      p.month.AddDate(0, 1, 0),
+		FileName:      p.name + ".jsonl.gz",
+		ArchivedAt:    time.Now().UTC(),
+	}
+	m.RetainUntil = m.RangeEnd.AddDate(r.cfg.RetentionYears, 0, 0)
+
+	if err := os.MkdirAll(r.cfg.ArchiveDir, 0o700); err != nil {
+		return Manifest{}, err
+	}
+
+	query := fmt.Sprintf(`SELECT id, request_id, created_at, event_type, action_type, affected_table, COALESCE(affected_record_id, 0), COALESCE(created_by, 0),
+                          COALESCE(ip_address, ''), COALESCE(old_data, ''), COALESCE(new_data, ''), COALESCE(prev_hash, ''), COALESCE(row_hash, '')
+                          FROM %s ORDER BY id`, pq.QuoteIdentifier(p.name))
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return Manifest{}, errors.New("failed to query partition")
+	}
+	defer rows.Close()
+
+	path := filepath.Join(r.cfg.ArchiveDir, m.FileName)
+	err = writeAtomic(path, func(w io.Writer) error {
+		sum := sha256.New()
+		gz := gzip.NewWriter(io.MultiWriter(w, sum))
+		enc := json.NewEncoder(gz)
+
+		var walk chainWalk
+		for rows.Next() {
+			var l model.AuditLog
+			err := rows.Scan(&l.ID, &l.RequestId, &l.CreatedAt, &l.EventType, &l.ActionType, &l.AffectedRecord, &l.AffectedRecordID, &l.CreatedBy,
+				&l.IPAddress, &l.OldData, &l.NewData, &l.PrevHash, &l.RowHash)
+			if err != nil {
+				return errors.New("failed to scan audit log")
+			}
+
+			if brk := walk.next(l); brk != nil {
+				return fmt.Errorf("audit log %d: %s", brk.ID, brk.Reason)
+			}
+			if err := enc.Encode(l); err != nil {
+				return err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return errors.New("error during audit log iteration")
+		}
+
+		if err := gz.Close(); err != nil {
+			return err
+		}
+
+		m.RowCount = walk.rows
+		m.FirstID = walk.firstID
+		m.LastID = walk.lastID
+		m.FirstPrevHash = walk.firstPrev
+		m.LastRowHash = walk.lastHash
+		m.Checksum = hex.EncodeToString(sum.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return Manifest{}, err
+	}
+	err = writeAtomic(filepath.Join(r.cfg.ArchiveDir, p.name+".manifest.json"), func(w io.Writer) error {
+		_, err := w.Write(append(manifest, '\n'))
+		return err
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	return m, nil
+}
+
+// checks an archive file against its manifest and re-verifies the chain inside it
+func VerifyArchive(manifestPath string) (Report, error) {
+	raw, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return Report{}, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return Report{}, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	f, err := os.Open(filepath.Join(filepath.Dir(manifestPath), m.FileName))
+	if err != nil {
+		return Report{}, err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	gz, err := gzip.NewReader(io.TeeReader(f, sum))
+	if err != nil {
+		return Report{}, fmt.Errorf("%w: not a gzip file", ErrArchiveChecksum)
+	}
+
+	var report Report
+	var walk chainWalk
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var l model.AuditLog
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return Report{}, fmt.Errorf("archive row %d: %w", walk.rows+1, err)
+		}
+
+		if brk := walk.next(l); brk != nil {
+			report.Broken = brk
+			return report, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Report{}, err
+	}
+
+	// the tee only sees what gzip read, drain the rest before comparing
+	if _, err := io.Copy(sum, f); err != nil {
+		return Report{}, err
+	}
+	if hex.EncodeToString(sum.Sum(nil)) != m.Checksum {
+		return Report{}, ErrArchiveChecksum
+	}
+
+	report.Checked = walk.checked
+	report.Legacy = walk.legacy
+	report.LastID = walk.lastID
+	report.LastHash = walk.lastHash
+
+	switch {
+	case walk.rows != m.RowCount || walk.firstID != m.FirstID || walk.lastID != m.LastID:
+		report.Broken = &Break{ID: walk.lastID, Reason: "archive rows don't match the manifest"}
+	case walk.firstPrev != m.FirstPrevHash || walk.lastHash != m.LastRowHash:
+		report.Broken = &Break{ID: walk.lastID, Reason: "archive hashes don't match the manifest"}
+	}
+
+	return report, nil
+}
+
+// same rules as Verify, shared by the export and VerifyArchive
+type chainWalk struct {
+	rows      int64
+	checked   int64
+	legacy    int64
+	firstID   int64
+	lastID    int64
+	firstPrev string
+	lastHash  string
+	chained   bool
+}
+
+func (c *chainWalk) next(l model.AuditLog) *Break {
+	if c.rows == 0 {
+		c.firstID = l.ID
+		c.firstPrev = l.PrevHash
+	}
+	c.rows++
+	c.lastID = l.ID
+
+	if l.RowHash == "" {
+		if c.chained {
+			return &Break{ID: l.ID, Reason: reasonUnhashed}
+		}
+		c.legacy++
+		return nil
+	}
+
+	if c.chained && l.PrevHash != c.lastHash {
+		return &Break{ID: l.ID, Reason: reasonPrevMismatch}
+	}
+	if ChainHash(l) != l.RowHash {
+		return &Break{ID: l.ID, Reason: reasonRowMismatch}
+	}
+
+	c.chained = true
+	c.checked++
+	c.lastHash = l.RowHash
+	return nil
+}
+
+// temp file, fsync, rename, so a crash never leaves a half written archive under the final name
+func writeAtomic(path string, fn func(io.Writer) error) error {
+	tmp, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := fn(w); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
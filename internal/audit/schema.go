@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// JSON shape of an event payload, derived from the Go type so it can't drift
+type Schema struct {
+	Type   string  `json:"type"`
+	Fields []Field `json:"fields,omitempty"`
+}
+
+type Field struct {
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	Optional bool    `json:"optional,omitempty"` // omitempty, may be missing
+	Fields   []Field `json:"fields,omitempty"`   // nested objects
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func schemaOf(t reflect.Type) *Schema {
+	if t == nil {
+		return nil
+	}
+
+	name, fields := describe(t)
+	return &Schema{Type: name, Fields: fields}
+}
+
+// maps a Go type to the JSON type encoding/json produces for it
+func describe(t reflect.Type) (string, []Field) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return "timestamp", nil
+	case t.Implements(jsonMarshalerType), t.Implements(textMarshalerType):
+		return "string", nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer", nil
+	case reflect.Float32, reflect.Float64:
+		return "number", nil
+	case reflect.String:
+		return "string", nil
+	case reflect.Slice, reflect.Array:
+		return "array", nil
+	case reflect.Map:
+		return "object", nil
+	case reflect.Struct:
+		return "object", structFields(t)
+	}
+
+	return "unknown", nil
+}
+
+func structFields(t reflect.Type) []Field {
+	var fields []Field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		typ, nested := describe(f.Type)
+		fields = append(fields, Field{
+			Name:     name,
+			Type:     typ,
+			Optional: strings.Contains(opts, "omitempty"),
+			Fields:   nested,
+		})
+	}
+	return fields
+}
@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/achsanalfitra/gopayslip/internal/model"
+)
+
+// outcome of walking the chain, Broken is nil when every link held
+type Report struct {
+	Checked  int64  `json:"checked"`
+	Legacy   int64  `json:"legacy"`   // rows written before the chain existed
+	Archived int64  `json:"archived"` // rows moved out to archive files, checked with VerifyArchive
+	LastID   int64  `json:"last_id"`
+	LastHash string `json:"last_hash"`
+	Broken   *Break `json:"broken,omitempty"`
+}
+
+type Break struct {
+	ID     int64  `json:"id"`
+	Reason string `json:"reason"`
+}
+
+const (
+	reasonUnhashed        = "row has no hash but follows chained rows"
+	reasonPrevMismatch    = "previous hash doesn't match, a row was removed, inserted or reordered"
+	reasonRowMismatch     = "row hash doesn't match its content, the row was modified"
+	reasonHeadMismatch    = "chain tip doesn't match the last row, rows were removed from or appended to the end"
+	reasonArchiveMismatch = "first row doesn't follow the last archived partition"
+)
+
+// walks audit_log in id order starting at fromID and stops at the first broken link
+// starting mid-chain trusts the prev_hash of the first row it sees
+func Verify(db *sql.DB, fromID int64, ctx context.Context) (Report, error) {
+	// rows and tip have to come from the same snapshot, writers keep appending meanwhile
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return Report{}, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	query := `SELECT id, request_id, created_at, event_type, action_type, affected_table, COALESCE(affected_record_id, 0), COALESCE(created_by, 0),
+                     COALESCE(ip_address, ''), COALESCE(old_data, ''), COALESCE(new_data, ''), COALESCE(prev_hash, ''), COALESCE(row_hash, '')
+              FROM audit_log WHERE id >= $1 ORDER BY id`
+	rows, err := tx.QueryContext(ctx, query, fromID)
+	if err != nil {
+		return Report{}, errors.New("failed to query audit log")
+	}
+	defer rows.Close()
+
+	var report Report
+	var walk chainWalk
+	for rows.Next() {
+		var log model.AuditLog
+		err := rows.Scan(
+			&log.ID,
+			&log.RequestId,
+			&log.CreatedAt,
+			&log.EventType,
+			&log.ActionType,
+			&log.AffectedRecord,
+			&log.AffectedRecordID,
+			&log.CreatedBy,
+			&log.IPAddress,
+			&log.OldData,
+			&log.NewData,
+			&log.PrevHash,
+			&log.RowHash,
+		)
+		if err != nil {
+			return Report{}, errors.New("failed to scan audit log")
+		}
+
+		brk := walk.next(log)
+		report.Checked, report.Legacy = walk.checked, walk.legacy
+		if brk != nil {
+			report.Broken = brk
+			return report, nil
+		}
+		if log.RowHash != "" {
+			report.LastID = log.ID
+			report.LastHash = log.RowHash
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return Report{}, errors.New("error during audit log iteration")
+	}
+
+	// archived partitions are gone from the table, the oldest remaining row has to pick up
+	// exactly where the newest archive left off
+	var archives int64
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(SUM(row_count), 0) FROM audit_archive").Scan(&archives, &report.Archived)
+	if err != nil {
+		return Report{}, errors.New("failed to query audit archive")
+	}
+	if walk.rows > 0 && archives > 0 {
+		var archive model.AuditArchive
+		err := tx.QueryRowContext(ctx, "SELECT last_id, last_row_hash FROM audit_archive WHERE last_id < $1 ORDER BY last_id DESC LIMIT 1", walk.firstID).Scan(&archive.LastID, &archive.LastRowHash)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return Report{}, errors.New("failed to query audit archive")
+		}
+		if err == nil && fromID <= archive.LastID+1 && archive.LastRowHash != "" && walk.firstPrev != archive.LastRowHash {
+			report.Broken = &Break{ID: walk.firstID, Reason: reasonArchiveMismatch}
+			return report, nil
+		}
+	}
+
+	// deleting the newest rows leaves a valid chain behind, the tip still remembers them
+	var head model.AuditChain
+	if err := tx.QueryRowContext(ctx, "SELECT last_id, last_hash FROM audit_chain WHERE id = 1").Scan(&head.LastID, &head.LastHash); err != nil {
+		return Report{}, errors.New("failed to query audit chain")
+	}
+	if head.LastID != report.LastID || head.LastHash != report.LastHash {
+		report.Broken = &Break{ID: head.LastID, Reason: reasonHeadMismatch}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/google/uuid"
+)
+
+var ErrNoActor = errors.New("audit entry has no actor")
+
+// one row of audit_log, action and table come from the catalogue, OldData and NewData are marshaled to JSON
+type Entry struct {
+	EventType model.EventType
+	RecordID  int64
+	OldData   any
+	NewData   any
+	ActorID   int64 // overrides the context actor, e.g. on public paths or for CLI commands
+}
+
+// takes the transaction of the business change, the entry can't exist without it and vice versa
+type Writer interface {
+	Write(ctx context.Context, tx *sql.Tx, e Entry) error
+}
+
+type writerImpl struct{}
+
+func NewWriter() Writer {
+	return &writerImpl{}
+}
+
+func (w *writerImpl) Write(ctx context.Context, tx *sql.Tx, e Entry) error {
+	log, err := buildLog(ctx, e)
+	if err != nil {
+		return err
+	}
+
+	if err := appendToChain(ctx, tx, &log); err != nil {
+		return err
+	}
+
+	insertQuery := `INSERT INTO audit_log (id, request_id, created_at, event_type, action_type, affected_table, affected_record_id, created_by, ip_address, old_data, new_data, prev_hash, row_hash)
+                    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+	_, err = tx.ExecContext(ctx, insertQuery,
+		log.ID,
+		log.RequestId,
+		log.CreatedAt,
+		log.EventType,
+		log.ActionType,
+		log.AffectedRecord,
+		nullInt(log.AffectedRecordID),
+		nullInt(log.CreatedBy),
+		nullString(log.IPAddress),
+		nullString(log.OldData),
+		nullString(log.NewData),
+		log.PrevHash,
+		log.RowHash,
+	)
+	if err != nil {
+		return errors.New("failed to insert audit log")
+	}
+
+	return nil
+}
+
+// checks the entry against the catalogue, then resolves actor, request id and payloads into the model
+func buildLog(ctx context.Context, e Entry) (model.AuditLog, error) {
+	ev, ok := Lookup(e.EventType)
+	if !ok {
+		return model.AuditLog{}, fmt.Errorf("%w: %s", ErrUnknownEvent, e.EventType)
+	}
+
+	if err := ev.validate(e.OldData, e.NewData); err != nil {
+		return model.AuditLog{}, err
+	}
+
+	actor, _ := ActorFrom(ctx)
+
+	createdBy := actor.UserID
+	if e.ActorID != 0 {
+		createdBy = e.ActorID
+	}
+	if createdBy == 0 && !ev.Anonymous {
+		return model.AuditLog{}, ErrNoActor
+	}
+
+	// CLI commands and background jobs have no request, give them their own id
+	requestID := actor.RequestID
+	if requestID == uuid.Nil {
+		requestID = uuid.New()
+	}
+
+	oldData, err := marshalData(e.OldData)
+	if err != nil {
+		return model.AuditLog{}, err
+	}
+	newData, err := marshalData(e.NewData)
+	if err != nil {
+		return model.AuditLog{}, err
+	}
+
+	return model.AuditLog{
+		CreatedBy:        createdBy,
+		AffectedRecordID: e.RecordID,
+		RequestId:        requestID,
+		ActionType:       ev.Action,
+		EventType:        ev.Type,
+		AffectedRecord:   ev.Table,
+		OldData:          oldData,
+		NewData:          newData,
+		IPAddress:        actor.IP,
+		CreatedAt:        time.Now().Truncate(chainTimePrecision),
+	}, nil
+}
+
+func marshalData(v any) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", errors.New("failed to marshal audit data")
+	}
+
+	return string(b), nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt(n int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: n, Valid: n != 0}
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/model"
+)
+
+type ServiceAccountRequest struct {
+	Name string `json:"name"`
+}
+
+type ServiceAccountResponse struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type IssueKeyRequest struct {
+	ServiceAccountID int64      `json:"service_account_id"`
+	Name             string     `json:"name"`
+	Scopes           []Scope    `json:"scopes"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+}
+
+type IssueKeyResponse struct {
+	Key    string       `json:"key"` // shown once, can't be retrieved later
+	APIKey model.APIKey `json:"api_key"`
+}
+
+type RevokeKeyRequest struct {
+	KeyID int64 `json:"key_id"`
+}
+
+// keys are sent as "Authorization: ApiKey gps_..." so they never collide with Bearer sessions
+func ReadAPIKey(req *http.Request) (string, bool) {
+	authHeader := req.Header.Get("Authorization")
+
+	const scheme = "ApiKey "
+	if len(authHeader) > len(scheme) && strings.EqualFold(authHeader[:len(scheme)], scheme) {
+		return strings.TrimSpace(authHeader[len(scheme):]), true
+	}
+
+	return "", false
+}
+
+// admin only, the router guards the path
+func (ah *AuthHandler) CreateServiceAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var req ServiceAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	r = ah.withDB(r)
+
+	_, adminID, err := ah.sessionUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "token unauthorized"})
+		return
+	}
+
+	id, err := ah.APIKeyService.CreateServiceAccount(adminID, req.Name, r.Context())
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUserExists):
+			writeJSON(w, http.StatusConflict, ErrorResponse{Error: err.Error()})
+		case errors.Is(err, ErrInvalidUsername):
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		default:
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "an unexpected error occured"})
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ServiceAccountResponse{ID: id, Name: req.Name})
+}
+
+func (ah *AuthHandler) IssueKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req IssueKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	r = ah.withDB(r)
+
+	_, adminID, err := ah.sessionUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "token unauthorized"})
+		return
+	}
+
+	key, record, err := ah.APIKeyService.IssueKey(adminID, req.ServiceAccountID, req.Name, req.Scopes, req.ExpiresAt, r.Context())
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUserNotFound):
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		case errors.Is(err, ErrNoScopes), errors.Is(err, ErrUnknownScope),
+			errors.Is(err, ErrNotServiceAccount), errors.Is(err, ErrAPIKeyExpiryPassed):
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		default:
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "an unexpected error occured"})
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, IssueKeyResponse{Key: key, APIKey: record})
+}
+
+func (ah *AuthHandler) ListKeysHandler(w http.ResponseWriter, r *http.Request) {
+	r = ah.withDB(r)
+
+	serviceAccountID, err := strconv.ParseInt(r.URL.Query().Get("service_account_id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "service_account_id is required"})
+		return
+	}
+
+	keys, err := ah.APIKeyService.ListKeys(serviceAccountID, r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "an unexpected error occured"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func (ah *AuthHandler) RevokeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req RevokeKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	r = ah.withDB(r)
+
+	_, adminID, err := ah.sessionUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "token unauthorized"})
+		return
+	}
+
+	if err := ah.APIKeyService.RevokeKey(adminID, req.KeyID, r.Context()); err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "an unexpected error occured"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, MessageResponse{Message: "api key revoked"})
+}
@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/hlp"
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
+)
+
+var (
+	ErrAPIKeyInvalid      = errors.New("api key is invalid, revoked or expired")
+	ErrNoScopes           = errors.New("at least one scope is required")
+	ErrUnknownScope       = errors.New("unknown scope")
+	ErrNotServiceAccount  = errors.New("user is not a service account")
+	ErrAPIKeyNotFound     = errors.New("api key not found")
+	ErrAPIKeyExpiryPassed = errors.New("expiry must be in the future")
+)
+
+// key format is gps_<prefix>_<secret>, the prefix is stored in plain for lookup
+const (
+	apiKeyTag          = "gps"
+	apiKeyPrefixLength = 6 // random bytes, 12 hex characters
+	lastUsedResolution = time.Minute
+)
+
+type APIKeyService interface {
+	CreateServiceAccount(actorID int64, name string, ctx context.Context) (serviceAccountID int64, err error)
+	IssueKey(actorID, serviceAccountID int64, name string, scopes []Scope, expiresAt *time.Time, ctx context.Context) (key string, record model.APIKey, err error)
+	ListKeys(serviceAccountID int64, ctx context.Context) ([]model.APIKey, error)
+	RevokeKey(actorID, keyID int64, ctx context.Context) error
+	Authenticate(key string, ctx context.Context) (Principal, error)
+}
+
+// the service account behind an authenticated API key
+type Principal struct {
+	ServiceAccountID int64
+	KeyID            int64
+	Scopes           []Scope
+}
+
+func (p Principal) HasScope(scope Scope) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type apiKeyServiceImpl struct {
+	auditor audit.Writer
+}
+
+func NewAPIKeyService(auditor audit.Writer) APIKeyService {
+	return &apiKeyServiceImpl{auditor: auditor}
+}
+
+// service accounts are users rows so audit created_by can reference them
+func (s *apiKeyServiceImpl) CreateServiceAccount(actorID int64, name string, ctx context.Context) (serviceAccountID int64, err error) {
+	if strings.TrimSpace(name) == "" {
+		return 0, ErrInvalidUsername
+	}
+
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	var tempID int64
+	err = tx.QueryRowContext(ctx, "SELECT id FROM users WHERE username=$1", name).Scan(&tempID)
+	if err == nil {
+		return 0, ErrUserExists
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, errors.New("database query error")
+	}
+
+	// no password, service accounts can't log in and only authenticate with keys
+	createdAt := time.Now()
+	account := model.User{
+		Username:    name,
+		Password:    "",
+		UserRole:    model.SERVICE,
+		Salary:      money.Decimal{},
+		CreatedAt:   createdAt,
+		UpdatedAt:   createdAt,
+		CreatedBy:   actorID,
+		UpdatedBy:   actorID,
+		ActivatedAt: sql.NullTime{Time: createdAt, Valid: true},
+	}
+
+	account.ID, err = insertUser(account, tx, ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventServiceAccountCreated,
+		RecordID:  account.ID,
+		NewData:   account,
+		ActorID:   actorID,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.New("commit failed")
+	}
+
+	return account.ID, nil
+}
+
+// the plain key is returned once, only its hash is persisted
+func (s *apiKeyServiceImpl) IssueKey(actorID, serviceAccountID int64, name string, scopes []Scope, expiresAt *time.Time, ctx context.Context) (key string, record model.APIKey, err error) {
+	if err := validateScopes(scopes); err != nil {
+		return "", model.APIKey{}, err
+	}
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return "", model.APIKey{}, ErrAPIKeyExpiryPassed
+	}
+
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return "", model.APIKey{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", model.APIKey{}, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	var role model.Role
+	err = tx.QueryRowContext(ctx, "SELECT role FROM users WHERE id=$1", serviceAccountID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", model.APIKey{}, ErrUserNotFound
+	}
+	if err != nil {
+		return "", model.APIKey{}, errors.New("database query error")
+	}
+	if role != model.SERVICE {
+		return "", model.APIKey{}, ErrNotServiceAccount
+	}
+
+	prefixBytes := make([]byte, apiKeyPrefixLength)
+	secretBytes := make([]byte, tokenLength)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", model.APIKey{}, errors.New("failed to generate api key")
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", model.APIKey{}, errors.New("failed to generate api key")
+	}
+
+	prefix := hex.EncodeToString(prefixBytes)
+	key = apiKeyTag + "_" + prefix + "_" + hex.EncodeToString(secretBytes)
+
+	record = model.APIKey{
+		ServiceAccountID: serviceAccountID,
+		CreatedBy:        actorID,
+		Name:             name,
+		Prefix:           prefix,
+		KeyHash:          hashAPIKey(key),
+		Scopes:           joinScopes(scopes),
+		CreatedAt:        time.Now(),
+	}
+	if expiresAt != nil {
+		record.ExpiresAt = sql.NullTime{Time: *expiresAt, Valid: true}
+	}
+
+	insertQuery := `INSERT INTO api_key (service_account_id, name, prefix, key_hash, scopes, expires_at, created_at, created_by)
+                    VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+	err = tx.QueryRowContext(ctx, insertQuery,
+		record.ServiceAccountID,
+		record.Name,
+		record.Prefix,
+		record.KeyHash,
+		record.Scopes,
+		record.ExpiresAt,
+		record.CreatedAt,
+		record.CreatedBy,
+	).Scan(&record.ID)
+	if err != nil {
+		return "", model.APIKey{}, errors.New("failed to insert api key")
+	}
+
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventAPIKeyIssued,
+		RecordID:  record.ID,
+		NewData:   record,
+		ActorID:   actorID,
+	})
+	if err != nil {
+		return "", model.APIKey{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", model.APIKey{}, errors.New("commit failed")
+	}
+
+	return key, record, nil
+}
+
+func (s *apiKeyServiceImpl) ListKeys(serviceAccountID int64, ctx context.Context) ([]model.APIKey, error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT id, service_account_id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at, created_by
+              FROM api_key WHERE service_account_id=$1 ORDER BY created_at DESC`
+	rows, err := db.QueryContext(ctx, query, serviceAccountID)
+	if err != nil {
+		return nil, errors.New("failed to query api keys")
+	}
+	defer rows.Close()
+
+	keys := []model.APIKey{}
+	for rows.Next() {
+		var k model.APIKey
+		if err := rows.Scan(&k.ID, &k.ServiceAccountID, &k.Name, &k.Prefix, &k.Scopes, &k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt, &k.CreatedAt, &k.CreatedBy); err != nil {
+			return nil, errors.New("failed to scan api key")
+		}
+		keys = append(keys, k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("error during api key iteration")
+	}
+
+	return keys, nil
+}
+
+func (s *apiKeyServiceImpl) RevokeKey(actorID, keyID int64, ctx context.Context) error {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	var old model.APIKey
+	query := `SELECT id, service_account_id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at, created_by
+              FROM api_key WHERE id=$1 AND revoked_at IS NULL FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, keyID).Scan(&old.ID, &old.ServiceAccountID, &old.Name, &old.Prefix, &old.Scopes, &old.ExpiresAt, &old.LastUsedAt, &old.RevokedAt, &old.CreatedAt, &old.CreatedBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return errors.New("failed to query api key")
+	}
+
+	revoked := old
+	revoked.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE api_key SET revoked_at=$1 WHERE id=$2", revoked.RevokedAt, keyID); err != nil {
+		return errors.New("failed to revoke api key")
+	}
+
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventAPIKeyRevoked,
+		RecordID:  keyID,
+		OldData:   old,
+		NewData:   revoked,
+		ActorID:   actorID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("commit failed")
+	}
+
+	return nil
+}
+
+func (s *apiKeyServiceImpl) Authenticate(key string, ctx context.Context) (Principal, error) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != apiKeyTag {
+		return Principal{}, ErrAPIKeyInvalid
+	}
+
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	var p Principal
+	var keyHash, scopes string
+	var expiresAt, revokedAt sql.NullTime
+	query := `SELECT k.id, k.service_account_id, k.key_hash, k.scopes, k.expires_at, k.revoked_at
+              FROM api_key k JOIN users u ON u.id = k.service_account_id WHERE k.prefix=$1 AND u.role=$2`
+	err = db.QueryRowContext(ctx, query, parts[1], model.SERVICE).Scan(&p.KeyID, &p.ServiceAccountID, &keyHash, &scopes, &expiresAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Principal{}, ErrAPIKeyInvalid
+	}
+	if err != nil {
+		return Principal{}, errors.New("failed to query api key")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(keyHash), []byte(hashAPIKey(key))) != 1 {
+		return Principal{}, ErrAPIKeyInvalid
+	}
+
+	now := time.Now()
+	if revokedAt.Valid || (expiresAt.Valid && now.After(expiresAt.Time)) {
+		return Principal{}, ErrAPIKeyInvalid
+	}
+
+	// coarse last-used tracking, a busy importer shouldn't write on every call, not audited
+	_, err = db.ExecContext(ctx, "UPDATE api_key SET last_used_at=$1 WHERE id=$2 AND (last_used_at IS NULL OR last_used_at < $3)", now, p.KeyID, now.Add(-lastUsedResolution))
+	if err != nil {
+		log.Printf("failed to update last_used_at for api key %d: %v", p.KeyID, err)
+	}
+
+	p.Scopes = splitScopes(scopes)
+
+	return p, nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
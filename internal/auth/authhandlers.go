@@ -11,6 +11,7 @@ import (
 
 	"github.com/achsanalfitra/gopayslip/internal/app"
 	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
 )
 
 type LoginRequest struct {
@@ -21,15 +22,21 @@ type LoginRequest struct {
 
 type LoginResponse struct {
 	// Message string `json:"message"` // for testing only <----
-	Access  string `json:"access"` // use it when tokenizer is already online
-	Refresh string `json:"refresh"`
+	Access  string `json:"access,omitempty"` // use it when tokenizer is already online
+	Refresh string `json:"refresh,omitempty"`
+
+	// two-step login, set when the password is correct but a second factor is still needed
+	MFAToken           string `json:"mfa_token,omitempty"`
+	MFARequired        bool   `json:"mfa_required,omitempty"`
+	EnrollmentRequired bool   `json:"enrollment_required,omitempty"`
 }
 
 type RegisterRequest struct {
-	Salary   float64    `json:"salary"`
-	Username string     `json:"username"`
-	Password string     `json:"password"`
-	UserRole model.Role `json:"user_role"`
+	Salary     money.Decimal `json:"salary"`
+	Username   string        `json:"username"`
+	Password   string        `json:"password"`
+	UserRole   model.Role    `json:"user_role"`
+	Department string        `json:"department"`
 }
 
 type RegisterResponse struct {
@@ -41,16 +48,21 @@ type ErrorResponse struct {
 }
 
 type AuthHandler struct {
-	AuthService AuthService
-	Tokenizer   *Tokenizer
-	App         *app.App
+	AuthService   AuthService
+	MFAService    MFAService
+	APIKeyService APIKeyService
+	Tokenizer     *Tokenizer
+	App           *app.App
 }
 
-func NewAuthHandler(a *app.App, svc AuthService) *AuthHandler {
+// the tokenizer is shared with the router so issued tokens can be authorized there
+func NewAuthHandler(a *app.App, svc AuthService, mfaSvc MFAService, keySvc APIKeyService, tk *Tokenizer) *AuthHandler {
 	return &AuthHandler{
-		Tokenizer:   NewTokenizer(),
-		App:         a,
-		AuthService: svc,
+		Tokenizer:     tk,
+		App:           a,
+		AuthService:   svc,
+		MFAService:    mfaSvc,
+		APIKeyService: keySvc,
 	}
 }
 
@@ -74,8 +86,9 @@ func (ah *AuthHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	err := ah.AuthService.Login(req.Username, req.Password, req.Role, r.Context())
 	if err != nil {
 		// check for unauthorized
-		if errors.Is(err, errors.New("user not found")) || errors.Is(err, errors.New("invalid password")) {
-			log.Printf("internal server error during login for user %s: %v", req.Username, err)
+		if errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrInvalidPassword) {
+			log.Printf("failed login for user %s: %v", req.Username, err)
+			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid username/password"})
 			return
@@ -87,6 +100,35 @@ func (ah *AuthHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// password is correct, decide whether a second factor is needed
+	userID, err := ah.UserIDFromToken(req.Username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "an unexpected error occured"})
+		return
+	}
+
+	enabled, err := ah.MFAService.Status(userID, r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "an unexpected error occured"})
+		return
+	}
+
+	mustEnroll := !enabled && ah.adminMFARequired() && model.Role(req.Role) == model.ADMIN
+	if enabled || mustEnroll {
+		challenge, err := ah.Tokenizer.GenerateChallenge(req.Username)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to generate token"})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, LoginResponse{
+			MFAToken:           challenge,
+			MFARequired:        enabled,
+			EnrollmentRequired: mustEnroll,
+		})
+		return
+	}
+
 	// get token
 	access, refresh, err := ah.Tokenizer.GenerateToken(req.Username)
 	if err != nil {
@@ -100,6 +142,7 @@ func (ah *AuthHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(LoginResponse{Access: access, Refresh: refresh})
 }
 
+// admin only, the router guards the path
 func (ah *AuthHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	// decode body to json
 	var req RegisterRequest
@@ -116,16 +159,30 @@ func (ah *AuthHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	// update context with injected DB
 	r = r.WithContext(newCtx)
 
+	// the admin behind the session is recorded as the creator
+	_, adminID, err := ah.sessionUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "token unauthorized"})
+		return
+	}
+
 	// run register service
-	err := ah.AuthService.Register(req.Username, req.Password, string(req.UserRole), req.Salary, r.Context())
+	err = ah.AuthService.Register(adminID, req.Username, req.Password, string(req.UserRole), req.Department, req.Salary, r.Context())
 	if err != nil {
 		// check if user already exists
-		if errors.Is(err, errors.New("user already exists")) {
-			w.WriteHeader(http.StatusUnauthorized)
+		if errors.Is(err, ErrUserExists) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
 			json.NewEncoder(w).Encode(ErrorResponse{Error: "user already exists"})
 			return
 		}
 
+		// invalid input or password policy violation
+		if isProvisioningError(err) {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "an unexpected error occured"})
@@ -139,20 +196,42 @@ func (ah *AuthHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (ah *AuthHandler) UserIDFromToken(user string) (int64, error) {
+	userID, _, err := ah.UserIdentityFromToken(user)
+	return userID, err
+}
+
+// id and role of the token owner, used by the router for authorization
+func (ah *AuthHandler) UserIdentityFromToken(user string) (int64, model.Role, error) {
 	db := ah.App.DB
 	if db == nil {
-		return 0, errors.New("database connection not available")
+		return 0, "", errors.New("database connection not available")
 	}
 
 	var userID int64
-	query := `SELECT id FROM users WHERE username = $1`
-	err := db.QueryRow(query, user).Scan(&userID)
+	var role model.Role
+	query := `SELECT id, role FROM users WHERE username = $1`
+	err := db.QueryRow(query, user).Scan(&userID, &role)
 	if err == sql.ErrNoRows {
-		return 0, errors.New("user not found")
+		return 0, "", ErrUserNotFound
 	}
 	if err != nil {
-		return 0, fmt.Errorf("failed to query user ID: %w", err)
+		return 0, "", fmt.Errorf("failed to query user ID: %w", err)
 	}
 
-	return userID, nil
+	return userID, role, nil
+}
+
+// inject DB
+func (ah *AuthHandler) withDB(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), app.PQ, ah.App.DB))
+}
+
+func (ah *AuthHandler) adminMFARequired() bool {
+	return ah.App.Auth != nil && ah.App.Auth.AdminMFARequired
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
 }
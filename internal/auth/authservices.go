@@ -2,28 +2,68 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/achsanalfitra/gopayslip/hlp"
 	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/config"
 	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	ErrUserNotFound      = errors.New("user not found")
+	ErrInvalidPassword   = errors.New("invalid password")
+	ErrUserExists        = errors.New("user already exists")
+	ErrResetTokenInvalid = errors.New("reset token is invalid or expired")
+	ErrInviteInvalid     = errors.New("invitation is invalid or expired")
+	ErrInvalidRole       = errors.New("invalid user role")
+	ErrInvalidSalary     = errors.New("salary can't be negative")
+	ErrSalaryPrecision   = errors.New("salary can't have more than 2 decimals")
+	ErrInvalidUsername   = errors.New("username is required")
+	ErrAdminExists       = errors.New("an admin already exists")
+)
+
 type AuthService interface {
 	Login(user, pass, role string, ctx context.Context) error
-	Register(user, pass, role string, salary float64, ctx context.Context) error
+	Register(actorID int64, user, pass, role, department string, salary money.Decimal, ctx context.Context) error
+	Invite(actorID int64, user, role, department string, salary money.Decimal, ctx context.Context) (token string, expiresAt time.Time, err error)
+	AcceptInvite(token, pass string, ctx context.Context) (username string, err error)
+	BootstrapAdmin(user, pass string, ctx context.Context) error
+	ChangePassword(userID int64, current, next string, ctx context.Context) error
+	IssueResetToken(adminID int64, username string, ctx context.Context) (token string, expiresAt time.Time, err error)
+	ResetPassword(token, next string, ctx context.Context) (username string, err error)
 }
 
-type authServiceImpl struct{}
+type authServiceImpl struct {
+	auditor   audit.Writer
+	policy    *PasswordPolicy
+	cost      int
+	resetTTL  time.Duration
+	inviteTTL time.Duration
+}
 
-func NewAuthService() AuthService {
-	return &authServiceImpl{}
+func NewAuthService(cfg *config.Auth, auditor audit.Writer) AuthService {
+	return &authServiceImpl{
+		auditor:   auditor,
+		policy:    NewPasswordPolicy(cfg),
+		cost:      cfg.BcryptCost,
+		resetTTL:  cfg.PasswordResetTTL,
+		inviteTTL: cfg.InviteTTL,
+	}
 }
 
 func (s *authServiceImpl) Login(user, pass, role string, ctx context.Context) error {
+	var userID int64
 	var hashedPassword string
 
 	// connect to database
@@ -32,22 +72,49 @@ func (s *authServiceImpl) Login(user, pass, role string, ctx context.Context) er
 		return err
 	}
 
-	err = db.QueryRowContext(ctx, "SELECT password FROM users WHERE username=$1 and user_role=$2", user, role).Scan(&hashedPassword)
+	attempt := audit.LoginAttempt{Username: user, Role: model.Role(role), Method: loginMethodPassword}
+
+	err = db.QueryRowContext(ctx, "SELECT id, password FROM users WHERE username=$1 and role=$2 and activated_at IS NOT NULL", user, role).Scan(&userID, &hashedPassword)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return errors.New("user not found")
+			attempt.Reason = ErrUserNotFound.Error()
+			recordLoginFailure(s.auditor, 0, attempt, db, ctx)
+			return ErrUserNotFound
 		}
 		return err
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(pass)); err != nil {
-		return errors.New("invalid password")
+		attempt.Reason = ErrInvalidPassword.Error()
+		recordLoginFailure(s.auditor, userID, attempt, db, ctx)
+		return ErrInvalidPassword
+	}
+
+	if err := recordLogin(s.auditor, audit.EventLoginSucceeded, userID, attempt, db, ctx); err != nil {
+		return err
+	}
+
+	// the plain password is only available here, upgrade the hash when the configured cost went up
+	if cost, err := bcrypt.Cost([]byte(hashedPassword)); err == nil && cost < s.cost {
+		if err := s.rehash(userID, pass, hashedPassword, db, ctx); err != nil {
+			// not fatal, the old hash is still valid and we try again on the next login
+			log.Printf("failed to rehash password for user %s: %v", user, err)
+		}
 	}
 
 	return nil
 }
 
-func (s *authServiceImpl) Register(user, pass, role string, salary float64, ctx context.Context) error {
+// admin creates an active user directly, the admin is recorded as the creator
+func (s *authServiceImpl) Register(actorID int64, user, pass, role, department string, salary money.Decimal, ctx context.Context) error {
+	if err := validateProvisioning(user, role, salary); err != nil {
+		return err
+	}
+
+	if err := s.policy.Validate(pass); err != nil {
+		return err
+	}
+
 	// connect to database
 	db, err := hlp.GetDB(ctx, app.PQ)
 	if err != nil {
@@ -58,61 +125,311 @@ func (s *authServiceImpl) Register(user, pass, role string, salary float64, ctx
 	var tempID int64
 	err = db.QueryRowContext(ctx, "SELECT id FROM users WHERE username=$1", user).Scan(&tempID)
 	if err == nil {
-		return errors.New("user already exists")
+		return ErrUserExists
 	}
 	if !errors.Is(err, sql.ErrNoRows) {
 		return errors.New("database query error")
 	}
 
-	hashedPasswordBytes, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	hashedPasswordBytes, err := bcrypt.GenerateFromPassword([]byte(pass), s.cost)
 	if err != nil {
 		return err
 	}
 
-	hashedPassword := string(hashedPasswordBytes)
-
-	// this services asks the whole model
-
 	// populate the data
 	createdAt := time.Now()
 
-	var initialCreatedUpdatedBy int64 = 0
-
 	userToInsert := model.User{
-		Username:  user,
-		Password:  hashedPassword,
-		UserRole:  model.Role(role),
-		Salary:    salary,
-		CreatedAt: createdAt,
-		UpdatedAt: createdAt,
-		CreatedBy: initialCreatedUpdatedBy, // initial placeholder
-		UpdatedBy: initialCreatedUpdatedBy,
+		Username:    user,
+		Password:    string(hashedPasswordBytes),
+		UserRole:    model.Role(role),
+		Department:  strings.TrimSpace(department),
+		Salary:      salary,
+		CreatedAt:   createdAt,
+		UpdatedAt:   createdAt,
+		CreatedBy:   actorID,
+		UpdatedBy:   actorID,
+		ActivatedAt: sql.NullTime{Time: createdAt, Valid: true},
 	}
 
-	insertQuery := `INSERT INTO users (username, password, user_role, salary, created_at, updated_at, created_by, updated_by)
-                    VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
 
-	var newUserID int64
-	err = db.QueryRowContext(
-		ctx, insertQuery,
-		userToInsert.Username,
-		userToInsert.Password,
-		userToInsert.UserRole,
-		userToInsert.Salary,
-		userToInsert.CreatedAt,
-		userToInsert.UpdatedAt,
-		userToInsert.CreatedBy,
-		userToInsert.UpdatedBy,
-	).Scan(&newUserID)
+	userToInsert.ID, err = insertUser(userToInsert, tx, ctx)
+	if err != nil {
+		return err
+	}
 
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventUserRegistered,
+		RecordID:  userToInsert.ID,
+		NewData:   userToInsert,
+		ActorID:   actorID,
+	})
 	if err != nil {
-		return errors.New("failed to insert user")
+		return err
 	}
 
-	_, err = db.ExecContext(ctx, "UPDATE users SET created_by=$1, updated_by=$1 WHERE id=$2", newUserID, newUserID)
+	if err := tx.Commit(); err != nil {
+		return errors.New("commit failed")
+	}
+
+	return nil
+}
+
+func (s *authServiceImpl) ChangePassword(userID int64, current, next string, ctx context.Context) error {
+	if err := s.policy.Validate(next); err != nil {
+		return err
+	}
+
+	db, err := hlp.GetDB(ctx, app.PQ)
 	if err != nil {
-		return errors.New("failed to update created_by/updated_by for new user")
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	var hashedPassword string
+	err = tx.QueryRowContext(ctx, "SELECT password FROM users WHERE id=$1 FOR UPDATE", userID).Scan(&hashedPassword)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return errors.New("failed to query user password")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(current)); err != nil {
+		return ErrInvalidPassword
+	}
+
+	changedAt, err := s.setPassword(userID, userID, next, hashedPassword, tx, ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventPasswordChanged,
+		RecordID:  userID,
+		NewData:   audit.PasswordChange{ChangedAt: changedAt},
+		ActorID:   userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("commit failed")
 	}
 
 	return nil
 }
+
+// the plain token is only returned here, the admin hands it over out-of-band
+func (s *authServiceImpl) IssueResetToken(adminID int64, username string, ctx context.Context) (token string, expiresAt time.Time, err error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	var userID int64
+	// pending users set their first password through the invitation instead
+	err = db.QueryRowContext(ctx, "SELECT id FROM users WHERE username=$1 AND activated_at IS NOT NULL", username).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", time.Time{}, ErrUserNotFound
+	}
+	if err != nil {
+		return "", time.Time{}, errors.New("database query error")
+	}
+
+	token, tokenHash, err := newOneTimeToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expiresAt = now.Add(s.resetTTL)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", time.Time{}, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	// only the latest token is valid
+	if _, err := tx.ExecContext(ctx, "DELETE FROM password_reset WHERE user_id=$1 AND used_at IS NULL", userID); err != nil {
+		return "", time.Time{}, errors.New("failed to revoke previous reset tokens")
+	}
+
+	reset := model.PasswordReset{
+		UserID:    userID,
+		CreatedBy: adminID,
+		TokenHash: tokenHash,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+
+	insertQuery := `INSERT INTO password_reset (user_id, token_hash, expires_at, created_at, created_by) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	err = tx.QueryRowContext(ctx, insertQuery, reset.UserID, reset.TokenHash, reset.ExpiresAt, reset.CreatedAt, reset.CreatedBy).Scan(&reset.ID)
+	if err != nil {
+		return "", time.Time{}, errors.New("failed to insert reset token")
+	}
+
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventPasswordResetIssued,
+		RecordID:  reset.ID,
+		NewData:   reset,
+		ActorID:   adminID,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", time.Time{}, errors.New("commit failed")
+	}
+
+	return token, expiresAt, nil
+}
+
+func (s *authServiceImpl) ResetPassword(token, next string, ctx context.Context) (username string, err error) {
+	if err := s.policy.Validate(next); err != nil {
+		return "", err
+	}
+
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	// lock the token so it can't be redeemed twice concurrently
+	var resetID, userID int64
+	var hashedPassword string
+	query := `SELECT pr.id, u.id, u.username, u.password FROM password_reset pr JOIN users u ON u.id = pr.user_id
+              WHERE pr.token_hash=$1 AND pr.used_at IS NULL AND pr.expires_at > $2 FOR UPDATE OF pr`
+	err = tx.QueryRowContext(ctx, query, hashOneTimeToken(token), time.Now()).Scan(&resetID, &userID, &username, &hashedPassword)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrResetTokenInvalid
+	}
+	if err != nil {
+		return "", errors.New("failed to query reset token")
+	}
+
+	changedAt, err := s.setPassword(userID, userID, next, hashedPassword, tx, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE password_reset SET used_at=$1 WHERE id=$2", changedAt, resetID); err != nil {
+		return "", errors.New("failed to mark reset token as used")
+	}
+
+	// public path, the user redeeming the token is the actor
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventPasswordReset,
+		RecordID:  userID,
+		NewData:   audit.PasswordChange{ChangedAt: changedAt, ResetID: resetID},
+		ActorID:   userID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", errors.New("commit failed")
+	}
+
+	return username, nil
+}
+
+// helper for ChangePassword and ResetPassword, the old hash moves into the history
+func (s *authServiceImpl) setPassword(userID, actorID int64, next, oldHash string, tx *sql.Tx, ctx context.Context) (changedAt time.Time, err error) {
+	if err := s.policy.CheckReuse(userID, next, oldHash, tx, ctx); err != nil {
+		return time.Time{}, err
+	}
+
+	newHash, err := bcrypt.GenerateFromPassword([]byte(next), s.cost)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now()
+	if _, err := tx.ExecContext(ctx, "INSERT INTO password_history (user_id, password, created_at) VALUES ($1, $2, $3)", userID, oldHash, now); err != nil {
+		return time.Time{}, errors.New("failed to insert password history")
+	}
+
+	updateQuery := `UPDATE users SET password=$1, password_changed_at=$2, updated_at=$2, updated_by=$3 WHERE id=$4`
+	if _, err := tx.ExecContext(ctx, updateQuery, string(newHash), now, actorID, userID); err != nil {
+		return time.Time{}, errors.New("failed to update password")
+	}
+
+	return now, nil
+}
+
+// helper for Login, the hash only changes if nobody changed the password in between
+func (s *authServiceImpl) rehash(userID int64, pass, oldHash string, db *sql.DB, ctx context.Context) error {
+	newHash, err := bcrypt.GenerateFromPassword([]byte(pass), s.cost)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE users SET password=$1 WHERE id=$2 AND password=$3", string(newHash), userID, oldHash)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return nil
+	}
+
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventPasswordRehashed,
+		RecordID:  userID,
+		OldData:   audit.BcryptCost{Cost: oldCost(oldHash)},
+		NewData:   audit.BcryptCost{Cost: s.cost},
+		ActorID:   userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func oldCost(hash string) int {
+	cost, _ := bcrypt.Cost([]byte(hash))
+	return cost
+}
+
+// reset and invitation links share the same token format, only the hash is stored
+func newOneTimeToken() (token, hash string, err error) {
+	tokenBytes := make([]byte, tokenLength)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", "", errors.New("failed to generate one-time token")
+	}
+	token = hex.EncodeToString(tokenBytes)
+
+	return token, hashOneTimeToken(token), nil
+}
+
+func hashOneTimeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
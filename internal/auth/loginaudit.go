@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+)
+
+// login methods recorded in the audit payload
+const (
+	loginMethodPassword     = "password"
+	loginMethodTOTP         = "totp"
+	loginMethodRecoveryCode = "recovery_code"
+	loginMethodOIDC         = "oidc"
+)
+
+// logins don't change a row, so the entry gets a transaction of its own
+// userID is 0 when the username is unknown
+func recordLogin(auditor audit.Writer, ev model.EventType, userID int64, attempt audit.LoginAttempt, db *sql.DB, ctx context.Context) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	err = auditor.Write(ctx, tx, audit.Entry{
+		EventType: ev,
+		RecordID:  userID,
+		NewData:   attempt,
+		ActorID:   userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("commit failed")
+	}
+
+	return nil
+}
+
+// a failed attempt is rejected either way, losing its audit entry must not change the response
+func recordLoginFailure(auditor audit.Writer, userID int64, attempt audit.LoginAttempt, db *sql.DB, ctx context.Context) {
+	if err := recordLogin(auditor, audit.EventLoginFailed, userID, attempt, db, ctx); err != nil {
+		log.Printf("failed to audit failed login for %q: %v", attempt.Username, err)
+	}
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+type MFARequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"` // TOTP code or recovery code
+}
+
+type MFAConfirmResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	Access        string   `json:"access,omitempty"`
+	Refresh       string   `json:"refresh,omitempty"`
+}
+
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// second step of the login, the challenge is spent regardless of the outcome
+func (ah *AuthHandler) LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var req MFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	r = ah.withDB(r)
+
+	user, err := ah.Tokenizer.ConsumeChallenge(req.MFAToken)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "invalid or expired mfa token"})
+		return
+	}
+
+	userID, err := ah.UserIDFromToken(user)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "invalid or expired mfa token"})
+		return
+	}
+
+	if err := ah.MFAService.Verify(userID, req.Code, r.Context()); err != nil {
+		log.Printf("failed second factor for user %s: %v", user, err)
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "invalid two-factor code"})
+		return
+	}
+
+	access, refresh, err := ah.Tokenizer.GenerateToken(user)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to generate token"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, LoginResponse{Access: access, Refresh: refresh})
+}
+
+// forced enrollment, only reachable with a challenge issued because of the admin policy
+func (ah *AuthHandler) LoginEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	var req MFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	r = ah.withDB(r)
+
+	user, err := ah.Tokenizer.PeekChallenge(req.MFAToken)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "invalid or expired mfa token"})
+		return
+	}
+
+	ah.enroll(w, r, user)
+}
+
+// confirms the forced enrollment and starts the session in one go
+func (ah *AuthHandler) LoginConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	var req MFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	r = ah.withDB(r)
+
+	user, err := ah.Tokenizer.ConsumeChallenge(req.MFAToken)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "invalid or expired mfa token"})
+		return
+	}
+
+	userID, err := ah.UserIDFromToken(user)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "invalid or expired mfa token"})
+		return
+	}
+
+	codes, ok := ah.confirm(w, r, userID, req.Code)
+	if !ok {
+		return
+	}
+
+	access, refresh, err := ah.Tokenizer.GenerateToken(user)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to generate token"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, MFAConfirmResponse{RecoveryCodes: codes, Access: access, Refresh: refresh})
+}
+
+// voluntary enrollment for an authenticated session
+func (ah *AuthHandler) EnrollHandler(w http.ResponseWriter, r *http.Request) {
+	r = ah.withDB(r)
+
+	user, _, err := ah.sessionUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "token unauthorized"})
+		return
+	}
+
+	ah.enroll(w, r, user)
+}
+
+func (ah *AuthHandler) ConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	var req MFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	r = ah.withDB(r)
+
+	_, userID, err := ah.sessionUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "token unauthorized"})
+		return
+	}
+
+	codes, ok := ah.confirm(w, r, userID, req.Code)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, MFAConfirmResponse{RecoveryCodes: codes})
+}
+
+func (ah *AuthHandler) DisableHandler(w http.ResponseWriter, r *http.Request) {
+	var req MFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	r = ah.withDB(r)
+
+	_, userID, err := ah.sessionUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "token unauthorized"})
+		return
+	}
+
+	if err := ah.MFAService.Disable(userID, req.Code, r.Context()); err != nil {
+		if errors.Is(err, ErrMFAInvalidCode) || errors.Is(err, ErrMFANotEnrolled) {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "an unexpected error occured"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, MessageResponse{Message: "two-factor authentication disabled"})
+}
+
+// helper for the enroll handlers
+func (ah *AuthHandler) enroll(w http.ResponseWriter, r *http.Request, user string) {
+	userID, err := ah.UserIDFromToken(user)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "token unauthorized"})
+		return
+	}
+
+	enrollment, err := ah.MFAService.Enroll(userID, user, r.Context())
+	if err != nil {
+		if errors.Is(err, ErrMFAAlreadyEnabled) {
+			writeJSON(w, http.StatusConflict, ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "an unexpected error occured"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, enrollment)
+}
+
+// helper for the confirm handlers, writes the error response itself
+func (ah *AuthHandler) confirm(w http.ResponseWriter, r *http.Request, userID int64, code string) ([]string, bool) {
+	codes, err := ah.MFAService.Confirm(userID, code, r.Context())
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrMFAInvalidCode), errors.Is(err, ErrMFANoPendingSecret):
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		case errors.Is(err, ErrMFAAlreadyEnabled):
+			writeJSON(w, http.StatusConflict, ErrorResponse{Error: err.Error()})
+		default:
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "an unexpected error occured"})
+		}
+		return nil, false
+	}
+
+	return codes, true
+}
+
+// the router already authorized the token, resolve it back to the user
+func (ah *AuthHandler) sessionUser(r *http.Request) (user string, userID int64, err error) {
+	access, err := ah.Tokenizer.ReadToken(r)
+	if err != nil {
+		return "", 0, err
+	}
+
+	user, err = ah.Tokenizer.GetUserFromAccess(access)
+	if err != nil {
+		return "", 0, err
+	}
+
+	userID, err = ah.UserIDFromToken(user)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return user, userID, nil
+}
@@ -0,0 +1,350 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/hlp"
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+)
+
+var (
+	ErrMFAInvalidCode     = errors.New("invalid two-factor code")
+	ErrMFANotEnrolled     = errors.New("two-factor authentication is not enrolled")
+	ErrMFAAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrMFANoPendingSecret = errors.New("no pending two-factor enrollment")
+)
+
+const (
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10 // random bytes, 16 characters once encoded
+)
+
+type MFAService interface {
+	Status(userID int64, ctx context.Context) (enabled bool, err error)
+	Enroll(userID int64, account string, ctx context.Context) (Enrollment, error)
+	Confirm(userID int64, code string, ctx context.Context) (recoveryCodes []string, err error)
+	Verify(userID int64, code string, ctx context.Context) error
+	Disable(userID int64, code string, ctx context.Context) error
+}
+
+// returned once on enrollment, the secret is not shown again after confirmation
+type Enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type mfaServiceImpl struct {
+	auditor audit.Writer
+	issuer  string
+}
+
+func NewMFAService(issuer string, auditor audit.Writer) MFAService {
+	return &mfaServiceImpl{auditor: auditor, issuer: issuer}
+}
+
+func (s *mfaServiceImpl) Status(userID int64, ctx context.Context) (enabled bool, err error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return false, err
+	}
+
+	err = db.QueryRowContext(ctx, "SELECT totp_enabled FROM users WHERE id=$1", userID).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrUserNotFound
+	}
+	if err != nil {
+		return false, errors.New("failed to query two-factor status")
+	}
+
+	return enabled, nil
+}
+
+// stores a pending secret, 2FA is only enabled once Confirm succeeds
+func (s *mfaServiceImpl) Enroll(userID int64, account string, ctx context.Context) (Enrollment, error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return Enrollment{}, err
+	}
+
+	enabled, err := s.Status(userID, ctx)
+	if err != nil {
+		return Enrollment{}, err
+	}
+	if enabled {
+		return Enrollment{}, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return Enrollment{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Enrollment{}, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET totp_secret=$1, totp_last_step=0, updated_at=$2, updated_by=$3 WHERE id=$3", secret, time.Now(), userID)
+	if err != nil {
+		return Enrollment{}, errors.New("failed to store pending two-factor secret")
+	}
+
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventMFAEnrollmentStarted,
+		RecordID:  userID,
+		OldData:   audit.MFAState{},
+		NewData:   audit.MFAState{Pending: true},
+		ActorID:   userID,
+	})
+	if err != nil {
+		return Enrollment{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Enrollment{}, errors.New("commit failed")
+	}
+
+	return Enrollment{
+		Secret: secret,
+		URI:    OTPAuthURI(s.issuer, account, secret),
+	}, nil
+}
+
+func (s *mfaServiceImpl) Confirm(userID int64, code string, ctx context.Context) (recoveryCodes []string, err error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return nil, err
+	}
+
+	var secret sql.NullString
+	var enabled bool
+	err = db.QueryRowContext(ctx, "SELECT totp_secret, totp_enabled FROM users WHERE id=$1", userID).Scan(&secret, &enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, errors.New("failed to query two-factor secret")
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if !secret.Valid || secret.String == "" {
+		return nil, ErrMFANoPendingSecret
+	}
+
+	step, err := ValidateTOTP(secret.String, code, time.Now())
+	if err != nil {
+		return nil, ErrMFAInvalidCode
+	}
+
+	recoveryCodes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	// enabling and issuing recovery codes happen together or not at all
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, "UPDATE users SET totp_enabled=TRUE, totp_last_step=$1, updated_at=$2, updated_by=$3 WHERE id=$3", step, now, userID)
+	if err != nil {
+		return nil, errors.New("failed to enable two-factor authentication")
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_code WHERE user_id=$1", userID); err != nil {
+		return nil, errors.New("failed to clear previous recovery codes")
+	}
+
+	for _, hash := range hashes {
+		_, err := tx.ExecContext(ctx, "INSERT INTO recovery_code (user_id, code_hash, created_at) VALUES ($1, $2, $3)", userID, hash, now)
+		if err != nil {
+			return nil, errors.New("failed to store recovery codes")
+		}
+	}
+
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventMFAEnabled,
+		RecordID:  userID,
+		OldData:   audit.MFAState{Pending: true},
+		NewData:   audit.MFAState{Enabled: true, RecoveryCodes: len(hashes)},
+		ActorID:   userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.New("commit failed")
+	}
+
+	return recoveryCodes, nil
+}
+
+// accepts either a TOTP code or an unused recovery code, rejected codes are audited
+func (s *mfaServiceImpl) Verify(userID int64, code string, ctx context.Context) error {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return err
+	}
+
+	err = s.verify(userID, code, db, ctx)
+	if errors.Is(err, ErrMFAInvalidCode) {
+		method := loginMethodRecoveryCode
+		if len(strings.TrimSpace(code)) == totpDigits {
+			method = loginMethodTOTP
+		}
+		recordLoginFailure(s.auditor, userID, audit.LoginAttempt{Method: method, Reason: err.Error()}, db, ctx)
+	}
+
+	return err
+}
+
+func (s *mfaServiceImpl) verify(userID int64, code string, db *sql.DB, ctx context.Context) error {
+	var secret sql.NullString
+	var enabled bool
+	err := db.QueryRowContext(ctx, "SELECT totp_secret, totp_enabled FROM users WHERE id=$1", userID).Scan(&secret, &enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return errors.New("failed to query two-factor secret")
+	}
+	if !enabled || !secret.Valid {
+		return ErrMFANotEnrolled
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		step, err := ValidateTOTP(secret.String, code, time.Now())
+		if err != nil {
+			return ErrMFAInvalidCode
+		}
+
+		// a code can only be used once, the step has to move forward
+		// replay bookkeeping only, not audited
+		res, err := db.ExecContext(ctx, "UPDATE users SET totp_last_step=$1 WHERE id=$2 AND totp_last_step < $1", step, userID)
+		if err != nil {
+			return errors.New("failed to update two-factor step")
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			return ErrMFAInvalidCode
+		}
+
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	redeemed := model.RecoveryCode{UserID: userID, UsedAt: sql.NullTime{Time: time.Now(), Valid: true}}
+	query := `UPDATE recovery_code SET used_at=$1 WHERE user_id=$2 AND code_hash=$3 AND used_at IS NULL RETURNING id, created_at`
+	err = tx.QueryRowContext(ctx, query, redeemed.UsedAt, userID, hashRecoveryCode(code)).Scan(&redeemed.ID, &redeemed.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrMFAInvalidCode
+	}
+	if err != nil {
+		return errors.New("failed to redeem recovery code")
+	}
+
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventMFARecoveryCodeUsed,
+		RecordID:  redeemed.ID,
+		NewData:   redeemed,
+		ActorID:   userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("commit failed")
+	}
+
+	return nil
+}
+
+func (s *mfaServiceImpl) Disable(userID int64, code string, ctx context.Context) error {
+	// require a fresh second factor, a stolen session alone can't turn it off
+	if err := s.Verify(userID, code, ctx); err != nil {
+		return err
+	}
+
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET totp_enabled=FALSE, totp_secret=NULL, totp_last_step=0, updated_at=$1, updated_by=$2 WHERE id=$2", time.Now(), userID)
+	if err != nil {
+		return errors.New("failed to disable two-factor authentication")
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_code WHERE user_id=$1", userID); err != nil {
+		return errors.New("failed to clear recovery codes")
+	}
+
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventMFADisabled,
+		RecordID:  userID,
+		OldData:   audit.MFAState{Enabled: true},
+		NewData:   audit.MFAState{},
+		ActorID:   userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("commit failed")
+	}
+
+	return nil
+}
+
+// helper for Confirm, returns the plain codes for the user and the hashes for storage
+func generateRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, recoveryCodeLength)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, errors.New("failed to generate recovery codes")
+		}
+
+		raw := totpEncoding.EncodeToString(b)
+		code := raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// codes are random enough that a plain sha256 is sufficient
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+)
+
+// redirects the browser to the IdP, only registered when OIDC is enabled
+func (ah *AuthHandler) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := ah.AuthService.(OIDCService)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "oidc is not enabled"})
+		return
+	}
+
+	redirect, err := provider.AuthCodeURL(r.Context())
+	if err != nil {
+		log.Printf("failed to start oidc login: %v", err)
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: "identity provider unavailable"})
+		return
+	}
+
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// the IdP redirects back here, the session is issued like a regular login
+func (ah *AuthHandler) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := ah.AuthService.(OIDCService)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "oidc is not enabled"})
+		return
+	}
+
+	q := r.URL.Query()
+	if idpErr := q.Get("error"); idpErr != "" {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "identity provider denied the login: " + idpErr})
+		return
+	}
+
+	r = ah.withDB(r)
+
+	user, err := provider.Exchange(q.Get("state"), q.Get("code"), r.Context())
+	if err != nil {
+		log.Printf("oidc login failed: %v", err)
+		switch {
+		case errors.Is(err, ErrOIDCState), errors.Is(err, ErrOIDCIDToken), errors.Is(err, ErrOIDCExchange):
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "oidc login failed"})
+		case errors.Is(err, ErrOIDCNoRole), errors.Is(err, ErrOIDCUnknownUser):
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: err.Error()})
+		case errors.Is(err, ErrUserExists):
+			writeJSON(w, http.StatusConflict, ErrorResponse{Error: "a local user with this name already exists"})
+		default:
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "an unexpected error occured"})
+		}
+		return
+	}
+
+	access, refresh, err := ah.Tokenizer.GenerateToken(user)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to generate token"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, LoginResponse{Access: access, Refresh: refresh})
+}
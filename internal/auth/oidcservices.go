@@ -0,0 +1,539 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/hlp"
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/config"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
+)
+
+var (
+	ErrOIDCState       = errors.New("oidc state is invalid or expired")
+	ErrOIDCExchange    = errors.New("oidc code exchange failed")
+	ErrOIDCIDToken     = errors.New("oidc id token is invalid")
+	ErrOIDCNoRole      = errors.New("identity provider groups don't map to any role")
+	ErrOIDCUnknownUser = errors.New("no user is linked to this identity and provisioning is disabled")
+)
+
+const (
+	oidcStateTTL    = 10 * time.Minute
+	oidcClockSkew   = time.Minute
+	oidcHTTPTimeout = 10 * time.Second
+)
+
+// AuthService backed by an external IdP, password methods fall through to the bcrypt implementation
+type OIDCService interface {
+	AuthService
+	AuthCodeURL(ctx context.Context) (redirect string, err error)
+	Exchange(state, code string, ctx context.Context) (username string, err error)
+}
+
+// kept in-memory between the redirect and the callback, same trade-off as the Tokenizer
+type oidcPending struct {
+	verifier string
+	nonce    string
+	expiry   time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcAuthService struct {
+	AuthService // bcrypt fallback
+
+	auditor audit.Writer
+	cfg     *config.OIDC
+	client  *http.Client
+
+	mu        sync.Mutex
+	pending   map[string]oidcPending
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey
+}
+
+func NewOIDCAuthService(cfg *config.OIDC, fallback AuthService, auditor audit.Writer) OIDCService {
+	return &oidcAuthService{
+		AuthService: fallback,
+		auditor:     auditor,
+		cfg:         cfg,
+		client:      &http.Client{Timeout: oidcHTTPTimeout},
+		pending:     make(map[string]oidcPending),
+		keys:        make(map[string]crypto.PublicKey),
+	}
+}
+
+// authorization-code flow with PKCE (S256), state and nonce are single use
+func (s *oidcAuthService) AuthCodeURL(ctx context.Context) (redirect string, err error) {
+	disc, err := s.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomURLString(tokenLength)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomURLString(tokenLength)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomURLString(tokenLength)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	s.mu.Lock()
+	s.prunePending()
+	s.pending[state] = oidcPending{verifier: verifier, nonce: nonce, expiry: time.Now().Add(oidcStateTTL)}
+	s.mu.Unlock()
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", s.cfg.ClientID)
+	v.Set("redirect_uri", s.cfg.RedirectURL)
+	v.Set("scope", strings.Join(s.cfg.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	v.Set("code_challenge_method", "S256")
+
+	return disc.AuthorizationEndpoint + "?" + v.Encode(), nil
+}
+
+// redeems the code, verifies the ID token and resolves it to a users row
+func (s *oidcAuthService) Exchange(state, code string, ctx context.Context) (username string, err error) {
+	s.mu.Lock()
+	pending, ok := s.pending[state]
+	delete(s.pending, state)
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(pending.expiry) {
+		return "", ErrOIDCState
+	}
+
+	disc, err := s.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	idToken, err := s.redeemCode(disc, code, pending.verifier, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := s.verifyIDToken(idToken, pending.nonce, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return "", ErrOIDCIDToken
+	}
+
+	role, err := s.mapRole(claims)
+	if err != nil {
+		return "", err
+	}
+
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return "", err
+	}
+
+	userID, username, err := s.resolveUser(subject, claimString(claims, s.cfg.UsernameClaim), role, db, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	attempt := audit.LoginAttempt{Username: username, Role: role, Method: loginMethodOIDC}
+	if err := recordLogin(s.auditor, audit.EventLoginSucceeded, userID, attempt, db, ctx); err != nil {
+		return "", err
+	}
+
+	return username, nil
+}
+
+// helper for Exchange, the confidential client authenticates with basic auth when a secret is set
+func (s *oidcAuthService) redeemCode(disc *oidcDiscovery, code, verifier string, ctx context.Context) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", s.cfg.RedirectURL)
+	form.Set("client_id", s.cfg.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, disc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", ErrOIDCExchange
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if s.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(s.cfg.ClientID), url.QueryEscape(s.cfg.ClientSecret))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrOIDCExchange, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: token endpoint returned %d", ErrOIDCExchange, resp.StatusCode)
+	}
+
+	var tokenResp struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil || tokenResp.IDToken == "" {
+		return "", fmt.Errorf("%w: no id_token in response", ErrOIDCExchange)
+	}
+
+	return tokenResp.IDToken, nil
+}
+
+func (s *oidcAuthService) verifyIDToken(token, nonce string, ctx context.Context) (map[string]any, error) {
+	header, claims, signingInput, sig, err := parseJWT(token)
+	if err != nil {
+		return nil, ErrOIDCIDToken
+	}
+
+	key, err := s.getKey(header.Kid, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyJWTSignature(header.Alg, signingInput, sig, key); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCIDToken, err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != s.cfg.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrOIDCIDToken)
+	}
+
+	if !audienceContains(claims["aud"], s.cfg.ClientID) {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrOIDCIDToken)
+	}
+
+	now := time.Now()
+	exp, ok := claimTime(claims, "exp")
+	if !ok || now.After(exp.Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("%w: token expired", ErrOIDCIDToken)
+	}
+	if iat, ok := claimTime(claims, "iat"); ok && iat.After(now.Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("%w: token issued in the future", ErrOIDCIDToken)
+	}
+
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrOIDCIDToken)
+	}
+
+	return claims, nil
+}
+
+// admin groups win over employee groups, no match means no access
+func (s *oidcAuthService) mapRole(claims map[string]any) (model.Role, error) {
+	groups := claimStrings(claims[s.cfg.GroupsClaim])
+
+	for _, g := range groups {
+		if slices.Contains(s.cfg.AdminGroups, g) {
+			return model.ADMIN, nil
+		}
+	}
+
+	if len(s.cfg.EmployeeGroups) == 0 {
+		return model.EMPLOYEE, nil
+	}
+
+	for _, g := range groups {
+		if slices.Contains(s.cfg.EmployeeGroups, g) {
+			return model.EMPLOYEE, nil
+		}
+	}
+
+	return "", ErrOIDCNoRole
+}
+
+// looks up the linked user, keeps the role in sync with the IdP, creates it just-in-time if allowed
+func (s *oidcAuthService) resolveUser(subject, preferredName string, role model.Role, db *sql.DB, ctx context.Context) (userID int64, username string, err error) {
+	var currentRole model.Role
+	query := `SELECT id, username, role FROM users WHERE oidc_issuer=$1 AND oidc_subject=$2`
+	err = db.QueryRowContext(ctx, query, s.cfg.Issuer, subject).Scan(&userID, &username, &currentRole)
+	if err == nil {
+		if currentRole != role {
+			if err := s.syncRole(userID, currentRole, role, db, ctx); err != nil {
+				return 0, "", err
+			}
+		}
+		return userID, username, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, "", errors.New("database query error")
+	}
+
+	if !s.cfg.JITProvisioning {
+		return 0, "", ErrOIDCUnknownUser
+	}
+
+	// an existing local user with the same name is never linked implicitly
+	username = preferredName
+	if username == "" {
+		username = subject
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	var tempID int64
+	err = tx.QueryRowContext(ctx, "SELECT id FROM users WHERE username=$1", username).Scan(&tempID)
+	if err == nil {
+		return 0, "", ErrUserExists
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, "", errors.New("database query error")
+	}
+
+	// nobody created a JIT user, so it references itself like the bootstrap admin
+	now := time.Now()
+	newUser := model.User{
+		Username:    username,
+		Password:    "",
+		UserRole:    role,
+		Salary:      money.Decimal{}, // set by an admin afterwards
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		ActivatedAt: sql.NullTime{Time: now, Valid: true},
+	}
+
+	newUser.ID, err = insertSelfCreatedUser(newUser, tx, ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	newUser.CreatedBy, newUser.UpdatedBy = newUser.ID, newUser.ID
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET oidc_issuer=$1, oidc_subject=$2 WHERE id=$3", s.cfg.Issuer, subject, newUser.ID)
+	if err != nil {
+		return 0, "", errors.New("failed to link identity")
+	}
+
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventUserProvisionedByIdP,
+		RecordID:  newUser.ID,
+		NewData:   newUser,
+		ActorID:   newUser.ID,
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, "", errors.New("commit failed")
+	}
+
+	return newUser.ID, username, nil
+}
+
+// the IdP is the source of truth for roles, the user logging in is recorded as the actor
+func (s *oidcAuthService) syncRole(userID int64, oldRole, newRole model.Role, db *sql.DB, ctx context.Context) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET role=$1, updated_at=$2, updated_by=$3 WHERE id=$3", newRole, time.Now(), userID)
+	if err != nil {
+		return errors.New("failed to sync role from identity provider")
+	}
+
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventUserRoleSyncedByIdP,
+		RecordID:  userID,
+		OldData:   audit.RoleChange{Role: oldRole},
+		NewData:   audit.RoleChange{Role: newRole},
+		ActorID:   userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("commit failed")
+	}
+
+	return nil
+}
+
+// discovery document is fetched once and cached
+func (s *oidcAuthService) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	s.mu.Lock()
+	disc := s.discovery
+	s.mu.Unlock()
+
+	if disc != nil {
+		return disc, nil
+	}
+
+	var fetched oidcDiscovery
+	if err := s.getJSON(s.cfg.Issuer+"/.well-known/openid-configuration", &fetched, ctx); err != nil {
+		return nil, err
+	}
+
+	if fetched.Issuer != s.cfg.Issuer {
+		return nil, errors.New("oidc discovery issuer mismatch")
+	}
+
+	s.mu.Lock()
+	s.discovery = &fetched
+	s.mu.Unlock()
+
+	return &fetched, nil
+}
+
+// unknown kid triggers one JWKS refresh, this is how IdP key rotation is picked up
+func (s *oidcAuthService) getKey(kid string, ctx context.Context) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	key, ok := s.keys[kid]
+	s.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	disc, err := s.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var set jwks
+	if err := s.getJSON(disc.JWKSURI, &set, ctx); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown signing key", ErrOIDCIDToken)
+	}
+
+	return key, nil
+}
+
+func (s *oidcAuthService) getJSON(endpoint string, v any, ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("can't reach identity provider: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("identity provider returned %d for %s", resp.StatusCode, endpoint)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// caller must hold the lock
+func (s *oidcAuthService) prunePending() {
+	now := time.Now()
+	for state, p := range s.pending {
+		if now.After(p.expiry) {
+			delete(s.pending, state)
+		}
+	}
+}
+
+func randomURLString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.New("failed to generate random bytes")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// aud is either a string or an array of strings
+func audienceContains(aud any, clientID string) bool {
+	return slices.Contains(claimStrings(aud), clientID)
+}
+
+func claimStrings(v any) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		var out []string
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func claimString(claims map[string]any, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+func claimTime(claims map[string]any, name string) (time.Time, bool) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	sec, err := n.Int64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(sec, 0), true
+}
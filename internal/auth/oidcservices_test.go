@@ -0,0 +1,404 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/config"
+)
+
+const (
+	testClientID = "gopayslip"
+	testNonce    = "nonce-1"
+)
+
+// a local identity provider, it serves discovery, a JWKS and a token endpoint that checks PKCE
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+
+	mu         sync.Mutex
+	codes      map[string]mockGrant
+	jwksServed int
+}
+
+// what the IdP remembers about an authorization code
+type mockGrant struct {
+	challenge string
+	idToken   string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &mockIdP{t: t, rsa: rsaKey, ec: ecKey, codes: make(map[string]mockGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", idp.jwks)
+	mux.HandleFunc("POST /token", idp.token)
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+func (idp *mockIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	idp.jwksServed++
+	idp.mu.Unlock()
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	json.NewEncoder(w).Encode(jwks{Keys: []jwk{
+		{Kty: "RSA", Kid: "rsa-1", Use: "sig", N: b64(idp.rsa.N.Bytes()), E: b64(big.NewInt(int64(idp.rsa.E)).Bytes())},
+		{Kty: "EC", Kid: "ec-1", Use: "sig", Crv: "P-256", X: b64(idp.ec.X.FillBytes(make([]byte, 32))), Y: b64(idp.ec.Y.FillBytes(make([]byte, 32)))},
+		// encryption keys must never verify a signature
+		{Kty: "RSA", Kid: "enc-1", Use: "enc", N: b64(idp.rsa.N.Bytes()), E: b64(big.NewInt(int64(idp.rsa.E)).Bytes())},
+	}})
+}
+
+// RFC 7636, the verifier has to hash to the challenge the authorization request carried
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("client_id") != testClientID {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+
+	idp.mu.Lock()
+	grant, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"id_token": grant.idToken, "token_type": "Bearer"})
+}
+
+// a browser going through /authorize with this URL, the ID token is signed for the request's nonce
+func (idp *mockIdP) authorize(redirect, code string, idToken func(nonce string) string) (state string) {
+	idp.t.Helper()
+
+	u, err := url.Parse(redirect)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		idp.t.Fatalf("code_challenge_method = %q, want S256", q.Get("code_challenge_method"))
+	}
+
+	idp.mu.Lock()
+	idp.codes[code] = mockGrant{challenge: q.Get("code_challenge"), idToken: idToken(q.Get("nonce"))}
+	idp.mu.Unlock()
+
+	return q.Get("state")
+}
+
+func (idp *mockIdP) signed(alg, kid string) func(nonce string) string {
+	return func(nonce string) string { return idp.sign(alg, kid, idp.claims(nonce)) }
+}
+
+func (idp *mockIdP) claims(nonce string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":                idp.server.URL,
+		"aud":                testClientID,
+		"sub":                "subject-1",
+		"preferred_username": "alice",
+		"groups":             []string{"contractors"},
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              nonce,
+	}
+}
+
+// alg is written to the header as given, the key is picked to match it
+func (idp *mockIdP) sign(alg, kid string, claims map[string]any) string {
+	idp.t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch alg {
+	case "RS256":
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, idp.rsa, crypto.SHA256, digest[:]); err != nil {
+			idp.t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, idp.ec, digest[:])
+		if err != nil {
+			idp.t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case "HS256":
+		// the classic confusion, the published RSA key used as an HMAC secret
+		der, _ := x509.MarshalPKIXPublicKey(&idp.rsa.PublicKey)
+		mac := hmac.New(sha256.New, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case "none":
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newTestOIDC(idp *mockIdP) *oidcAuthService {
+	cfg := &config.OIDC{
+		Enabled:        true,
+		Issuer:         idp.server.URL,
+		ClientID:       testClientID,
+		RedirectURL:    "https://payroll.example/api/auth/oidc/callback",
+		Scopes:         []string{"openid"},
+		UsernameClaim:  "preferred_username",
+		GroupsClaim:    "groups",
+		AdminGroups:    []string{"payroll-admins"},
+		EmployeeGroups: []string{"staff"},
+	}
+	return NewOIDCAuthService(cfg, nil, nil).(*oidcAuthService)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := newMockIdP(t)
+	svc := newTestOIDC(idp)
+	ctx := context.Background()
+
+	with := func(key string, value any) map[string]any {
+		c := idp.claims(testNonce)
+		c[key] = value
+		return c
+	}
+	// flips a bit of the s half, the length stays right
+	tamperES := func(token string) string {
+		parts := strings.Split(token, ".")
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		sig[63] ^= 1
+		return parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+	// DER instead of the fixed r||s form the JWS spec requires
+	derES := func(token string) string {
+		parts := strings.Split(token, ".")
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		sig, err := ecdsa.SignASN1(rand.Reader, idp.ec, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"RS256", idp.sign("RS256", "rsa-1", idp.claims(testNonce)), nil},
+		{"ES256", idp.sign("ES256", "ec-1", idp.claims(testNonce)), nil},
+		{"audience list", idp.sign("RS256", "rsa-1", with("aud", []string{"other", testClientID})), nil},
+
+		{"alg none", idp.sign("none", "rsa-1", idp.claims(testNonce)), ErrJWTUnsupported},
+		{"HS256 with the public key", idp.sign("HS256", "rsa-1", idp.claims(testNonce)), ErrJWTUnsupported},
+		{"RS256 header on an EC key", strings.Replace(idp.sign("ES256", "ec-1", idp.claims(testNonce)), encodeHeader("ES256", "ec-1"), encodeHeader("RS256", "ec-1"), 1), ErrJWTBadSignature},
+		{"ES256 header on an RSA key", strings.Replace(idp.sign("RS256", "rsa-1", idp.claims(testNonce)), encodeHeader("RS256", "rsa-1"), encodeHeader("ES256", "rsa-1"), 1), ErrJWTBadSignature},
+		{"unknown kid", idp.sign("RS256", "rsa-2", idp.claims(testNonce)), ErrOIDCIDToken},
+		{"encryption key", idp.sign("RS256", "enc-1", idp.claims(testNonce)), ErrOIDCIDToken},
+		{"expired", idp.sign("RS256", "rsa-1", with("exp", time.Now().Add(-2*oidcClockSkew).Unix())), ErrOIDCIDToken},
+		{"no exp", idp.sign("RS256", "rsa-1", with("exp", nil)), ErrOIDCIDToken},
+		{"issued in the future", idp.sign("RS256", "rsa-1", with("iat", time.Now().Add(2*oidcClockSkew).Unix())), ErrOIDCIDToken},
+		{"wrong audience", idp.sign("RS256", "rsa-1", with("aud", "someone-else")), ErrOIDCIDToken},
+		{"wrong issuer", idp.sign("RS256", "rsa-1", with("iss", "https://evil.example")), ErrOIDCIDToken},
+		{"bad nonce", idp.sign("RS256", "rsa-1", with("nonce", "replayed")), ErrOIDCIDToken},
+		{"missing nonce", idp.sign("RS256", "rsa-1", with("nonce", nil)), ErrOIDCIDToken},
+		{"tampered claims", tamperClaims(idp.sign("RS256", "rsa-1", idp.claims(testNonce))), ErrJWTBadSignature},
+		{"tampered ES256 signature", tamperES(idp.sign("ES256", "ec-1", idp.claims(testNonce))), ErrJWTBadSignature},
+		{"DER ES256 signature", derES(idp.sign("ES256", "ec-1", idp.claims(testNonce))), ErrJWTBadSignature},
+		{"truncated ES256 signature", truncate(idp.sign("ES256", "ec-1", idp.claims(testNonce)), 4), ErrJWTBadSignature},
+		{"two segments", "eyJhbGciOiJSUzI1NiJ9.e30", ErrOIDCIDToken},
+		{"not base64", "!!.!!.!!", ErrOIDCIDToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := svc.verifyIDToken(tt.token, testNonce, ctx)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("verifyIDToken() error = %v", err)
+				}
+				if claims["sub"] != "subject-1" {
+					t.Fatalf("sub = %v, want subject-1", claims["sub"])
+				}
+				return
+			}
+			if !errors.Is(err, ErrOIDCIDToken) {
+				t.Fatalf("verifyIDToken() error = %v, want %v", err, ErrOIDCIDToken)
+			}
+			// the JWS errors are only in the message, verifyIDToken wraps them with %v
+			if tt.wantErr != ErrOIDCIDToken && !strings.Contains(err.Error(), tt.wantErr.Error()) {
+				t.Fatalf("verifyIDToken() error = %v, want it to mention %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// drops the last characters, three bytes of the signature for every four
+func truncate(token string, n int) string {
+	return token[:len(token)-n]
+}
+
+func encodeHeader(alg, kid string) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	return base64.RawURLEncoding.EncodeToString(header)
+}
+
+// swaps the subject, the signature no longer covers the claims
+func tamperClaims(token string) string {
+	parts := strings.Split(token, ".")
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	payload = []byte(strings.Replace(string(payload), "subject-1", "subject-2", 1))
+	return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+}
+
+// a rotated key is picked up with one JWKS fetch, known kids don't fetch again
+func TestVerifyIDTokenKeyRefresh(t *testing.T) {
+	idp := newMockIdP(t)
+	svc := newTestOIDC(idp)
+	ctx := context.Background()
+
+	for range 3 {
+		if _, err := svc.verifyIDToken(idp.sign("RS256", "rsa-1", idp.claims(testNonce)), testNonce, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := svc.verifyIDToken(idp.sign("RS256", "rsa-9", idp.claims(testNonce)), testNonce, ctx); !errors.Is(err, ErrOIDCIDToken) {
+		t.Fatalf("unknown kid error = %v", err)
+	}
+
+	idp.mu.Lock()
+	served := idp.jwksServed
+	idp.mu.Unlock()
+	if served != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", served)
+	}
+}
+
+// the whole redirect and callback, a valid login fails only at role mapping since the test has no database
+func TestExchange(t *testing.T) {
+	idp := newMockIdP(t)
+	svc := newTestOIDC(idp)
+	ctx := context.Background()
+
+	t.Run("valid", func(t *testing.T) {
+		redirect, err := svc.AuthCodeURL(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := idp.authorize(redirect, "code-valid", idp.signed("RS256", "rsa-1"))
+
+		if _, err := svc.Exchange(state, "code-valid", ctx); !errors.Is(err, ErrOIDCNoRole) {
+			t.Fatalf("Exchange() error = %v, want %v", err, ErrOIDCNoRole)
+		}
+	})
+
+	t.Run("PKCE mismatch", func(t *testing.T) {
+		first, err := svc.AuthCodeURL(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := svc.AuthCodeURL(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the code was issued for the first request, the callback carries the second request's state
+		idp.authorize(first, "code-stolen", idp.signed("RS256", "rsa-1"))
+		state := idp.authorize(second, "code-unused", idp.signed("RS256", "rsa-1"))
+
+		if _, err := svc.Exchange(state, "code-stolen", ctx); !errors.Is(err, ErrOIDCExchange) {
+			t.Fatalf("Exchange() error = %v, want %v", err, ErrOIDCExchange)
+		}
+	})
+
+	t.Run("nonce of another request", func(t *testing.T) {
+		first, err := svc.AuthCodeURL(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := svc.AuthCodeURL(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the IdP puts the first request's nonce into the token of the second
+		var firstNonce string
+		idp.authorize(first, "code-first", func(nonce string) string { firstNonce = nonce; return "" })
+		state := idp.authorize(second, "code-second", func(string) string { return idp.sign("ES256", "ec-1", idp.claims(firstNonce)) })
+
+		if _, err := svc.Exchange(state, "code-second", ctx); !errors.Is(err, ErrOIDCIDToken) {
+			t.Fatalf("Exchange() error = %v, want %v", err, ErrOIDCIDToken)
+		}
+	})
+
+	t.Run("state is single use", func(t *testing.T) {
+		redirect, err := svc.AuthCodeURL(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := idp.authorize(redirect, "code-once", idp.signed("RS256", "rsa-1"))
+
+		// the first attempt spends the state even though the IdP rejects it
+		svc.Exchange(state, "code-wrong", ctx)
+		if _, err := svc.Exchange(state, "code-once", ctx); !errors.Is(err, ErrOIDCState) {
+			t.Fatalf("Exchange() error = %v, want %v", err, ErrOIDCState)
+		}
+	})
+
+	t.Run("unknown state", func(t *testing.T) {
+		if _, err := svc.Exchange("forged", "code", ctx); !errors.Is(err, ErrOIDCState) {
+			t.Fatalf("Exchange() error = %v, want %v", err, ErrOIDCState)
+		}
+	})
+}
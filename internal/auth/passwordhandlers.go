@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ResetTokenRequest struct {
+	Username string `json:"username"`
+}
+
+type ResetTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// changing the password ends the session and every token issued to it, the caller gets a fresh pair
+func (ah *AuthHandler) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	r = ah.withDB(r)
+
+	user, userID, err := ah.sessionUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "token unauthorized"})
+		return
+	}
+
+	if err := ah.AuthService.ChangePassword(userID, req.CurrentPassword, req.NewPassword, r.Context()); err != nil {
+		ah.writePasswordError(w, err)
+		return
+	}
+
+	ah.Tokenizer.RevokeUser(user)
+
+	access, refresh, err := ah.Tokenizer.GenerateToken(user)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to generate token"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, LoginResponse{Access: access, Refresh: refresh})
+}
+
+// admin only, the router guards the path
+func (ah *AuthHandler) IssueResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req ResetTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	r = ah.withDB(r)
+
+	_, adminID, err := ah.sessionUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "token unauthorized"})
+		return
+	}
+
+	token, expiresAt, err := ah.AuthService.IssueResetToken(adminID, req.Username, r.Context())
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "an unexpected error occured"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ResetTokenResponse{Token: token, ExpiresAt: expiresAt})
+}
+
+// public, the one-time token is the credential
+func (ah *AuthHandler) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	r = ah.withDB(r)
+
+	user, err := ah.AuthService.ResetPassword(req.Token, req.NewPassword, r.Context())
+	if err != nil {
+		ah.writePasswordError(w, err)
+		return
+	}
+
+	ah.Tokenizer.RevokeUser(user)
+
+	writeJSON(w, http.StatusOK, MessageResponse{Message: "password has been reset"})
+}
+
+// helper for the password handlers, policy violations are the caller's fault
+func (ah *AuthHandler) writePasswordError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrPasswordTooShort), errors.Is(err, ErrPasswordTooLong),
+		errors.Is(err, ErrPasswordBreached), errors.Is(err, ErrPasswordReused):
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, ErrInvalidPassword):
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "invalid current password"})
+	case errors.Is(err, ErrResetTokenInvalid):
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "an unexpected error occured"})
+	}
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/achsanalfitra/gopayslip/internal/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordTooLong  = errors.New("password is too long")
+	ErrPasswordBreached = errors.New("password appears in a list of breached passwords")
+	ErrPasswordReused   = errors.New("password was used recently")
+)
+
+// bcrypt ignores everything after 72 bytes, reject instead of silently truncating
+const maxPasswordBytes = 72
+
+type PasswordPolicy struct {
+	minLength   int
+	historySize int
+	breached    map[string]struct{} // uppercase SHA-1 hex
+}
+
+func NewPasswordPolicy(cfg *config.Auth) *PasswordPolicy {
+	return &PasswordPolicy{
+		minLength:   cfg.PasswordMinLength,
+		historySize: cfg.PasswordHistorySize,
+		breached:    cfg.BreachedPasswords,
+	}
+}
+
+// stateless checks, reuse is checked separately because it needs the user's history
+func (p *PasswordPolicy) Validate(pass string) error {
+	if utf8.RuneCountInString(pass) < p.minLength || pass == "" {
+		return ErrPasswordTooShort
+	}
+
+	if len(pass) > maxPasswordBytes {
+		return ErrPasswordTooLong
+	}
+
+	if _, found := p.breached[sha1Hex(pass)]; found {
+		return ErrPasswordBreached
+	}
+
+	return nil
+}
+
+// compares against the current hash and the last historySize hashes
+func (p *PasswordPolicy) CheckReuse(userID int64, pass, currentHash string, db *sql.Tx, ctx context.Context) error {
+	if p.historySize == 0 {
+		return nil
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(currentHash), []byte(pass)) == nil {
+		return ErrPasswordReused
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT password FROM password_history WHERE user_id=$1 ORDER BY created_at DESC LIMIT $2", userID, p.historySize)
+	if err != nil {
+		return errors.New("failed to query password history")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return errors.New("failed to scan password history")
+		}
+
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil {
+			return ErrPasswordReused
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return errors.New("error during password history iteration")
+	}
+
+	return nil
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
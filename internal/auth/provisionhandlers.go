@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
+)
+
+type InviteRequest struct {
+	Salary     money.Decimal `json:"salary"`
+	Username   string        `json:"username"`
+	UserRole   model.Role    `json:"user_role"`
+	Department string        `json:"department"`
+}
+
+type InviteResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type AcceptInviteRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// admin only, the router guards the path
+func (ah *AuthHandler) InviteHandler(w http.ResponseWriter, r *http.Request) {
+	var req InviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	r = ah.withDB(r)
+
+	_, adminID, err := ah.sessionUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "token unauthorized"})
+		return
+	}
+
+	token, expiresAt, err := ah.AuthService.Invite(adminID, req.Username, string(req.UserRole), req.Department, req.Salary, r.Context())
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUserExists):
+			writeJSON(w, http.StatusConflict, ErrorResponse{Error: err.Error()})
+		case isProvisioningError(err):
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		default:
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "an unexpected error occured"})
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, InviteResponse{Token: token, ExpiresAt: expiresAt})
+}
+
+// public, the one-time invitation token is the credential
+func (ah *AuthHandler) AcceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	var req AcceptInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	r = ah.withDB(r)
+
+	user, err := ah.AuthService.AcceptInvite(req.Token, req.Password, r.Context())
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInviteInvalid):
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+		case isProvisioningError(err):
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		default:
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "an unexpected error occured"})
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, MessageResponse{Message: "account for " + user + " is activated"})
+}
+
+// errors caused by the request content rather than the server
+func isProvisioningError(err error) bool {
+	return errors.Is(err, ErrInvalidUsername) || errors.Is(err, ErrInvalidRole) || errors.Is(err, ErrInvalidSalary) || errors.Is(err, ErrSalaryPrecision) ||
+		errors.Is(err, ErrPasswordTooShort) || errors.Is(err, ErrPasswordTooLong) || errors.Is(err, ErrPasswordBreached)
+}
@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/hlp"
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// admin creates a pending user, the employee picks the password through the invitation token
+func (s *authServiceImpl) Invite(actorID int64, user, role, department string, salary money.Decimal, ctx context.Context) (token string, expiresAt time.Time, err error) {
+	if err := validateProvisioning(user, role, salary); err != nil {
+		return "", time.Time{}, err
+	}
+
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	token, tokenHash, err := newOneTimeToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", time.Time{}, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	var tempID int64
+	err = tx.QueryRowContext(ctx, "SELECT id FROM users WHERE username=$1", user).Scan(&tempID)
+	if err == nil {
+		return "", time.Time{}, ErrUserExists
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", time.Time{}, errors.New("database query error")
+	}
+
+	createdAt := time.Now()
+	expiresAt = createdAt.Add(s.inviteTTL)
+
+	// no usable password until the invitation is accepted, activated_at stays NULL
+	userToInsert := model.User{
+		Username:   user,
+		Password:   "",
+		UserRole:   model.Role(role),
+		Department: strings.TrimSpace(department),
+		Salary:     salary,
+		CreatedAt:  createdAt,
+		UpdatedAt:  createdAt,
+		CreatedBy:  actorID,
+		UpdatedBy:  actorID,
+	}
+
+	userToInsert.ID, err = insertUser(userToInsert, tx, ctx)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	insertQuery := `INSERT INTO user_invite (user_id, token_hash, expires_at, created_at, created_by) VALUES ($1, $2, $3, $4, $5)`
+	if _, err := tx.ExecContext(ctx, insertQuery, userToInsert.ID, tokenHash, expiresAt, createdAt, actorID); err != nil {
+		return "", time.Time{}, errors.New("failed to insert invitation")
+	}
+
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventUserInvited,
+		RecordID:  userToInsert.ID,
+		NewData:   userToInsert,
+		ActorID:   actorID,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", time.Time{}, errors.New("commit failed")
+	}
+
+	return token, expiresAt, nil
+}
+
+func (s *authServiceImpl) AcceptInvite(token, pass string, ctx context.Context) (username string, err error) {
+	if err := s.policy.Validate(pass); err != nil {
+		return "", err
+	}
+
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	// lock the invitation so it can't be accepted twice concurrently
+	var inviteID, userID int64
+	query := `SELECT ui.id, u.id, u.username FROM user_invite ui JOIN users u ON u.id = ui.user_id
+              WHERE ui.token_hash=$1 AND ui.used_at IS NULL AND ui.expires_at > $2 AND u.activated_at IS NULL FOR UPDATE OF ui`
+	err = tx.QueryRowContext(ctx, query, hashOneTimeToken(token), time.Now()).Scan(&inviteID, &userID, &username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrInviteInvalid
+	}
+	if err != nil {
+		return "", errors.New("failed to query invitation")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(pass), s.cost)
+	if err != nil {
+		return "", err
+	}
+
+	// the employee is the one setting the password, the creator stays the inviting admin
+	now := time.Now()
+	updateQuery := `UPDATE users SET password=$1, activated_at=$2, password_changed_at=$2, updated_at=$2, updated_by=$3 WHERE id=$3`
+	if _, err := tx.ExecContext(ctx, updateQuery, string(hashedPassword), now, userID); err != nil {
+		return "", errors.New("failed to activate user")
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE user_invite SET used_at=$1 WHERE id=$2", now, inviteID); err != nil {
+		return "", errors.New("failed to mark invitation as used")
+	}
+
+	// public path, the invited user is the actor
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventUserActivated,
+		RecordID:  userID,
+		OldData:   audit.Activation{},
+		NewData:   audit.Activation{ActivatedAt: sql.NullTime{Time: now, Valid: true}, InviteID: inviteID},
+		ActorID:   userID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", errors.New("commit failed")
+	}
+
+	return username, nil
+}
+
+// the very first admin has nobody to be created by, so the row references itself
+func (s *authServiceImpl) BootstrapAdmin(user, pass string, ctx context.Context) error {
+	if strings.TrimSpace(user) == "" {
+		return ErrInvalidUsername
+	}
+
+	if err := s.policy.Validate(pass); err != nil {
+		return err
+	}
+
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(pass), s.cost)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	// serialize concurrent bootstraps, only one can ever win
+	if _, err := tx.ExecContext(ctx, "LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return errors.New("failed to lock users table")
+	}
+
+	var adminCount int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE role=$1", model.ADMIN).Scan(&adminCount); err != nil {
+		return errors.New("database query error")
+	}
+	if adminCount > 0 {
+		return ErrAdminExists
+	}
+
+	now := time.Now()
+	admin := model.User{
+		Username:    user,
+		Password:    string(hashedPassword),
+		UserRole:    model.ADMIN,
+		Salary:      money.Decimal{},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		ActivatedAt: sql.NullTime{Time: now, Valid: true},
+	}
+
+	admin.ID, err = insertSelfCreatedUser(admin, tx, ctx)
+	if err != nil {
+		return err
+	}
+	admin.CreatedBy, admin.UpdatedBy = admin.ID, admin.ID
+
+	err = s.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventAdminBootstrapped,
+		RecordID:  admin.ID,
+		NewData:   admin,
+		ActorID:   admin.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("commit failed")
+	}
+
+	return nil
+}
+
+// shared by Register and Invite
+func insertUser(user model.User, db queryRower, ctx context.Context) (int64, error) {
+	insertQuery := `INSERT INTO users (username, password, role, salary, created_at, updated_at, created_by, updated_by, activated_at, department)
+                    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`
+
+	var newUserID int64
+	err := db.QueryRowContext(
+		ctx, insertQuery,
+		user.Username,
+		user.Password,
+		user.UserRole,
+		user.Salary,
+		user.CreatedAt,
+		user.UpdatedAt,
+		user.CreatedBy,
+		user.UpdatedBy,
+		user.ActivatedAt,
+		user.Department,
+	).Scan(&newUserID)
+
+	if err != nil {
+		return 0, errors.New("failed to insert user")
+	}
+
+	return newUserID, nil
+}
+
+// for users nobody created (bootstrap admin, just-in-time IdP users), created_by points at the row itself
+func insertSelfCreatedUser(user model.User, tx *sql.Tx, ctx context.Context) (int64, error) {
+	// reserve the id first so the foreign key is satisfied within the same statement
+	var newUserID int64
+	if err := tx.QueryRowContext(ctx, "SELECT nextval(pg_get_serial_sequence('users', 'id'))").Scan(&newUserID); err != nil {
+		return 0, errors.New("failed to reserve user id")
+	}
+
+	insertQuery := `INSERT INTO users (id, username, password, role, salary, created_at, updated_at, created_by, updated_by, activated_at)
+                    VALUES ($1, $2, $3, $4, $5, $6, $7, $1, $1, $8)`
+	_, err := tx.ExecContext(ctx, insertQuery,
+		newUserID,
+		user.Username,
+		user.Password,
+		user.UserRole,
+		user.Salary,
+		user.CreatedAt,
+		user.UpdatedAt,
+		user.ActivatedAt,
+	)
+	if err != nil {
+		return 0, errors.New("failed to insert user")
+	}
+
+	return newUserID, nil
+}
+
+// input checks shared by Register and Invite, role and salary come from the admin
+func validateProvisioning(user, role string, salary money.Decimal) error {
+	if strings.TrimSpace(user) == "" {
+		return ErrInvalidUsername
+	}
+
+	if model.Role(role) != model.ADMIN && model.Role(role) != model.EMPLOYEE {
+		return ErrInvalidRole
+	}
+
+	if salary.Sign() < 0 {
+		return ErrInvalidSalary
+	}
+
+	// the column would round it silently
+	if !salary.FitsScale(money.StorageScale) {
+		return ErrSalaryPrecision
+	}
+
+	return nil
+}
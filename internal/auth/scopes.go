@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// permission granted to an API key, keys get nothing that isn't listed
+type Scope string
+
+const (
+	ScopeAttendanceWrite    Scope = "attendance:write"
+	ScopeOvertimeWrite      Scope = "overtime:write"
+	ScopeReimbursementWrite Scope = "reimbursement:write"
+	ScopePayslipRead        Scope = "payslip:read"
+	ScopePayrollRead        Scope = "payroll:read"
+	ScopePayrollWrite       Scope = "payroll:write"
+	ScopeAuditRead          Scope = "audit:read"
+)
+
+var knownScopes = map[Scope]bool{
+	ScopeAttendanceWrite:    true,
+	ScopeOvertimeWrite:      true,
+	ScopeReimbursementWrite: true,
+	ScopePayslipRead:        true,
+	ScopePayrollRead:        true,
+	ScopePayrollWrite:       true,
+	ScopeAuditRead:          true,
+}
+
+// stored as a comma separated list
+func joinScopes(scopes []Scope) string {
+	s := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		s = append(s, string(scope))
+	}
+	return strings.Join(s, ",")
+}
+
+func splitScopes(s string) []Scope {
+	var scopes []Scope
+	for _, scope := range strings.Split(s, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, Scope(scope))
+		}
+	}
+	return scopes
+}
+
+func validateScopes(scopes []Scope) error {
+	if len(scopes) == 0 {
+		return ErrNoScopes
+	}
+
+	for _, scope := range scopes {
+		if !knownScopes[scope] {
+			return fmt.Errorf("%w: %s", ErrUnknownScope, scope)
+		}
+	}
+
+	return nil
+}
@@ -14,9 +14,10 @@ import (
 
 // reasonable
 const (
-	tokenLength = 32
-	accessTTL   = 15 * time.Minute
-	refreshTTL  = 7 * 24 * time.Hour
+	tokenLength  = 32
+	accessTTL    = 15 * time.Minute
+	refreshTTL   = 7 * 24 * time.Hour
+	challengeTTL = 5 * time.Minute // password verified, waiting for the second factor
 )
 
 // this has to be instantiated because it stores the token data)
@@ -25,8 +26,11 @@ type Tokenizer struct {
 	userRefreshTokens map[string]string
 	accessToUser      map[string]string
 	refreshToUser     map[string]string
+	refreshToAccess   map[string]string // the access token issued with each refresh token
 	accessExpiry      map[string]time.Time
 	refreshExpiry     map[string]time.Time
+	challengeToUser   map[string]string
+	challengeExpiry   map[string]time.Time
 }
 
 func NewTokenizer() *Tokenizer {
@@ -34,9 +38,12 @@ func NewTokenizer() *Tokenizer {
 		mu:                sync.RWMutex{},
 		accessToUser:      make(map[string]string),
 		refreshToUser:     make(map[string]string),
+		refreshToAccess:   make(map[string]string),
 		userRefreshTokens: make(map[string]string),
 		accessExpiry:      make(map[string]time.Time),
 		refreshExpiry:     make(map[string]time.Time),
+		challengeToUser:   make(map[string]string),
+		challengeExpiry:   make(map[string]time.Time),
 	}
 }
 
@@ -45,6 +52,11 @@ func (t *Tokenizer) GenerateToken(user string) (Access, Refresh string, err erro
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	return t.generateToken(user)
+}
+
+// caller must hold the write lock
+func (t *Tokenizer) generateToken(user string) (Access, Refresh string, err error) {
 	accessBytes, err := t.generateRandomBytes(tokenLength)
 	if err != nil {
 		return "", "", errors.New("failed to generate access token bytes")
@@ -59,7 +71,11 @@ func (t *Tokenizer) GenerateToken(user string) (Access, Refresh string, err erro
 	rTokenHash := sha256.Sum256(refreshBytes)
 	Refresh = hex.EncodeToString(rTokenHash[:])
 
+	// a user has one session, a new login ends the previous one
+	t.endSession(user)
+
 	t.userRefreshTokens[user] = Refresh
+	t.refreshToAccess[Refresh] = Access
 
 	t.accessExpiry[Access] = time.Now().Add(accessTTL)
 	t.refreshExpiry[Refresh] = time.Now().Add(refreshTTL)
@@ -71,8 +87,8 @@ func (t *Tokenizer) GenerateToken(user string) (Access, Refresh string, err erro
 }
 
 func (t *Tokenizer) AuthorizeToken(access string) error {
-	// lock read
-	t.mu.RLock()
+	// lock write, expired sessions are cleaned up here
+	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	// get user
@@ -95,10 +111,7 @@ func (t *Tokenizer) AuthorizeToken(access string) error {
 
 	refreshExpiry, ok := t.refreshExpiry[refresh]
 	if !ok || time.Now().After(refreshExpiry) {
-		delete(t.userRefreshTokens, user)
-		if ok {
-			delete(t.refreshExpiry, refresh)
-		}
+		t.endSession(user)
 		return errors.New("refresh token invalid or expired")
 	}
 
@@ -106,8 +119,8 @@ func (t *Tokenizer) AuthorizeToken(access string) error {
 }
 
 func (t *Tokenizer) RefreshToken(oldRefreshToken string) (Access, Refresh string, err error) {
-	// lock read
-	t.mu.RLock()
+	// lock write
+	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	// get user
@@ -126,15 +139,95 @@ func (t *Tokenizer) RefreshToken(oldRefreshToken string) (Access, Refresh string
 
 	if time.Now().After(expiry) {
 		// delete expired sessions
-		delete(t.userRefreshTokens, user)
-		delete(t.refreshExpiry, oldRefreshToken)
+		t.endSession(user)
 		return "", "", errors.New("refresh token expired")
 	}
 
+	// the access token issued with the old refresh token stops working too
+	return t.generateToken(user)
+}
+
+// ends the user's session, its access token stops working at once
+func (t *Tokenizer) RevokeUser(user string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.endSession(user)
+}
+
+// caller must hold the write lock
+func (t *Tokenizer) endSession(user string) {
+	refresh, ok := t.userRefreshTokens[user]
+	if !ok {
+		return
+	}
+
+	if access, ok := t.refreshToAccess[refresh]; ok {
+		delete(t.accessToUser, access)
+		delete(t.accessExpiry, access)
+	}
+	delete(t.refreshToAccess, refresh)
+	delete(t.refreshExpiry, refresh)
+	delete(t.refreshToUser, refresh)
 	delete(t.userRefreshTokens, user)
-	delete(t.refreshExpiry, oldRefreshToken)
+}
+
+// short-lived token proving the password step of a two-step login
+func (t *Tokenizer) GenerateChallenge(user string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	challengeBytes, err := t.generateRandomBytes(tokenLength)
+	if err != nil {
+		return "", errors.New("failed to generate challenge token bytes")
+	}
+	cTokenHash := sha256.Sum256(challengeBytes)
+	challenge := hex.EncodeToString(cTokenHash[:])
 
-	return t.GenerateToken(user)
+	t.challengeToUser[challenge] = user
+	t.challengeExpiry[challenge] = time.Now().Add(challengeTTL)
+
+	return challenge, nil
+}
+
+// returns the user behind a challenge without spending it, e.g. during forced enrollment
+func (t *Tokenizer) PeekChallenge(challenge string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.lookupChallenge(challenge)
+}
+
+// spends the challenge, it can't be used again
+func (t *Tokenizer) ConsumeChallenge(challenge string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	user, err := t.lookupChallenge(challenge)
+	if err != nil {
+		return "", err
+	}
+
+	delete(t.challengeToUser, challenge)
+	delete(t.challengeExpiry, challenge)
+
+	return user, nil
+}
+
+// caller must hold the write lock
+func (t *Tokenizer) lookupChallenge(challenge string) (string, error) {
+	user, ok := t.challengeToUser[challenge]
+	if !ok {
+		return "", errors.New("invalid challenge token")
+	}
+
+	if time.Now().After(t.challengeExpiry[challenge]) {
+		delete(t.challengeToUser, challenge)
+		delete(t.challengeExpiry, challenge)
+		return "", errors.New("challenge token expired")
+	}
+
+	return user, nil
 }
 
 func (t *Tokenizer) ReadToken(req *http.Request) (string, error) {
@@ -155,6 +248,9 @@ func (t *Tokenizer) ReadToken(req *http.Request) (string, error) {
 }
 
 func (t *Tokenizer) GetUserFromAccess(access string) (string, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	user, ok := t.accessToUser[access]
 	if !ok {
 		return "", errors.New("invalid token")
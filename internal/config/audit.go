@@ -0,0 +1,145 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Audit struct {
+	// signed chain checkpoints are optional, disabled unless AUDIT_CHECKPOINT_FILE is set
+	CheckpointFile     string
+	CheckpointInterval time.Duration
+	CheckpointKeyFile  string             // hex ed25519 seed, generate one with `audit keygen`
+	CheckpointKey      ed25519.PrivateKey // loaded from CheckpointKeyFile
+
+	// READ auditing of salary data
+	ReadEvents      []string // empty audits every read event, "none" disables them
+	ReadMode        string   // all, sample or dedupe
+	ReadSampleRate  float64  // share of reads written in sample mode
+	ReadDedupWindow time.Duration
+
+	// monthly partitions older than the hot window are archived to files and dropped
+	ArchiveDir        string // empty disables the retention job
+	HotMonths         int    // full months kept in the database besides the current one
+	PartitionsAhead   int    // future months that get their partition up front
+	RetentionYears    int    // how long archive files must be kept, recorded in the manifest
+	RetentionInterval time.Duration
+
+	// READ entries go through a queue backed by a local write-ahead file, mutations never do
+	AsyncWALDir         string // empty writes READ entries synchronously
+	AsyncInstance       string // keys the committed WAL position, must be unique per running instance
+	AsyncQueueSize      int
+	AsyncBatchSize      int
+	AsyncFlushInterval  time.Duration
+	AsyncEnqueueTimeout time.Duration // how long a read waits for queue space before writing synchronously
+}
+
+const (
+	ReadModeAll    = "all"
+	ReadModeSample = "sample"
+	ReadModeDedupe = "dedupe"
+)
+
+const (
+	defaultCheckpointInterval = time.Hour
+	defaultReadSampleRate     = 0.1
+	defaultReadDedupWindow    = 30 * time.Minute
+	defaultHotMonths          = 12
+	defaultPartitionsAhead    = 3
+	defaultRetentionYears     = 10
+	defaultRetentionInterval  = 24 * time.Hour
+	defaultAsyncQueueSize     = 10000
+	defaultAsyncBatchSize     = 500
+	defaultAsyncFlushInterval = time.Second
+	defaultAsyncEnqueueWait   = 50 * time.Millisecond
+)
+
+func InitAudit() (*Audit, error) {
+	audit := Audit{
+		CheckpointFile:     os.Getenv("AUDIT_CHECKPOINT_FILE"),
+		CheckpointInterval: envDuration("AUDIT_CHECKPOINT_INTERVAL", defaultCheckpointInterval),
+		CheckpointKeyFile:  os.Getenv("AUDIT_CHECKPOINT_KEY_FILE"),
+		ReadEvents:         envList("AUDIT_READ_EVENTS"),
+		ReadMode:           envString("AUDIT_READ_MODE", ReadModeDedupe),
+		ReadSampleRate:     defaultReadSampleRate,
+		ReadDedupWindow:    envDuration("AUDIT_READ_DEDUP_WINDOW", defaultReadDedupWindow),
+		ArchiveDir:         os.Getenv("AUDIT_ARCHIVE_DIR"),
+		HotMonths:          envInt("AUDIT_HOT_MONTHS", defaultHotMonths),
+		PartitionsAhead:    envInt("AUDIT_PARTITIONS_AHEAD", defaultPartitionsAhead),
+		RetentionYears:     envInt("AUDIT_RETENTION_YEARS", defaultRetentionYears),
+		RetentionInterval:  envDuration("AUDIT_RETENTION_INTERVAL", defaultRetentionInterval),
+
+		AsyncWALDir:         os.Getenv("AUDIT_ASYNC_WAL_DIR"),
+		AsyncInstance:       os.Getenv("AUDIT_ASYNC_INSTANCE"),
+		AsyncQueueSize:      envInt("AUDIT_ASYNC_QUEUE_SIZE", defaultAsyncQueueSize),
+		AsyncBatchSize:      envInt("AUDIT_ASYNC_BATCH_SIZE", defaultAsyncBatchSize),
+		AsyncFlushInterval:  envDuration("AUDIT_ASYNC_FLUSH_INTERVAL", defaultAsyncFlushInterval),
+		AsyncEnqueueTimeout: envDuration("AUDIT_ASYNC_ENQUEUE_TIMEOUT", defaultAsyncEnqueueWait),
+	}
+
+	if audit.AsyncInstance == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("AUDIT_ASYNC_INSTANCE is required when the hostname is unavailable")
+		}
+		audit.AsyncInstance = host
+	}
+	if audit.AsyncQueueSize < 1 || audit.AsyncBatchSize < 1 {
+		return nil, fmt.Errorf("AUDIT_ASYNC_QUEUE_SIZE and AUDIT_ASYNC_BATCH_SIZE must be at least 1")
+	}
+
+	if audit.HotMonths < 1 {
+		return nil, fmt.Errorf("AUDIT_HOT_MONTHS must be at least 1")
+	}
+	if audit.PartitionsAhead < 1 {
+		return nil, fmt.Errorf("AUDIT_PARTITIONS_AHEAD must be at least 1")
+	}
+
+	switch audit.ReadMode {
+	case ReadModeAll, ReadModeSample, ReadModeDedupe:
+	default:
+		return nil, fmt.Errorf("AUDIT_READ_MODE must be %s, %s or %s", ReadModeAll, ReadModeSample, ReadModeDedupe)
+	}
+
+	if v := os.Getenv("AUDIT_READ_SAMPLE_RATE"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("AUDIT_READ_SAMPLE_RATE must be between 0 and 1")
+		}
+		audit.ReadSampleRate = rate
+	}
+
+	if audit.CheckpointKeyFile == "" {
+		if audit.CheckpointFile != "" {
+			return nil, fmt.Errorf("AUDIT_CHECKPOINT_KEY_FILE is required when AUDIT_CHECKPOINT_FILE is set")
+		}
+		return &audit, nil
+	}
+
+	key, err := LoadCheckpointKey(audit.CheckpointKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	audit.CheckpointKey = key
+
+	return &audit, nil
+}
+
+func LoadCheckpointKey(path string) (ed25519.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read audit checkpoint key: %w", err)
+	}
+
+	seed, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("audit checkpoint key must be a hex encoded %d byte ed25519 seed", ed25519.SeedSize)
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
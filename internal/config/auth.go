@@ -0,0 +1,123 @@
+package config
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Auth struct {
+	AdminMFARequired bool   // policy switch, admins must enroll 2FA before they get a session
+	TOTPIssuer       string // shown in authenticator apps
+
+	// password policy
+	PasswordMinLength    int
+	PasswordHistorySize  int    // how many previous passwords can't be reused, 0 disables the check
+	PasswordBreachedList string // optional local file, one password or SHA-1 hash per line
+	BcryptCost           int    // raising it rehashes existing passwords on their next login
+	PasswordResetTTL     time.Duration
+	InviteTTL            time.Duration
+	BreachedPasswords    map[string]struct{} // uppercase SHA-1 hex, loaded from PasswordBreachedList
+}
+
+const (
+	defaultTOTPIssuer          = "gopayslip"
+	defaultPasswordMinLength   = 12
+	defaultPasswordHistorySize = 5
+	defaultPasswordResetTTL    = time.Hour
+	defaultInviteTTL           = 72 * time.Hour
+)
+
+// breached list lines can be HIBP style "SHA1:count" or a plain password
+var sha1Line = regexp.MustCompile(`^[0-9A-Fa-f]{40}(:\d+)?$`)
+
+func InitAuth() (*Auth, error) {
+	auth := Auth{
+		TOTPIssuer:           os.Getenv("AUTH_TOTP_ISSUER"),
+		PasswordBreachedList: os.Getenv("AUTH_PASSWORD_BREACHED_LIST"),
+		PasswordMinLength:    envInt("AUTH_PASSWORD_MIN_LENGTH", defaultPasswordMinLength),
+		PasswordHistorySize:  envInt("AUTH_PASSWORD_HISTORY_SIZE", defaultPasswordHistorySize),
+		BcryptCost:           envInt("AUTH_BCRYPT_COST", bcrypt.DefaultCost),
+		PasswordResetTTL:     envDuration("AUTH_PASSWORD_RESET_TTL", defaultPasswordResetTTL),
+		InviteTTL:            envDuration("AUTH_INVITE_TTL", defaultInviteTTL),
+		BreachedPasswords:    make(map[string]struct{}),
+	}
+
+	if auth.TOTPIssuer == "" {
+		auth.TOTPIssuer = defaultTOTPIssuer
+	}
+
+	// invalid value falls back to false, the policy is opt-in
+	auth.AdminMFARequired, _ = strconv.ParseBool(os.Getenv("AUTH_ADMIN_2FA_REQUIRED"))
+
+	// keep the cost within what bcrypt accepts
+	if auth.BcryptCost < bcrypt.MinCost || auth.BcryptCost > bcrypt.MaxCost {
+		auth.BcryptCost = bcrypt.DefaultCost
+	}
+
+	if err := auth.loadBreachedList(); err != nil {
+		return nil, err
+	}
+
+	return &auth, nil
+}
+
+// loaded once on start-up so checks stay in-memory and offline
+func (a *Auth) loadBreachedList() error {
+	if a.PasswordBreachedList == "" {
+		return nil
+	}
+
+	f, err := os.Open(a.PasswordBreachedList)
+	if err != nil {
+		return fmt.Errorf("can't open breached password list %s", a.PasswordBreachedList)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if sha1Line.MatchString(line) {
+			a.BreachedPasswords[strings.ToUpper(line[:40])] = struct{}{}
+			continue
+		}
+
+		sum := sha1.Sum([]byte(line))
+		a.BreachedPasswords[strings.ToUpper(hex.EncodeToString(sum[:]))] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed reading breached password list %s", a.PasswordBreachedList)
+	}
+
+	return nil
+}
+
+// helpers for optional numeric env, invalid values fall back to the default
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v < 0 {
+		return def
+	}
+	return v
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || v <= 0 {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// external identity provider, disabled unless OIDC_ISSUER is set
+type OIDC struct {
+	Enabled         bool
+	Issuer          string
+	ClientID        string
+	ClientSecret    string
+	RedirectURL     string
+	Scopes          []string
+	UsernameClaim   string
+	GroupsClaim     string
+	AdminGroups     []string
+	EmployeeGroups  []string // empty means every authenticated subject may be an employee
+	JITProvisioning bool     // create users on their first login
+}
+
+const (
+	defaultOIDCScopes        = "openid profile email"
+	defaultOIDCUsernameClaim = "preferred_username"
+	defaultOIDCGroupsClaim   = "groups"
+)
+
+func InitOIDC() (*OIDC, error) {
+	oidc := OIDC{
+		Issuer:         strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+		ClientID:       os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:   os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:    os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:         strings.Fields(envString("OIDC_SCOPES", defaultOIDCScopes)),
+		UsernameClaim:  envString("OIDC_USERNAME_CLAIM", defaultOIDCUsernameClaim),
+		GroupsClaim:    envString("OIDC_GROUPS_CLAIM", defaultOIDCGroupsClaim),
+		AdminGroups:    envList("OIDC_ADMIN_GROUPS"),
+		EmployeeGroups: envList("OIDC_EMPLOYEE_GROUPS"),
+	}
+
+	if oidc.Issuer == "" {
+		return &oidc, nil
+	}
+
+	if oidc.ClientID == "" || oidc.RedirectURL == "" {
+		return nil, fmt.Errorf("OIDC_ISSUER is set but OIDC_CLIENT_ID or OIDC_REDIRECT_URL is missing")
+	}
+
+	oidc.Enabled = true
+	oidc.JITProvisioning, _ = strconv.ParseBool(os.Getenv("OIDC_JIT_PROVISIONING"))
+
+	return &oidc, nil
+}
+
+// helpers for optional string env
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// comma separated, blanks are dropped
+func envList(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/achsanalfitra/gopayslip/internal/money"
+)
+
+// every amount is paid in one currency, salaries and reimbursements are stored in it
+type Payroll struct {
+	Currency money.Currency
+	Rounding money.Rounding
+
+	// a preview flags take-home pay that moved this much against the previous run, zero disables a threshold
+	PreviewDeltaPercent money.Decimal
+	PreviewDeltaAmount  money.Decimal
+
+	// goroutines computing payslips in a run, preview or summary
+	Workers int
+
+	// default export columns as "key,key:Header,...", empty exports every column
+	SummaryExportColumns string
+	PayslipExportColumns string
+
+	PDF          *PayslipPDF
+	Verification *PayslipVerification // nil when payslips carry no verification code
+}
+
+func InitPayroll() (*Payroll, error) {
+	currency, err := money.ParseCurrency(envString("PAYROLL_CURRENCY", "IDR"))
+	if err != nil {
+		return nil, fmt.Errorf("PAYROLL_CURRENCY: %w", err)
+	}
+
+	mode, err := money.ParseRoundingMode(envString("PAYROLL_ROUNDING_MODE", string(money.HalfEven)))
+	if err != nil {
+		return nil, fmt.Errorf("PAYROLL_ROUNDING_MODE must be %s or %s", money.HalfEven, money.HalfUp)
+	}
+
+	scope, err := money.ParseRoundingScope(envString("PAYROLL_ROUNDING_SCOPE", string(money.PerLine)))
+	if err != nil {
+		return nil, fmt.Errorf("PAYROLL_ROUNDING_SCOPE must be %s or %s", money.PerLine, money.PerTotal)
+	}
+
+	deltaPercent, err := envDecimal("PAYROLL_PREVIEW_DELTA_PERCENT", "10")
+	if err != nil {
+		return nil, err
+	}
+
+	deltaAmount, err := envDecimal("PAYROLL_PREVIEW_DELTA_AMOUNT", "0")
+	if err != nil {
+		return nil, err
+	}
+
+	// 0 or unset uses every CPU
+	workers := envInt("PAYROLL_WORKERS", 0)
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	payslipPDF, err := initPayslipPDF()
+	if err != nil {
+		return nil, err
+	}
+
+	verification, err := initPayslipVerification()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Payroll{
+		Currency:            currency,
+		Rounding:            money.Rounding{Mode: mode, Scope: scope},
+		PreviewDeltaPercent: deltaPercent,
+		PreviewDeltaAmount:  deltaAmount,
+		Workers:             workers,
+
+		SummaryExportColumns: envString("PAYROLL_EXPORT_SUMMARY_COLUMNS", ""),
+		PayslipExportColumns: envString("PAYROLL_EXPORT_PAYSLIP_COLUMNS", ""),
+
+		PDF:          payslipPDF,
+		Verification: verification,
+	}, nil
+}
+
+func envDecimal(key, def string) (money.Decimal, error) {
+	d, err := money.Parse(envString(key, def))
+	if err != nil || d.Sign() < 0 {
+		return money.Decimal{}, fmt.Errorf("%s must be a non-negative decimal", key)
+	}
+	return d, nil
+}
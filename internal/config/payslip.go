@@ -0,0 +1,235 @@
+package config
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/achsanalfitra/gopayslip/internal/pdf"
+)
+
+// how printed payslips look, loaded from the JSON file PAYSLIP_PDF_TEMPLATE points to
+type PayslipPDF struct {
+	CompanyName string
+	Address     []string
+	Logo        *pdf.Image // nil prints the header without one
+	Locale      string
+	Labels      map[string]string // every label of the locale with the template's overrides applied
+
+	// amounts are grouped and split with the locale's separators
+	ThousandsSeparator string
+	DecimalSeparator   string
+
+	// derives each employee's PDF password, nil leaves PDFs unprotected
+	PasswordKey []byte
+}
+
+// signed codes on issued payslips that anyone can check without logging in
+type PayslipVerification struct {
+	Keys     [][]byte // the first signs new codes, the others still verify codes signed before a rotation
+	URL      string   // the QR code opens this with ?code= appended, empty encodes the bare code
+	Disclose string   // how the public endpoint shows the take-home pay
+	// requests per minute from one client address, 0 leaves the public endpoint unthrottled
+	RateLimit int
+}
+
+const (
+	DiscloseAmount = "amount"
+	DiscloseHash   = "hash"
+)
+
+// the template file, the logo path is relative to the file
+type payslipTemplate struct {
+	CompanyName string            `json:"company_name"`
+	Address     []string          `json:"address"`
+	Logo        string            `json:"logo"`
+	Locale      string            `json:"locale"`
+	Labels      map[string]string `json:"labels"`
+}
+
+type payslipLocale struct {
+	thousands string
+	decimal   string
+	labels    map[string]string
+}
+
+// the labels a template may override, every locale defines all of them
+var payslipLocales = map[string]payslipLocale{
+	"en": {
+		thousands: ",",
+		decimal:   ".",
+		labels: map[string]string{
+			"title":             "Payslip",
+			"employee":          "Employee",
+			"department":        "Department",
+			"period":            "Period",
+			"payroll":           "Payroll",
+			"issued":            "Issued",
+			"attendance":        "Attendance",
+			"base_salary":       "Base salary",
+			"working_days":      "Working days",
+			"attended_days":     "Days attended",
+			"attendance_pay":    "Attendance pay",
+			"overtime":          "Overtime",
+			"hourly_rate":       "Hourly rate",
+			"date":              "Date",
+			"hours":             "Hours",
+			"rate":              "Rate",
+			"multiplier":        "Multiplier",
+			"amount":            "Amount",
+			"overtime_pay":      "Overtime pay",
+			"reimbursements":    "Reimbursements",
+			"description":       "Description",
+			"reimbursement_pay": "Reimbursement total",
+			"take_home_pay":     "Take-home pay",
+			"none":              "None",
+			"page":              "Page",
+			"verification":      "Verify this payslip",
+		},
+	},
+	"id": {
+		thousands: ".",
+		decimal:   ",",
+		labels: map[string]string{
+			"title":             "Slip Gaji",
+			"employee":          "Karyawan",
+			"department":        "Departemen",
+			"period":            "Periode",
+			"payroll":           "Penggajian",
+			"issued":            "Diterbitkan",
+			"attendance":        "Kehadiran",
+			"base_salary":       "Gaji pokok",
+			"working_days":      "Hari kerja",
+			"attended_days":     "Hari hadir",
+			"attendance_pay":    "Gaji kehadiran",
+			"overtime":          "Lembur",
+			"hourly_rate":       "Tarif per jam",
+			"date":              "Tanggal",
+			"hours":             "Jam",
+			"rate":              "Tarif",
+			"multiplier":        "Pengali",
+			"amount":            "Jumlah",
+			"overtime_pay":      "Upah lembur",
+			"reimbursements":    "Penggantian biaya",
+			"description":       "Keterangan",
+			"reimbursement_pay": "Total penggantian biaya",
+			"take_home_pay":     "Gaji bersih",
+			"none":              "Tidak ada",
+			"page":              "Halaman",
+			"verification":      "Verifikasi slip gaji ini",
+		},
+	},
+}
+
+func initPayslipPDF() (*PayslipPDF, error) {
+	tmpl := payslipTemplate{Locale: "en"}
+
+	path := envString("PAYSLIP_PDF_TEMPLATE", "")
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("can't read payslip template: %w", err)
+		}
+		if err := json.Unmarshal(raw, &tmpl); err != nil {
+			return nil, fmt.Errorf("payslip template is not valid JSON: %w", err)
+		}
+	}
+
+	if tmpl.Locale == "" {
+		tmpl.Locale = "en"
+	}
+	locale, ok := payslipLocales[tmpl.Locale]
+	if !ok {
+		return nil, fmt.Errorf("payslip template locale %q is not one of en, id", tmpl.Locale)
+	}
+
+	labels := make(map[string]string, len(locale.labels))
+	for k, v := range locale.labels {
+		labels[k] = v
+	}
+	// a typo would silently keep the default, so unknown keys are refused
+	for k, v := range tmpl.Labels {
+		if _, ok := labels[k]; !ok {
+			return nil, fmt.Errorf("payslip template has an unknown label %q", k)
+		}
+		labels[k] = v
+	}
+
+	p := &PayslipPDF{
+		CompanyName:        tmpl.CompanyName,
+		Address:            tmpl.Address,
+		Locale:             tmpl.Locale,
+		Labels:             labels,
+		ThousandsSeparator: locale.thousands,
+		DecimalSeparator:   locale.decimal,
+	}
+
+	if tmpl.Logo != "" {
+		logo := tmpl.Logo
+		if !filepath.IsAbs(logo) {
+			logo = filepath.Join(filepath.Dir(path), logo)
+		}
+		raw, err := os.ReadFile(logo)
+		if err != nil {
+			return nil, fmt.Errorf("can't read payslip logo: %w", err)
+		}
+		if p.Logo, err = pdf.LoadImage(raw); err != nil {
+			return nil, fmt.Errorf("payslip logo: %w", err)
+		}
+	}
+
+	if keyFile := envString("PAYSLIP_PDF_PASSWORD_KEY_FILE", ""); keyFile != "" {
+		key, err := readHexKey(keyFile, "payslip password key")
+		if err != nil {
+			return nil, err
+		}
+		p.PasswordKey = key
+	}
+
+	return p, nil
+}
+
+// nil unless PAYSLIP_VERIFY_KEY_FILES is set
+func initPayslipVerification() (*PayslipVerification, error) {
+	files := envList("PAYSLIP_VERIFY_KEY_FILES")
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	v := &PayslipVerification{
+		URL:       envString("PAYSLIP_VERIFY_URL", ""),
+		Disclose:  envString("PAYSLIP_VERIFY_DISCLOSE", DiscloseAmount),
+		RateLimit: envInt("PAYSLIP_VERIFY_RATE_LIMIT", 30),
+	}
+	if v.Disclose != DiscloseAmount && v.Disclose != DiscloseHash {
+		return nil, fmt.Errorf("PAYSLIP_VERIFY_DISCLOSE must be %s or %s", DiscloseAmount, DiscloseHash)
+	}
+	if v.RateLimit < 0 {
+		return nil, fmt.Errorf("PAYSLIP_VERIFY_RATE_LIMIT must not be negative")
+	}
+
+	for _, f := range files {
+		key, err := readHexKey(f, "payslip verification key")
+		if err != nil {
+			return nil, err
+		}
+		v.Keys = append(v.Keys, key)
+	}
+
+	return v, nil
+}
+
+func readHexKey(path, what string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read %s: %w", what, err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(key) < 32 {
+		return nil, fmt.Errorf("%s must be at least 32 hex encoded bytes", what)
+	}
+	return key, nil
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// the forwarding headers a proxy may set, only the configured one is read
+const (
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderForwarded     = "Forwarded"
+)
+
+// forwarding headers are only believed when the peer is one of these, empty trusts nobody
+// Header is the one the proxies set, a client can send the other one straight through them
+type Proxy struct {
+	Trusted []netip.Prefix
+	Header  string
+}
+
+// TRUSTED_PROXIES is a comma separated list of CIDRs, bare addresses are single hosts
+// TRUSTED_PROXY_HEADER is X-Forwarded-For or Forwarded
+func InitProxy() (*Proxy, error) {
+	var proxy Proxy
+
+	switch header := http.CanonicalHeaderKey(envString("TRUSTED_PROXY_HEADER", HeaderXForwardedFor)); header {
+	case HeaderXForwardedFor, HeaderForwarded:
+		proxy.Header = header
+	default:
+		return nil, fmt.Errorf("TRUSTED_PROXY_HEADER must be %s or %s", HeaderXForwardedFor, HeaderForwarded)
+	}
+
+	for _, v := range envList("TRUSTED_PROXIES") {
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: invalid address %s", v)
+			}
+			addr = addr.Unmap()
+			proxy.Trusted = append(proxy.Trusted, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: invalid CIDR %s", v)
+		}
+		// 4-in-6 prefixes would never match the unmapped peer addresses
+		if prefix.Addr().Is4In6() {
+			if prefix.Bits() < 96 {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: invalid CIDR %s", v)
+			}
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		proxy.Trusted = append(proxy.Trusted, prefix.Masked())
+	}
+
+	return &proxy, nil
+}
+
+func (p *Proxy) IsTrusted(addr netip.Addr) bool {
+	if p == nil {
+		return false
+	}
+
+	addr = addr.Unmap()
+	for _, prefix := range p.Trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
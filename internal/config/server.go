@@ -3,23 +3,33 @@ package config
 import (
 	"log"
 	"net/http"
+	"os"
 	"time"
 )
 
 type Server struct {
 	Addr   string
-	Router *http.ServeMux
+	Router http.Handler
 	Srv    *http.Server
 }
 
 const (
+	DefaultAddr    = ":8080"
 	ReadTimeout    = 10 * time.Second
 	WriteTimeout   = 10 * time.Second
 	MaxHeaderBytes = 1 << 20
 )
 
+// SERVER_ADDR is optional, defaults to DefaultAddr
+func ServerAddr() string {
+	if addr := os.Getenv("SERVER_ADDR"); addr != "" {
+		return addr
+	}
+	return DefaultAddr
+}
+
 // custom server building
-func CreateServer(addr string, rtr *http.ServeMux) *Server {
+func CreateServer(addr string, rtr http.Handler) *Server {
 	srv := Server{
 		Addr:   addr,
 		Router: rtr,
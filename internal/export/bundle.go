@@ -0,0 +1,101 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var ErrDuplicateFile = errors.New("a file with this name is already in the bundle")
+
+// the checksums of every file in a bundle, `sha256sum -c SHA256SUMS` checks them
+const manifestName = "SHA256SUMS"
+
+// collects many files, Close writes the manifest
+type Bundle interface {
+	Add(f File) error
+	Close() error
+	Kind() string // dir or zip
+}
+
+type manifest struct {
+	buf   bytes.Buffer
+	names map[string]bool
+}
+
+func (m *manifest) add(f File) error {
+	if m.names == nil {
+		m.names = map[string]bool{}
+	}
+	if m.names[f.Name] || f.Name == manifestName {
+		return ErrDuplicateFile
+	}
+	m.names[f.Name] = true
+	m.buf.Write(f.Sidecar())
+	return nil
+}
+
+type dirBundle struct {
+	dir string
+	manifest
+}
+
+// the directory is created when missing, existing files of the same name are replaced
+func NewDirBundle(dir string) (Bundle, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &dirBundle{dir: dir}, nil
+}
+
+func (b *dirBundle) Add(f File) error {
+	if err := b.add(f); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(b.dir, f.Name), f.Data, 0o600)
+}
+
+func (b *dirBundle) Close() error {
+	return os.WriteFile(filepath.Join(b.dir, manifestName), b.buf.Bytes(), 0o600)
+}
+
+func (b *dirBundle) Kind() string { return "dir" }
+
+type zipBundle struct {
+	zw *zip.Writer
+	manifest
+}
+
+// the caller closes w after the bundle
+func NewZipBundle(w io.Writer) Bundle {
+	return &zipBundle{zw: zip.NewWriter(w)}
+}
+
+func (b *zipBundle) Add(f File) error {
+	if err := b.add(f); err != nil {
+		return err
+	}
+	return b.write(f.Name, f.Data)
+}
+
+func (b *zipBundle) Close() error {
+	if err := b.write(manifestName, b.buf.Bytes()); err != nil {
+		return err
+	}
+	return b.zw.Close()
+}
+
+func (b *zipBundle) Kind() string { return "zip" }
+
+// the same fixed time as the XLSX parts so archive bytes only depend on the files
+func (b *zipBundle) write(name string, data []byte) error {
+	w, err := b.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: zipEpoch})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
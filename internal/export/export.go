@@ -0,0 +1,173 @@
+package export
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown export format")
+	ErrUnknownColumn = errors.New("unknown export column")
+)
+
+type Format string
+
+const (
+	CSV  Format = "csv"
+	XLSX Format = "xlsx"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(strings.TrimSpace(s))); f {
+	case CSV, XLSX:
+		return f, nil
+	case "":
+		return CSV, nil
+	}
+	return "", fmt.Errorf("%w %q", ErrUnknownFormat, s)
+}
+
+func (f Format) ContentType() string {
+	if f == XLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv"
+}
+
+// Key is what the data is looked up by, Header is what the file shows
+type Column struct {
+	Key    string
+	Header string
+}
+
+// Numeric cells are written as numbers in XLSX, CSV writes every cell as text
+type Cell struct {
+	Value   string
+	Numeric bool
+}
+
+func Text(s string) Cell   { return Cell{Value: s} }
+func Number(s string) Cell { return Cell{Value: s, Numeric: true} }
+
+// spreadsheets run text starting with = + - @ as a formula, the quote makes them show it as typed
+func CSVText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+type Row interface {
+	Cell(key string) Cell
+}
+
+// "key,key:Header,..." picks and orders the columns, an empty spec keeps every available column
+func ParseColumns(spec string, available []Column) ([]Column, error) {
+	if strings.TrimSpace(spec) == "" {
+		return available, nil
+	}
+
+	headers := make(map[string]string, len(available))
+	for _, c := range available {
+		headers[c.Key] = c.Header
+	}
+
+	var columns []Column
+	for _, part := range strings.Split(spec, ",") {
+		key, header, renamed := strings.Cut(strings.TrimSpace(part), ":")
+		key = strings.TrimSpace(key)
+		def, ok := headers[key]
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownColumn, key)
+		}
+		if !renamed || strings.TrimSpace(header) == "" {
+			header = def
+		}
+		columns = append(columns, Column{Key: key, Header: strings.TrimSpace(header)})
+	}
+
+	return columns, nil
+}
+
+// a rendered export and its checksum, the same data always renders to the same bytes
+type File struct {
+	Name        string
+	ContentType string
+	Data        []byte
+	SHA256      string // hex
+}
+
+func Render(name, sheet string, format Format, columns []Column, rows []Row) (File, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case CSV:
+		err = writeCSV(&buf, columns, rows)
+	case XLSX:
+		err = writeXLSX(&buf, sheet, columns, rows)
+	default:
+		err = fmt.Errorf("%w %q", ErrUnknownFormat, format)
+	}
+	if err != nil {
+		return File{}, err
+	}
+
+	return NewFile(name+"."+string(format), format.ContentType(), buf.Bytes()), nil
+}
+
+// wraps files rendered elsewhere, such as PDFs, so they're checksummed the same way
+func NewFile(name, contentType string, data []byte) File {
+	sum := sha256.Sum256(data)
+	return File{
+		Name:        name,
+		ContentType: contentType,
+		Data:        data,
+		SHA256:      hex.EncodeToString(sum[:]),
+	}
+}
+
+// sha256sum format, `sha256sum -c` checks the file next to it
+func (f File) Sidecar() []byte {
+	return []byte(f.SHA256 + "  " + f.Name + "\n")
+}
+
+// RFC 9530 Content-Digest value
+func (f File) Digest() string {
+	sum, _ := hex.DecodeString(f.SHA256)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum) + ":"
+}
+
+func writeCSV(buf *bytes.Buffer, columns []Column, rows []Row) error {
+	w := csv.NewWriter(buf)
+
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.Header
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, c := range columns {
+			cell := row.Cell(c.Key)
+			record[i] = cell.Value
+			if !cell.Numeric {
+				record[i] = CSVText(cell.Value)
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/export"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/services/admin"
+	"github.com/google/uuid"
+)
+
+// flush the export every this many rows so clients see progress
+const exportFlushEvery = 500
+
+// the server's WriteTimeout would cut a long export off, each flush buys the stream this much more
+const exportWriteWindow = time.Minute
+
+// a client that stops reading still times out, just one window after its last flush
+func extendWriteDeadline(rc *http.ResponseController) {
+	rc.SetWriteDeadline(time.Now().Add(exportWriteWindow))
+}
+
+// payloads are embedded as JSON instead of escaped strings
+type AuditLogResponse struct {
+	ID               int64            `json:"id"`
+	RequestID        uuid.UUID        `json:"request_id"`
+	CreatedAt        time.Time        `json:"created_at"`
+	EventType        model.EventType  `json:"event_type"`
+	ActionType       model.ActionType `json:"action_type"`
+	AffectedTable    model.Table      `json:"affected_table"`
+	AffectedRecordID int64            `json:"affected_record_id,omitempty"`
+	CreatedBy        int64            `json:"created_by,omitempty"`
+	IPAddress        string           `json:"ip_address,omitempty"`
+	OldData          json.RawMessage  `json:"old_data,omitempty"`
+	NewData          json.RawMessage  `json:"new_data,omitempty"`
+	PrevHash         string           `json:"prev_hash"`
+	RowHash          string           `json:"row_hash"`
+}
+
+type AuditPageResponse struct {
+	Entries    []AuditLogResponse `json:"entries"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+var auditCSVHeader = []string{"id", "created_at", "request_id", "event_type", "action_type", "affected_table", "affected_record_id", "created_by", "ip_address", "old_data", "new_data", "prev_hash", "row_hash"}
+
+type AuditHandler struct {
+	AuditService admin.Audit
+	App          *app.App
+}
+
+func NewAuditHandler(auditSvc admin.Audit, a *app.App) *AuditHandler {
+	return &AuditHandler{
+		AuditService: auditSvc,
+		App:          a,
+	}
+}
+
+// lists every registered event with its action, table and payload schemas
+func (h *AuditHandler) CatalogueHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"events": audit.Catalogue()})
+}
+
+func (h *AuditHandler) QueryHandler(w http.ResponseWriter, r *http.Request) {
+	// inject DB
+	r = r.WithContext(context.WithValue(r.Context(), app.PQ, h.App.DB))
+
+	filter, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.AuditService.Query(filter, r.Context())
+	if errors.Is(err, admin.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to query audit log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	resp := AuditPageResponse{Entries: make([]AuditLogResponse, 0, len(page.Entries)), NextCursor: page.NextCursor}
+	for _, entry := range page.Entries {
+		resp.Entries = append(resp.Entries, toAuditLogResponse(entry))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ?format=csv|jsonl plus the same filters as QueryHandler, rows are streamed as they're read
+func (h *AuditHandler) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	// inject DB
+	r = r.WithContext(context.WithValue(r.Context(), app.PQ, h.App.DB))
+
+	filter, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "jsonl"
+	}
+
+	var write func(model.AuditLog) error
+	var finish func() error
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(auditCSVHeader) // buffered, goes out with the first flush
+		write = func(l model.AuditLog) error { return cw.Write(auditCSVRow(l)) }
+		finish = func() error { cw.Flush(); return cw.Error() }
+		w.Header().Set("Content-Type", "text/csv")
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		write = func(l model.AuditLog) error { return enc.Encode(toAuditLogResponse(l)) }
+		finish = func() error { return nil }
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	default:
+		http.Error(w, "format must be csv or jsonl", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit_log_%s.%s"`, time.Now().UTC().Format("20060102T150405Z"), format))
+
+	// the status goes out with the first row, so failures before that still get a proper error
+	started := false
+	start := func() {
+		if !started {
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	extendWriteDeadline(rc)
+
+	count := 0
+	err = h.AuditService.Export(filter, func(l model.AuditLog) error {
+		start()
+		if err := write(l); err != nil {
+			return err
+		}
+		count++
+		if count%exportFlushEvery == 0 {
+			if err := finish(); err != nil {
+				return err
+			}
+			extendWriteDeadline(rc)
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+		}
+		return nil
+	}, r.Context())
+
+	if err != nil && !started {
+		w.Header().Del("Content-Disposition")
+		status := http.StatusInternalServerError
+		if errors.Is(err, admin.ErrInvalidCursor) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, fmt.Sprintf("Failed to export audit log: %v", err), status)
+		return
+	}
+
+	// the status is already sent, a truncated export is all we can signal
+	if err != nil {
+		log.Printf("audit export aborted after %d rows: %v", count, err)
+		return
+	}
+
+	start()
+	if err := finish(); err != nil {
+		log.Printf("audit export failed to flush: %v", err)
+	}
+}
+
+// ?table=users&record_id=42, every version of the record oldest first
+func (h *AuditHandler) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	// inject DB
+	r = r.WithContext(context.WithValue(r.Context(), app.PQ, h.App.DB))
+
+	table := model.Table(r.URL.Query().Get("table"))
+	recordID, err := strconv.ParseInt(r.URL.Query().Get("record_id"), 10, 64)
+	if table == "" || err != nil || recordID <= 0 {
+		http.Error(w, "table and a positive record_id are required", http.StatusBadRequest)
+		return
+	}
+
+	versions, err := h.AuditService.History(table, recordID, r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to rebuild record history: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"table": table, "record_id": recordID, "versions": versions})
+}
+
+// queue depth, lag and flush counters of the async pipeline, for alerting on backpressure
+func (h *AuditHandler) PipelineHandler(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]any{"enabled": h.App.Pipeline != nil}
+	if h.App.Pipeline != nil {
+		resp["stats"] = h.App.Pipeline.Stats()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func parseAuditFilter(q url.Values) (admin.AuditFilter, error) {
+	var f admin.AuditFilter
+	var err error
+
+	if v := q.Get("from"); v != "" {
+		if f.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, errors.New("from must be RFC3339")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if f.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, errors.New("to must be RFC3339")
+		}
+	}
+	if v := q.Get("actor_id"); v != "" {
+		if f.ActorID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return f, errors.New("actor_id must be an integer")
+		}
+	}
+	if v := q.Get("record_id"); v != "" {
+		if f.RecordID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return f, errors.New("record_id must be an integer")
+		}
+	}
+	if v := q.Get("request_id"); v != "" {
+		if f.RequestID, err = uuid.Parse(v); err != nil {
+			return f, errors.New("request_id must be a UUID")
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil {
+			return f, errors.New("limit must be an integer")
+		}
+	}
+	if v := q.Get("event_type"); v != "" {
+		f.EventType = model.EventType(v)
+		if _, ok := audit.Lookup(f.EventType); !ok {
+			return f, fmt.Errorf("unknown event_type %s", v)
+		}
+	}
+
+	f.Table = model.Table(q.Get("table"))
+	f.Cursor = q.Get("cursor")
+
+	return f, nil
+}
+
+func toAuditLogResponse(l model.AuditLog) AuditLogResponse {
+	resp := AuditLogResponse{
+		ID:               l.ID,
+		RequestID:        l.RequestId,
+		CreatedAt:        l.CreatedAt,
+		EventType:        l.EventType,
+		ActionType:       l.ActionType,
+		AffectedTable:    l.AffectedRecord,
+		AffectedRecordID: l.AffectedRecordID,
+		CreatedBy:        l.CreatedBy,
+		IPAddress:        l.IPAddress,
+		PrevHash:         l.PrevHash,
+		RowHash:          l.RowHash,
+	}
+	if l.OldData != "" {
+		resp.OldData = json.RawMessage(l.OldData)
+	}
+	if l.NewData != "" {
+		resp.NewData = json.RawMessage(l.NewData)
+	}
+	return resp
+}
+
+// the payloads carry what employees typed, a spreadsheet mustn't run it
+func auditCSVRow(l model.AuditLog) []string {
+	return []string{
+		strconv.FormatInt(l.ID, 10),
+		l.CreatedAt.UTC().Format(time.RFC3339Nano),
+		l.RequestId.String(),
+		string(l.EventType),
+		string(l.ActionType),
+		string(l.AffectedRecord),
+		strconv.FormatInt(l.AffectedRecordID, 10),
+		strconv.FormatInt(l.CreatedBy, 10),
+		export.CSVText(l.IPAddress),
+		export.CSVText(l.OldData),
+		export.CSVText(l.NewData),
+		l.PrevHash,
+		l.RowHash,
+	}
+}
@@ -3,25 +3,36 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
 	"github.com/achsanalfitra/gopayslip/internal/router"
 	"github.com/achsanalfitra/gopayslip/internal/services/empl"
 	"github.com/google/uuid"
 )
 
+// only used by service accounts, employees always check in for themselves
+type AttendanceRequest struct {
+	UserID int64 `json:"user_id"`
+}
+
+// hours, read as a decimal so 1.1 is exactly 1h6m
 type OvertimeRequest struct {
-	Interval     float64 `json:"overtime_duration"`
-	OvertimeDate string  `json:"overtime_date"`
+	Interval     money.Decimal `json:"overtime_duration"`
+	OvertimeDate string        `json:"overtime_date"`
+	UserID       int64         `json:"user_id"` // only used by service accounts
 }
 
 type ReimbursementRequest struct {
-	Amount      float64 `json:"reimbursement_amount"`
-	Description string  `json:"description"`
+	Amount      money.Decimal `json:"reimbursement_amount"`
+	Description string        `json:"description"`
+	UserID      int64         `json:"user_id"` // only used by service accounts
 }
 
 type EmplHandler struct {
@@ -45,25 +56,38 @@ func (e *EmplHandler) AttendanceHandler(w http.ResponseWriter, r *http.Request)
 	// update context with injected DB
 	r = r.WithContext(newCtx)
 
-	userIDStr, ok := r.Context().Value(router.CtxUserKey).(string)
+	userID, ok := r.Context().Value(router.CtxUserKey).(int64)
 	if !ok {
 		http.Error(w, "User ID not found in context or invalid type", http.StatusInternalServerError)
 		return
 	}
 
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID format in context", http.StatusInternalServerError)
-		return
-	}
-
 	requestID, ok := r.Context().Value(router.CtxRequestKey).(uuid.UUID)
 	if !ok {
 		http.Error(w, "Request ID not found in context or invalid type", http.StatusInternalServerError)
 		return
 	}
 
-	err = e.UserService.CheckIn(userID, requestID, r.Context())
+	// integrations check in on behalf of an employee, the service account stays the actor
+	actorID := userID
+	if isService(r) {
+		var reqBody AttendanceRequest
+		if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil || reqBody.UserID == 0 {
+			http.Error(w, "user_id is required when checking in on behalf of an employee", http.StatusBadRequest)
+			return
+		}
+		userID = reqBody.UserID
+	}
+
+	err := e.UserService.CheckIn(userID, actorID, requestID, r.Context())
+	if errors.Is(err, empl.ErrUserNotFound) || errors.Is(err, empl.ErrNotEmployee) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, empl.ErrInputsFrozen) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to process check-in: %v", err), http.StatusInternalServerError)
 		return
@@ -80,16 +104,11 @@ func (e *EmplHandler) OvertimeHandler(w http.ResponseWriter, r *http.Request) {
 	// update context with injected DB
 	r = r.WithContext(newCtx)
 
-	userIDStr, ok := r.Context().Value(router.CtxUserKey).(string)
+	userID, ok := r.Context().Value(router.CtxUserKey).(int64)
 	if !ok {
 		http.Error(w, "User ID not found in context or invalid type", http.StatusInternalServerError)
 		return
 	}
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID format in context", http.StatusInternalServerError)
-		return
-	}
 
 	requestID, ok := r.Context().Value(router.CtxRequestKey).(uuid.UUID)
 	if !ok {
@@ -103,7 +122,22 @@ func (e *EmplHandler) OvertimeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	overtimeDuration := time.Duration(reqBody.Interval * float64(time.Hour))
+	// integrations propose on behalf of an employee, the service account stays the actor
+	actorID := userID
+	if isService(r) {
+		if reqBody.UserID == 0 {
+			http.Error(w, "user_id is required when proposing on behalf of an employee", http.StatusBadRequest)
+			return
+		}
+		userID = reqBody.UserID
+	}
+
+	nanos, ok := reqBody.Interval.MulInt(int64(time.Hour)).Int64()
+	if !ok || reqBody.Interval.Sign() <= 0 {
+		http.Error(w, "overtime_duration must be a positive number of hours", http.StatusBadRequest)
+		return
+	}
+	overtimeDuration := time.Duration(nanos)
 
 	overtimeDate, err := time.Parse(time.RFC3339, reqBody.OvertimeDate)
 	if err != nil {
@@ -111,7 +145,15 @@ func (e *EmplHandler) OvertimeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = e.UserService.ProposeOvertime(userID, requestID, overtimeDuration, overtimeDate, r.Context())
+	err = e.UserService.ProposeOvertime(userID, actorID, requestID, overtimeDuration, overtimeDate, r.Context())
+	if errors.Is(err, empl.ErrUserNotFound) || errors.Is(err, empl.ErrNotEmployee) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, empl.ErrInputsFrozen) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to propose overtime: %v", err), http.StatusInternalServerError)
 		return
@@ -128,16 +170,11 @@ func (e *EmplHandler) ReimbursementHandler(w http.ResponseWriter, r *http.Reques
 	// update context with injected DB
 	r = r.WithContext(newCtx)
 
-	userIDStr, ok := r.Context().Value(router.CtxUserKey).(string)
+	userID, ok := r.Context().Value(router.CtxUserKey).(int64)
 	if !ok {
 		http.Error(w, "User ID not found in context or invalid type", http.StatusInternalServerError)
 		return
 	}
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID format in context", http.StatusInternalServerError)
-		return
-	}
 
 	requestID, ok := r.Context().Value(router.CtxRequestKey).(uuid.UUID)
 	if !ok {
@@ -151,7 +188,25 @@ func (e *EmplHandler) ReimbursementHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = e.UserService.ProposeReimbursement(userID, requestID, reqBody.Amount, reqBody.Description, r.Context())
+	// integrations propose on behalf of an employee, the service account stays the actor
+	actorID := userID
+	if isService(r) {
+		if reqBody.UserID == 0 {
+			http.Error(w, "user_id is required when proposing on behalf of an employee", http.StatusBadRequest)
+			return
+		}
+		userID = reqBody.UserID
+	}
+
+	err := e.UserService.ProposeReimbursement(userID, actorID, requestID, reqBody.Amount, reqBody.Description, r.Context())
+	if errors.Is(err, empl.ErrUserNotFound) || errors.Is(err, empl.ErrNotEmployee) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, empl.ErrInputsFrozen) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to propose reimbursement: %v", err), http.StatusInternalServerError)
 		return
@@ -168,16 +223,11 @@ func (e *EmplHandler) PayslipHandler(w http.ResponseWriter, r *http.Request) {
 	// update context with injected DB
 	r = r.WithContext(newCtx)
 
-	userIDStr, ok := r.Context().Value(router.CtxUserKey).(string)
+	userID, ok := r.Context().Value(router.CtxUserKey).(int64)
 	if !ok {
 		http.Error(w, "User ID not found in context or invalid type", http.StatusInternalServerError)
 		return
 	}
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID format in context", http.StatusInternalServerError)
-		return
-	}
 
 	start, ok := r.Context().Value(router.CtxStartKey).(time.Time)
 	if !ok {
@@ -191,12 +241,143 @@ func (e *EmplHandler) PayslipHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	userID, err := payslipOwner(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	payslip, err := e.EmplService.GeneratePayslip(userID, r.Context(), start, end)
+	if errors.Is(err, empl.ErrPayslipNotFound) || errors.Is(err, empl.ErrPayslipPendingApproval) || errors.Is(err, empl.ErrUserNotFound) || errors.Is(err, empl.ErrNotEmployee) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to generate payslip: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(payslip)
 }
+
+// the issued payslip of the current period as a PDF, live computations are never printed
+func (e *EmplHandler) PayslipPDFHandler(w http.ResponseWriter, r *http.Request) {
+	// inject DB
+	r = r.WithContext(context.WithValue(r.Context(), app.PQ, e.App.DB))
+
+	userID, ok := r.Context().Value(router.CtxUserKey).(int64)
+	if !ok {
+		http.Error(w, "User ID not found in context or invalid type", http.StatusInternalServerError)
+		return
+	}
+
+	start, ok := r.Context().Value(router.CtxStartKey).(time.Time)
+	if !ok {
+		http.Error(w, "Start date not found in context or invalid type", http.StatusInternalServerError)
+		return
+	}
+
+	end, ok := r.Context().Value(router.CtxEndKey).(time.Time)
+	if !ok {
+		http.Error(w, "End date not found in context or invalid type", http.StatusInternalServerError)
+		return
+	}
+
+	userID, err := payslipOwner(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file, err := e.EmplService.PayslipPDF(userID, r.Context(), start, end)
+	if errors.Is(err, empl.ErrPayslipNotFound) || errors.Is(err, empl.ErrPayslipPendingApproval) || errors.Is(err, empl.ErrPayslipNotIssued) || errors.Is(err, empl.ErrUserNotFound) || errors.Is(err, empl.ErrNotEmployee) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to render payslip: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", file.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Name))
+	w.Header().Set("Content-Digest", file.Digest())
+	w.WriteHeader(http.StatusOK)
+	w.Write(file.Data)
+}
+
+// what the caller's payslip PDFs open with
+func (e *EmplHandler) PayslipPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(router.CtxUserKey).(int64)
+	if !ok {
+		http.Error(w, "User ID not found in context or invalid type", http.StatusInternalServerError)
+		return
+	}
+
+	password, err := e.EmplService.PayslipPassword(userID)
+	if errors.Is(err, empl.ErrPDFUnprotected) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to derive payslip password: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"password": password})
+}
+
+// public, banks and landlords check a payslip's code without an account
+func (e *EmplHandler) VerifyPayslipHandler(w http.ResponseWriter, r *http.Request) {
+	// inject DB
+	r = r.WithContext(context.WithValue(r.Context(), app.PQ, e.App.DB))
+
+	q := r.URL.Query()
+	code := q.Get("code")
+	if code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return
+	}
+
+	verification, err := e.EmplService.VerifyPayslip(code, q.Get("take_home"), r.Context())
+	if errors.Is(err, empl.ErrInvalidCode) || errors.Is(err, empl.ErrVerificationDisabled) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, empl.ErrInvalidTakeHome) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to verify payslip: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(verification)
+}
+
+// API keys belong to service accounts, they always act on behalf of someone else
+func isService(r *http.Request) bool {
+	role, _ := r.Context().Value(router.CtxRoleKey).(model.Role)
+	return role == model.SERVICE
+}
+
+// integrations name the employee with ?user_id=, everyone else reads their own payslip
+func payslipOwner(r *http.Request, userID int64) (int64, error) {
+	if !isService(r) {
+		return userID, nil
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errors.New("user_id is required when reading an employee's payslip")
+	}
+	return id, nil
+}
@@ -0,0 +1,541 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/export"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
+	"github.com/achsanalfitra/gopayslip/internal/router"
+	"github.com/achsanalfitra/gopayslip/internal/services/admin"
+	"github.com/achsanalfitra/gopayslip/internal/services/empl"
+	"github.com/google/uuid"
+)
+
+type DefinePayrollRequest struct {
+	StartPeriod string `json:"start_period"`
+	EndPeriod   string `json:"end_period"`
+}
+
+type PayrollTransitionRequest struct {
+	PayrollID int64               `json:"payroll_id"`
+	Status    model.PayrollStatus `json:"status"`
+	Reason    string              `json:"reason"`
+}
+
+type RunPayrollRequest struct {
+	PayrollID int64  `json:"payroll_id"`
+	Reason    string `json:"reason"`
+}
+
+// submit, approve and reject, the comment is required to reject
+type ApprovalRequest struct {
+	PayrollID int64  `json:"payroll_id"`
+	Comment   string `json:"comment"`
+}
+
+var previewCSVHeader = []string{"user_id", "username", "working_days", "attended_days", "attendance_pay", "overtime_pay", "reimbursement_pay", "take_home_pay", "previous_take_home_pay", "delta", "delta_percent", "flags", "currency"}
+
+type PayrollHandler struct {
+	AdminService admin.Admin
+	App          *app.App
+}
+
+func NewPayrollHandler(adminSvc admin.Admin, a *app.App) *PayrollHandler {
+	return &PayrollHandler{
+		AdminService: adminSvc,
+		App:          a,
+	}
+}
+
+func (h *PayrollHandler) DefineHandler(w http.ResponseWriter, r *http.Request) {
+	// inject DB
+	r = r.WithContext(context.WithValue(r.Context(), app.PQ, h.App.DB))
+
+	userID, ok := r.Context().Value(router.CtxUserKey).(int64)
+	if !ok {
+		http.Error(w, "User ID not found in context or invalid type", http.StatusInternalServerError)
+		return
+	}
+
+	var reqBody DefinePayrollRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	start, errStart := time.Parse(time.RFC3339, reqBody.StartPeriod)
+	end, errEnd := time.Parse(time.RFC3339, reqBody.EndPeriod)
+	if errStart != nil || errEnd != nil {
+		http.Error(w, "Invalid period format. Expected RFC3339 (e.g., 2006-01-02T15:04:05Z07:00)", http.StatusBadRequest)
+		return
+	}
+
+	payroll, err := h.AdminService.DefinePayroll(userID, start, end, r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to define payroll: %v", err), payrollErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(payroll)
+}
+
+func (h *PayrollHandler) TransitionHandler(w http.ResponseWriter, r *http.Request) {
+	// inject DB
+	r = r.WithContext(context.WithValue(r.Context(), app.PQ, h.App.DB))
+
+	userID, ok := r.Context().Value(router.CtxUserKey).(int64)
+	if !ok {
+		http.Error(w, "User ID not found in context or invalid type", http.StatusInternalServerError)
+		return
+	}
+
+	var reqBody PayrollTransitionRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil || reqBody.PayrollID <= 0 || reqBody.Status == "" {
+		http.Error(w, "payroll_id and status are required", http.StatusBadRequest)
+		return
+	}
+
+	payroll, err := h.AdminService.TransitionPayroll(userID, reqBody.PayrollID, reqBody.Status, reqBody.Reason, r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to change payroll status: %v", err), payrollErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(payroll)
+}
+
+func (h *PayrollHandler) RunHandler(w http.ResponseWriter, r *http.Request) {
+	// inject DB
+	r = r.WithContext(context.WithValue(r.Context(), app.PQ, h.App.DB))
+
+	userID, ok := r.Context().Value(router.CtxUserKey).(int64)
+	if !ok {
+		http.Error(w, "User ID not found in context or invalid type", http.StatusInternalServerError)
+		return
+	}
+
+	var reqBody RunPayrollRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil || reqBody.PayrollID <= 0 {
+		http.Error(w, "payroll_id is required", http.StatusBadRequest)
+		return
+	}
+
+	// clients send the same key when they retry, without one every request is a new run
+	key := uuid.New()
+	if v := r.Header.Get("Idempotency-Key"); v != "" {
+		parsed, err := uuid.Parse(v)
+		if err != nil {
+			http.Error(w, "Idempotency-Key must be a UUID", http.StatusBadRequest)
+			return
+		}
+		key = parsed
+	}
+
+	result, err := h.AdminService.RunPayroll(userID, reqBody.PayrollID, key, reqBody.Reason, r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to run payroll: %v", err), payrollErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotency-Key", key.String())
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// ?payroll_id=7, every run attempt with its status oldest first
+func (h *PayrollHandler) RunsHandler(w http.ResponseWriter, r *http.Request) {
+	// inject DB
+	r = r.WithContext(context.WithValue(r.Context(), app.PQ, h.App.DB))
+
+	payrollID, err := strconv.ParseInt(r.URL.Query().Get("payroll_id"), 10, 64)
+	if err != nil || payrollID <= 0 {
+		http.Error(w, "a positive payroll_id is required", http.StatusBadRequest)
+		return
+	}
+
+	runs, err := h.AdminService.PayrollRuns(payrollID, r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read payroll runs: %v", err), payrollErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"runs": runs})
+}
+
+// ?payroll_id=7, every status change oldest first
+func (h *PayrollHandler) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	// inject DB
+	r = r.WithContext(context.WithValue(r.Context(), app.PQ, h.App.DB))
+
+	payrollID, err := strconv.ParseInt(r.URL.Query().Get("payroll_id"), 10, 64)
+	if err != nil || payrollID <= 0 {
+		http.Error(w, "a positive payroll_id is required", http.StatusBadRequest)
+		return
+	}
+
+	history, err := h.AdminService.PayrollHistory(payrollID, r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read payroll history: %v", err), payrollErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"transitions": history})
+}
+
+func (h *PayrollHandler) SubmitHandler(w http.ResponseWriter, r *http.Request) {
+	h.approvalStep(w, r, "submit", h.AdminService.SubmitPayroll)
+}
+
+func (h *PayrollHandler) ApproveHandler(w http.ResponseWriter, r *http.Request) {
+	h.approvalStep(w, r, "approve", h.AdminService.ApprovePayroll)
+}
+
+func (h *PayrollHandler) RejectHandler(w http.ResponseWriter, r *http.Request) {
+	h.approvalStep(w, r, "reject", h.AdminService.RejectPayroll)
+}
+
+// the three maker-checker steps only differ in the service call
+func (h *PayrollHandler) approvalStep(w http.ResponseWriter, r *http.Request, verb string, step func(userID, payrollID int64, comment string, ctx context.Context) (model.PayrollApproval, error)) {
+	// inject DB
+	r = r.WithContext(context.WithValue(r.Context(), app.PQ, h.App.DB))
+
+	userID, ok := r.Context().Value(router.CtxUserKey).(int64)
+	if !ok {
+		http.Error(w, "User ID not found in context or invalid type", http.StatusInternalServerError)
+		return
+	}
+
+	var reqBody ApprovalRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil || reqBody.PayrollID <= 0 {
+		http.Error(w, "payroll_id is required", http.StatusBadRequest)
+		return
+	}
+
+	approval, err := step(userID, reqBody.PayrollID, reqBody.Comment, r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to %s payroll: %v", verb, err), payrollErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(approval)
+}
+
+// ?payroll_id=7, every submission with its decision oldest first
+func (h *PayrollHandler) ApprovalsHandler(w http.ResponseWriter, r *http.Request) {
+	// inject DB
+	r = r.WithContext(context.WithValue(r.Context(), app.PQ, h.App.DB))
+
+	payrollID, err := strconv.ParseInt(r.URL.Query().Get("payroll_id"), 10, 64)
+	if err != nil || payrollID <= 0 {
+		http.Error(w, "a positive payroll_id is required", http.StatusBadRequest)
+		return
+	}
+
+	approvals, err := h.AdminService.PayrollApprovals(payrollID, r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read payroll approvals: %v", err), payrollErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"approvals": approvals})
+}
+
+// ?payroll_id=7&department=&username=&sort=take_home_pay&order=desc&limit=50&offset=0
+func (h *PayrollHandler) SummaryHandler(w http.ResponseWriter, r *http.Request) {
+	// inject DB
+	r = r.WithContext(context.WithValue(r.Context(), app.PQ, h.App.DB))
+
+	payrollID, filter, err := parseSummaryQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.AdminService.PayrollSummary(payrollID, filter, r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to generate payroll summary: %v", err), payrollErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+func parseSummaryQuery(r *http.Request) (int64, admin.SummaryFilter, error) {
+	q := r.URL.Query()
+	var f admin.SummaryFilter
+
+	payrollID, err := strconv.ParseInt(q.Get("payroll_id"), 10, 64)
+	if err != nil || payrollID <= 0 {
+		return 0, f, errors.New("a positive payroll_id is required")
+	}
+
+	f.Department = q.Get("department")
+	f.Username = q.Get("username")
+	f.Sort = q.Get("sort")
+
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		f.Desc = true
+	default:
+		return 0, f, errors.New("order must be asc or desc")
+	}
+
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil {
+			return 0, f, errors.New("limit must be an integer")
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			return 0, f, errors.New("offset must be a non-negative integer")
+		}
+	}
+
+	return payrollID, f, nil
+}
+
+// ?payroll_id=7&format=csv|xlsx&columns=user_id,take_home_pay:Net pay
+func (h *PayrollHandler) ExportSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	h.export(w, r, false)
+}
+
+// ?payroll_id=7&user_id=3&format=csv|xlsx&columns=line,amount
+func (h *PayrollHandler) ExportPayslipHandler(w http.ResponseWriter, r *http.Request) {
+	h.export(w, r, true)
+}
+
+// the checksum goes in Content-Digest, the CLI writes it as a sidecar file instead
+func (h *PayrollHandler) export(w http.ResponseWriter, r *http.Request, payslip bool) {
+	// inject DB
+	r = r.WithContext(context.WithValue(r.Context(), app.PQ, h.App.DB))
+
+	userID, ok := r.Context().Value(router.CtxUserKey).(int64)
+	if !ok {
+		http.Error(w, "User ID not found in context or invalid type", http.StatusInternalServerError)
+		return
+	}
+
+	q := r.URL.Query()
+	payrollID, err := strconv.ParseInt(q.Get("payroll_id"), 10, 64)
+	if err != nil || payrollID <= 0 {
+		http.Error(w, "a positive payroll_id is required", http.StatusBadRequest)
+		return
+	}
+
+	format, err := export.ParseFormat(q.Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var file export.File
+	if payslip {
+		employeeID, parseErr := strconv.ParseInt(q.Get("user_id"), 10, 64)
+		if parseErr != nil || employeeID <= 0 {
+			http.Error(w, "a positive user_id is required", http.StatusBadRequest)
+			return
+		}
+		file, err = h.AdminService.ExportPayslip(userID, payrollID, employeeID, format, q.Get("columns"), r.Context())
+	} else {
+		file, err = h.AdminService.ExportSummary(userID, payrollID, format, q.Get("columns"), r.Context())
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to export payroll: %v", err), payrollErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", file.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Name))
+	w.Header().Set("Content-Digest", file.Digest())
+	w.WriteHeader(http.StatusOK)
+	w.Write(file.Data)
+}
+
+// ?payroll_id=7&format=json|csv, computes the locked payroll without persisting anything
+func (h *PayrollHandler) PreviewHandler(w http.ResponseWriter, r *http.Request) {
+	// inject DB
+	r = r.WithContext(context.WithValue(r.Context(), app.PQ, h.App.DB))
+
+	payrollID, err := strconv.ParseInt(r.URL.Query().Get("payroll_id"), 10, 64)
+	if err != nil || payrollID <= 0 {
+		http.Error(w, "a positive payroll_id is required", http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	preview, err := h.AdminService.PreviewPayroll(payrollID, format, r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to preview payroll: %v", err), payrollErrorStatus(err))
+		return
+	}
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(preview)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="payroll_%d_preview_%s.csv"`, preview.PayrollID, preview.GeneratedAt.UTC().Format("20060102T150405Z")))
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	cw.Write(previewCSVHeader)
+	for _, line := range preview.Employees {
+		cw.Write(previewCSVRow(line, preview.Currency))
+	}
+	cw.Flush()
+}
+
+// previous figures are empty for employees the previous run didn't pay
+func previewCSVRow(line admin.PreviewLine, currency money.Currency) []string {
+	optional := func(m *money.Money) string {
+		if m == nil {
+			return ""
+		}
+		return m.Amount.String()
+	}
+
+	percent := ""
+	if line.DeltaPercent != nil {
+		percent = line.DeltaPercent.String()
+	}
+
+	flags := make([]string, 0, len(line.Flags))
+	for _, f := range line.Flags {
+		flags = append(flags, string(f))
+	}
+
+	return []string{
+		strconv.FormatInt(line.UserID, 10),
+		export.CSVText(line.Username),
+		strconv.Itoa(line.WorkingDays),
+		strconv.Itoa(line.AttendedDays),
+		line.AttendancePay.Amount.String(),
+		line.OvertimePay.Amount.String(),
+		line.ReimbursementPay.Amount.String(),
+		line.TakeHomePay.Amount.String(),
+		optional(line.PreviousTakeHomePay),
+		optional(line.Delta),
+		percent,
+		strings.Join(flags, ";"),
+		string(currency),
+	}
+}
+
+// a zip with one PDF per issued payslip of the run and a SHA256SUMS manifest, streamed so the headcount doesn't matter
+func (h *PayrollHandler) PrintPayslipsHandler(w http.ResponseWriter, r *http.Request) {
+	// inject DB
+	r = r.WithContext(context.WithValue(r.Context(), app.PQ, h.App.DB))
+
+	userID, ok := r.Context().Value(router.CtxUserKey).(int64)
+	if !ok {
+		http.Error(w, "User ID not found in context or invalid type", http.StatusInternalServerError)
+		return
+	}
+
+	payrollID, err := strconv.ParseInt(r.URL.Query().Get("payroll_id"), 10, 64)
+	if err != nil || payrollID <= 0 {
+		http.Error(w, "a positive payroll_id is required", http.StatusBadRequest)
+		return
+	}
+
+	// the zip is streamed, failures before its first bytes still get an error status
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("payroll-%d-payslips.zip", payrollID)))
+	w.Header().Set("Trailer", "Content-Digest")
+
+	sw := &streamWriter{w: w, rc: http.NewResponseController(w), hash: sha256.New()}
+	extendWriteDeadline(sw.rc)
+
+	_, err = h.AdminService.PrintPayslips(userID, payrollID, export.NewZipBundle(sw), r.Context())
+	if err != nil && !sw.started {
+		w.Header().Del("Content-Disposition")
+		w.Header().Del("Trailer")
+		http.Error(w, fmt.Sprintf("Failed to print payslips: %v", err), payrollErrorStatus(err))
+		return
+	}
+	if err != nil {
+		// the status is already sent, dropping the connection keeps a truncated zip from looking complete
+		log.Printf("payslip printing of payroll %d aborted: %v", payrollID, err)
+		panic(http.ErrAbortHandler)
+	}
+
+	w.Header().Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sw.hash.Sum(nil))+":")
+}
+
+// sends the status with the first bytes and pushes the write deadline out on every write
+type streamWriter struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	hash    hash.Hash
+	started bool
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if !s.started {
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+	extendWriteDeadline(s.rc)
+	s.hash.Write(p)
+	return s.w.Write(p)
+}
+
+func payrollErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, admin.ErrPayrollNotFound), errors.Is(err, empl.ErrPayslipNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, admin.ErrInvalidPeriod), errors.Is(err, admin.ErrReasonRequired), errors.Is(err, admin.ErrCommentRequired),
+		errors.Is(err, admin.ErrInvalidSort), errors.Is(err, export.ErrUnknownColumn), errors.Is(err, export.ErrUnknownFormat):
+		return http.StatusBadRequest
+	case errors.Is(err, admin.ErrSameApprover):
+		return http.StatusForbidden
+	case errors.Is(err, admin.ErrInvalidTransition), errors.Is(err, admin.ErrPayrollPending), errors.Is(err, admin.ErrPayrollOverlap),
+		errors.Is(err, admin.ErrPreviewNotLocked), errors.Is(err, admin.ErrNotSubmitted), errors.Is(err, admin.ErrRunInProgress),
+		errors.Is(err, admin.ErrNotRun):
+		return http.StatusConflict
+	case errors.Is(err, admin.ErrIdempotencyConflict):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS password_reset CASCADE;
+
+DROP TABLE IF EXISTS password_history CASCADE;
+
+ALTER TABLE users DROP COLUMN IF EXISTS password_changed_at;
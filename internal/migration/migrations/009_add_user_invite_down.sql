@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS user_invite CASCADE;
+
+ALTER TABLE users DROP COLUMN IF EXISTS activated_at;
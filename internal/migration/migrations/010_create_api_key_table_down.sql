@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS api_key CASCADE;
+
+-- postgres can't drop a single enum value, SERVICE stays in user_role
@@ -0,0 +1,3 @@
+DELETE FROM audit_log WHERE created_by IS NULL;
+
+ALTER TABLE audit_log ALTER COLUMN created_by SET NOT NULL;
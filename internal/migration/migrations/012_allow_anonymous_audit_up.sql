@@ -0,0 +1,2 @@
+-- failed logins for unknown usernames have nobody to reference
+ALTER TABLE audit_log ALTER COLUMN created_by DROP NOT NULL;
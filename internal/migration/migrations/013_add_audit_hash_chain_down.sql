@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS audit_chain;
+
+ALTER TABLE audit_log DROP COLUMN IF EXISTS row_hash;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS prev_hash;
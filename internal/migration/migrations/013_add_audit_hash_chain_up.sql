@@ -0,0 +1,13 @@
+-- rows written before this migration stay unchained, verification starts at the first hashed row
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64);
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS row_hash VARCHAR(64);
+
+-- single row holding the chain tip, locking it serializes audit writes
+CREATE TABLE IF NOT EXISTS audit_chain (
+    id SMALLINT PRIMARY KEY CHECK (id = 1),
+    last_id BIGINT NOT NULL DEFAULT 0,
+    last_hash VARCHAR(64) NOT NULL DEFAULT '',
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+INSERT INTO audit_chain (id) VALUES (1) ON CONFLICT (id) DO NOTHING;
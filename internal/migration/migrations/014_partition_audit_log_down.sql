@@ -0,0 +1,60 @@
+-- archived rows stay in their archive files, only what is still attached comes back
+DROP TABLE IF EXISTS audit_archive;
+ALTER TABLE audit_chain DROP COLUMN IF EXISTS last_created_at;
+
+ALTER TABLE audit_log RENAME TO audit_log_partitioned;
+ALTER SEQUENCE audit_log_id_seq OWNED BY NONE;
+
+DROP INDEX IF EXISTS idx_audit_log_request_id;
+DROP INDEX IF EXISTS idx_audit_log_created_at;
+DROP INDEX IF EXISTS idx_audit_log_event_type;
+DROP INDEX IF EXISTS idx_audit_log_action_type;
+DROP INDEX IF EXISTS idx_audit_log_affected_table;
+DROP INDEX IF EXISTS idx_audit_log_affected_record_id;
+DROP INDEX IF EXISTS idx_audit_log_created_by;
+ALTER TABLE audit_log_partitioned RENAME CONSTRAINT audit_log_pkey TO audit_log_partitioned_pkey;
+
+CREATE TABLE audit_log (
+    id BIGINT PRIMARY KEY DEFAULT nextval('audit_log_id_seq'),
+    request_id UUID NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    event_type VARCHAR(255) NOT NULL,
+    action_type action_type NOT NULL,
+    affected_table VARCHAR(255) NOT NULL,
+    affected_record_id BIGINT,
+    created_by BIGINT,
+    ip_address VARCHAR(255),
+    old_data TEXT,
+    new_data TEXT,
+    prev_hash VARCHAR(64),
+    row_hash VARCHAR(64),
+    FOREIGN KEY (created_by) REFERENCES users(id)
+);
+
+ALTER SEQUENCE audit_log_id_seq OWNED BY audit_log.id;
+
+INSERT INTO audit_log SELECT * FROM audit_log_partitioned;
+
+-- partitions detached but not yet archived still hold rows
+DO $$
+DECLARE
+    partition_name TEXT;
+BEGIN
+    FOR partition_name IN SELECT c.relname FROM pg_class c
+                          WHERE c.relkind = 'r' AND c.relname ~ '^audit_log_p[0-9]{6}$'
+                          AND NOT EXISTS (SELECT 1 FROM pg_inherits i WHERE i.inhrelid = c.oid) LOOP
+        EXECUTE format('INSERT INTO audit_log SELECT * FROM %I', partition_name);
+        EXECUTE format('DROP TABLE %I', partition_name);
+    END LOOP;
+END $$;
+
+DROP TABLE audit_log_partitioned CASCADE;
+DROP FUNCTION IF EXISTS audit_log_create_partition(DATE);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_request_id ON audit_log (request_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_event_type ON audit_log (event_type);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action_type ON audit_log (action_type);
+CREATE INDEX IF NOT EXISTS idx_audit_log_affected_table ON audit_log (affected_table);
+CREATE INDEX IF NOT EXISTS idx_audit_log_affected_record_id ON audit_log (affected_record_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_by ON audit_log (created_by);
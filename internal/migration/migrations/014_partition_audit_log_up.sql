@@ -0,0 +1,108 @@
+-- monthly range partitions on created_at, the retention job archives and drops the old ones
+ALTER TABLE audit_log RENAME TO audit_log_unpartitioned;
+ALTER TABLE audit_log_unpartitioned RENAME CONSTRAINT audit_log_pkey TO audit_log_unpartitioned_pkey;
+ALTER SEQUENCE audit_log_id_seq OWNED BY NONE;
+
+DROP INDEX IF EXISTS idx_audit_log_request_id;
+DROP INDEX IF EXISTS idx_audit_log_created_at;
+DROP INDEX IF EXISTS idx_audit_log_event_type;
+DROP INDEX IF EXISTS idx_audit_log_action_type;
+DROP INDEX IF EXISTS idx_audit_log_affected_table;
+DROP INDEX IF EXISTS idx_audit_log_affected_record_id;
+DROP INDEX IF EXISTS idx_audit_log_created_by;
+
+-- the partition key has to be part of the primary key
+CREATE TABLE audit_log (
+    id BIGINT NOT NULL DEFAULT nextval('audit_log_id_seq'),
+    request_id UUID NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    event_type VARCHAR(255) NOT NULL,
+    action_type action_type NOT NULL,
+    affected_table VARCHAR(255) NOT NULL,
+    affected_record_id BIGINT,
+    created_by BIGINT,
+    ip_address VARCHAR(255),
+    old_data TEXT,
+    new_data TEXT,
+    prev_hash VARCHAR(64),
+    row_hash VARCHAR(64),
+    PRIMARY KEY (id, created_at),
+    FOREIGN KEY (created_by) REFERENCES users(id)
+) PARTITION BY RANGE (created_at);
+
+ALTER SEQUENCE audit_log_id_seq OWNED BY audit_log.id;
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_request_id ON audit_log (request_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_event_type ON audit_log (event_type);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action_type ON audit_log (action_type);
+CREATE INDEX IF NOT EXISTS idx_audit_log_affected_table ON audit_log (affected_table);
+CREATE INDEX IF NOT EXISTS idx_audit_log_affected_record_id ON audit_log (affected_record_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_by ON audit_log (created_by);
+
+-- catches rows for months without a partition, the retention job keeps it empty
+CREATE TABLE IF NOT EXISTS audit_log_default PARTITION OF audit_log DEFAULT;
+
+-- creates audit_log_pYYYYMM for the UTC month containing the given day, rows already sitting
+-- in the default partition for that month are moved into it
+CREATE OR REPLACE FUNCTION audit_log_create_partition(day DATE) RETURNS TEXT AS $$
+DECLARE
+    month_start TIMESTAMP := date_trunc('month', day::TIMESTAMP);
+    range_start TIMESTAMPTZ := month_start AT TIME ZONE 'UTC';
+    range_end TIMESTAMPTZ := (month_start + INTERVAL '1 month') AT TIME ZONE 'UTC';
+    partition_name TEXT := 'audit_log_p' || to_char(month_start, 'YYYYMM');
+BEGIN
+    -- a detached partition waiting to be archived still blocks the name, the month is long gone then
+    IF to_regclass(partition_name) IS NOT NULL THEN
+        RETURN partition_name;
+    END IF;
+
+    EXECUTE format('CREATE TABLE %I (LIKE audit_log INCLUDING DEFAULTS INCLUDING CONSTRAINTS)', partition_name);
+    EXECUTE format('WITH moved AS (DELETE FROM audit_log_default WHERE created_at >= %L AND created_at < %L RETURNING *) INSERT INTO %I SELECT * FROM moved',
+                   range_start, range_end, partition_name);
+    EXECUTE format('ALTER TABLE audit_log ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)', partition_name, range_start, range_end);
+
+    RETURN partition_name;
+END;
+$$ LANGUAGE plpgsql;
+
+-- every month with existing rows plus the next three
+DO $$
+DECLARE
+    day DATE := COALESCE((SELECT min(created_at AT TIME ZONE 'UTC') FROM audit_log_unpartitioned), now() AT TIME ZONE 'UTC')::DATE;
+BEGIN
+    WHILE day <= ((now() AT TIME ZONE 'UTC') + INTERVAL '3 months')::DATE LOOP
+        PERFORM audit_log_create_partition(day);
+        day := (date_trunc('month', day::TIMESTAMP) + INTERVAL '1 month')::DATE;
+    END LOOP;
+END $$;
+
+INSERT INTO audit_log (id, request_id, created_at, event_type, action_type, affected_table, affected_record_id, created_by, ip_address, old_data, new_data, prev_hash, row_hash)
+SELECT id, request_id, created_at, event_type, action_type, affected_table, affected_record_id, created_by, ip_address, old_data, new_data, prev_hash, row_hash
+FROM audit_log_unpartitioned;
+
+DROP TABLE audit_log_unpartitioned;
+
+-- timestamps are assigned under the chain lock and never go backwards, so every partition
+-- holds one contiguous stretch of the chain
+ALTER TABLE audit_chain ADD COLUMN IF NOT EXISTS last_created_at TIMESTAMP WITH TIME ZONE;
+UPDATE audit_chain SET last_created_at = (SELECT max(created_at) FROM audit_log) WHERE id = 1;
+
+-- one row per partition moved out of the database, the archive files are kept for ten years
+CREATE TABLE IF NOT EXISTS audit_archive (
+    id BIGSERIAL PRIMARY KEY,
+    partition_name VARCHAR(63) NOT NULL UNIQUE,
+    range_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    range_end TIMESTAMP WITH TIME ZONE NOT NULL,
+    row_count BIGINT NOT NULL,
+    first_id BIGINT NOT NULL DEFAULT 0,
+    last_id BIGINT NOT NULL DEFAULT 0,
+    first_prev_hash VARCHAR(64) NOT NULL DEFAULT '',
+    last_row_hash VARCHAR(64) NOT NULL DEFAULT '',
+    file_name VARCHAR(255) NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    archived_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    retain_until TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_archive_last_id ON audit_archive (last_id);
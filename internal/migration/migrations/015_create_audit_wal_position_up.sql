@@ -0,0 +1,7 @@
+-- last write-ahead sequence committed by each instance's async audit pipeline, advanced in the
+-- same transaction as the batch so a replay after a crash never writes an entry twice
+CREATE TABLE IF NOT EXISTS audit_wal_position (
+    instance VARCHAR(255) PRIMARY KEY,
+    seq BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS payslip_item;
+DROP TYPE IF EXISTS payslip_item_kind;
+DROP TABLE IF EXISTS payslip;
@@ -0,0 +1,49 @@
+-- payslips frozen when the payroll is run, reads for run periods never recompute
+CREATE TABLE IF NOT EXISTS payslip (
+    id BIGSERIAL PRIMARY KEY,
+    payroll_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    period_end TIMESTAMP WITH TIME ZONE NOT NULL,
+    base_salary NUMERIC NOT NULL,
+    working_days INT NOT NULL,
+    attended_days INT NOT NULL,
+    attendance_pay NUMERIC NOT NULL,
+    hourly_rate NUMERIC NOT NULL,
+    overtime_hours NUMERIC NOT NULL,
+    overtime_multiplier NUMERIC NOT NULL,
+    overtime_pay NUMERIC NOT NULL,
+    reimbursement_pay NUMERIC NOT NULL,
+    take_home_pay NUMERIC NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    created_by BIGINT NOT NULL,
+    UNIQUE (payroll_id, user_id),
+    FOREIGN KEY (payroll_id) REFERENCES payroll(id),
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (created_by) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payslip_user_id ON payslip (user_id);
+
+DO $$ BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'payslip_item_kind') THEN
+        CREATE TYPE payslip_item_kind AS ENUM ('ATTENDANCE', 'OVERTIME', 'REIMBURSEMENT');
+    END IF;
+END $$;
+
+-- the inputs the payslip was computed from, copied so later edits can't change them
+CREATE TABLE IF NOT EXISTS payslip_item (
+    id BIGSERIAL PRIMARY KEY,
+    payslip_id BIGINT NOT NULL,
+    kind payslip_item_kind NOT NULL,
+    source_id BIGINT NOT NULL, -- id in attendance, overtime or reimbursement
+    item_date TIMESTAMP WITH TIME ZONE NOT NULL,
+    description TEXT,
+    hours NUMERIC,
+    rate NUMERIC,
+    multiplier NUMERIC,
+    amount NUMERIC NOT NULL,
+    FOREIGN KEY (payslip_id) REFERENCES payslip(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_payslip_item_payslip_id ON payslip_item (payslip_id);
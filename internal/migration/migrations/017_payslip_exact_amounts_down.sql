@@ -0,0 +1,20 @@
+ALTER TABLE payslip_item
+    ALTER COLUMN hours TYPE NUMERIC,
+    ALTER COLUMN rate TYPE NUMERIC,
+    ALTER COLUMN multiplier TYPE NUMERIC,
+    ALTER COLUMN amount TYPE NUMERIC;
+
+ALTER TABLE payslip
+    DROP COLUMN IF EXISTS rounding_scope,
+    DROP COLUMN IF EXISTS rounding_mode,
+    DROP COLUMN IF EXISTS currency;
+
+ALTER TABLE payslip
+    ALTER COLUMN base_salary TYPE NUMERIC,
+    ALTER COLUMN attendance_pay TYPE NUMERIC,
+    ALTER COLUMN hourly_rate TYPE NUMERIC,
+    ALTER COLUMN overtime_hours TYPE NUMERIC,
+    ALTER COLUMN overtime_multiplier TYPE NUMERIC,
+    ALTER COLUMN overtime_pay TYPE NUMERIC,
+    ALTER COLUMN reimbursement_pay TYPE NUMERIC,
+    ALTER COLUMN take_home_pay TYPE NUMERIC;
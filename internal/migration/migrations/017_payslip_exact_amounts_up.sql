@@ -0,0 +1,22 @@
+-- amounts are exact decimals at the storage scale, rates and hours keep more digits for display
+ALTER TABLE payslip
+    ALTER COLUMN base_salary TYPE DECIMAL(20, 2),
+    ALTER COLUMN attendance_pay TYPE DECIMAL(20, 2),
+    ALTER COLUMN hourly_rate TYPE DECIMAL(26, 6),
+    ALTER COLUMN overtime_hours TYPE DECIMAL(20, 6),
+    ALTER COLUMN overtime_multiplier TYPE DECIMAL(8, 4),
+    ALTER COLUMN overtime_pay TYPE DECIMAL(20, 2),
+    ALTER COLUMN reimbursement_pay TYPE DECIMAL(20, 2),
+    ALTER COLUMN take_home_pay TYPE DECIMAL(20, 2);
+
+-- the rules a frozen payslip was rounded by, so it can be explained after the configuration changes
+ALTER TABLE payslip
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'IDR',
+    ADD COLUMN IF NOT EXISTS rounding_mode TEXT NOT NULL DEFAULT 'half_even',
+    ADD COLUMN IF NOT EXISTS rounding_scope TEXT NOT NULL DEFAULT 'line';
+
+ALTER TABLE payslip_item
+    ALTER COLUMN hours TYPE DECIMAL(20, 6),
+    ALTER COLUMN rate TYPE DECIMAL(26, 6),
+    ALTER COLUMN multiplier TYPE DECIMAL(8, 4),
+    ALTER COLUMN amount TYPE DECIMAL(20, 2);
@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS payroll_transition;
+
+-- only the latest revision of each payslip fits the old constraint
+DELETE FROM payslip p USING payroll r WHERE p.payroll_id = r.id AND p.revision <> r.revision;
+ALTER TABLE payslip DROP CONSTRAINT IF EXISTS payslip_payroll_id_user_id_revision_key;
+ALTER TABLE payslip ADD CONSTRAINT payslip_payroll_id_user_id_key UNIQUE (payroll_id, user_id);
+ALTER TABLE payslip DROP COLUMN IF EXISTS revision;
+
+ALTER TABLE payroll ADD COLUMN IF NOT EXISTS is_run BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE payroll SET is_run = status IN ('RUN', 'APPROVED', 'PAID');
+
+DROP INDEX IF EXISTS idx_payroll_status;
+ALTER TABLE payroll DROP COLUMN IF EXISTS revision;
+ALTER TABLE payroll DROP COLUMN IF EXISTS status;
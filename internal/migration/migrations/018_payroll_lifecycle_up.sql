@@ -0,0 +1,33 @@
+-- explicit lifecycle instead of is_run, the allowed transitions are enforced by the service
+ALTER TABLE payroll ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'DRAFT'
+    CONSTRAINT payroll_status_check CHECK (status IN ('DRAFT', 'OPEN', 'LOCKED', 'RUN', 'APPROVED', 'PAID', 'REOPENED'));
+
+-- bumped on every run, payslips of a reopened payroll stay behind under their revision
+ALTER TABLE payroll ADD COLUMN IF NOT EXISTS revision INT NOT NULL DEFAULT 0;
+
+-- periods that were run before are RUN, pending ones were already taking inputs
+UPDATE payroll SET status = CASE WHEN is_run THEN 'RUN' ELSE 'OPEN' END,
+                   revision = CASE WHEN is_run THEN 1 ELSE 0 END;
+
+ALTER TABLE payroll DROP COLUMN IF EXISTS is_run;
+
+CREATE INDEX IF NOT EXISTS idx_payroll_status ON payroll (status);
+
+ALTER TABLE payslip ADD COLUMN IF NOT EXISTS revision INT NOT NULL DEFAULT 1;
+ALTER TABLE payslip DROP CONSTRAINT IF EXISTS payslip_payroll_id_user_id_key;
+ALTER TABLE payslip ADD CONSTRAINT payslip_payroll_id_user_id_revision_key UNIQUE (payroll_id, user_id, revision);
+
+-- one row per status change, from_status is NULL when the payroll is defined
+CREATE TABLE IF NOT EXISTS payroll_transition (
+    id BIGSERIAL PRIMARY KEY,
+    payroll_id BIGINT NOT NULL,
+    from_status TEXT,
+    to_status TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    created_by BIGINT NOT NULL,
+    FOREIGN KEY (payroll_id) REFERENCES payroll(id),
+    FOREIGN KEY (created_by) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payroll_transition_payroll_id ON payroll_transition (payroll_id);
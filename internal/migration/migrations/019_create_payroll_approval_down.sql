@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS payroll_approval;
+
+UPDATE payroll SET status = 'RUN' WHERE status = 'SUBMITTED';
+ALTER TABLE payroll DROP CONSTRAINT IF EXISTS payroll_status_check;
+ALTER TABLE payroll ADD CONSTRAINT payroll_status_check
+    CHECK (status IN ('DRAFT', 'OPEN', 'LOCKED', 'RUN', 'APPROVED', 'PAID', 'REOPENED'));
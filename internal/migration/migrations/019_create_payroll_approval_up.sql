@@ -0,0 +1,34 @@
+-- a run has to be submitted by one admin and approved by another before employees see it
+ALTER TABLE payroll DROP CONSTRAINT IF EXISTS payroll_status_check;
+ALTER TABLE payroll ADD CONSTRAINT payroll_status_check
+    CHECK (status IN ('DRAFT', 'OPEN', 'LOCKED', 'RUN', 'SUBMITTED', 'APPROVED', 'PAID', 'REOPENED'));
+
+-- one row per submission, decision stays NULL until a second admin acts on it
+CREATE TABLE IF NOT EXISTS payroll_approval (
+    id BIGSERIAL PRIMARY KEY,
+    payroll_id BIGINT NOT NULL,
+    revision INT NOT NULL,
+    ran_by BIGINT NOT NULL,
+    submitted_by BIGINT NOT NULL,
+    submit_comment TEXT NOT NULL DEFAULT '',
+    submitted_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    decision TEXT CHECK (decision IN ('APPROVED', 'REJECTED')),
+    decided_by BIGINT,
+    decision_comment TEXT NOT NULL DEFAULT '',
+    decided_at TIMESTAMP WITH TIME ZONE,
+    FOREIGN KEY (payroll_id) REFERENCES payroll(id),
+    FOREIGN KEY (ran_by) REFERENCES users(id),
+    FOREIGN KEY (submitted_by) REFERENCES users(id),
+    FOREIGN KEY (decided_by) REFERENCES users(id),
+    -- the database backs the service rule, the maker can't be the checker
+    CHECK (decided_by IS NULL OR (decided_by <> submitted_by AND decided_by <> ran_by))
+);
+
+CREATE INDEX IF NOT EXISTS idx_payroll_approval_payroll_id ON payroll_approval (payroll_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payroll_approval_pending ON payroll_approval (payroll_id) WHERE decision IS NULL;
+
+-- runs from before approvals were required were already visible to employees and stay so
+-- nobody approved them, the transition says the migration did it, created_by is only the admin who last touched the payroll
+INSERT INTO payroll_transition (payroll_id, from_status, to_status, reason, created_by)
+SELECT id, 'RUN', 'APPROVED', 'promoted by migration 019 without an approver, the run predates approvals', updated_by FROM payroll WHERE status = 'RUN';
+UPDATE payroll SET status = 'APPROVED', updated_at = CURRENT_TIMESTAMP WHERE status = 'RUN';
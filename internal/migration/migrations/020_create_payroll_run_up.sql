@@ -0,0 +1,20 @@
+-- one row per idempotency key, a retried request with the same key gets the stored result back
+CREATE TABLE IF NOT EXISTS payroll_run (
+    id BIGSERIAL PRIMARY KEY,
+    payroll_id BIGINT NOT NULL,
+    idempotency_key UUID NOT NULL UNIQUE,
+    status TEXT NOT NULL CHECK (status IN ('RUNNING', 'FAILED', 'COMPLETE')),
+    attempts INT NOT NULL DEFAULT 1,
+    revision INT,
+    payslips INT NOT NULL DEFAULT 0,
+    result JSONB,
+    error TEXT NOT NULL DEFAULT '',
+    started_by BIGINT NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    FOREIGN KEY (payroll_id) REFERENCES payroll(id),
+    FOREIGN KEY (started_by) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payroll_run_payroll_id ON payroll_run (payroll_id);
+CREATE INDEX IF NOT EXISTS idx_payroll_run_running ON payroll_run (payroll_id) WHERE status = 'RUNNING';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_department;
+ALTER TABLE users DROP COLUMN IF EXISTS department;
@@ -0,0 +1,4 @@
+-- reporting groups employees by department, existing users start without one
+ALTER TABLE users ADD COLUMN IF NOT EXISTS department TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_users_department ON users (department);
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// hashed API key owned by a service account, the plain key is only shown once
+type APIKey struct {
+	ID               int64        `json:"id"`
+	ServiceAccountID int64        `json:"service_account_id"`
+	CreatedBy        int64        `json:"created_by"`
+	Name             string       `json:"name"`
+	Prefix           string       `json:"prefix"` // public part of the key, used for lookup
+	KeyHash          string       `json:"-"`
+	Scopes           string       `json:"scopes"`
+	ExpiresAt        sql.NullTime `json:"expires_at"`
+	LastUsedAt       sql.NullTime `json:"last_used_at"`
+	RevokedAt        sql.NullTime `json:"revoked_at"`
+	CreatedAt        time.Time    `json:"created_at"`
+}
@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// an audit_log partition that was exported to an archive file and dropped
+type AuditArchive struct {
+	ID            int64     `json:"id"`
+	PartitionName string    `json:"partition_name"`
+	RangeStart    time.Time `json:"range_start"`
+	RangeEnd      time.Time `json:"range_end"`
+	RowCount      int64     `json:"row_count"`
+	FirstID       int64     `json:"first_id"` // 0 when the partition was empty
+	LastID        int64     `json:"last_id"`
+	FirstPrevHash string    `json:"first_prev_hash"` // links the archive to the chain before it
+	LastRowHash   string    `json:"last_row_hash"`   // what the first row after it has as prev_hash
+	FileName      string    `json:"file_name"`
+	Checksum      string    `json:"checksum"` // sha256 of the archive file
+	ArchivedAt    time.Time `json:"archived_at"`
+	RetainUntil   time.Time `json:"retain_until"`
+}
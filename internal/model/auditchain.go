@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// tip of the audit hash chain, there is only ever one row
+type AuditChain struct {
+	ID            int16     `json:"id"`
+	LastID        int64     `json:"last_id"`
+	LastHash      string    `json:"last_hash"`
+	LastCreatedAt time.Time `json:"last_created_at"` // entries never get an earlier timestamp than this
+	UpdatedAt     time.Time `json:"updated_at"`
+}
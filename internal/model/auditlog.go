@@ -8,6 +8,9 @@ import (
 
 type ActionType string
 
+// registered in the audit catalogue, unknown event types are rejected at write time
+type EventType string
+
 const (
 	CREATE ActionType = "CREATE"
 	UPDATE ActionType = "UPDATE"
@@ -17,14 +20,16 @@ const (
 
 type AuditLog struct {
 	ID               int64      `json:"id"`
-	CreatedBy        int64      `json:"created_by"`
+	CreatedBy        int64      `json:"created_by"` // 0 for anonymous events, e.g. a failed login for an unknown user
 	AffectedRecordID int64      `json:"affected_record_id"`
 	RequestId        uuid.UUID  `json:"request_id"`
 	ActionType       ActionType `json:"action_type"`
-	EventType        string     `json:"event_type"` // always implement this using a strict typing
+	EventType        EventType  `json:"event_type"`
 	AffectedRecord   Table      `json:"affected_table"`
 	OldData          string     `json:"old_data"`
 	NewData          string     `json:"new_data"`
 	IPAddress        string     `json:"ip_address"`
 	CreatedAt        time.Time  `json:"created_at"`
+	PrevHash         string     `json:"prev_hash"` // row_hash of the previous row, empty for the first one
+	RowHash          string     `json:"row_hash"`
 }
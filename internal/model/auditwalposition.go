@@ -0,0 +1,10 @@
+package model
+
+import "time"
+
+// how far an instance's async audit write-ahead file is committed
+type AuditWALPosition struct {
+	Instance  string    `json:"instance"`
+	Seq       int64     `json:"seq"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
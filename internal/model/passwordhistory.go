@@ -0,0 +1,13 @@
+package model
+
+import (
+	"time"
+)
+
+// previous password hashes, used to prevent reuse
+type PasswordHistory struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Password  string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
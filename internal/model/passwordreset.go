@@ -0,0 +1,17 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// admin-initiated one-time reset token, only the hash is stored
+type PasswordReset struct {
+	ID        int64        `json:"id"`
+	UserID    int64        `json:"user_id"`
+	CreatedBy int64        `json:"created_by"`
+	TokenHash string       `json:"-"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	UsedAt    sql.NullTime `json:"used_at"`
+	CreatedAt time.Time    `json:"created_at"`
+}
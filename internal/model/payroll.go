@@ -4,13 +4,42 @@ import (
 	"time"
 )
 
+type PayrollStatus string
+
+const (
+	PAYROLLDRAFT     PayrollStatus = "DRAFT"     // defined, not taking inputs yet
+	PAYROLLOPEN      PayrollStatus = "OPEN"      // attendance and proposals are accepted
+	PAYROLLLOCKED    PayrollStatus = "LOCKED"    // inputs frozen, can be previewed and run
+	PAYROLLRUN       PayrollStatus = "RUN"       // payslips issued, not visible to employees yet
+	PAYROLLSUBMITTED PayrollStatus = "SUBMITTED" // waiting for a second admin to approve or reject
+	PAYROLLAPPROVED  PayrollStatus = "APPROVED"  // payslips signed off and visible
+	PAYROLLPAID      PayrollStatus = "PAID"      // money left the bank, final
+	PAYROLLREOPENED  PayrollStatus = "REOPENED"  // run undone to correct inputs, payslips are kept under their revision
+)
+
 type Payroll struct {
-	IsRun       bool      `json:"is_run"`
-	ID          int64     `json:"id"`
-	CreatedBy   int64     `json:"created_by"`
-	UpdatedBy   int64     `json:"updated_by"`
-	StartPeriod time.Time `json:"start_period"`
-	EndPeriod   time.Time `json:"end_period"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          int64         `json:"id"`
+	Status      PayrollStatus `json:"status"`
+	Revision    int           `json:"revision"` // number of times the payroll was run
+	CreatedBy   int64         `json:"created_by"`
+	UpdatedBy   int64         `json:"updated_by"`
+	StartPeriod time.Time     `json:"start_period"`
+	EndPeriod   time.Time     `json:"end_period"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+// payslips exist for the current revision
+func (p Payroll) IsRun() bool {
+	return p.Status == PAYROLLRUN || p.Status == PAYROLLSUBMITTED || p.Status == PAYROLLAPPROVED || p.Status == PAYROLLPAID
+}
+
+// employees only see payslips a second admin signed off
+func (p Payroll) IsApproved() bool {
+	return p.Status == PAYROLLAPPROVED || p.Status == PAYROLLPAID
+}
+
+// attendance and proposals dated inside the period are rejected
+func (p Payroll) InputsFrozen() bool {
+	return p.Status == PAYROLLLOCKED || p.IsRun()
 }
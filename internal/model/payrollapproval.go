@@ -0,0 +1,28 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+type ApprovalDecision string
+
+const (
+	APPROVALAPPROVED ApprovalDecision = "APPROVED"
+	APPROVALREJECTED ApprovalDecision = "REJECTED"
+)
+
+// maker-checker record of one submitted run, Decision is empty while it's pending
+type PayrollApproval struct {
+	ID              int64            `json:"id"`
+	PayrollID       int64            `json:"payroll_id"`
+	Revision        int              `json:"revision"`
+	RanBy           int64            `json:"ran_by"`
+	SubmittedBy     int64            `json:"submitted_by"`
+	SubmitComment   string           `json:"submit_comment"`
+	SubmittedAt     time.Time        `json:"submitted_at"`
+	Decision        ApprovalDecision `json:"decision"`
+	DecidedBy       sql.NullInt64    `json:"decided_by"`
+	DecisionComment string           `json:"decision_comment"`
+	DecidedAt       sql.NullTime     `json:"decided_at"`
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type RunStatus string
+
+const (
+	RUNRUNNING  RunStatus = "RUNNING"  // payslips are being issued, or the process died while issuing them
+	RUNFAILED   RunStatus = "FAILED"   // nothing was issued, the same key can be retried
+	RUNCOMPLETE RunStatus = "COMPLETE" // issued, retries with the same key get the stored result
+)
+
+// one attempt to run a payroll under an idempotency key, Revision is only set once it completes
+type PayrollRun struct {
+	ID             int64         `json:"id"`
+	PayrollID      int64         `json:"payroll_id"`
+	IdempotencyKey uuid.UUID     `json:"idempotency_key"`
+	Status         RunStatus     `json:"status"`
+	Attempts       int           `json:"attempts"`
+	Revision       sql.NullInt64 `json:"revision"`
+	Payslips       int           `json:"payslips"`
+	Error          string        `json:"error"`
+	StartedBy      int64         `json:"started_by"`
+	StartedAt      time.Time     `json:"started_at"`
+	FinishedAt     sql.NullTime  `json:"finished_at"`
+	Progress       *RunProgress  `json:"progress,omitempty"` // not stored, only the instance executing the run knows it
+}
+
+type RunProgress struct {
+	Computed  int `json:"computed"`
+	Headcount int `json:"headcount"`
+}
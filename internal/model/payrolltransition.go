@@ -0,0 +1,16 @@
+package model
+
+import (
+	"time"
+)
+
+// From is empty when the payroll was defined
+type PayrollTransition struct {
+	ID        int64         `json:"id"`
+	PayrollID int64         `json:"payroll_id"`
+	From      PayrollStatus `json:"from_status"`
+	To        PayrollStatus `json:"to_status"`
+	Reason    string        `json:"reason"`
+	CreatedAt time.Time     `json:"created_at"`
+	CreatedBy int64         `json:"created_by"`
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/money"
+)
+
+type PayslipItemKind string
+
+const (
+	ATTENDANCEITEM    PayslipItemKind = "ATTENDANCE"
+	OVERTIMEITEM      PayslipItemKind = "OVERTIME"
+	REIMBURSEMENTITEM PayslipItemKind = "REIMBURSEMENT"
+)
+
+// frozen when the payroll is run, every figure is stored as computed together with the rules it was rounded by
+type Payslip struct {
+	ID                 int64               `json:"id"`
+	PayrollID          int64               `json:"payroll_id"`
+	Revision           int                 `json:"revision"`
+	UserID             int64               `json:"user_id"`
+	PeriodStart        time.Time           `json:"period_start"`
+	PeriodEnd          time.Time           `json:"period_end"`
+	Currency           money.Currency      `json:"currency"`
+	RoundingMode       money.RoundingMode  `json:"rounding_mode"`
+	RoundingScope      money.RoundingScope `json:"rounding_scope"`
+	BaseSalary         money.Decimal       `json:"base_salary"`
+	WorkingDays        int                 `json:"working_days"`
+	AttendedDays       int                 `json:"attended_days"`
+	AttendancePay      money.Decimal       `json:"attendance_pay"`
+	HourlyRate         money.Decimal       `json:"hourly_rate"`
+	OvertimeHours      money.Decimal       `json:"overtime_hours"`
+	OvertimeMultiplier money.Decimal       `json:"overtime_multiplier"`
+	OvertimePay        money.Decimal       `json:"overtime_pay"`
+	ReimbursementPay   money.Decimal       `json:"reimbursement_pay"`
+	TakeHomePay        money.Decimal       `json:"take_home_pay"`
+	CreatedAt          time.Time           `json:"created_at"`
+	CreatedBy          int64               `json:"created_by"`
+}
+
+// one input of a payslip, the columns that don't apply to the kind are NULL
+type PayslipItem struct {
+	ID          int64             `json:"id"`
+	PayslipID   int64             `json:"payslip_id"`
+	Kind        PayslipItemKind   `json:"kind"`
+	SourceID    int64             `json:"source_id"`
+	ItemDate    time.Time         `json:"item_date"`
+	Description sql.NullString    `json:"description"`
+	Hours       money.NullDecimal `json:"hours"`
+	Rate        money.NullDecimal `json:"rate"`
+	Multiplier  money.NullDecimal `json:"multiplier"`
+	Amount      money.Decimal     `json:"amount"`
+}
@@ -9,4 +9,17 @@ const (
 	OVERTIME      Table = "overtime"
 	PAYROLL       Table = "payroll"
 	AUDITLOG      Table = "audit_log"
+	RECOVERYCODE  Table = "recovery_code"
+	PWDHISTORY    Table = "password_history"
+	PWDRESET      Table = "password_reset"
+	USERINVITE    Table = "user_invite"
+	APIKEY        Table = "api_key"
+	AUDITCHAIN    Table = "audit_chain"
+	AUDITARCHIVE  Table = "audit_archive"
+	AUDITWALPOS   Table = "audit_wal_position"
+	PAYSLIP       Table = "payslip"
+	PAYSLIPITEM   Table = "payslip_item"
+	PAYROLLTRANS  Table = "payroll_transition"
+	PAYROLLAPPR   Table = "payroll_approval"
+	PAYROLLRUNS   Table = "payroll_run"
 )
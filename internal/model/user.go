@@ -1,7 +1,10 @@
 package model
 
 import (
+	"database/sql"
 	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/money"
 )
 
 type Role string
@@ -9,16 +12,30 @@ type Role string
 const (
 	ADMIN    Role = "ADMIN"
 	EMPLOYEE Role = "EMPLOYEE"
+	SERVICE  Role = "SERVICE" // machine integrations, authenticated by API key only
 )
 
 type User struct {
-	ID        int64     `json:"id"`
-	CreatedBy int64     `json:"created_by"`
-	UpdatedBy int64     `json:"updated_by"`
-	Salary    float64   `json:"salary"`
-	Username  string    `json:"username"`
-	Password  string    `json:"password"`
-	UserRole  Role      `json:"user_role"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID         int64         `json:"id"`
+	CreatedBy  int64         `json:"created_by"`
+	UpdatedBy  int64         `json:"updated_by"`
+	Salary     money.Decimal `json:"salary"`
+	Username   string        `json:"username"`
+	Department string        `json:"department"` // free text, empty when not assigned
+	Password   string        `json:"-"`          // bcrypt hash, kept out of API responses and audit payloads
+	UserRole   Role          `json:"user_role"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+
+	PasswordChangedAt time.Time    `json:"password_changed_at"`
+	ActivatedAt       sql.NullTime `json:"activated_at"` // NULL while an invitation is pending
+
+	// external identity, set once the user logged in through the IdP
+	OIDCIssuer  sql.NullString `json:"-"`
+	OIDCSubject sql.NullString `json:"-"`
+
+	// two-factor authentication, secret is never serialized
+	TOTPEnabled  bool   `json:"totp_enabled"`
+	TOTPLastStep int64  `json:"-"`
+	TOTPSecret   string `json:"-"`
 }
@@ -0,0 +1,368 @@
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// amounts are kept in DECIMAL(20, 2) columns, anything finer is rejected on input
+const StorageScale int32 = 2
+
+var ErrInvalidDecimal = errors.New("invalid decimal")
+
+// Parse bounds, well past DECIMAL(26, 6), the widest column, but an exponent can't make it allocate
+const (
+	maxDigits = 40 // digits as written, and digits of the value once an exponent moved the point
+	maxScale  = 20
+)
+
+// exact base-10 number coef * 10^-scale, the zero value is 0
+// coef is never mutated once set, so values can be copied freely
+type Decimal struct {
+	coef  *big.Int
+	scale int32
+}
+
+var (
+	bigTen = big.NewInt(10)
+	bigOne = big.NewInt(1)
+)
+
+// NewDecimal(12345, 2) is 123.45
+func NewDecimal(coef int64, scale int32) Decimal {
+	if scale < 0 {
+		return Decimal{coef: new(big.Int).Mul(big.NewInt(coef), pow10(-scale))}
+	}
+	return Decimal{coef: big.NewInt(coef), scale: scale}
+}
+
+func FromInt(v int64) Decimal {
+	return Decimal{coef: big.NewInt(v)}
+}
+
+// accepts plain decimal notation with an optional exponent, the way NUMERIC columns and JSON numbers come in
+func Parse(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Decimal{}, ErrInvalidDecimal
+	}
+
+	mantissa, exp := s, int64(0)
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.ParseInt(s[i+1:], 10, 32)
+		if err != nil {
+			return Decimal{}, ErrInvalidDecimal
+		}
+		mantissa, exp = s[:i], e
+	}
+
+	neg := false
+	switch {
+	case strings.HasPrefix(mantissa, "-"):
+		neg, mantissa = true, mantissa[1:]
+	case strings.HasPrefix(mantissa, "+"):
+		mantissa = mantissa[1:]
+	}
+
+	intPart, fracPart, _ := strings.Cut(mantissa, ".")
+	digits := intPart + fracPart
+	if digits == "" || strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+		return Decimal{}, ErrInvalidDecimal
+	}
+	// before SetString, which is quadratic in the length
+	if len(digits) > maxDigits {
+		return Decimal{}, ErrInvalidDecimal
+	}
+
+	coef, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return Decimal{}, ErrInvalidDecimal
+	}
+	if neg {
+		coef.Neg(coef)
+	}
+
+	scale := int64(len(fracPart)) - exp
+	if scale > maxScale {
+		return Decimal{}, ErrInvalidDecimal
+	}
+	if scale < 0 && int64(len(strings.TrimLeft(digits, "0")))-scale > maxDigits {
+		return Decimal{}, ErrInvalidDecimal
+	}
+	if scale < 0 {
+		coef.Mul(coef, pow10(int32(-scale)))
+		scale = 0
+	}
+
+	return Decimal{coef: coef, scale: int32(scale)}, nil
+}
+
+// for constants in code, panics on malformed input
+func MustParse(s string) Decimal {
+	d, err := Parse(s)
+	if err != nil {
+		panic(fmt.Sprintf("money: %q: %v", s, err))
+	}
+	return d
+}
+
+// rounds an exact fraction to scale decimals, this is the only place precision is ever lost
+func FromRat(r *big.Rat, scale int32, mode RoundingMode) Decimal {
+	num := new(big.Int).Mul(r.Num(), pow10(scale))
+	den := r.Denom()
+
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Sign() != 0 {
+		// compare twice the remainder with the denominator to find which side of the half it is on
+		twice := new(big.Int).Abs(rem)
+		twice.Lsh(twice, 1)
+		cmp := twice.Cmp(den)
+
+		up := false
+		switch mode {
+		case HalfUp:
+			up = cmp >= 0
+		default:
+			up = cmp > 0 || (cmp == 0 && q.Bit(0) == 1)
+		}
+
+		// QuoRem truncates toward zero, so rounding up moves away from zero
+		if up {
+			if num.Sign() < 0 {
+				q.Sub(q, bigOne)
+			} else {
+				q.Add(q, bigOne)
+			}
+		}
+	}
+
+	return Decimal{coef: q, scale: scale}
+}
+
+func (d Decimal) int() *big.Int {
+	if d.coef == nil {
+		return new(big.Int)
+	}
+	return d.coef
+}
+
+func (d Decimal) Scale() int32 {
+	return d.scale
+}
+
+func (d Decimal) Sign() int {
+	return d.int().Sign()
+}
+
+func (d Decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+// numeric comparison, 1.50 and 1.5 are equal
+func (d Decimal) Cmp(o Decimal) int {
+	a, b := align(d, o)
+	return a.Cmp(b)
+}
+
+func (d Decimal) Equal(o Decimal) bool {
+	return d.Cmp(o) == 0
+}
+
+func (d Decimal) Add(o Decimal) Decimal {
+	a, b := align(d, o)
+	return Decimal{coef: new(big.Int).Add(a, b), scale: max(d.scale, o.scale)}
+}
+
+func (d Decimal) Sub(o Decimal) Decimal {
+	a, b := align(d, o)
+	return Decimal{coef: new(big.Int).Sub(a, b), scale: max(d.scale, o.scale)}
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{coef: new(big.Int).Neg(d.int()), scale: d.scale}
+}
+
+// exact, the scale of the product is the sum of both scales
+func (d Decimal) Mul(o Decimal) Decimal {
+	return Decimal{coef: new(big.Int).Mul(d.int(), o.int()), scale: d.scale + o.scale}
+}
+
+func (d Decimal) MulInt(v int64) Decimal {
+	return Decimal{coef: new(big.Int).Mul(d.int(), big.NewInt(v)), scale: d.scale}
+}
+
+// exact value as a fraction, division happens on these and is rounded once with FromRat
+func (d Decimal) Rat() *big.Rat {
+	return new(big.Rat).SetFrac(d.int(), pow10(d.scale))
+}
+
+func (d Decimal) Round(scale int32, mode RoundingMode) Decimal {
+	if scale >= d.scale {
+		return d.Rescale(scale)
+	}
+	return FromRat(d.Rat(), scale, mode)
+}
+
+// pads with zeros up to scale, never drops digits, use Round for that
+func (d Decimal) Rescale(scale int32) Decimal {
+	if scale <= d.scale {
+		return d
+	}
+	return Decimal{coef: new(big.Int).Mul(d.int(), pow10(scale-d.scale)), scale: scale}
+}
+
+// true when the value has no non-zero digits past scale decimals
+func (d Decimal) FitsScale(scale int32) bool {
+	if d.scale <= scale {
+		return true
+	}
+	rem := new(big.Int).Rem(d.int(), pow10(d.scale-scale))
+	return rem.Sign() == 0
+}
+
+// whole values only, false when there is a fraction or it doesn't fit
+func (d Decimal) Int64() (int64, bool) {
+	if !d.FitsScale(0) {
+		return 0, false
+	}
+	v := new(big.Int).Quo(d.int(), pow10(d.scale))
+	if !v.IsInt64() {
+		return 0, false
+	}
+	return v.Int64(), true
+}
+
+// fixed point with exactly Scale() decimals
+func (d Decimal) String() string {
+	digits := new(big.Int).Abs(d.int()).String()
+
+	sign := ""
+	if d.Sign() < 0 {
+		sign = "-"
+	}
+
+	if d.scale == 0 {
+		return sign + digits
+	}
+
+	if pad := int(d.scale) + 1 - len(digits); pad > 0 {
+		digits = strings.Repeat("0", pad) + digits
+	}
+	cut := len(digits) - int(d.scale)
+
+	return sign + digits[:cut] + "." + digits[cut:]
+}
+
+// a JSON string so clients never parse it into a float
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + d.String() + `"`), nil
+}
+
+// both "12.50" and 12.50 are accepted, the number is read from its text and never goes through a float
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+
+	s := string(b)
+	if len(b) >= 2 && b[0] == '"' && b[len(b)-1] == '"' {
+		s = string(b[1 : len(b)-1])
+	}
+
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+
+	return nil
+}
+
+func (d *Decimal) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return d.scanString(string(v))
+	case string:
+		return d.scanString(v)
+	case int64:
+		*d = FromInt(v)
+		return nil
+	case float64:
+		// only double precision columns end up here, their shortest text is what postgres would print
+		return d.scanString(strconv.FormatFloat(v, 'f', -1, 64))
+	case nil:
+		return errors.New("money: cannot scan NULL into Decimal, use NullDecimal")
+	}
+	return fmt.Errorf("money: cannot scan %T into Decimal", src)
+}
+
+func (d *Decimal) scanString(s string) error {
+	v, err := Parse(s)
+	if err != nil {
+		return fmt.Errorf("money: cannot scan %q into Decimal", s)
+	}
+	*d = v
+	return nil
+}
+
+// sent as text, postgres casts it to the column's NUMERIC without a float in between
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// a Decimal that can be NULL, serialized as JSON null when not valid
+type NullDecimal struct {
+	Decimal Decimal
+	Valid   bool
+}
+
+func (n *NullDecimal) Scan(src any) error {
+	if src == nil {
+		*n = NullDecimal{}
+		return nil
+	}
+	n.Valid = true
+	return n.Decimal.Scan(src)
+}
+
+func (n NullDecimal) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return n.Decimal.Value()
+}
+
+func (n NullDecimal) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return n.Decimal.MarshalJSON()
+}
+
+func (n *NullDecimal) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		*n = NullDecimal{}
+		return nil
+	}
+	n.Valid = true
+	return n.Decimal.UnmarshalJSON(b)
+}
+
+func align(a, b Decimal) (*big.Int, *big.Int) {
+	switch {
+	case a.scale < b.scale:
+		return a.Rescale(b.scale).int(), b.int()
+	case a.scale > b.scale:
+		return a.int(), b.Rescale(a.scale).int()
+	}
+	return a.int(), b.int()
+}
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
@@ -0,0 +1,154 @@
+package money
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  string
+		scale int32
+	}{
+		{"0", "0", 0},
+		{"-0", "0", 0},
+		{"12", "12", 0},
+		{"+12", "12", 0},
+		{" 12.50 ", "12.50", 2},
+		{"-12.345", "-12.345", 3},
+		{".5", "0.5", 1},
+		{"5.", "5", 0},
+		{"0.000001", "0.000001", 6},
+		{"1e3", "1000", 0},
+		{"1.5E2", "150", 0},
+		{"1.25e1", "12.5", 1},
+		{"125e-2", "1.25", 2},
+		{"-7e-3", "-0.007", 3},
+		{strings.Repeat("9", maxDigits), strings.Repeat("9", maxDigits), 0},
+		{"1e" + "39", "1" + strings.Repeat("0", 39), 0},
+		{"0e40", "0", 0},
+		{"1e-20", "0." + strings.Repeat("0", 19) + "1", 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			d, err := Parse(tt.in)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.in, err)
+			}
+			if got := d.String(); got != tt.want {
+				t.Errorf("Parse(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+			if d.Scale() != tt.scale {
+				t.Errorf("Parse(%q) scale = %d, want %d", tt.in, d.Scale(), tt.scale)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		" ",
+		"-",
+		".",
+		"abc",
+		"1.2.3",
+		"--1",
+		"1,000",
+		"0x10",
+		"1e",
+		"1e1.5",
+		"e5",
+		"NaN",
+		"Inf",
+		"1e99999999999",
+		"1e-21",
+		"1e40",
+		strings.Repeat("9", maxDigits+1),
+		"0." + strings.Repeat("0", maxDigits),
+		strings.Repeat("1", 1_000_000),
+	}
+
+	for _, in := range tests {
+		name := in
+		if len(name) > 50 {
+			name = name[:50] + "..."
+		}
+		t.Run(name, func(t *testing.T) {
+			if d, err := Parse(in); !errors.Is(err, ErrInvalidDecimal) {
+				t.Fatalf("Parse(%q) = %s, %v, want ErrInvalidDecimal", name, d, err)
+			}
+		})
+	}
+}
+
+func TestFromRat(t *testing.T) {
+	tests := []struct {
+		num, den int64
+		scale    int32
+		halfEven string
+		halfUp   string
+	}{
+		{1, 2, 0, "0", "1"},
+		{3, 2, 0, "2", "2"},
+		{5, 2, 0, "2", "3"},
+		{-1, 2, 0, "0", "-1"},
+		{-3, 2, 0, "-2", "-2"},
+		{-5, 2, 0, "-2", "-3"},
+		{125, 1000, 2, "0.12", "0.13"},
+		{135, 1000, 2, "0.14", "0.14"},
+		{-125, 1000, 2, "-0.12", "-0.13"},
+		{-135, 1000, 2, "-0.14", "-0.14"},
+		{1, 3, 2, "0.33", "0.33"},
+		{2, 3, 2, "0.67", "0.67"},
+		{-2, 3, 2, "-0.67", "-0.67"},
+		{1249, 10000, 2, "0.12", "0.12"},
+		{1251, 10000, 2, "0.13", "0.13"},
+		{-1251, 10000, 2, "-0.13", "-0.13"},
+		{7, 1, 2, "7.00", "7.00"},
+		{0, 1, 2, "0.00", "0.00"},
+		{1, 400, 2, "0.00", "0.00"},
+		{1, 200, 2, "0.00", "0.01"},
+		{3, 200, 2, "0.02", "0.02"},
+	}
+
+	for _, tt := range tests {
+		r := big.NewRat(tt.num, tt.den)
+		if got := FromRat(r, tt.scale, HalfEven).String(); got != tt.halfEven {
+			t.Errorf("FromRat(%s, %d, half_even) = %s, want %s", r, tt.scale, got, tt.halfEven)
+		}
+		if got := FromRat(r, tt.scale, HalfUp).String(); got != tt.halfUp {
+			t.Errorf("FromRat(%s, %d, half_up) = %s, want %s", r, tt.scale, got, tt.halfUp)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		d    Decimal
+		want string
+	}{
+		{Decimal{}, "0"},
+		{NewDecimal(0, 2), "0.00"},
+		{NewDecimal(5, 0), "5"},
+		{NewDecimal(-5, 0), "-5"},
+		{NewDecimal(12345, 2), "123.45"},
+		{NewDecimal(-12345, 2), "-123.45"},
+		{NewDecimal(5, 2), "0.05"},
+		{NewDecimal(-5, 2), "-0.05"},
+		{NewDecimal(50, 2), "0.50"},
+		{NewDecimal(1, 6), "0.000001"},
+		{NewDecimal(12, -3), "12000"},
+		{FromInt(-7).Rescale(2), "-7.00"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.d.String(); got != tt.want {
+			t.Errorf("String() = %s, want %s", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUnknownCurrency = errors.New("unknown currency")
+	ErrUnknownRounding = errors.New("unknown rounding rule")
+)
+
+// ISO 4217 code
+type Currency string
+
+// minor units per currency, only currencies that fit DECIMAL(20, 2) are listed
+var minorUnits = map[Currency]int32{
+	"IDR": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"SGD": 2,
+	"MYR": 2,
+	"AUD": 2,
+	"JPY": 0,
+}
+
+func ParseCurrency(code string) (Currency, error) {
+	c := Currency(strings.ToUpper(strings.TrimSpace(code)))
+	if _, ok := minorUnits[c]; !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownCurrency, code)
+	}
+	return c, nil
+}
+
+// decimals the currency is paid out in, payable amounts are rounded to this
+func (c Currency) Scale() int32 {
+	if s, ok := minorUnits[c]; ok {
+		return s
+	}
+	return StorageScale
+}
+
+type RoundingMode string
+
+const (
+	HalfEven RoundingMode = "half_even" // ties go to the even digit, banker's rounding
+	HalfUp   RoundingMode = "half_up"   // ties go away from zero, commercial rounding
+)
+
+type RoundingScope string
+
+const (
+	PerLine  RoundingScope = "line"  // every line is rounded and totals are the sum of the rounded lines
+	PerTotal RoundingScope = "total" // lines are kept exact and only the totals are rounded
+)
+
+type Rounding struct {
+	Mode  RoundingMode  `json:"mode"`
+	Scope RoundingScope `json:"scope"`
+}
+
+func ParseRoundingMode(s string) (RoundingMode, error) {
+	switch m := RoundingMode(strings.ToLower(strings.TrimSpace(s))); m {
+	case HalfEven, HalfUp:
+		return m, nil
+	}
+	return "", fmt.Errorf("%w %q", ErrUnknownRounding, s)
+}
+
+func ParseRoundingScope(s string) (RoundingScope, error) {
+	switch sc := RoundingScope(strings.ToLower(strings.TrimSpace(s))); sc {
+	case PerLine, PerTotal:
+		return sc, nil
+	}
+	return "", fmt.Errorf("%w %q", ErrUnknownRounding, s)
+}
+
+// an amount and the currency it is in
+type Money struct {
+	Amount   Decimal  `json:"amount"`
+	Currency Currency `json:"currency"`
+}
+
+func New(amount Decimal, currency Currency) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+func (m Money) String() string {
+	return string(m.Currency) + " " + m.Amount.String()
+}
@@ -0,0 +1,150 @@
+package pdf
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var ErrEmptyPassword = errors.New("user password must not be empty")
+
+// the user password opens the document, the owner password lifts the permission restrictions
+type Encryption struct {
+	UserPassword  string
+	OwnerPassword string
+}
+
+// printing and accessibility extraction are allowed, modifying and copying are not
+const permissions int32 = -1340
+
+// the padding string of the standard security handler
+var padding = []byte{
+	0x28, 0xbf, 0x4e, 0x5e, 0x4e, 0x75, 0x8a, 0x41, 0x64, 0x00, 0x4e, 0x56, 0xff, 0xfa, 0x01, 0x08,
+	0x2e, 0x2e, 0x00, 0xb6, 0xd0, 0x68, 0x3e, 0x80, 0x2f, 0x0c, 0xa9, 0xfe, 0x64, 0x53, 0x69, 0x7a,
+}
+
+// standard security handler revision 4 with AES-128 for strings and streams
+type encryptor struct {
+	key   []byte
+	owner []byte
+	user  []byte
+}
+
+func newEncryptor(e Encryption, id []byte) (*encryptor, error) {
+	if e.UserPassword == "" {
+		return nil, ErrEmptyPassword
+	}
+	if e.OwnerPassword == "" {
+		// nobody should be able to lift the restrictions without knowing a secret
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		e.OwnerPassword = fmt.Sprintf("%x", random)
+	}
+
+	owner, err := ownerHash(e.OwnerPassword, e.UserPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	// algorithm 2, the file key
+	h := md5.New()
+	h.Write(pad(e.UserPassword))
+	h.Write(owner)
+	binary.Write(h, binary.LittleEndian, permissions)
+	h.Write(id)
+	key := h.Sum(nil)
+	for range 50 {
+		sum := md5.Sum(key)
+		key = sum[:]
+	}
+
+	// algorithm 5, the user hash padded to 32 bytes
+	h = md5.New()
+	h.Write(padding)
+	h.Write(id)
+	user, err := rc4Rounds(key, h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	user = append(user, make([]byte, 16)...)
+
+	return &encryptor{key: key, owner: owner, user: user}, nil
+}
+
+// algorithm 3
+func ownerHash(ownerPassword, userPassword string) ([]byte, error) {
+	key := md5.Sum(pad(ownerPassword))
+	for range 50 {
+		key = md5.Sum(key[:])
+	}
+	return rc4Rounds(key[:], pad(userPassword))
+}
+
+// encrypts data with key and then 19 times with key xor the round number
+func rc4Rounds(key, data []byte) ([]byte, error) {
+	out := append([]byte(nil), data...)
+	round := make([]byte, len(key))
+	for i := range 20 {
+		for j := range key {
+			round[j] = key[j] ^ byte(i)
+		}
+		c, err := rc4.NewCipher(round)
+		if err != nil {
+			return nil, err
+		}
+		c.XORKeyStream(out, out)
+	}
+	return out, nil
+}
+
+func pad(password string) []byte {
+	b := winAnsi(password)
+	if len(b) > 32 {
+		b = b[:32]
+	}
+	return append(b, padding[:32-len(b)]...)
+}
+
+// algorithm 1 for AESV2, every object has its own key and the iv is prepended to the data
+func (e *encryptor) encrypt(obj int, data []byte) ([]byte, error) {
+	h := md5.New()
+	h.Write(e.key)
+	h.Write([]byte{byte(obj), byte(obj >> 8), byte(obj >> 16), 0, 0})
+	h.Write([]byte("sAlT"))
+
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	n := aes.BlockSize - len(data)%aes.BlockSize
+	out := make([]byte, aes.BlockSize, aes.BlockSize+len(data)+n)
+	if _, err := rand.Read(out); err != nil {
+		return nil, err
+	}
+	out = append(out, data...)
+	for range n {
+		out = append(out, byte(n))
+	}
+
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], out[aes.BlockSize:])
+	return out, nil
+}
+
+func (e *encryptor) dictionary() string {
+	return fmt.Sprintf("<< /Filter /Standard /V 4 /R 4 /Length 128 /CF << /StdCF << /Type /CryptFilter /CFM /AESV2 /AuthEvent /DocOpen /Length 16 >> >> /StmF /StdCF /StrF /StdCF /O <%x> /U <%x> /P %d >>",
+		e.owner, e.user, permissions)
+}
+
+func randomID() ([]byte, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	return id, err
+}
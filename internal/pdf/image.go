@@ -0,0 +1,65 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+)
+
+var ErrUnsupportedImage = errors.New("only JPEG and PNG images are supported")
+
+type Image struct {
+	Width  int
+	Height int
+	filter string
+	data   []byte
+}
+
+// JPEGs are embedded as they are, PNGs are flattened to RGB on white
+func LoadImage(data []byte) (*Image, error) {
+	if cfg, err := jpeg.DecodeConfig(bytes.NewReader(data)); err == nil {
+		// the DCT filter needs three components, grayscale and CMYK logos are converted first
+		if cfg.ColorModel == color.YCbCrModel {
+			return &Image{Width: cfg.Width, Height: cfg.Height, filter: "/DCTDecode", data: data}, nil
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return flate(img)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	return flate(img)
+}
+
+func flate(img image.Image) (*Image, error) {
+	bounds := img.Bounds()
+	raw := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			// alpha premultiplied, composited over white
+			white := 0xffff - a
+			raw = append(raw, byte((r+white)>>8), byte((g+white)>>8), byte((b+white)>>8))
+		}
+	}
+
+	var buf bytes.Buffer
+	z := zlib.NewWriter(&buf)
+	if _, err := z.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+
+	return &Image{Width: bounds.Dx(), Height: bounds.Dy(), filter: "/FlateDecode", data: buf.Bytes()}, nil
+}
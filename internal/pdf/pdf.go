@@ -0,0 +1,226 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// A4 portrait in points
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+type Document struct {
+	pages []*Page
+}
+
+type Page struct {
+	content bytes.Buffer
+	images  []*Image
+}
+
+func New() *Document {
+	return &Document{}
+}
+
+func (d *Document) AddPage() *Page {
+	p := &Page{}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+func (d *Document) Pages() int {
+	return len(d.pages)
+}
+
+// draws s with its baseline starting at x, y measured from the bottom left corner
+func (p *Page) Text(x, y, size float64, font Font, s string) {
+	fmt.Fprintf(&p.content, "BT %s %s Tf %s %s Td %s Tj ET\n", font.resource(), num(size), num(x), num(y), literal(s))
+}
+
+// draws s so that it ends at x
+func (p *Page) TextRight(x, y, size float64, font Font, s string) {
+	p.Text(x-TextWidth(s, size, font), y, size, font, s)
+}
+
+// gray is 0 for black and 1 for white
+func (p *Page) Line(x1, y1, x2, y2, width, gray float64) {
+	fmt.Fprintf(&p.content, "q %s G %s w %s %s m %s %s l S Q\n", num(gray), num(width), num(x1), num(y1), num(x2), num(y2))
+}
+
+// filled in black with its bottom left corner at x, y
+func (p *Page) Rect(x, y, w, h float64) {
+	fmt.Fprintf(&p.content, "0 g %s %s %s %s re f\n", num(x), num(y), num(w), num(h))
+}
+
+// places img with its bottom left corner at x, y scaled to w by h
+func (p *Page) Image(img *Image, x, y, w, h float64) {
+	idx := -1
+	for i, existing := range p.images {
+		if existing == img {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		p.images = append(p.images, img)
+		idx = len(p.images) - 1
+	}
+	fmt.Fprintf(&p.content, "q %s 0 0 %s %s %s cm /Im%d Do Q\n", num(w), num(h), num(x), num(y), idx)
+}
+
+// hundredths of a point are finer than any printer resolves
+func num(f float64) string {
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
+
+// object numbers are fixed for the catalog, the page tree and both fonts
+const (
+	catalogObj = 1
+	pagesObj   = 2
+	regularObj = 3
+	boldObj    = 4
+	firstObj   = 5
+)
+
+type writer struct {
+	buf     bytes.Buffer
+	offsets []int
+	enc     *encryptor
+}
+
+func (w *writer) object(n int, body string) {
+	for len(w.offsets) < n {
+		w.offsets = append(w.offsets, 0)
+	}
+	w.offsets[n-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", n, body)
+}
+
+func (w *writer) stream(n int, dict string, data []byte) error {
+	if w.enc != nil {
+		var err error
+		if data, err = w.enc.encrypt(n, data); err != nil {
+			return err
+		}
+	}
+	for len(w.offsets) < n {
+		w.offsets = append(w.offsets, 0)
+	}
+	w.offsets[n-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n<< %s /Length %d >>\nstream\n", n, dict, len(data))
+	w.buf.Write(data)
+	w.buf.WriteString("\nendstream\nendobj\n")
+	return nil
+}
+
+// serializes the document, enc is optional and protects it with the standard security handler
+func (d *Document) Bytes(enc *Encryption) ([]byte, error) {
+	w := &writer{}
+	w.buf.WriteString("%PDF-1.6\n%\xe2\xe3\xcf\xd3\n")
+
+	id := d.id()
+	if enc != nil {
+		var err error
+		if id, err = randomID(); err != nil {
+			return nil, err
+		}
+		if w.enc, err = newEncryptor(*enc, id); err != nil {
+			return nil, err
+		}
+	}
+
+	// images are numbered once per document even when several pages share them
+	imageObj := map[*Image]int{}
+	var images []*Image
+	for _, p := range d.pages {
+		for _, img := range p.images {
+			if _, ok := imageObj[img]; !ok {
+				imageObj[img] = firstObj + len(images)
+				images = append(images, img)
+			}
+		}
+	}
+	pageObj := firstObj + len(images)
+
+	w.object(catalogObj, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesObj))
+
+	kids := ""
+	for i := range d.pages {
+		kids += fmt.Sprintf("%d 0 R ", pageObj+2*i)
+	}
+	w.object(pagesObj, fmt.Sprintf("<< /Type /Pages /Kids [ %s] /Count %d >>", kids, len(d.pages)))
+	w.object(regularObj, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	w.object(boldObj, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for _, img := range images {
+		n := imageObj[img]
+		dict := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter %s", img.Width, img.Height, img.filter)
+		if err := w.stream(n, dict, img.data); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, p := range d.pages {
+		n := pageObj + 2*i
+		xobjects := ""
+		for j, img := range p.images {
+			xobjects += fmt.Sprintf("/Im%d %d 0 R ", j, imageObj[img])
+		}
+		w.object(n, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 %d 0 R /F2 %d 0 R >> /XObject << %s>> >> /Contents %d 0 R >>",
+			pagesObj, num(PageWidth), num(PageHeight), regularObj, boldObj, xobjects, n+1))
+
+		content, err := deflate(p.content.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		if err := w.stream(n+1, "/Filter /FlateDecode", content); err != nil {
+			return nil, err
+		}
+	}
+
+	size := pageObj + 2*len(d.pages)
+	trailer := fmt.Sprintf("/Size %d /Root %d 0 R /ID [<%x> <%x>]", size, catalogObj, id, id)
+	if w.enc != nil {
+		w.object(size, w.enc.dictionary())
+		size++
+		trailer = fmt.Sprintf("/Size %d /Root %d 0 R /Encrypt %d 0 R /ID [<%x> <%x>]", size, catalogObj, size-1, id, id)
+	}
+
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", size)
+	for _, off := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< %s >>\nstartxref\n%d\n%%%%EOF\n", trailer, xref)
+
+	return w.buf.Bytes(), nil
+}
+
+// plain documents get an id derived from their content so that rendering is reproducible
+func (d *Document) id() []byte {
+	h := md5.New()
+	for _, p := range d.pages {
+		h.Write(p.content.Bytes())
+		for _, img := range p.images {
+			h.Write(img.data)
+		}
+	}
+	return h.Sum(nil)
+}
+
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	z := zlib.NewWriter(&buf)
+	if _, err := z.Write(data); err != nil {
+		return nil, err
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,383 @@
+package qr
+
+import (
+	"errors"
+)
+
+var ErrTooLong = errors.New("data does not fit a version 10 QR code")
+
+// error correction is fixed at level M, about 15% of the symbol may be damaged
+type Code struct {
+	Size    int
+	modules [][]bool
+}
+
+// true for a dark module, x is the column and y the row
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// per version at level M, index 0 is unused
+var (
+	totalCodewords = [...]int{0, 26, 44, 70, 100, 134, 172, 196, 242, 292, 346}
+	eccPerBlock    = [...]int{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26}
+	blocks         = [...]int{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5}
+	alignment      = [...][]int{nil, nil, {6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34}, {6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50}}
+)
+
+const maxVersion = 10
+
+// byte mode in the smallest version that fits, the mask with the lowest penalty is chosen
+func Encode(data []byte) (*Code, error) {
+	version := 0
+	for v := 1; v <= maxVersion; v++ {
+		if headerBits(v)+8*len(data) <= 8*dataCodewords(v) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	codewords := interleave(version, payload(version, data))
+
+	var best *builder
+	bestPenalty := -1
+	for mask := range 8 {
+		c := newBuilder(version)
+		c.drawFunctionPatterns(version)
+		c.drawCodewords(codewords)
+		c.applyMask(mask)
+		c.drawFormat(mask)
+		if p := c.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = c, p
+		}
+	}
+
+	return &Code{Size: best.size, modules: best.modules}, nil
+}
+
+func dataCodewords(version int) int {
+	return totalCodewords[version] - eccPerBlock[version]*blocks[version]
+}
+
+// mode indicator and character count, the count grows to 16 bits from version 10
+func headerBits(version int) int {
+	if version < 10 {
+		return 4 + 8
+	}
+	return 4 + 16
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, (v>>i)&1 == 1)
+	}
+}
+
+// the data codewords, terminated and padded to capacity
+func payload(version int, data []byte) []byte {
+	var bits bitBuffer
+	bits.append(0b0100, 4)
+	bits.append(len(data), headerBits(version)-4)
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	capacity := 8 * dataCodewords(version)
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	out := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			out[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return out
+}
+
+// splits the data into blocks, adds their error correction and interleaves both
+func interleave(version int, data []byte) []byte {
+	numBlocks := blocks[version]
+	ecc := eccPerBlock[version]
+	total := totalCodewords[version]
+	numShort := numBlocks - total%numBlocks
+	shortLen := total / numBlocks
+
+	divisor := rsDivisor(ecc)
+	var dataBlocks, eccBlocks [][]byte
+	k := 0
+	for i := range numBlocks {
+		n := shortLen - ecc
+		if i >= numShort {
+			n++
+		}
+		block := data[k : k+n]
+		k += n
+		dataBlocks = append(dataBlocks, block)
+		eccBlocks = append(eccBlocks, rsRemainder(block, divisor))
+	}
+
+	out := make([]byte, 0, total)
+	for i := 0; i <= shortLen-ecc; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := range ecc {
+		for _, block := range eccBlocks {
+			out = append(out, block[i])
+		}
+	}
+	return out
+}
+
+type builder struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+func newBuilder(version int) *builder {
+	size := 17 + 4*version
+	b := &builder{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for i := range size {
+		b.modules[i] = make([]bool, size)
+		b.function[i] = make([]bool, size)
+	}
+	return b
+}
+
+func (b *builder) set(x, y int, dark bool) {
+	b.modules[y][x] = dark
+	b.function[y][x] = true
+}
+
+func (b *builder) drawFunctionPatterns(version int) {
+	for i := range b.size {
+		b.set(6, i, i%2 == 0)
+		b.set(i, 6, i%2 == 0)
+	}
+
+	b.drawFinder(3, 3)
+	b.drawFinder(b.size-4, 3)
+	b.drawFinder(3, b.size-4)
+
+	pos := alignment[version]
+	last := len(pos) - 1
+	for i := range pos {
+		for j := range pos {
+			// the corners overlap the finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					b.set(pos[i]+dx, pos[j]+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// reserved with a dummy mask, drawn for real once the mask is chosen
+	b.drawFormat(0)
+
+	if version >= 7 {
+		rem := version
+		for range 12 {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := range 18 {
+			dark := (bits>>i)&1 == 1
+			a, c := b.size-11+i%3, i/3
+			b.set(a, c, dark)
+			b.set(c, a, dark)
+		}
+	}
+}
+
+// finder with its separator, clipped at the edges
+func (b *builder) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= b.size || yy < 0 || yy >= b.size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			b.set(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// level M has the format bits 00
+func (b *builder) drawFormat(mask int) {
+	data := mask
+	rem := data
+	for range 10 {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	for i := range 6 {
+		b.set(8, i, bit(i))
+	}
+	b.set(8, 7, bit(6))
+	b.set(8, 8, bit(7))
+	b.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		b.set(14-i, 8, bit(i))
+	}
+
+	for i := range 8 {
+		b.set(b.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		b.set(8, b.size-15+i, bit(i))
+	}
+	b.set(8, b.size-8, true)
+}
+
+// zigzags two columns at a time from the bottom right, skipping the vertical timing pattern
+func (b *builder) drawCodewords(data []byte) {
+	i := 0
+	for right := b.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := range b.size {
+			for j := range 2 {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = b.size - 1 - vert
+				}
+				if !b.function[y][x] && i < len(data)*8 {
+					b.modules[y][x] = (data[i/8]>>(7-i%8))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (b *builder) applyMask(mask int) {
+	for y := range b.size {
+		for x := range b.size {
+			if b.function[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			b.modules[y][x] = b.modules[y][x] != invert
+		}
+	}
+}
+
+// the four rules of the standard, lower is easier to scan
+func (b *builder) penalty() int {
+	result := 0
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return b.modules[x][y]
+		}
+		return b.modules[y][x]
+	}
+
+	finderA := []bool{true, false, true, true, true, false, true, false, false, false, false}
+	finderB := []bool{false, false, false, false, true, false, true, true, true, false, true}
+
+	for _, transpose := range []bool{false, true} {
+		for y := range b.size {
+			// runs of five or more of one color
+			run := 1
+			for x := 1; x < b.size; x++ {
+				if at(x, y, transpose) == at(x-1, y, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					result += run - 2
+				}
+				run = 1
+			}
+			if run >= 5 {
+				result += run - 2
+			}
+
+			// patterns that look like a finder
+			for x := 0; x+11 <= b.size; x++ {
+				matchA, matchB := true, true
+				for k := range 11 {
+					m := at(x+k, y, transpose)
+					matchA = matchA && m == finderA[k]
+					matchB = matchB && m == finderB[k]
+				}
+				if matchA {
+					result += 40
+				}
+				if matchB {
+					result += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := range b.size {
+		for x := range b.size {
+			if b.modules[y][x] {
+				dark++
+			}
+			// 2x2 blocks of one color
+			if x > 0 && y > 0 {
+				m := b.modules[y][x]
+				if m == b.modules[y-1][x] && m == b.modules[y][x-1] && m == b.modules[y-1][x-1] {
+					result += 3
+				}
+			}
+		}
+	}
+
+	// every 5% the dark share strays from half
+	total := b.size * b.size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	result += k * 10
+
+	return result
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,122 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/achsanalfitra/gopayslip/internal/config"
+)
+
+// resolves the client address behind the trusted proxies
+// each proxy appends the address it received the request from, so the chain is read right to left
+// and the first hop that isn't a trusted proxy is the client, anything left of it is client supplied
+type ipResolver struct {
+	proxy *config.Proxy
+}
+
+func newIPResolver(proxy *config.Proxy) *ipResolver {
+	return &ipResolver{proxy: proxy}
+}
+
+func (res *ipResolver) Resolve(req *http.Request) string {
+	peer, ok := parseHop(req.RemoteAddr)
+	if !ok {
+		return req.RemoteAddr
+	}
+	if !res.proxy.IsTrusted(peer) {
+		return peer.String()
+	}
+
+	// only the header our proxies set, they pass the other one through as the client sent it
+	var hops []string
+	if res.proxy.Header == config.HeaderForwarded {
+		hops = forwardedHops(req.Header.Values(config.HeaderForwarded))
+	} else {
+		hops = xffHops(req.Header.Values(config.HeaderXForwardedFor))
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			// unknown or obfuscated, nothing further left can be trusted
+			break
+		}
+		client = addr
+		if !res.proxy.IsTrusted(addr) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+// every header line is concatenated in order, as if the proxies had used a single one
+func xffHops(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// RFC 7239, only the for= parameter of each element matters here
+// an element without one still counts as a hop, it just can't be resolved
+func forwardedHops(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, element := range splitQuoted(v, ',') {
+			hop := ""
+			for _, pair := range splitQuoted(element, ';') {
+				name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(strings.TrimSpace(name), "for") {
+					hop = strings.Trim(strings.TrimSpace(value), `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// separators inside quoted strings, e.g. "[2001:db8::1]:4711", don't split
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// accepts 192.0.2.1, 192.0.2.1:443, 2001:db8::1 and [2001:db8::1]:443
+func parseHop(hop string) (netip.Addr, bool) {
+	if hop == "" {
+		return netip.Addr{}, false
+	}
+
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	} else {
+		hop = strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]")
+	}
+
+	addr, err := netip.ParseAddr(hop)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	// zones are local to the proxy and meaningless to us
+	return addr.Unmap().WithZone(""), true
+}
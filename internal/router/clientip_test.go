@@ -0,0 +1,51 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/achsanalfitra/gopayslip/internal/config"
+)
+
+func TestResolve(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name      string
+		header    string
+		peer      string
+		forwarded []string
+		xff       []string
+		want      string
+	}{
+		{"untrusted peer", config.HeaderXForwardedFor, "198.51.100.9:5000", nil, []string{"203.0.113.7"}, "198.51.100.9"},
+		{"xff", config.HeaderXForwardedFor, "10.0.0.1:5000", nil, []string{"203.0.113.7"}, "203.0.113.7"},
+		{"xff chain", config.HeaderXForwardedFor, "10.0.0.1:5000", nil, []string{"192.0.2.1, 203.0.113.7, 10.0.0.2"}, "203.0.113.7"},
+		{"injected forwarded ignored", config.HeaderXForwardedFor, "10.0.0.1:5000", []string{"for=1.2.3.4"}, []string{"203.0.113.7"}, "203.0.113.7"},
+		{"injected forwarded without xff", config.HeaderXForwardedFor, "10.0.0.1:5000", []string{"for=1.2.3.4"}, nil, "10.0.0.1"},
+		{"forwarded", config.HeaderForwarded, "10.0.0.1:5000", []string{`for="[2001:db8::1]:4711";proto=https`}, nil, "2001:db8::1"},
+		{"injected xff ignored", config.HeaderForwarded, "10.0.0.1:5000", []string{"for=203.0.113.7"}, []string{"1.2.3.4"}, "203.0.113.7"},
+		{"obfuscated hop", config.HeaderForwarded, "10.0.0.1:5000", []string{"for=203.0.113.7, for=_hidden"}, nil, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := newIPResolver(&config.Proxy{Trusted: trusted, Header: tt.header})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.peer
+			for _, v := range tt.forwarded {
+				req.Header.Add(config.HeaderForwarded, v)
+			}
+			for _, v := range tt.xff {
+				req.Header.Add(config.HeaderXForwardedFor, v)
+			}
+
+			if got := res.Resolve(req); got != tt.want {
+				t.Fatalf("Resolve() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
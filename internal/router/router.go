@@ -2,37 +2,63 @@ package router
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 
 	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
 	"github.com/achsanalfitra/gopayslip/internal/auth"
+	"github.com/achsanalfitra/gopayslip/internal/model"
 	"github.com/google/uuid"
 )
 
 // configure public path
 var publicPath = map[string]bool{
-	"/api/": true,
+	"/api/":                  true,
+	"/api/login":             true,
+	"/api/login/2fa":         true,
+	"/api/login/2fa/enroll":  true,
+	"/api/login/2fa/confirm": true,
+	"/api/password/reset":    true,
+	"/api/invite/accept":     true,
+	"/api/oidc/login":        true,
+	"/api/oidc/callback":     true,
+	"/api/payslip/verify":    true,
 }
 
+// every path under this prefix requires the ADMIN role
+const adminPathPrefix = "/api/admin/"
+
 type ReqKey string
 type UserKey string
+type RoleKey string
 type StartKey string
 type Endkey string
+type ClientIPKey string
 
 const (
 	CtxRequestKey ReqKey   = "requestkey"
 	CtxUserKey    UserKey  = "userkey"
+	CtxRoleKey    RoleKey  = "rolekey"
 	CtxStartKey   StartKey = "startdate"
 	CtxEndKey     Endkey   = "enddate"
+
+	// resolved through the trusted proxies, for anything keyed on the caller's address
+	CtxClientIPKey ClientIPKey = "clientip"
 )
 
 type Router struct {
 	Route     map[string]map[string]http.HandlerFunc // format -> path: {method: http.HandlerFunc}
+	Scope     map[string]map[string]auth.Scope       // routes reachable with an API key, same format as Route
 	Tokenizer *auth.Tokenizer
 	auth      *auth.AuthHandler
+	ips       *ipResolver
 	a         *app.App
 	mu        sync.RWMutex
 }
@@ -48,18 +74,63 @@ func NewDefaultAuthService() auth.AuthService {
 func NewRouter(a *app.App) *Router {
 	router := Router{
 		Route:     make(map[string]map[string]http.HandlerFunc),
+		Scope:     make(map[string]map[string]auth.Scope),
 		Tokenizer: auth.NewTokenizer(),
+		ips:       newIPResolver(a.Proxy),
 		a:         a,
 		mu:        sync.RWMutex{},
 	}
 
-	// assign inherent auth functionality
-	authSvc := auth.NewAuthService()
-	router.auth = auth.NewAuthHandler(a, authSvc)
+	// assign inherent auth functionality, the IdP wraps the bcrypt service which stays the fallback
+	authSvc := auth.NewAuthService(a.Auth, a.Audit)
+	if a.OIDC != nil && a.OIDC.Enabled {
+		authSvc = auth.NewOIDCAuthService(a.OIDC, authSvc, a.Audit)
+	}
+	mfaSvc := auth.NewMFAService(a.Auth.TOTPIssuer, a.Audit)
+	keySvc := auth.NewAPIKeyService(a.Audit)
+	router.auth = auth.NewAuthHandler(a, authSvc, mfaSvc, keySvc, router.Tokenizer)
+
+	router.registerAuthRoutes()
 
 	return &router
 }
 
+func (r *Router) registerAuthRoutes() {
+	r.RegisterRoute(http.MethodPost, "/api/login", r.auth.LoginHandler)
+
+	// external identity provider
+	if _, ok := r.auth.AuthService.(auth.OIDCService); ok {
+		r.RegisterRoute(http.MethodGet, "/api/oidc/login", r.auth.OIDCLoginHandler)
+		r.RegisterRoute(http.MethodGet, "/api/oidc/callback", r.auth.OIDCCallbackHandler)
+	}
+
+	// two-step login
+	r.RegisterRoute(http.MethodPost, "/api/login/2fa", r.auth.LoginMFAHandler)
+	r.RegisterRoute(http.MethodPost, "/api/login/2fa/enroll", r.auth.LoginEnrollHandler)
+	r.RegisterRoute(http.MethodPost, "/api/login/2fa/confirm", r.auth.LoginConfirmHandler)
+
+	// two-factor management for the current session
+	r.RegisterRoute(http.MethodPost, "/api/2fa/enroll", r.auth.EnrollHandler)
+	r.RegisterRoute(http.MethodPost, "/api/2fa/confirm", r.auth.ConfirmHandler)
+	r.RegisterRoute(http.MethodPost, "/api/2fa/disable", r.auth.DisableHandler)
+
+	// password management
+	r.RegisterRoute(http.MethodPost, "/api/password/change", r.auth.ChangePasswordHandler)
+	r.RegisterRoute(http.MethodPost, "/api/password/reset", r.auth.ResetPasswordHandler)
+	r.RegisterRoute(http.MethodPost, "/api/admin/password/reset", r.auth.IssueResetTokenHandler)
+
+	// provisioning, registration is admin only
+	r.RegisterRoute(http.MethodPost, "/api/admin/users", r.auth.RegisterHandler)
+	r.RegisterRoute(http.MethodPost, "/api/admin/users/invite", r.auth.InviteHandler)
+	r.RegisterRoute(http.MethodPost, "/api/invite/accept", r.auth.AcceptInviteHandler)
+
+	// service accounts and their API keys
+	r.RegisterRoute(http.MethodPost, "/api/admin/service-accounts", r.auth.CreateServiceAccountHandler)
+	r.RegisterRoute(http.MethodPost, "/api/admin/service-accounts/keys", r.auth.IssueKeyHandler)
+	r.RegisterRoute(http.MethodGet, "/api/admin/service-accounts/keys", r.auth.ListKeysHandler)
+	r.RegisterRoute(http.MethodPost, "/api/admin/service-accounts/keys/revoke", r.auth.RevokeKeyHandler)
+}
+
 func (r *Router) RegisterRoute(method, path string, handler http.HandlerFunc) error {
 	// instantiate the path if it doesn't exist
 	if _, exists := r.Route[path]; !exists {
@@ -76,6 +147,21 @@ func (r *Router) RegisterRoute(method, path string, handler http.HandlerFunc) er
 	return nil
 }
 
+// same as RegisterRoute, but API keys holding the scope may call it as well
+func (r *Router) RegisterScopedRoute(method, path string, scope auth.Scope, handler http.HandlerFunc) error {
+	if err := r.RegisterRoute(method, path, handler); err != nil {
+		return err
+	}
+
+	if _, exists := r.Scope[path]; !exists {
+		r.Scope[path] = make(map[string]auth.Scope)
+	}
+
+	r.Scope[path][method] = scope
+
+	return nil
+}
+
 // boilerplate entry point for accessing the handler function
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	path := req.URL.Path
@@ -95,50 +181,94 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if !publicPath[path] {
-		// parse header, look for Authorization
-		access, err := r.Tokenizer.ReadToken(req)
-		if err != nil {
-			http.Error(w, "bad authorization header", http.StatusUnauthorized)
-			return
-		}
-		if err := r.Tokenizer.AuthorizeToken(access); err != nil {
-			http.Error(w, "token unauthorized", http.StatusUnauthorized)
-			return
-		}
+	// every request gets an id and an audit actor, public paths just have no user yet
+	newRequestId := uuid.New()
+	clientIP := r.ips.Resolve(req)
+	actor := audit.Actor{RequestID: newRequestId, IP: clientIP}
 
-		user, err := r.Tokenizer.GetUserFromAccess(access)
-		if err != nil {
-			http.Error(w, "token unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		userID, err := r.auth.UserIDFromToken(user)
+	if !publicPath[path] {
+		userID, role, session, status, err := r.authenticate(req, path, method)
 		if err != nil {
-			http.Error(w, "token unauthorized", http.StatusUnauthorized)
+			http.Error(w, err.Error(), status)
 			return
 		}
+		actor.UserID = userID
+		actor.Session = session
 
 		// Context injection
-		currentCtx := req.Context()
+		currentCtx := audit.WithActor(req.Context(), actor)
 
 		// Lock this to prevent panic
 		r.mu.RLock()
 		currentCtx = context.WithValue(currentCtx, CtxStartKey, r.a.InitStates[string(CtxStartKey)])
 		currentCtx = context.WithValue(currentCtx, CtxEndKey, r.a.InitStates[string(CtxEndKey)])
-		r.mu.Unlock()
+		r.mu.RUnlock()
 
 		// TODO: implement freeze for POST methods based on dates
 
-		newRequestId := uuid.New()
 		currentCtx = context.WithValue(currentCtx, CtxRequestKey, newRequestId)
+		currentCtx = context.WithValue(currentCtx, CtxClientIPKey, clientIP)
 		currentCtx = context.WithValue(currentCtx, CtxUserKey, userID)
+		currentCtx = context.WithValue(currentCtx, CtxRoleKey, role)
 
 		// Update the request with the fully populated context
 		req = req.WithContext(currentCtx)
 
 		r.Route[path][method](w, req)
+		return
 	}
 
+	currentCtx := audit.WithActor(req.Context(), actor)
+	currentCtx = context.WithValue(currentCtx, CtxRequestKey, newRequestId)
+	currentCtx = context.WithValue(currentCtx, CtxClientIPKey, clientIP)
+	req = req.WithContext(currentCtx)
+
 	r.Route[path][method](w, req)
 }
+
+// resolves the caller from either a session token or an API key
+// session identifies the credential without exposing it, an access token lives until the next refresh
+func (r *Router) authenticate(req *http.Request, path, method string) (userID int64, role model.Role, session string, status int, err error) {
+	if key, ok := auth.ReadAPIKey(req); ok {
+		ctx := context.WithValue(req.Context(), app.PQ, r.a.DB)
+
+		principal, err := r.auth.APIKeyService.Authenticate(key, ctx)
+		if err != nil {
+			return 0, "", "", http.StatusUnauthorized, errors.New("api key unauthorized")
+		}
+
+		// keys only reach routes that declare a scope, everything else is session only
+		scope, scoped := r.Scope[path][method]
+		if !scoped || !principal.HasScope(scope) {
+			return 0, "", "", http.StatusForbidden, errors.New("forbidden")
+		}
+
+		return principal.ServiceAccountID, model.SERVICE, fmt.Sprintf("apikey:%d", principal.KeyID), 0, nil
+	}
+
+	// parse header, look for Authorization
+	access, err := r.Tokenizer.ReadToken(req)
+	if err != nil {
+		return 0, "", "", http.StatusUnauthorized, errors.New("bad authorization header")
+	}
+	if err := r.Tokenizer.AuthorizeToken(access); err != nil {
+		return 0, "", "", http.StatusUnauthorized, errors.New("token unauthorized")
+	}
+
+	user, err := r.Tokenizer.GetUserFromAccess(access)
+	if err != nil {
+		return 0, "", "", http.StatusUnauthorized, errors.New("token unauthorized")
+	}
+
+	userID, role, err = r.auth.UserIdentityFromToken(user)
+	if err != nil {
+		return 0, "", "", http.StatusUnauthorized, errors.New("token unauthorized")
+	}
+
+	if strings.HasPrefix(path, adminPathPrefix) && role != model.ADMIN {
+		return 0, "", "", http.StatusForbidden, errors.New("forbidden")
+	}
+
+	sum := sha256.Sum256([]byte(access))
+	return userID, role, "token:" + hex.EncodeToString(sum[:8]), 0, nil
+}
@@ -0,0 +1,75 @@
+package router
+
+import (
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// fixed windows per client address, for public routes that can't tell callers apart otherwise
+type throttle struct {
+	limit  int
+	window time.Duration
+
+	mu         sync.Mutex
+	hits       map[string]int
+	windowFrom time.Time
+}
+
+// at most limit requests per window from one address, 0 disables the limit
+// it reads the address the router resolved behind the trusted proxies
+func Throttle(limit int, window time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	if limit <= 0 {
+		return next
+	}
+
+	t := &throttle{limit: limit, window: window, hits: make(map[string]int), windowFrom: time.Now()}
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		ip, _ := req.Context().Value(CtxClientIPKey).(string)
+
+		if retry, ok := t.allow(throttleKey(ip)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds()+1)))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next(w, req)
+	}
+}
+
+func (t *throttle) allow(key string) (retryAfter time.Duration, ok bool) {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// the whole map is dropped with the window, it never outgrows one window of addresses
+	if elapsed := now.Sub(t.windowFrom); elapsed >= t.window {
+		clear(t.hits)
+		t.windowFrom = now.Add(-(elapsed % t.window))
+	}
+
+	if t.hits[key] >= t.limit {
+		return t.window - now.Sub(t.windowFrom), false
+	}
+	t.hits[key]++
+
+	return 0, true
+}
+
+// an IPv6 client usually holds a whole /64, counting single addresses would let it rotate through them
+func throttleKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap().WithZone("")
+	if addr.Is4() {
+		return addr.String()
+	}
+	prefix, _ := addr.Prefix(64)
+	return prefix.String()
+}
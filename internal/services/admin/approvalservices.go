@@ -0,0 +1,234 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/achsanalfitra/gopayslip/hlp"
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+)
+
+// the maker side, anyone can submit but whoever ran or submitted the payroll can't approve it
+func (a *adminSvcImpl) SubmitPayroll(userID, payrollID int64, comment string, ctx context.Context) (model.PayrollApproval, error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return model.PayrollApproval{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.PayrollApproval{}, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	payroll, err := lockPayroll(payrollID, tx, ctx)
+	if err != nil {
+		return model.PayrollApproval{}, err
+	}
+
+	updated, err := a.transition(payroll, model.PAYROLLSUBMITTED, userID, comment, tx, ctx)
+	if err != nil {
+		return model.PayrollApproval{}, err
+	}
+
+	approval := model.PayrollApproval{
+		PayrollID:     updated.ID,
+		Revision:      updated.Revision,
+		SubmittedBy:   userID,
+		SubmitComment: strings.TrimSpace(comment),
+		SubmittedAt:   updated.UpdatedAt,
+	}
+
+	// the runner of the current revision, RUN is only ever reached through RunPayroll
+	query := `SELECT created_by FROM payroll_transition WHERE payroll_id = $1 AND to_status = $2 ORDER BY id DESC LIMIT 1`
+	err = tx.QueryRowContext(ctx, query, updated.ID, model.PAYROLLRUN).Scan(&approval.RanBy)
+	if err != nil {
+		return model.PayrollApproval{}, errors.New("failed to find who ran the payroll")
+	}
+
+	insertQuery := `INSERT INTO payroll_approval (payroll_id, revision, ran_by, submitted_by, submit_comment, submitted_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+	err = tx.QueryRowContext(ctx, insertQuery,
+		approval.PayrollID,
+		approval.Revision,
+		approval.RanBy,
+		approval.SubmittedBy,
+		approval.SubmitComment,
+		approval.SubmittedAt,
+	).Scan(&approval.ID)
+	if err != nil {
+		return model.PayrollApproval{}, errors.New("failed to insert payroll approval")
+	}
+
+	err = a.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventPayrollSubmit,
+		RecordID:  approval.ID,
+		NewData:   approval,
+		ActorID:   userID,
+	})
+	if err != nil {
+		return model.PayrollApproval{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.PayrollApproval{}, errors.New("commit failed")
+	}
+
+	return approval, nil
+}
+
+// payslips become visible to employees once this commits
+func (a *adminSvcImpl) ApprovePayroll(userID, payrollID int64, comment string, ctx context.Context) (model.PayrollApproval, error) {
+	return a.decide(userID, payrollID, model.APPROVALAPPROVED, comment, ctx)
+}
+
+// sends the payroll back to REOPENED, the comment tells the maker what to fix
+func (a *adminSvcImpl) RejectPayroll(userID, payrollID int64, comment string, ctx context.Context) (model.PayrollApproval, error) {
+	if strings.TrimSpace(comment) == "" {
+		return model.PayrollApproval{}, ErrCommentRequired
+	}
+	return a.decide(userID, payrollID, model.APPROVALREJECTED, comment, ctx)
+}
+
+func (a *adminSvcImpl) decide(userID, payrollID int64, decision model.ApprovalDecision, comment string, ctx context.Context) (model.PayrollApproval, error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return model.PayrollApproval{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.PayrollApproval{}, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	payroll, err := lockPayroll(payrollID, tx, ctx)
+	if err != nil {
+		return model.PayrollApproval{}, err
+	}
+
+	pending, err := pendingApproval(payroll.ID, tx, ctx)
+	if err != nil {
+		return model.PayrollApproval{}, err
+	}
+
+	if userID == pending.SubmittedBy || userID == pending.RanBy {
+		return model.PayrollApproval{}, ErrSameApprover
+	}
+
+	to := model.PAYROLLAPPROVED
+	event := audit.EventPayrollApprove
+	if decision == model.APPROVALREJECTED {
+		to = model.PAYROLLREOPENED
+		event = audit.EventPayrollReject
+	}
+
+	updated, err := a.transition(payroll, to, userID, comment, tx, ctx)
+	if err != nil {
+		return model.PayrollApproval{}, err
+	}
+
+	decided := pending
+	decided.Decision = decision
+	decided.DecidedBy = sql.NullInt64{Int64: userID, Valid: true}
+	decided.DecisionComment = strings.TrimSpace(comment)
+	decided.DecidedAt = sql.NullTime{Time: updated.UpdatedAt, Valid: true}
+
+	updateQuery := `UPDATE payroll_approval SET decision = $1, decided_by = $2, decision_comment = $3, decided_at = $4 WHERE id = $5`
+	_, err = tx.ExecContext(ctx, updateQuery, decided.Decision, decided.DecidedBy, decided.DecisionComment, decided.DecidedAt, decided.ID)
+	if err != nil {
+		return model.PayrollApproval{}, errors.New("failed to record the approval decision")
+	}
+
+	err = a.auditor.Write(ctx, tx, audit.Entry{
+		EventType: event,
+		RecordID:  decided.ID,
+		OldData:   pending,
+		NewData:   decided,
+		ActorID:   userID,
+	})
+	if err != nil {
+		return model.PayrollApproval{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.PayrollApproval{}, errors.New("commit failed")
+	}
+
+	return decided, nil
+}
+
+// every submission of the payroll, oldest first
+func (a *adminSvcImpl) PayrollApprovals(payrollID int64, ctx context.Context) ([]model.PayrollApproval, error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, approvalSelect+` WHERE payroll_id = $1 ORDER BY id`, payrollID)
+	if err != nil {
+		return nil, errors.New("failed to query payroll approvals")
+	}
+	defer rows.Close()
+
+	approvals := []model.PayrollApproval{}
+	for rows.Next() {
+		approval, err := scanApproval(rows)
+		if err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, approval)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("error during payroll approval iteration")
+	}
+
+	return approvals, nil
+}
+
+const approvalSelect = `SELECT id, payroll_id, revision, ran_by, submitted_by, submit_comment, submitted_at,
+                               COALESCE(decision, ''), decided_by, decision_comment, decided_at
+                        FROM payroll_approval`
+
+// at most one per payroll, a partial unique index keeps it that way
+func pendingApproval(payrollID int64, tx *sql.Tx, ctx context.Context) (model.PayrollApproval, error) {
+	row := tx.QueryRowContext(ctx, approvalSelect+` WHERE payroll_id = $1 AND decision IS NULL FOR UPDATE`, payrollID)
+	approval, err := scanApproval(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.PayrollApproval{}, ErrNotSubmitted
+	}
+	return approval, err
+}
+
+// satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanApproval(s scanner) (model.PayrollApproval, error) {
+	var p model.PayrollApproval
+	err := s.Scan(
+		&p.ID,
+		&p.PayrollID,
+		&p.Revision,
+		&p.RanBy,
+		&p.SubmittedBy,
+		&p.SubmitComment,
+		&p.SubmittedAt,
+		&p.Decision,
+		&p.DecidedBy,
+		&p.DecisionComment,
+		&p.DecidedAt,
+	)
+	if err == sql.ErrNoRows {
+		return model.PayrollApproval{}, err
+	}
+	if err != nil {
+		return model.PayrollApproval{}, errors.New("failed to scan payroll approval")
+	}
+	return p, nil
+}
@@ -0,0 +1,365 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/hlp"
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+type Audit interface {
+	Query(f AuditFilter, ctx context.Context) (AuditPage, error)
+	Export(f AuditFilter, fn func(model.AuditLog) error, ctx context.Context) error
+	History(table model.Table, recordID int64, ctx context.Context) ([]RecordVersion, error)
+}
+
+// zero values are ignored, newest entries come first
+type AuditFilter struct {
+	From      time.Time
+	To        time.Time
+	ActorID   int64
+	EventType model.EventType
+	Table     model.Table
+	RecordID  int64
+	RequestID uuid.UUID
+	Cursor    string // from the previous page, opaque to clients
+	Limit     int
+}
+
+type AuditPage struct {
+	Entries    []model.AuditLog
+	NextCursor string // empty on the last page
+}
+
+// state of a record after one audit entry, rebuilt from old_data/new_data
+type RecordVersion struct {
+	AuditID    int64                  `json:"audit_id"`
+	EventType  model.EventType        `json:"event_type"`
+	ActionType model.ActionType       `json:"action_type"`
+	ActorID    int64                  `json:"actor_id"`
+	RequestID  uuid.UUID              `json:"request_id"`
+	At         time.Time              `json:"at"`
+	State      map[string]any         `json:"state"` // nil once the record is deleted
+	Changes    map[string]FieldChange `json:"changes"`
+}
+
+type FieldChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+type auditSvcImpl struct{}
+
+func NewAuditServices() Audit {
+	return &auditSvcImpl{}
+}
+
+const auditColumns = `id, request_id, created_at, event_type, action_type, affected_table, COALESCE(affected_record_id, 0), COALESCE(created_by, 0),
+                      COALESCE(ip_address, ''), COALESCE(old_data, ''), COALESCE(new_data, ''), COALESCE(prev_hash, ''), COALESCE(row_hash, '')`
+
+func (a *auditSvcImpl) Query(f AuditFilter, ctx context.Context) (AuditPage, error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return AuditPage{}, err
+	}
+
+	if f.Limit <= 0 {
+		f.Limit = defaultAuditPageSize
+	}
+	if f.Limit > maxAuditPageSize {
+		f.Limit = maxAuditPageSize
+	}
+
+	where, args, err := f.where()
+	if err != nil {
+		return AuditPage{}, err
+	}
+
+	// one extra row tells whether there is a next page
+	args = append(args, f.Limit+1)
+	query := fmt.Sprintf(`SELECT %s FROM audit_log %s ORDER BY id DESC LIMIT $%d`, auditColumns, where, len(args))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return AuditPage{}, errors.New("failed to query audit log")
+	}
+	defer rows.Close()
+
+	page := AuditPage{Entries: []model.AuditLog{}}
+	for rows.Next() {
+		log, err := scanAuditLog(rows)
+		if err != nil {
+			return AuditPage{}, err
+		}
+		page.Entries = append(page.Entries, log)
+	}
+
+	if err := rows.Err(); err != nil {
+		return AuditPage{}, errors.New("error during audit log iteration")
+	}
+
+	if len(page.Entries) > f.Limit {
+		page.Entries = page.Entries[:f.Limit]
+		page.NextCursor = encodeCursor(page.Entries[f.Limit-1].ID)
+	}
+
+	return page, nil
+}
+
+// streams every matching row, the limit is ignored
+func (a *auditSvcImpl) Export(f AuditFilter, fn func(model.AuditLog) error, ctx context.Context) error {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return err
+	}
+
+	where, args, err := f.where()
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM audit_log %s ORDER BY id DESC`, auditColumns, where)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return errors.New("failed to query audit log")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		log, err := scanAuditLog(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(log); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return errors.New("error during audit log iteration")
+	}
+
+	return nil
+}
+
+// replays the record's entries oldest first, READ entries don't change state and are skipped
+func (a *auditSvcImpl) History(table model.Table, recordID int64, ctx context.Context) ([]RecordVersion, error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM audit_log WHERE affected_table=$1 AND affected_record_id=$2 AND action_type<>$3 ORDER BY id ASC`, auditColumns)
+	rows, err := db.QueryContext(ctx, query, table, recordID, model.READ)
+	if err != nil {
+		return nil, errors.New("failed to query audit log")
+	}
+	defer rows.Close()
+
+	versions := []RecordVersion{}
+	var state map[string]any
+	for rows.Next() {
+		log, err := scanAuditLog(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		next, changes, err := applyEntry(state, log)
+		if err != nil {
+			return nil, fmt.Errorf("audit entry %d: %w", log.ID, err)
+		}
+		state = next
+
+		versions = append(versions, RecordVersion{
+			AuditID:    log.ID,
+			EventType:  log.EventType,
+			ActionType: log.ActionType,
+			ActorID:    log.CreatedBy,
+			RequestID:  log.RequestId,
+			At:         log.CreatedAt,
+			State:      copyState(state),
+			Changes:    changes,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("error during audit log iteration")
+	}
+
+	return versions, nil
+}
+
+// payloads can be partial (e.g. only password_changed_at), so new data is merged into the state
+// old data fills in fields the history hasn't seen yet, e.g. rows created before auditing
+func applyEntry(state map[string]any, log model.AuditLog) (map[string]any, map[string]FieldChange, error) {
+	oldData, err := decodePayload(log.OldData)
+	if err != nil {
+		return nil, nil, err
+	}
+	newData, err := decodePayload(log.NewData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes := make(map[string]FieldChange)
+
+	if log.ActionType == model.DELETE {
+		for k, v := range state {
+			changes[k] = FieldChange{Old: v}
+		}
+		return nil, changes, nil
+	}
+
+	next := copyState(state)
+	if next == nil {
+		next = make(map[string]any)
+	}
+	for k, v := range oldData {
+		if _, seen := next[k]; !seen {
+			next[k] = v
+		}
+	}
+
+	for k, v := range newData {
+		old, seen := next[k]
+		if !seen || !jsonEqual(old, v) {
+			changes[k] = FieldChange{Old: old, New: v}
+		}
+		next[k] = v
+	}
+
+	return next, changes, nil
+}
+
+func decodePayload(s string) (map[string]any, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return nil, errors.New("payload is not a JSON object")
+	}
+	return m, nil
+}
+
+func jsonEqual(a, b any) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+func copyState(state map[string]any) map[string]any {
+	if state == nil {
+		return nil
+	}
+	c := make(map[string]any, len(state))
+	for k, v := range state {
+		c[k] = v
+	}
+	return c
+}
+
+// builds the WHERE clause, every value goes through a placeholder
+func (f AuditFilter) where() (string, []any, error) {
+	var conds []string
+	var args []any
+
+	add := func(cond string, v any) {
+		args = append(args, v)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+	if f.ActorID != 0 {
+		add("created_by = $%d", f.ActorID)
+	}
+	if f.EventType != "" {
+		add("event_type = $%d", f.EventType)
+	}
+	if f.Table != "" {
+		add("affected_table = $%d", f.Table)
+	}
+	if f.RecordID != 0 {
+		add("affected_record_id = $%d", f.RecordID)
+	}
+	if f.RequestID != uuid.Nil {
+		add("request_id = $%d", f.RequestID)
+	}
+	if f.Cursor != "" {
+		id, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		add("id < $%d", id)
+	}
+
+	if len(conds) == 0 {
+		return "", args, nil
+	}
+
+	return "WHERE " + strings.Join(conds, " AND "), args, nil
+}
+
+// keyset cursor on the id, wrapped so clients don't build their own
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	id, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
+
+func scanAuditLog(rows *sql.Rows) (model.AuditLog, error) {
+	var log model.AuditLog
+	err := rows.Scan(
+		&log.ID,
+		&log.RequestId,
+		&log.CreatedAt,
+		&log.EventType,
+		&log.ActionType,
+		&log.AffectedRecord,
+		&log.AffectedRecordID,
+		&log.CreatedBy,
+		&log.IPAddress,
+		&log.OldData,
+		&log.NewData,
+		&log.PrevHash,
+		&log.RowHash,
+	)
+	if err != nil {
+		return model.AuditLog{}, errors.New("failed to scan audit log")
+	}
+	return log, nil
+}
@@ -0,0 +1,313 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/achsanalfitra/gopayslip/hlp"
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/export"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
+)
+
+var ErrNotRun = errors.New("the payroll has not been run, only issued payslips are exported")
+
+// available columns in their default order
+var (
+	SummaryExportColumns = []export.Column{
+		{Key: "user_id", Header: "User ID"},
+		{Key: "username", Header: "Username"},
+		{Key: "department", Header: "Department"},
+		{Key: "working_days", Header: "Working days"},
+		{Key: "attended_days", Header: "Attended days"},
+		{Key: "base_salary", Header: "Base salary"},
+		{Key: "attendance_pay", Header: "Attendance pay"},
+		{Key: "overtime_pay", Header: "Overtime pay"},
+		{Key: "reimbursement_pay", Header: "Reimbursement pay"},
+		{Key: "take_home_pay", Header: "Take-home pay"},
+		{Key: "currency", Header: "Currency"},
+	}
+
+	PayslipExportColumns = []export.Column{
+		{Key: "payroll_id", Header: "Payroll ID"},
+		{Key: "revision", Header: "Revision"},
+		{Key: "user_id", Header: "User ID"},
+		{Key: "username", Header: "Username"},
+		{Key: "line", Header: "Line"},
+		{Key: "date", Header: "Date"},
+		{Key: "description", Header: "Description"},
+		{Key: "hours", Header: "Hours"},
+		{Key: "rate", Header: "Rate"},
+		{Key: "multiplier", Header: "Multiplier"},
+		{Key: "amount", Header: "Amount"},
+		{Key: "currency", Header: "Currency"},
+	}
+)
+
+// payslip export lines after the items, one per component of the pay
+const (
+	lineAttendancePay    = "ATTENDANCE_PAY"
+	lineOvertimePay      = "OVERTIME_PAY"
+	lineReimbursementPay = "REIMBURSEMENT_PAY"
+	lineTakeHomePay      = "TAKE_HOME_PAY"
+)
+
+// employees of the run at its current revision, read from the issued payslips only
+func (a *adminSvcImpl) ExportSummary(userID, payrollID int64, format export.Format, columns string, ctx context.Context) (export.File, error) {
+	cols, err := export.ParseColumns(columnSpec(columns, a.payroll.SummaryExportColumns), SummaryExportColumns)
+	if err != nil {
+		return export.File{}, err
+	}
+
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return export.File{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return export.File{}, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	payroll, err := issuedPayroll(payrollID, tx, ctx)
+	if err != nil {
+		return export.File{}, err
+	}
+
+	summary, _, err := issuedSummary(payroll, tx, ctx)
+	if err != nil {
+		return export.File{}, err
+	}
+
+	rows := make([]export.Row, len(summary))
+	for i, r := range summary {
+		rows[i] = r
+	}
+
+	file, err := export.Render(fmt.Sprintf("payroll-%d-r%d-summary", payroll.ID, payroll.Revision), "Summary", format, cols, rows)
+	if err != nil {
+		return export.File{}, err
+	}
+
+	if err := a.recordExport(userID, payroll, 0, format, len(rows), file, db, ctx); err != nil {
+		return export.File{}, err
+	}
+
+	return file, nil
+}
+
+// the payslip's items followed by one line per pay component
+func (a *adminSvcImpl) ExportPayslip(userID, payrollID, employeeID int64, format export.Format, columns string, ctx context.Context) (export.File, error) {
+	cols, err := export.ParseColumns(columnSpec(columns, a.payroll.PayslipExportColumns), PayslipExportColumns)
+	if err != nil {
+		return export.File{}, err
+	}
+
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return export.File{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return export.File{}, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	payroll, err := issuedPayroll(payrollID, tx, ctx)
+	if err != nil {
+		return export.File{}, err
+	}
+
+	payslip, items, err := a.empl.IssuedPayslip(payroll, employeeID, tx, ctx)
+	if err != nil {
+		return export.File{}, err
+	}
+
+	var username string
+	if err := tx.QueryRowContext(ctx, `SELECT username FROM users WHERE id = $1`, employeeID).Scan(&username); err != nil {
+		return export.File{}, errors.New("failed to query user")
+	}
+
+	rows := payslipLines(payslip, items, username)
+
+	file, err := export.Render(fmt.Sprintf("payroll-%d-r%d-payslip-%d", payroll.ID, payroll.Revision, employeeID), "Payslip", format, cols, rows)
+	if err != nil {
+		return export.File{}, err
+	}
+
+	if err := a.recordExport(userID, payroll, employeeID, format, len(rows), file, db, ctx); err != nil {
+		return export.File{}, err
+	}
+
+	return file, nil
+}
+
+// the request's columns win over the configured default
+func columnSpec(requested, configured string) string {
+	if requested != "" {
+		return requested
+	}
+	return configured
+}
+
+func issuedPayroll(payrollID int64, tx *sql.Tx, ctx context.Context) (model.Payroll, error) {
+	var payroll model.Payroll
+	query := `SELECT id, status, revision, start_period, end_period FROM payroll WHERE id = $1`
+	err := tx.QueryRowContext(ctx, query, payrollID).Scan(&payroll.ID, &payroll.Status, &payroll.Revision, &payroll.StartPeriod, &payroll.EndPeriod)
+	if err == sql.ErrNoRows {
+		return model.Payroll{}, ErrPayrollNotFound
+	}
+	if err != nil {
+		return model.Payroll{}, errors.New("failed to query payroll")
+	}
+
+	if !payroll.IsRun() {
+		return model.Payroll{}, ErrNotRun
+	}
+
+	return payroll, nil
+}
+
+// the file isn't handed out unless the disclosure is on record, with the checksum of what left
+func (a *adminSvcImpl) recordExport(userID int64, payroll model.Payroll, employeeID int64, format export.Format, rows int, file export.File, db *sql.DB, ctx context.Context) error {
+	if a.reads == nil {
+		return nil
+	}
+
+	err := a.reads.Record(db, audit.Entry{
+		EventType: audit.EventPayrollExported,
+		RecordID:  payroll.ID,
+		NewData: audit.ExportRead{
+			PayrollID: payroll.ID,
+			Revision:  payroll.Revision,
+			UserID:    employeeID,
+			Format:    string(format),
+			Rows:      rows,
+			Checksum:  file.SHA256,
+		},
+		ActorID: userID,
+	}, ctx)
+	if err != nil {
+		return errors.New("failed to audit payroll export")
+	}
+
+	return nil
+}
+
+func (r SummaryRow) Cell(key string) export.Cell {
+	switch key {
+	case "user_id":
+		return export.Number(strconv.FormatInt(r.UserID, 10))
+	case "username":
+		return export.Text(r.Username)
+	case "department":
+		return export.Text(r.Department)
+	case "working_days":
+		return export.Number(strconv.Itoa(r.WorkingDays))
+	case "attended_days":
+		return export.Number(strconv.Itoa(r.AttendedDays))
+	case "base_salary":
+		return export.Number(r.BaseSalary.Amount.String())
+	case "attendance_pay":
+		return export.Number(r.AttendancePay.Amount.String())
+	case "overtime_pay":
+		return export.Number(r.OvertimePay.Amount.String())
+	case "reimbursement_pay":
+		return export.Number(r.ReimbursementPay.Amount.String())
+	case "take_home_pay":
+		return export.Number(r.TakeHomePay.Amount.String())
+	case "currency":
+		return export.Text(string(r.TakeHomePay.Currency))
+	}
+	return export.Cell{}
+}
+
+// one row of a payslip export, blank quantities stay blank
+type payslipLine struct {
+	payslip     model.Payslip
+	username    string
+	line        string
+	date        string
+	description string
+	hours       money.NullDecimal
+	rate        money.NullDecimal
+	multiplier  money.NullDecimal
+	amount      money.NullDecimal
+}
+
+func payslipLines(p model.Payslip, items []model.PayslipItem, username string) []export.Row {
+	rows := make([]export.Row, 0, len(items)+4)
+	for _, item := range items {
+		line := payslipLine{
+			payslip:     p,
+			username:    username,
+			line:        string(item.Kind),
+			date:        item.ItemDate.Format("2006-01-02"),
+			description: item.Description.String,
+			hours:       item.Hours,
+			rate:        item.Rate,
+			multiplier:  item.Multiplier,
+		}
+		// attendance is the numerator of the proration, it has no amount of its own
+		if item.Kind != model.ATTENDANCEITEM {
+			line.amount = money.NullDecimal{Decimal: item.Amount, Valid: true}
+		}
+		rows = append(rows, line)
+	}
+
+	total := func(line, description string, amount money.Decimal) payslipLine {
+		return payslipLine{payslip: p, username: username, line: line, description: description, amount: money.NullDecimal{Decimal: amount, Valid: true}}
+	}
+	rows = append(rows,
+		total(lineAttendancePay, fmt.Sprintf("%d of %d working days", p.AttendedDays, p.WorkingDays), p.AttendancePay),
+		total(lineOvertimePay, fmt.Sprintf("%s hours", p.OvertimeHours), p.OvertimePay),
+		total(lineReimbursementPay, "", p.ReimbursementPay),
+		total(lineTakeHomePay, "", p.TakeHomePay),
+	)
+
+	return rows
+}
+
+func (l payslipLine) Cell(key string) export.Cell {
+	decimal := func(d money.NullDecimal) export.Cell {
+		if !d.Valid {
+			return export.Cell{}
+		}
+		return export.Number(d.Decimal.String())
+	}
+
+	switch key {
+	case "payroll_id":
+		return export.Number(strconv.FormatInt(l.payslip.PayrollID, 10))
+	case "revision":
+		return export.Number(strconv.Itoa(l.payslip.Revision))
+	case "user_id":
+		return export.Number(strconv.FormatInt(l.payslip.UserID, 10))
+	case "username":
+		return export.Text(l.username)
+	case "line":
+		return export.Text(l.line)
+	case "date":
+		return export.Text(l.date)
+	case "description":
+		return export.Text(l.description)
+	case "hours":
+		return decimal(l.hours)
+	case "rate":
+		return decimal(l.rate)
+	case "multiplier":
+		return decimal(l.multiplier)
+	case "amount":
+		return decimal(l.amount)
+	case "currency":
+		return export.Text(string(l.payslip.Currency))
+	}
+	return export.Cell{}
+}
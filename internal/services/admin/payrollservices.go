@@ -6,166 +6,556 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"strconv"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/achsanalfitra/gopayslip/hlp"
 	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/config"
+	"github.com/achsanalfitra/gopayslip/internal/export"
 	"github.com/achsanalfitra/gopayslip/internal/model"
 	"github.com/achsanalfitra/gopayslip/internal/services/empl"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrPayrollNotFound   = errors.New("payroll not found")
+	ErrInvalidPeriod     = errors.New("start period cannot be after end period")
+	ErrPayrollPending    = errors.New("previous payroll period has not been run yet")
+	ErrPayrollOverlap    = errors.New("new payroll period overlaps with a previously run payroll")
+	ErrInvalidTransition = errors.New("payroll can't move to that status")
+	ErrReasonRequired    = errors.New("a reason is required to move a payroll backwards")
+	ErrPreviewNotLocked  = errors.New("only a locked payroll can be previewed")
+	ErrNotSubmitted      = errors.New("payroll has no submission waiting for approval")
+	ErrSameApprover      = errors.New("the approver must be a different admin than the one who ran or submitted the payroll")
+	ErrCommentRequired   = errors.New("a comment is required to reject a payroll")
 )
 
 type Admin interface {
-	DefinePayroll(userID int64, start, end time.Time, ctx context.Context) error
-	RunPayroll(ctx context.Context) (end time.Time, err error)
+	DefinePayroll(userID int64, start, end time.Time, ctx context.Context) (model.Payroll, error)
+	// OPEN, LOCKED, PAID and REOPENED, the statuses that issue payslips or need a second admin have their own methods
+	TransitionPayroll(userID, payrollID int64, to model.PayrollStatus, reason string, ctx context.Context) (model.Payroll, error)
+	// retries with the same key get the original result, a run already in progress is refused
+	RunPayroll(userID, payrollID int64, key uuid.UUID, reason string, ctx context.Context) (RunResult, error)
+	PayrollRuns(payrollID int64, ctx context.Context) ([]model.PayrollRun, error)
+	RecoverPayrollRuns(ctx context.Context) (int, error)
+	PayrollHistory(payrollID int64, ctx context.Context) ([]model.PayrollTransition, error)
+	// computes every payslip of a locked payroll without persisting them, format is only recorded in the audit entry
+	PreviewPayroll(payrollID int64, format string, ctx context.Context) (Preview, error)
+	// employees only, issued payslips once the payroll was run and live figures before that
+	PayrollSummary(payrollID int64, f SummaryFilter, ctx context.Context) (SummaryReport, error)
+	// CSV or XLSX of a run's issued payslips, columns is "key,key:Header,..." and empty falls back to the configured order
+	ExportSummary(userID, payrollID int64, format export.Format, columns string, ctx context.Context) (export.File, error)
+	ExportPayslip(userID, payrollID, employeeID int64, format export.Format, columns string, ctx context.Context) (export.File, error)
+	// a PDF per issued payslip of the run into a directory or zip, the bundle is closed on success
+	PrintPayslips(userID, payrollID int64, bundle export.Bundle, ctx context.Context) (int, error)
+
+	// maker-checker, the approver can't be the admin who ran or submitted the payroll
+	SubmitPayroll(userID, payrollID int64, comment string, ctx context.Context) (model.PayrollApproval, error)
+	ApprovePayroll(userID, payrollID int64, comment string, ctx context.Context) (model.PayrollApproval, error)
+	RejectPayroll(userID, payrollID int64, comment string, ctx context.Context) (model.PayrollApproval, error)
+	PayrollApprovals(payrollID int64, ctx context.Context) ([]model.PayrollApproval, error)
+}
+
+type adminSvcImpl struct {
+	auditor audit.Writer
+	reads   audit.ReadAuditor
+	payroll *config.Payroll
+	empl    empl.Empl // the summary is audited as a whole instead of once per payslip
+	runs    *runTracker
 }
 
-type adminSvcImpl struct{}
+func NewAdminServices(auditor audit.Writer, reads audit.ReadAuditor, payroll *config.Payroll) Admin {
+	return &adminSvcImpl{
+		auditor: auditor,
+		reads:   reads,
+		payroll: payroll,
+		empl:    empl.NewEmplServices(nil, payroll),
+		runs:    newRunTracker(),
+	}
+}
 
-func NewAdminServices() Admin {
-	return &adminSvcImpl{}
+// the lifecycle, PAID is final and a reopened payroll has to be locked again before it's rerun
+var payrollTransitions = map[model.PayrollStatus][]model.PayrollStatus{
+	model.PAYROLLDRAFT:     {model.PAYROLLOPEN},
+	model.PAYROLLOPEN:      {model.PAYROLLLOCKED},
+	model.PAYROLLLOCKED:    {model.PAYROLLOPEN, model.PAYROLLRUN},
+	model.PAYROLLRUN:       {model.PAYROLLSUBMITTED, model.PAYROLLREOPENED},
+	model.PAYROLLSUBMITTED: {model.PAYROLLAPPROVED, model.PAYROLLREOPENED},
+	model.PAYROLLAPPROVED:  {model.PAYROLLPAID, model.PAYROLLREOPENED},
+	model.PAYROLLREOPENED:  {model.PAYROLLLOCKED},
 }
 
-// get the service
-var emplServices = empl.NewEmplServices()
+// statuses TransitionPayroll refuses, with the method that reaches them
+var dedicatedTransitions = map[model.PayrollStatus]string{
+	model.PAYROLLRUN:       "RunPayroll",
+	model.PAYROLLSUBMITTED: "SubmitPayroll",
+	model.PAYROLLAPPROVED:  "ApprovePayroll",
+}
+
+func canTransition(from, to model.PayrollStatus) bool {
+	return slices.Contains(payrollTransitions[from], to)
+}
 
-func (a *adminSvcImpl) DefinePayroll(userID int64, start, end time.Time, ctx context.Context) error {
+// unlocking and reopening undo work someone else may have relied on
+func needsReason(from, to model.PayrollStatus) bool {
+	return to == model.PAYROLLREOPENED || (from == model.PAYROLLLOCKED && to == model.PAYROLLOPEN)
+}
+
+func (a *adminSvcImpl) DefinePayroll(userID int64, start, end time.Time, ctx context.Context) (model.Payroll, error) {
 	db, err := hlp.GetDB(ctx, app.PQ)
 	if err != nil {
-		return err
+		return model.Payroll{}, err
 	}
 
 	// start period can't be after end period
 	if start.After(end) {
-		return errors.New("start period cannot be after end period")
+		return model.Payroll{}, ErrInvalidPeriod
 	}
 
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.Payroll{}, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
 	// check interval with the latest payroll
-	// if start < than latest end exit where is run == true
+	// a new period can only follow one that was run and can't start before it ends
 	var latestPayroll model.Payroll
-	var tempStatus bool
-	query := `SELECT id, start_period, end_period, is_run FROM payroll ORDER BY end_period DESC LIMIT 1`
-	err = db.QueryRowContext(ctx, query).Scan(
+	query := `SELECT id, start_period, end_period, status FROM payroll ORDER BY end_period DESC LIMIT 1 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query).Scan(
 		&latestPayroll.ID,
 		&latestPayroll.StartPeriod,
 		&latestPayroll.EndPeriod,
-		&tempStatus,
+		&latestPayroll.Status,
 	)
 
 	if err == nil {
-		if start.Before(latestPayroll.EndPeriod) && tempStatus {
-			return errors.New("new payroll period overlaps with a previously run payroll")
+		if !latestPayroll.IsRun() {
+			return model.Payroll{}, ErrPayrollPending
 		}
 
-		// check if the latest payroll is not run
-		if !tempStatus {
-			return errors.New("previous payroll period has not been run yet")
+		if start.Before(latestPayroll.EndPeriod) {
+			return model.Payroll{}, ErrPayrollOverlap
 		}
-	}
-
-	if err != sql.ErrNoRows {
-		return errors.New("failed to query payroll row during validation")
+	} else if err != sql.ErrNoRows {
+		return model.Payroll{}, errors.New("failed to query payroll row during validation")
 	}
 
 	// populate the model
 	payroll := model.Payroll{
+		Status:      model.PAYROLLDRAFT,
 		CreatedBy:   userID,
 		UpdatedBy:   userID,
 		StartPeriod: start,
 		EndPeriod:   end,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
-		IsRun:       false,
 	}
 
 	// insert the payload to db
-	insertQuery := `INSERT INTO payroll (created_by, updated_by, start_period, end_period, created_at, updated_at, is_run) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
-	err = db.QueryRowContext(ctx, insertQuery,
+	insertQuery := `INSERT INTO payroll (created_by, updated_by, start_period, end_period, created_at, updated_at, status, revision) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+	err = tx.QueryRowContext(ctx, insertQuery,
 		payroll.CreatedBy,
 		payroll.UpdatedBy,
 		payroll.StartPeriod,
 		payroll.EndPeriod,
 		payroll.CreatedAt,
 		payroll.UpdatedAt,
-		payroll.IsRun,
+		payroll.Status,
+		payroll.Revision,
 	).Scan(&payroll.ID)
 
 	if err != nil {
-		return errors.New("failed to insert payroll")
+		return model.Payroll{}, errors.New("failed to insert payroll")
 	}
 
-	return nil
+	err = a.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventPayrollDefined,
+		RecordID:  payroll.ID,
+		NewData:   payroll,
+		ActorID:   userID,
+	})
+	if err != nil {
+		return model.Payroll{}, err
+	}
+
+	err = a.recordTransition(model.PayrollTransition{
+		PayrollID: payroll.ID,
+		To:        payroll.Status,
+		CreatedAt: payroll.CreatedAt,
+		CreatedBy: userID,
+	}, tx, ctx)
+	if err != nil {
+		return model.Payroll{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.Payroll{}, errors.New("commit failed")
+	}
+
+	return payroll, nil
 }
 
-// run payroll
-func (a *adminSvcImpl) RunPayroll(ctx context.Context) (end time.Time, err error) {
+func (a *adminSvcImpl) TransitionPayroll(userID, payrollID int64, to model.PayrollStatus, reason string, ctx context.Context) (model.Payroll, error) {
+	if method, ok := dedicatedTransitions[to]; ok {
+		return model.Payroll{}, fmt.Errorf("%w: %s is reached through %s", ErrInvalidTransition, to, method)
+	}
+
 	db, err := hlp.GetDB(ctx, app.PQ)
 	if err != nil {
-		return time.Time{}, err
+		return model.Payroll{}, err
 	}
 
-	// find the latest payroll where statis is_run is false, if not found return error
-	var latestUnrunPayroll model.Payroll
-	query := `SELECT id, end_period FROM payroll WHERE is_run = FALSE ORDER BY end_period ASC LIMIT 1`
-	err = db.QueryRowContext(ctx, query).Scan(
-		&latestUnrunPayroll.ID,
-		&latestUnrunPayroll.EndPeriod,
-	)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.Payroll{}, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	payroll, err := lockPayroll(payrollID, tx, ctx)
+	if err != nil {
+		return model.Payroll{}, err
+	}
+
+	// the pending submission has to be decided by a second admin
+	if payroll.Status == model.PAYROLLSUBMITTED {
+		return model.Payroll{}, fmt.Errorf("%w: a submitted payroll is approved or rejected", ErrInvalidTransition)
+	}
 
+	updated, err := a.transition(payroll, to, userID, reason, tx, ctx)
+	if err != nil {
+		return model.Payroll{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.Payroll{}, errors.New("commit failed")
+	}
+
+	return updated, nil
+}
+
+func (a *adminSvcImpl) PayrollHistory(payrollID int64, ctx context.Context) ([]model.PayrollTransition, error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT id, payroll_id, COALESCE(from_status, ''), to_status, reason, created_at, created_by FROM payroll_transition WHERE payroll_id = $1 ORDER BY id`
+	rows, err := db.QueryContext(ctx, query, payrollID)
+	if err != nil {
+		return nil, errors.New("failed to query payroll transitions")
+	}
+	defer rows.Close()
+
+	history := []model.PayrollTransition{}
+	for rows.Next() {
+		var t model.PayrollTransition
+		if err := rows.Scan(&t.ID, &t.PayrollID, &t.From, &t.To, &t.Reason, &t.CreatedAt, &t.CreatedBy); err != nil {
+			return nil, errors.New("failed to scan payroll transition")
+		}
+		history = append(history, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("error during payroll transition iteration")
+	}
+
+	if len(history) == 0 {
+		return nil, ErrPayrollNotFound
+	}
+
+	return history, nil
+}
+
+func lockPayroll(payrollID int64, tx *sql.Tx, ctx context.Context) (model.Payroll, error) {
+	var p model.Payroll
+	query := `SELECT id, status, revision, created_by, updated_by, start_period, end_period, created_at, updated_at FROM payroll WHERE id = $1 FOR UPDATE`
+	err := tx.QueryRowContext(ctx, query, payrollID).Scan(
+		&p.ID,
+		&p.Status,
+		&p.Revision,
+		&p.CreatedBy,
+		&p.UpdatedBy,
+		&p.StartPeriod,
+		&p.EndPeriod,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
 	if err == sql.ErrNoRows {
-		return time.Time{}, errors.New("no pending payroll to run")
+		return model.Payroll{}, ErrPayrollNotFound
+	}
+	if err != nil {
+		return model.Payroll{}, errors.New("failed to query payroll")
+	}
+
+	return p, nil
+}
+
+// validates and applies one step of the lifecycle on a payroll locked by the caller, running bumps the revision
+func (a *adminSvcImpl) transition(payroll model.Payroll, to model.PayrollStatus, userID int64, reason string, tx *sql.Tx, ctx context.Context) (model.Payroll, error) {
+	if err := checkTransition(payroll.Status, to, reason); err != nil {
+		return model.Payroll{}, err
+	}
+	reason = strings.TrimSpace(reason)
+
+	updated := payroll
+	updated.Status = to
+	updated.UpdatedAt = time.Now()
+	updated.UpdatedBy = userID
+	if to == model.PAYROLLRUN {
+		updated.Revision++
+	}
+
+	updateQuery := `UPDATE payroll SET status = $1, revision = $2, updated_at = $3, updated_by = $4 WHERE id = $5`
+	_, err := tx.ExecContext(ctx, updateQuery, updated.Status, updated.Revision, updated.UpdatedAt, updated.UpdatedBy, updated.ID)
+	if err != nil {
+		return model.Payroll{}, errors.New("failed to update payroll status")
 	}
+
+	err = a.recordTransition(model.PayrollTransition{
+		PayrollID: payroll.ID,
+		From:      payroll.Status,
+		To:        to,
+		Reason:    reason,
+		CreatedAt: updated.UpdatedAt,
+		CreatedBy: userID,
+	}, tx, ctx)
 	if err != nil {
-		return time.Time{}, errors.New("failed to run payroll")
+		return model.Payroll{}, err
 	}
 
-	// insert
-	updateQuery := `UPDATE payroll SET is_run = TRUE, updated_at = $1 WHERE id = $2`
-	_, err = db.ExecContext(ctx, updateQuery, time.Now(), latestUnrunPayroll.ID)
+	// the payroll row itself, so its record history follows the status
+	event := audit.EventPayrollStatus
+	if to == model.PAYROLLRUN {
+		event = audit.EventPayrollRun
+	}
+	err = a.auditor.Write(ctx, tx, audit.Entry{
+		EventType: event,
+		RecordID:  updated.ID,
+		OldData:   payroll,
+		NewData:   updated,
+		ActorID:   userID,
+	})
 	if err != nil {
-		return time.Time{}, errors.New("failed to update payroll status")
+		return model.Payroll{}, err
 	}
 
-	return latestUnrunPayroll.EndPeriod, nil
+	return updated, nil
+}
+
+// lets a caller fail before doing any work the transition would throw away
+func checkTransition(from, to model.PayrollStatus, reason string) error {
+	if !canTransition(from, to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+	}
+	if strings.TrimSpace(reason) == "" && needsReason(from, to) {
+		return ErrReasonRequired
+	}
+	return nil
 }
 
-func (a *adminSvcImpl) GeneratePayrollSummary(ctx context.Context, start, end time.Time) (PayslipList map[string]float64, Total float64, err error) {
+func (a *adminSvcImpl) recordTransition(t model.PayrollTransition, tx *sql.Tx, ctx context.Context) error {
+	query := `INSERT INTO payroll_transition (payroll_id, from_status, to_status, reason, created_at, created_by) VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6) RETURNING id`
+	err := tx.QueryRowContext(ctx, query, t.PayrollID, t.From, t.To, t.Reason, t.CreatedAt, t.CreatedBy).Scan(&t.ID)
+	if err != nil {
+		return errors.New("failed to record payroll transition")
+	}
+
+	return a.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventPayrollStep,
+		RecordID:  t.ID,
+		NewData:   t,
+		ActorID:   t.CreatedBy,
+	})
+}
+
+// freezes a payslip for every employee and admin inside the run transaction, a failure for anyone fails the run
+func (a *adminSvcImpl) issuePayslips(payroll model.Payroll, userID int64, progress empl.Progress, tx *sql.Tx, ctx context.Context) (audit.PayslipBatch, error) {
+	computed, err := a.computeSnapshot(payroll, progress, ctx)
+	if err != nil {
+		return audit.PayslipBatch{}, err
+	}
+
+	batch := audit.PayslipBatch{PayrollID: payroll.ID}
+	for i := range computed {
+		c := &computed[i]
+		if c.Err != nil {
+			return audit.PayslipBatch{}, fmt.Errorf("payslip for user %d: %w", c.Payslip.UserID, c.Err)
+		}
+
+		c.Payslip.PayrollID = payroll.ID
+		c.Payslip.Revision = payroll.Revision
+		c.Payslip.CreatedBy = userID
+
+		batch.Payslips++
+		batch.UserIDs = append(batch.UserIDs, c.Payslip.UserID)
+	}
+
+	if err := insertPayslips(computed, tx, ctx); err != nil {
+		return audit.PayslipBatch{}, err
+	}
+
+	return batch, nil
+}
+
+// every payslip is computed from the same snapshot of attendance, overtime and reimbursements
+// on a read only transaction of its own, the run's transaction stays read committed so it can still lock the audit chain
+func (a *adminSvcImpl) computeSnapshot(payroll model.Payroll, progress empl.Progress, ctx context.Context) ([]empl.ComputedPayslip, error) {
 	db, err := hlp.GetDB(ctx, app.PQ)
 	if err != nil {
-		return make(map[string]float64), 0, err
+		return nil, err
+	}
+
+	snap, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, errors.New("failed starting the transaction")
 	}
+	defer snap.Rollback()
 
-	// instantiate
-	PayslipList = make(map[string]float64)
-	Total = 0.0
+	users, err := payrollUsers(snap, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]int64, len(users))
+	for i, user := range users {
+		userIDs[i] = user.ID
+	}
 
-	// get all the user id
-	rows, err := db.QueryContext(ctx, `SELECT id FROM users`)
+	return a.empl.ComputePayslips(userIDs, snap, ctx, payroll.StartPeriod, payroll.EndPeriod, progress)
+}
+
+// everyone who gets a payslip, service accounts and pending invitations are never paid
+func payrollUsers(tx *sql.Tx, ctx context.Context) ([]model.User, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, username FROM users WHERE role IN ($1, $2) AND activated_at IS NOT NULL ORDER BY id`, model.EMPLOYEE, model.ADMIN)
 	if err != nil {
-		return make(map[string]float64), 0, errors.New("failed to query users")
+		return nil, errors.New("failed to query users")
 	}
 	defer rows.Close()
 
-	// iterate for all user id
+	// read them all up front, the transaction can't run other statements while the rows are open
+	var users []model.User
 	for rows.Next() {
-		var userID int64
-		if err := rows.Scan(&userID); err != nil {
-			return make(map[string]float64), 0, fmt.Errorf("failed to scan user ID: %w", err)
+		var u model.User
+		if err := rows.Scan(&u.ID, &u.Username); err != nil {
+			return nil, fmt.Errorf("failed to scan user ID: %w", err)
 		}
+		users = append(users, u)
+	}
 
-		payslip, err := emplServices.GeneratePayslip(userID, ctx, start, end)
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("error during user iteration")
+	}
+
+	return users, nil
+}
+
+// one COPY for the payslips and one for their items, ids are reserved up front since COPY returns nothing
+func insertPayslips(computed []empl.ComputedPayslip, tx *sql.Tx, ctx context.Context) error {
+	if len(computed) == 0 {
+		return nil
+	}
+
+	ids, err := reservePayslipIDs(len(computed), tx, ctx)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("payslip", "id", "payroll_id", "revision", "user_id", "period_start", "period_end", "currency", "rounding_mode",
+		"rounding_scope", "base_salary", "working_days", "attended_days", "attendance_pay", "hourly_rate", "overtime_hours", "overtime_multiplier", "overtime_pay",
+		"reimbursement_pay", "take_home_pay", "created_at", "created_by"))
+	if err != nil {
+		return errors.New("failed to start payslip copy")
+	}
+	defer stmt.Close()
+
+	for i, c := range computed {
+		p := c.Payslip
+		_, err := stmt.ExecContext(ctx,
+			ids[i],
+			p.PayrollID,
+			p.Revision,
+			p.UserID,
+			p.PeriodStart,
+			p.PeriodEnd,
+			p.Currency,
+			p.RoundingMode,
+			p.RoundingScope,
+			p.BaseSalary,
+			p.WorkingDays,
+			p.AttendedDays,
+			p.AttendancePay,
+			p.HourlyRate,
+			p.OvertimeHours,
+			p.OvertimeMultiplier,
+			p.OvertimePay,
+			p.ReimbursementPay,
+			p.TakeHomePay,
+			p.CreatedAt,
+			p.CreatedBy,
+		)
 		if err != nil {
-			log.Printf("failed to generate payslip for user %d: %v", userID, err)
-			continue
+			return errors.New("failed to copy payslip")
 		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return errors.New("failed to copy payslip")
+	}
 
-		PayslipList[strconv.FormatInt(userID, 10)] = payslip.TakeHomePay
-		Total += payslip.TakeHomePay
+	itemStmt, err := tx.PrepareContext(ctx, pq.CopyIn("payslip_item", "payslip_id", "kind", "source_id", "item_date", "description", "hours", "rate", "multiplier", "amount"))
+	if err != nil {
+		return errors.New("failed to start payslip item copy")
 	}
+	defer itemStmt.Close()
 
-	if err := rows.Err(); err != nil {
-		return make(map[string]float64), 0, fmt.Errorf("error during user iteration")
+	for i, c := range computed {
+		for _, item := range c.Items {
+			_, err := itemStmt.ExecContext(ctx, ids[i], item.Kind, item.SourceID, item.ItemDate, item.Description, item.Hours, item.Rate, item.Multiplier, item.Amount)
+			if err != nil {
+				return errors.New("failed to copy payslip item")
+			}
+		}
+	}
+	if _, err := itemStmt.ExecContext(ctx); err != nil {
+		return errors.New("failed to copy payslip item")
+	}
+
+	return nil
+}
+
+func reservePayslipIDs(n int, tx *sql.Tx, ctx context.Context) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT nextval(pg_get_serial_sequence('payslip', 'id')) FROM generate_series(1, $1)", n)
+	if err != nil {
+		return nil, errors.New("failed to reserve payslip ids")
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0, n)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.New("failed to reserve payslip ids")
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil || len(ids) != n {
+		return nil, errors.New("failed to reserve payslip ids")
 	}
 
-	return PayslipList, Total, nil
+	return ids, nil
+}
+
+// logs every tenth of the way, large headcounts take long enough to want a sign of life
+func logProgress(what string) empl.Progress {
+	return func(done, total int) {
+		step := max(total/10, 1)
+		if done%step == 0 || done == total {
+			log.Printf("%s: %d of %d payslips computed", what, done, total)
+		}
+	}
 }
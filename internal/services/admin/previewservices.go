@@ -0,0 +1,247 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/hlp"
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
+)
+
+type PreviewFlag string
+
+const (
+	FlagLargeDelta     PreviewFlag = "LARGE_DELTA"     // take-home pay moved past a threshold against the previous run
+	FlagNewHire        PreviewFlag = "NEW_HIRE"        // wasn't paid in the previous run
+	FlagZeroAttendance PreviewFlag = "ZERO_ATTENDANCE" // no attendance in the period
+)
+
+// what finance reviews before a run, nothing in it is persisted
+type Preview struct {
+	PayrollID         int64          `json:"payroll_id"`
+	PeriodStart       time.Time      `json:"period_start"`
+	PeriodEnd         time.Time      `json:"period_end"`
+	Currency          money.Currency `json:"currency"`
+	Rounding          money.Rounding `json:"rounding"`
+	PreviousPayrollID int64          `json:"previous_payroll_id,omitempty"` // zero when no earlier payroll was run
+	Thresholds        Thresholds     `json:"thresholds"`
+	Employees         []PreviewLine  `json:"employees"`
+	Total             money.Money    `json:"total"`
+	PreviousTotal     money.Money    `json:"previous_total"`
+	Flagged           int            `json:"flagged"`
+	GeneratedAt       time.Time      `json:"generated_at"`
+}
+
+type Thresholds struct {
+	DeltaPercent money.Decimal `json:"delta_percent"`
+	DeltaAmount  money.Decimal `json:"delta_amount"`
+}
+
+// previous figures are null for employees the previous run didn't pay
+type PreviewLine struct {
+	UserID              int64          `json:"user_id"`
+	Username            string         `json:"username"`
+	WorkingDays         int            `json:"working_days"`
+	AttendedDays        int            `json:"attended_days"`
+	AttendancePay       money.Money    `json:"attendance_pay"`
+	OvertimePay         money.Money    `json:"overtime_pay"`
+	ReimbursementPay    money.Money    `json:"reimbursement_pay"`
+	TakeHomePay         money.Money    `json:"take_home_pay"`
+	PreviousTakeHomePay *money.Money   `json:"previous_take_home_pay"`
+	Delta               *money.Money   `json:"delta"`
+	DeltaPercent        *money.Decimal `json:"delta_percent"` // rounded to two decimals, null when the previous pay was zero
+	Flags               []PreviewFlag  `json:"flags"`
+}
+
+func (a *adminSvcImpl) PreviewPayroll(payrollID int64, format string, ctx context.Context) (Preview, error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	// same snapshot semantics as the run, and nothing can be written by accident
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return Preview{}, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	var payroll model.Payroll
+	query := `SELECT id, status, start_period, end_period FROM payroll WHERE id = $1`
+	err = tx.QueryRowContext(ctx, query, payrollID).Scan(&payroll.ID, &payroll.Status, &payroll.StartPeriod, &payroll.EndPeriod)
+	if err == sql.ErrNoRows {
+		return Preview{}, ErrPayrollNotFound
+	}
+	if err != nil {
+		return Preview{}, errors.New("failed to query payroll")
+	}
+
+	if payroll.Status != model.PAYROLLLOCKED {
+		return Preview{}, ErrPreviewNotLocked
+	}
+
+	previousID, previous, err := previousTakeHome(payroll, tx, ctx)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	users, err := payrollUsers(tx, ctx)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	currency := a.payroll.Currency
+	in := func(d money.Decimal) money.Money { return money.New(d, currency) }
+
+	preview := Preview{
+		PayrollID:         payroll.ID,
+		PeriodStart:       payroll.StartPeriod,
+		PeriodEnd:         payroll.EndPeriod,
+		Currency:          currency,
+		Rounding:          a.payroll.Rounding,
+		PreviousPayrollID: previousID,
+		Thresholds:        Thresholds{DeltaPercent: a.payroll.PreviewDeltaPercent, DeltaAmount: a.payroll.PreviewDeltaAmount},
+		Employees:         make([]PreviewLine, 0, len(users)),
+		Total:             in(money.Decimal{}),
+		PreviousTotal:     in(money.Decimal{}),
+	}
+
+	userIDs := make([]int64, len(users))
+	for i, user := range users {
+		userIDs[i] = user.ID
+	}
+
+	computed, err := a.empl.ComputePayslips(userIDs, tx, ctx, payroll.StartPeriod, payroll.EndPeriod, logProgress(fmt.Sprintf("payroll %d preview", payroll.ID)))
+	if err != nil {
+		return Preview{}, err
+	}
+
+	for i, user := range users {
+		if err := computed[i].Err; err != nil {
+			return Preview{}, fmt.Errorf("payslip for user %d: %w", user.ID, err)
+		}
+		payslip := computed[i].Payslip
+
+		line := PreviewLine{
+			UserID:           user.ID,
+			Username:         user.Username,
+			WorkingDays:      payslip.WorkingDays,
+			AttendedDays:     payslip.AttendedDays,
+			AttendancePay:    in(payslip.AttendancePay),
+			OvertimePay:      in(payslip.OvertimePay),
+			ReimbursementPay: in(payslip.ReimbursementPay),
+			TakeHomePay:      in(payslip.TakeHomePay),
+			Flags:            []PreviewFlag{},
+		}
+
+		if payslip.AttendedDays == 0 {
+			line.Flags = append(line.Flags, FlagZeroAttendance)
+		}
+
+		prev, paid := previous[user.ID]
+		switch {
+		case paid:
+			delta := payslip.TakeHomePay.Sub(prev)
+			line.PreviousTakeHomePay = &money.Money{Amount: prev, Currency: currency}
+			line.Delta = &money.Money{Amount: delta, Currency: currency}
+			if prev.Sign() != 0 {
+				percent := money.FromRat(new(big.Rat).Quo(delta.MulInt(100).Rat(), prev.Rat()), 2, money.HalfEven)
+				line.DeltaPercent = &percent
+			}
+			if a.exceedsThreshold(prev, delta) {
+				line.Flags = append(line.Flags, FlagLargeDelta)
+			}
+			preview.PreviousTotal.Amount = preview.PreviousTotal.Amount.Add(prev)
+		case previousID != 0:
+			// with no previous run at all everyone would be new, which says nothing
+			line.Flags = append(line.Flags, FlagNewHire)
+		}
+
+		if len(line.Flags) > 0 {
+			preview.Flagged++
+		}
+		preview.Total.Amount = preview.Total.Amount.Add(payslip.TakeHomePay)
+		preview.Employees = append(preview.Employees, line)
+	}
+
+	// the preview discloses every salary, it isn't handed out unless that is on record
+	if a.reads != nil {
+		err := a.reads.Record(db, audit.Entry{
+			EventType: audit.EventPayrollPreviewed,
+			RecordID:  payroll.ID,
+			NewData:   audit.PreviewRead{PayrollID: payroll.ID, Format: format, Users: len(preview.Employees)},
+		}, ctx)
+		if err != nil {
+			return Preview{}, errors.New("failed to audit payroll preview")
+		}
+	}
+
+	preview.GeneratedAt = time.Now()
+
+	return preview, nil
+}
+
+// compared exactly, the percentage is |delta| * 100 >= threshold * previous so nothing is rounded first
+func (a *adminSvcImpl) exceedsThreshold(previous, delta money.Decimal) bool {
+	if delta.IsZero() {
+		return false
+	}
+
+	abs := delta
+	if abs.Sign() < 0 {
+		abs = abs.Neg()
+	}
+
+	if pct := a.payroll.PreviewDeltaPercent; pct.Sign() > 0 && abs.MulInt(100).Cmp(pct.Mul(previous)) >= 0 {
+		return true
+	}
+
+	if amount := a.payroll.PreviewDeltaAmount; amount.Sign() > 0 && abs.Cmp(amount) >= 0 {
+		return true
+	}
+
+	return false
+}
+
+// take-home pay from the latest run payroll before this one, at the revision that is served
+func previousTakeHome(payroll model.Payroll, tx *sql.Tx, ctx context.Context) (int64, map[int64]money.Decimal, error) {
+	var previousID int64
+	var revision int
+	query := `SELECT id, revision FROM payroll WHERE end_period <= $1 AND id <> $2 AND status IN ($3, $4, $5, $6) ORDER BY end_period DESC LIMIT 1`
+	err := tx.QueryRowContext(ctx, query, payroll.StartPeriod, payroll.ID, model.PAYROLLRUN, model.PAYROLLSUBMITTED, model.PAYROLLAPPROVED, model.PAYROLLPAID).Scan(&previousID, &revision)
+	if err == sql.ErrNoRows {
+		return 0, map[int64]money.Decimal{}, nil
+	}
+	if err != nil {
+		return 0, nil, errors.New("failed to query previous payroll")
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT user_id, take_home_pay FROM payslip WHERE payroll_id = $1 AND revision = $2`, previousID, revision)
+	if err != nil {
+		return 0, nil, errors.New("failed to query previous payslips")
+	}
+	defer rows.Close()
+
+	previous := make(map[int64]money.Decimal)
+	for rows.Next() {
+		var userID int64
+		var takeHome money.Decimal
+		if err := rows.Scan(&userID, &takeHome); err != nil {
+			return 0, nil, errors.New("failed to scan previous payslip")
+		}
+		previous[userID] = takeHome
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, nil, errors.New("error during previous payslip iteration")
+	}
+
+	return previousID, previous, nil
+}
@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/achsanalfitra/gopayslip/hlp"
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/export"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+)
+
+// every payslip the run issued at its current revision, rendered into the bundle in user id order
+func (a *adminSvcImpl) PrintPayslips(userID, payrollID int64, bundle export.Bundle, ctx context.Context) (int, error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return 0, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	payroll, err := issuedPayroll(payrollID, tx, ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `SELECT u.id, u.username, u.department FROM payslip p JOIN users u ON u.id = p.user_id
+              WHERE p.payroll_id = $1 AND p.revision = $2 ORDER BY u.id`
+	rows, err := tx.QueryContext(ctx, query, payroll.ID, payroll.Revision)
+	if err != nil {
+		return 0, errors.New("failed to query payslips")
+	}
+	defer rows.Close()
+
+	var employees []model.User
+	for rows.Next() {
+		var u model.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Department); err != nil {
+			return 0, errors.New("failed to scan payslip owner")
+		}
+		employees = append(employees, u)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, errors.New("error during payslip iteration")
+	}
+
+	// nothing is written unless the disclosure is on record
+	if err := a.recordPrint(userID, payroll, len(employees), bundle.Kind(), db, ctx); err != nil {
+		return 0, err
+	}
+
+	for _, employee := range employees {
+		payslip, items, err := a.empl.IssuedPayslip(payroll, employee.ID, tx, ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		file, err := a.empl.RenderPayslip(payslip, items, employee)
+		if err != nil {
+			return 0, err
+		}
+
+		if err := bundle.Add(file); err != nil {
+			return 0, errors.New("failed to write payslip PDF")
+		}
+	}
+
+	if err := bundle.Close(); err != nil {
+		return 0, errors.New("failed to finish the payslip bundle")
+	}
+
+	return len(employees), nil
+}
+
+func (a *adminSvcImpl) recordPrint(userID int64, payroll model.Payroll, payslips int, destination string, db *sql.DB, ctx context.Context) error {
+	if a.reads == nil {
+		return nil
+	}
+
+	err := a.reads.Record(db, audit.Entry{
+		EventType: audit.EventPayslipsPrinted,
+		RecordID:  payroll.ID,
+		NewData: audit.PrintBatchRead{
+			PayrollID:   payroll.ID,
+			Revision:    payroll.Revision,
+			Payslips:    payslips,
+			Protected:   a.payroll.PDF.PasswordKey != nil,
+			Destination: destination,
+		},
+		ActorID: userID,
+	}, ctx)
+	if err != nil {
+		return errors.New("failed to audit payslip printing")
+	}
+
+	return nil
+}
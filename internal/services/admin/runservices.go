@@ -0,0 +1,479 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/hlp"
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/services/empl"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrRunInProgress       = errors.New("the payroll is already being run")
+	ErrIdempotencyConflict = errors.New("the idempotency key was used for a different payroll or admin")
+)
+
+// progress of the runs this instance is executing, keyed by run id
+type runTracker struct {
+	mu   sync.Mutex
+	runs map[int64]model.RunProgress
+}
+
+func newRunTracker() *runTracker {
+	return &runTracker{runs: make(map[int64]model.RunProgress)}
+}
+
+func (t *runTracker) track(runID int64) empl.Progress {
+	logged := logProgress(fmt.Sprintf("payroll run %d", runID))
+	return func(done, total int) {
+		t.mu.Lock()
+		// workers report out of order, the count only moves forward
+		if done > t.runs[runID].Computed {
+			t.runs[runID] = model.RunProgress{Computed: done, Headcount: total}
+		}
+		t.mu.Unlock()
+		logged(done, total)
+	}
+}
+
+func (t *runTracker) forget(runID int64) {
+	t.mu.Lock()
+	delete(t.runs, runID)
+	t.mu.Unlock()
+}
+
+func (t *runTracker) progress(runID int64) (model.RunProgress, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.runs[runID]
+	return p, ok
+}
+
+// what a run request answers, Replayed is set when the key had already completed
+type RunResult struct {
+	Run      model.PayrollRun `json:"run"`
+	Payroll  model.Payroll    `json:"payroll"`
+	Replayed bool             `json:"replayed"`
+}
+
+// the advisory lock is held on a pinned connection for the whole run, so it's released when the process dies
+func (a *adminSvcImpl) RunPayroll(userID, payrollID int64, key uuid.UUID, reason string, ctx context.Context) (RunResult, error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return RunResult{}, err
+	}
+
+	// a retried request is answered without waiting for the lock
+	run, result, err := runByKey(key, db, ctx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return RunResult{}, err
+	}
+	if err == nil {
+		if run.PayrollID != payrollID || run.StartedBy != userID {
+			return RunResult{}, ErrIdempotencyConflict
+		}
+		if run.Status == model.RUNCOMPLETE {
+			return replayRun(run, result)
+		}
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return RunResult{}, errors.New("failed to get a database connection")
+	}
+	defer conn.Close()
+
+	held, err := lockRun(payrollID, conn, ctx)
+	if err != nil {
+		return RunResult{}, err
+	}
+	if !held {
+		return RunResult{}, ErrRunInProgress
+	}
+	defer unlockRun(payrollID, conn)
+
+	// nobody else holds the lock, a run still marked RUNNING lost its process
+	if _, err := a.abandonRuns(payrollID, conn, ctx); err != nil {
+		return RunResult{}, err
+	}
+
+	run, err = claimRun(userID, payrollID, key, conn, ctx)
+	if err != nil {
+		return RunResult{}, err
+	}
+	if run.Status == model.RUNCOMPLETE {
+		// completed by the same key between the first read and the lock
+		_, result, err := runByKey(key, conn, ctx)
+		if err != nil {
+			return RunResult{}, errors.New("failed to read the completed payroll run")
+		}
+		return replayRun(run, result)
+	}
+
+	payroll, err := a.executeRun(&run, reason, conn, ctx)
+	if err != nil {
+		// recorded even when the request was cancelled halfway
+		if failErr := a.failRun(run, err.Error(), conn, context.WithoutCancel(ctx)); failErr != nil {
+			log.Printf("payroll run %d failed and couldn't be marked: %v", run.ID, failErr)
+		}
+		return RunResult{}, err
+	}
+
+	return RunResult{Run: run, Payroll: payroll}, nil
+}
+
+// every attempt of the payroll, oldest first
+func (a *adminSvcImpl) PayrollRuns(payrollID int64, ctx context.Context) ([]model.PayrollRun, error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, runSelect+` WHERE payroll_id = $1 ORDER BY id`, payrollID)
+	if err != nil {
+		return nil, errors.New("failed to query payroll runs")
+	}
+	defer rows.Close()
+
+	runs := []model.PayrollRun{}
+	for rows.Next() {
+		run, _, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		if progress, ok := a.runs.progress(run.ID); ok && run.Status == model.RUNRUNNING {
+			run.Progress = &progress
+		}
+		runs = append(runs, run)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("error during payroll run iteration")
+	}
+
+	return runs, nil
+}
+
+// called at startup, runs left RUNNING by a crash are marked failed so they can be retried
+func (a *adminSvcImpl) RecoverPayrollRuns(ctx context.Context) (int, error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT payroll_id FROM payroll_run WHERE status = $1`, model.RUNRUNNING)
+	if err != nil {
+		return 0, errors.New("failed to query running payroll runs")
+	}
+	var payrollIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, errors.New("failed to scan running payroll run")
+		}
+		payrollIDs = append(payrollIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, errors.New("error during running payroll run iteration")
+	}
+
+	recovered := 0
+	for _, payrollID := range payrollIDs {
+		n, err := a.recoverPayroll(payrollID, db, ctx)
+		if err != nil {
+			return recovered, err
+		}
+		recovered += n
+	}
+
+	return recovered, nil
+}
+
+func (a *adminSvcImpl) recoverPayroll(payrollID int64, db *sql.DB, ctx context.Context) (int, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, errors.New("failed to get a database connection")
+	}
+	defer conn.Close()
+
+	// another instance is running it right now
+	held, err := lockRun(payrollID, conn, ctx)
+	if err != nil || !held {
+		return 0, err
+	}
+	defer unlockRun(payrollID, conn)
+
+	return a.abandonRuns(payrollID, conn, ctx)
+}
+
+// issues the payslips and completes the run in one transaction, either all of it is visible or none
+func (a *adminSvcImpl) executeRun(run *model.PayrollRun, reason string, conn *sql.Conn, ctx context.Context) (model.Payroll, error) {
+	// read committed, a repeatable read transaction can't lock an audit chain tip that moved since its snapshot
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return model.Payroll{}, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	oldPayroll, err := lockPayroll(run.PayrollID, tx, ctx)
+	if err != nil {
+		return model.Payroll{}, err
+	}
+
+	if err := checkTransition(oldPayroll.Status, model.PAYROLLRUN, reason); err != nil {
+		return model.Payroll{}, err
+	}
+
+	// under the revision the transition gives the payroll below
+	issuing := oldPayroll
+	issuing.Revision++
+
+	progress := a.runs.track(run.ID)
+	defer a.runs.forget(run.ID)
+
+	issued, err := a.issuePayslips(issuing, run.StartedBy, progress, tx, ctx)
+	if err != nil {
+		return model.Payroll{}, err
+	}
+
+	// audit writes hold the chain lock until commit, they come last so other writers only wait for the commit
+	newPayroll, err := a.transition(oldPayroll, model.PAYROLLRUN, run.StartedBy, reason, tx, ctx)
+	if err != nil {
+		return model.Payroll{}, err
+	}
+
+	err = a.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventPayslipsIssued,
+		RecordID:  newPayroll.ID,
+		NewData:   issued,
+		ActorID:   run.StartedBy,
+	})
+	if err != nil {
+		return model.Payroll{}, err
+	}
+
+	result, err := json.Marshal(newPayroll)
+	if err != nil {
+		return model.Payroll{}, errors.New("failed to encode the payroll run result")
+	}
+
+	completed := *run
+	completed.Status = model.RUNCOMPLETE
+	completed.Revision = sql.NullInt64{Int64: int64(newPayroll.Revision), Valid: true}
+	completed.Payslips = issued.Payslips
+	completed.FinishedAt = sql.NullTime{Time: time.Now(), Valid: true}
+
+	updateQuery := `UPDATE payroll_run SET status = $1, revision = $2, payslips = $3, result = $4, finished_at = $5 WHERE id = $6`
+	_, err = tx.ExecContext(ctx, updateQuery, completed.Status, completed.Revision, completed.Payslips, result, completed.FinishedAt, completed.ID)
+	if err != nil {
+		return model.Payroll{}, errors.New("failed to complete the payroll run")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.Payroll{}, errors.New("commit failed")
+	}
+
+	*run = completed
+	return newPayroll, nil
+}
+
+// marks the attempt failed, a later request with the same key resumes it
+func (a *adminSvcImpl) failRun(run model.PayrollRun, reason string, conn *sql.Conn, ctx context.Context) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	failed := run
+	failed.Status = model.RUNFAILED
+	failed.Error = reason
+	failed.FinishedAt = sql.NullTime{Time: time.Now(), Valid: true}
+
+	updateQuery := `UPDATE payroll_run SET status = $1, error = $2, finished_at = $3 WHERE id = $4 AND status = $5`
+	res, err := tx.ExecContext(ctx, updateQuery, failed.Status, failed.Error, failed.FinishedAt, failed.ID, model.RUNRUNNING)
+	if err != nil {
+		return errors.New("failed to mark the payroll run failed")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	// attributed to the admin who started it, crashes are found without anyone acting
+	err = a.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventPayrollRunFail,
+		RecordID:  failed.ID,
+		OldData:   run,
+		NewData:   failed,
+		ActorID:   run.StartedBy,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("commit failed")
+	}
+
+	return nil
+}
+
+// only safe while holding the payroll's run lock
+func (a *adminSvcImpl) abandonRuns(payrollID int64, conn *sql.Conn, ctx context.Context) (int, error) {
+	rows, err := conn.QueryContext(ctx, runSelect+` WHERE payroll_id = $1 AND status = $2 ORDER BY id`, payrollID, model.RUNRUNNING)
+	if err != nil {
+		return 0, errors.New("failed to query running payroll runs")
+	}
+	var stale []model.PayrollRun
+	for rows.Next() {
+		run, _, err := scanRun(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stale = append(stale, run)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, errors.New("error during running payroll run iteration")
+	}
+
+	for _, run := range stale {
+		log.Printf("payroll run %d of payroll %d was abandoned while running, marking it failed", run.ID, payrollID)
+		if err := a.failRun(run, "abandoned, the process running it stopped", conn, ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(stale), nil
+}
+
+// a new key is recorded as RUNNING, a failed one is resumed as the next attempt
+func claimRun(userID, payrollID int64, key uuid.UUID, conn *sql.Conn, ctx context.Context) (model.PayrollRun, error) {
+	run, _, err := runByKey(key, conn, ctx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return model.PayrollRun{}, err
+	}
+
+	if err == nil {
+		if run.PayrollID != payrollID || run.StartedBy != userID {
+			return model.PayrollRun{}, ErrIdempotencyConflict
+		}
+		if run.Status == model.RUNCOMPLETE {
+			return run, nil
+		}
+
+		run.Status = model.RUNRUNNING
+		run.Attempts++
+		run.Error = ""
+		run.StartedAt = time.Now()
+		run.FinishedAt = sql.NullTime{}
+
+		updateQuery := `UPDATE payroll_run SET status = $1, attempts = $2, error = $3, started_at = $4, finished_at = NULL WHERE id = $5`
+		_, err := conn.ExecContext(ctx, updateQuery, run.Status, run.Attempts, run.Error, run.StartedAt, run.ID)
+		if err != nil {
+			return model.PayrollRun{}, errors.New("failed to resume the payroll run")
+		}
+		return run, nil
+	}
+
+	run = model.PayrollRun{
+		PayrollID:      payrollID,
+		IdempotencyKey: key,
+		Status:         model.RUNRUNNING,
+		Attempts:       1,
+		StartedBy:      userID,
+		StartedAt:      time.Now(),
+	}
+
+	// the key can still be taken for another payroll, which holds a different lock
+	insertQuery := `INSERT INTO payroll_run (payroll_id, idempotency_key, status, attempts, started_by, started_at) VALUES ($1, $2, $3, $4, $5, $6)
+	                ON CONFLICT (idempotency_key) DO NOTHING RETURNING id`
+	err = conn.QueryRowContext(ctx, insertQuery, run.PayrollID, run.IdempotencyKey, run.Status, run.Attempts, run.StartedBy, run.StartedAt).Scan(&run.ID)
+	if err == sql.ErrNoRows {
+		return model.PayrollRun{}, ErrIdempotencyConflict
+	}
+	if err != nil {
+		return model.PayrollRun{}, errors.New("failed to record the payroll run")
+	}
+
+	return run, nil
+}
+
+func replayRun(run model.PayrollRun, result []byte) (RunResult, error) {
+	var payroll model.Payroll
+	if err := json.Unmarshal(result, &payroll); err != nil {
+		return RunResult{}, fmt.Errorf("payroll run %d has no readable result", run.ID)
+	}
+	return RunResult{Run: run, Payroll: payroll, Replayed: true}, nil
+}
+
+// nothing else in the schema takes advisory locks, the payroll id is the key
+func lockRun(payrollID int64, conn *sql.Conn, ctx context.Context) (bool, error) {
+	var held bool
+	err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, payrollID).Scan(&held)
+	if err != nil {
+		return false, errors.New("failed to take the payroll run lock")
+	}
+	return held, nil
+}
+
+func unlockRun(payrollID int64, conn *sql.Conn) {
+	var released bool
+	err := conn.QueryRowContext(context.Background(), `SELECT pg_advisory_unlock($1)`, payrollID).Scan(&released)
+	if err != nil || !released {
+		// a pooled session can't keep the lock, the connection is discarded instead
+		conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+}
+
+const runSelect = `SELECT id, payroll_id, idempotency_key, status, attempts, revision, payslips, error, started_by, started_at, finished_at, result
+                   FROM payroll_run`
+
+// satisfied by *sql.DB and *sql.Conn
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func runByKey(key uuid.UUID, q querier, ctx context.Context) (model.PayrollRun, []byte, error) {
+	return scanRun(q.QueryRowContext(ctx, runSelect+` WHERE idempotency_key = $1`, key))
+}
+
+func scanRun(s scanner) (model.PayrollRun, []byte, error) {
+	var r model.PayrollRun
+	var result []byte
+	err := s.Scan(
+		&r.ID,
+		&r.PayrollID,
+		&r.IdempotencyKey,
+		&r.Status,
+		&r.Attempts,
+		&r.Revision,
+		&r.Payslips,
+		&r.Error,
+		&r.StartedBy,
+		&r.StartedAt,
+		&r.FinishedAt,
+		&result,
+	)
+	if err == sql.ErrNoRows {
+		return model.PayrollRun{}, nil, err
+	}
+	if err != nil {
+		return model.PayrollRun{}, nil, errors.New("failed to scan payroll run")
+	}
+	return r, result, nil
+}
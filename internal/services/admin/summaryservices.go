@@ -0,0 +1,299 @@
+package admin
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/hlp"
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
+)
+
+var ErrInvalidSort = errors.New("unknown sort field")
+
+const (
+	defaultSummaryPageSize = 50
+	maxSummaryPageSize     = 500
+)
+
+// zero values are ignored, rows are sorted by user id unless Sort says otherwise
+type SummaryFilter struct {
+	Department string // exact match
+	Username   string // case-insensitive substring
+	Sort       string // a key of summarySorts
+	Desc       bool
+	Limit      int
+	Offset     int
+}
+
+// admins are left out, the report is about what employees are paid
+type SummaryReport struct {
+	PayrollID   int64               `json:"payroll_id"`
+	Status      model.PayrollStatus `json:"status"`
+	Frozen      bool                `json:"frozen"` // read from the payslips issued by the run, otherwise computed live
+	PeriodStart time.Time           `json:"period_start"`
+	PeriodEnd   time.Time           `json:"period_end"`
+	Currency    money.Currency      `json:"currency"`
+	Rows        []SummaryRow        `json:"rows"`
+	Matched     int                 `json:"matched"` // rows matching the filters, across every page
+	Limit       int                 `json:"limit"`
+	Offset      int                 `json:"offset"`
+	Totals      SummaryTotals       `json:"totals"`   // over every matching row, not just the page
+	Failures    []SummaryFailure    `json:"failures"` // employees matching the filters that couldn't be computed
+	GeneratedAt time.Time           `json:"generated_at"`
+}
+
+type SummaryRow struct {
+	UserID           int64       `json:"user_id"`
+	Username         string      `json:"username"`
+	Department       string      `json:"department"`
+	WorkingDays      int         `json:"working_days"`
+	AttendedDays     int         `json:"attended_days"`
+	BaseSalary       money.Money `json:"base_salary"`
+	AttendancePay    money.Money `json:"attendance_pay"`
+	OvertimePay      money.Money `json:"overtime_pay"`
+	ReimbursementPay money.Money `json:"reimbursement_pay"`
+	TakeHomePay      money.Money `json:"take_home_pay"`
+}
+
+type SummaryTotals struct {
+	Employees        int         `json:"employees"`
+	AttendancePay    money.Money `json:"attendance_pay"`
+	OvertimePay      money.Money `json:"overtime_pay"`
+	ReimbursementPay money.Money `json:"reimbursement_pay"`
+	TakeHomePay      money.Money `json:"take_home_pay"`
+}
+
+type SummaryFailure struct {
+	UserID     int64  `json:"user_id"`
+	Username   string `json:"username"`
+	Department string `json:"department"`
+	Error      string `json:"error"`
+}
+
+// ties are broken by user id so pages don't overlap
+var summarySorts = map[string]func(a, b SummaryRow) int{
+	"user_id":           func(a, b SummaryRow) int { return cmp.Compare(a.UserID, b.UserID) },
+	"username":          func(a, b SummaryRow) int { return strings.Compare(a.Username, b.Username) },
+	"department":        func(a, b SummaryRow) int { return strings.Compare(a.Department, b.Department) },
+	"attended_days":     func(a, b SummaryRow) int { return cmp.Compare(a.AttendedDays, b.AttendedDays) },
+	"base_salary":       func(a, b SummaryRow) int { return a.BaseSalary.Amount.Cmp(b.BaseSalary.Amount) },
+	"attendance_pay":    func(a, b SummaryRow) int { return a.AttendancePay.Amount.Cmp(b.AttendancePay.Amount) },
+	"overtime_pay":      func(a, b SummaryRow) int { return a.OvertimePay.Amount.Cmp(b.OvertimePay.Amount) },
+	"reimbursement_pay": func(a, b SummaryRow) int { return a.ReimbursementPay.Amount.Cmp(b.ReimbursementPay.Amount) },
+	"take_home_pay":     func(a, b SummaryRow) int { return a.TakeHomePay.Amount.Cmp(b.TakeHomePay.Amount) },
+}
+
+func (a *adminSvcImpl) PayrollSummary(payrollID int64, f SummaryFilter, ctx context.Context) (SummaryReport, error) {
+	order, ok := summarySorts[cmp.Or(f.Sort, "user_id")]
+	if !ok {
+		return SummaryReport{}, fmt.Errorf("%w %q", ErrInvalidSort, f.Sort)
+	}
+	if f.Limit <= 0 {
+		f.Limit = defaultSummaryPageSize
+	}
+	if f.Limit > maxSummaryPageSize {
+		f.Limit = maxSummaryPageSize
+	}
+	f.Offset = max(f.Offset, 0)
+
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return SummaryReport{}, err
+	}
+
+	// live figures come from one snapshot, and nothing can be written by accident
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return SummaryReport{}, errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	var payroll model.Payroll
+	query := `SELECT id, status, revision, start_period, end_period FROM payroll WHERE id = $1`
+	err = tx.QueryRowContext(ctx, query, payrollID).Scan(&payroll.ID, &payroll.Status, &payroll.Revision, &payroll.StartPeriod, &payroll.EndPeriod)
+	if err == sql.ErrNoRows {
+		return SummaryReport{}, ErrPayrollNotFound
+	}
+	if err != nil {
+		return SummaryReport{}, errors.New("failed to query payroll")
+	}
+
+	report := SummaryReport{
+		PayrollID:   payroll.ID,
+		Status:      payroll.Status,
+		Frozen:      payroll.IsRun(),
+		PeriodStart: payroll.StartPeriod,
+		PeriodEnd:   payroll.EndPeriod,
+		Currency:    a.payroll.Currency,
+		Limit:       f.Limit,
+		Offset:      f.Offset,
+		Failures:    []SummaryFailure{},
+	}
+
+	var rows []SummaryRow
+	if report.Frozen {
+		rows, report.Currency, err = issuedSummary(payroll, tx, ctx)
+	} else {
+		rows, report.Failures, err = a.liveSummary(payroll, tx, ctx)
+	}
+	if err != nil {
+		return SummaryReport{}, err
+	}
+	// a run that paid no employees has no payslip to take the currency from
+	report.Currency = cmp.Or(report.Currency, a.payroll.Currency)
+
+	matches := func(username, department string) bool {
+		if f.Department != "" && department != f.Department {
+			return false
+		}
+		return f.Username == "" || strings.Contains(strings.ToLower(username), strings.ToLower(f.Username))
+	}
+
+	rows = slices.DeleteFunc(rows, func(r SummaryRow) bool { return !matches(r.Username, r.Department) })
+	report.Failures = slices.DeleteFunc(report.Failures, func(r SummaryFailure) bool { return !matches(r.Username, r.Department) })
+
+	slices.SortStableFunc(rows, func(x, y SummaryRow) int {
+		c := order(x, y)
+		if f.Desc {
+			c = -c
+		}
+		return cmp.Or(c, cmp.Compare(x.UserID, y.UserID))
+	})
+
+	in := func(d money.Decimal) money.Money { return money.New(d, report.Currency) }
+	totals := SummaryTotals{Employees: len(rows), AttendancePay: in(money.Decimal{}), OvertimePay: in(money.Decimal{}), ReimbursementPay: in(money.Decimal{}), TakeHomePay: in(money.Decimal{})}
+	// every figure is already rounded, the sums are exact
+	for _, r := range rows {
+		totals.AttendancePay.Amount = totals.AttendancePay.Amount.Add(r.AttendancePay.Amount)
+		totals.OvertimePay.Amount = totals.OvertimePay.Amount.Add(r.OvertimePay.Amount)
+		totals.ReimbursementPay.Amount = totals.ReimbursementPay.Amount.Add(r.ReimbursementPay.Amount)
+		totals.TakeHomePay.Amount = totals.TakeHomePay.Amount.Add(r.TakeHomePay.Amount)
+	}
+	report.Totals = totals
+	report.Matched = len(rows)
+
+	start := min(f.Offset, len(rows))
+	end := min(start+f.Limit, len(rows))
+	report.Rows = append([]SummaryRow{}, rows[start:end]...)
+
+	// the report discloses salaries, it isn't handed out unless that is on record
+	if a.reads != nil {
+		err := a.reads.Record(db, audit.Entry{
+			EventType: audit.EventPayrollSummaryViewed,
+			RecordID:  payroll.ID,
+			NewData:   audit.SummaryRead{PeriodStart: payroll.StartPeriod, PeriodEnd: payroll.EndPeriod, Users: len(report.Rows)},
+		}, ctx)
+		if err != nil {
+			return SummaryReport{}, errors.New("failed to audit payroll summary read")
+		}
+	}
+
+	report.GeneratedAt = time.Now()
+
+	return report, nil
+}
+
+// the current revision's payslips, in the currency they were issued in
+func issuedSummary(payroll model.Payroll, tx *sql.Tx, ctx context.Context) ([]SummaryRow, money.Currency, error) {
+	query := `SELECT p.user_id, u.username, u.department, p.currency, p.working_days, p.attended_days,
+                     p.base_salary, p.attendance_pay, p.overtime_pay, p.reimbursement_pay, p.take_home_pay
+              FROM payslip p JOIN users u ON u.id = p.user_id
+              WHERE p.payroll_id = $1 AND p.revision = $2 AND u.role = $3 ORDER BY p.user_id`
+	rows, err := tx.QueryContext(ctx, query, payroll.ID, payroll.Revision, model.EMPLOYEE)
+	if err != nil {
+		return nil, "", errors.New("failed to query payslips")
+	}
+	defer rows.Close()
+
+	var summary []SummaryRow
+	var currency money.Currency
+	for rows.Next() {
+		var r SummaryRow
+		var base, attendance, overtime, reimbursement, takeHome money.Decimal
+		err := rows.Scan(&r.UserID, &r.Username, &r.Department, &currency, &r.WorkingDays, &r.AttendedDays,
+			&base, &attendance, &overtime, &reimbursement, &takeHome)
+		if err != nil {
+			return nil, "", errors.New("failed to scan payslip")
+		}
+		r.BaseSalary = money.New(base, currency)
+		r.AttendancePay = money.New(attendance, currency)
+		r.OvertimePay = money.New(overtime, currency)
+		r.ReimbursementPay = money.New(reimbursement, currency)
+		r.TakeHomePay = money.New(takeHome, currency)
+		summary = append(summary, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", errors.New("error during payslip iteration")
+	}
+
+	return summary, currency, nil
+}
+
+// computed in bulk from the live tables, users that can't be paid are listed instead of dropped
+func (a *adminSvcImpl) liveSummary(payroll model.Payroll, tx *sql.Tx, ctx context.Context) ([]SummaryRow, []SummaryFailure, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, username, department FROM users WHERE role = $1 ORDER BY id`, model.EMPLOYEE)
+	if err != nil {
+		return nil, nil, errors.New("failed to query users")
+	}
+	defer rows.Close()
+
+	var users []model.User
+	for rows.Next() {
+		var u model.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Department); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan user ID: %w", err)
+		}
+		users = append(users, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, errors.New("error during user iteration")
+	}
+
+	userIDs := make([]int64, len(users))
+	for i, user := range users {
+		userIDs[i] = user.ID
+	}
+
+	computed, err := a.empl.ComputePayslips(userIDs, tx, ctx, payroll.StartPeriod, payroll.EndPeriod, logProgress(fmt.Sprintf("payroll %d summary", payroll.ID)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	currency := a.payroll.Currency
+	summary := make([]SummaryRow, 0, len(users))
+	failures := []SummaryFailure{}
+	for i, user := range users {
+		c := computed[i]
+		if c.Err != nil {
+			failures = append(failures, SummaryFailure{UserID: user.ID, Username: user.Username, Department: user.Department, Error: c.Err.Error()})
+			continue
+		}
+
+		p := c.Payslip
+		summary = append(summary, SummaryRow{
+			UserID:           user.ID,
+			Username:         user.Username,
+			Department:       user.Department,
+			WorkingDays:      p.WorkingDays,
+			AttendedDays:     p.AttendedDays,
+			BaseSalary:       money.New(p.BaseSalary, currency),
+			AttendancePay:    money.New(p.AttendancePay, currency),
+			OvertimePay:      money.New(p.OvertimePay, currency),
+			ReimbursementPay: money.New(p.ReimbursementPay, currency),
+			TakeHomePay:      money.New(p.TakeHomePay, currency),
+		})
+	}
+
+	return summary, failures, nil
+}
@@ -0,0 +1,188 @@
+package empl
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrSalaryUndefined = errors.New("salary not defined")
+)
+
+// called from the workers as payslips finish, it has to be safe for concurrent use
+type Progress func(done, total int)
+
+// Err is set for users that can't be paid, the rest of the batch is still computed
+type ComputedPayslip struct {
+	Payslip model.Payslip
+	Items   []model.PayslipItem
+	Err     error
+}
+
+// results are in the order of userIDs, cancelling ctx stops the pool and returns its error
+func (e *emplImplementation) ComputePayslips(userIDs []int64, q Querier, ctx context.Context, start, end time.Time, progress Progress) ([]ComputedPayslip, error) {
+	inputs, err := e.bulkInputs(userIDs, q, ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.computeAll(userIDs, inputs, ctx, start, end, progress)
+}
+
+// the worker pool, no I/O happens past the bulk load
+func (e *emplImplementation) computeAll(userIDs []int64, inputs map[int64]payslipInputs, ctx context.Context, start, end time.Time, progress Progress) ([]ComputedPayslip, error) {
+	total := len(userIDs)
+	workers := min(max(e.payroll.Workers, 1), total)
+	totalWorkingDays := workingDays(start, end)
+
+	computed := make([]ComputedPayslip, total)
+	jobs := make(chan int)
+	var done atomic.Int64
+	var wg sync.WaitGroup
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				in := inputs[userIDs[i]]
+				if in.err != nil {
+					computed[i] = ComputedPayslip{Payslip: model.Payslip{UserID: userIDs[i]}, Err: in.err}
+				} else {
+					payslip, items := e.compute(userIDs[i], in, totalWorkingDays, start, end)
+					computed[i] = ComputedPayslip{Payslip: payslip, Items: items}
+				}
+				if n := done.Add(1); progress != nil {
+					progress(int(n), total)
+				}
+			}
+		}()
+	}
+
+	var cancelled error
+feed:
+	for i := range userIDs {
+		select {
+		case <-ctx.Done():
+			cancelled = ctx.Err()
+			break feed
+		case jobs <- i:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if cancelled != nil {
+		return nil, cancelled
+	}
+
+	return computed, nil
+}
+
+// four queries whatever the headcount, rows come back in the order the per-user queries use
+func (e *emplImplementation) bulkInputs(userIDs []int64, q Querier, ctx context.Context, start, end time.Time) (map[int64]payslipInputs, error) {
+	inputs := make(map[int64]payslipInputs, len(userIDs))
+	ids := pq.Array(userIDs)
+
+	rows, err := q.QueryContext(ctx, `SELECT id, salary FROM users WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return nil, errors.New("failed to query user salaries")
+	}
+	for rows.Next() {
+		var userID int64
+		var salary money.NullDecimal
+		if err := rows.Scan(&userID, &salary); err != nil {
+			rows.Close()
+			return nil, errors.New("failed to scan user salary")
+		}
+		in := payslipInputs{salary: salary.Decimal}
+		if !salary.Valid {
+			in.err = ErrSalaryUndefined
+		}
+		inputs[userID] = in
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("error during user salary iteration")
+	}
+
+	for _, userID := range userIDs {
+		if _, ok := inputs[userID]; !ok {
+			inputs[userID] = payslipInputs{err: ErrUserNotFound}
+		}
+	}
+
+	rows, err = q.QueryContext(ctx, `SELECT user_id, id, created_at FROM attendance WHERE user_id = ANY($1) AND created_at BETWEEN $2 AND $3 ORDER BY user_id, created_at`, ids, start, end)
+	if err != nil {
+		return nil, errors.New("failed to query attendance")
+	}
+	for rows.Next() {
+		var a model.Attendance
+		if err := rows.Scan(&a.UserID, &a.ID, &a.CreatedAt); err != nil {
+			rows.Close()
+			return nil, errors.New("failed to scan attendance")
+		}
+		in := inputs[a.UserID]
+		in.attendance = append(in.attendance, a)
+		inputs[a.UserID] = in
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("error during attendance iteration")
+	}
+
+	rows, err = q.QueryContext(ctx, `SELECT user_id, id, EXTRACT(EPOCH FROM overtime_duration), overtime_date FROM overtime WHERE user_id = ANY($1) AND created_at BETWEEN $2 AND $3 ORDER BY user_id, overtime_date`, ids, start, end)
+	if err != nil {
+		return nil, errors.New("failed to query overtime")
+	}
+	for rows.Next() {
+		var o model.Overtime
+		var seconds money.Decimal
+		if err := rows.Scan(&o.UserID, &o.ID, &seconds, &o.Date); err != nil {
+			rows.Close()
+			return nil, errors.New("failed to scan overtime")
+		}
+		interval, ok := overtimeInterval(seconds)
+		if !ok {
+			rows.Close()
+			return nil, errors.New("failed to scan overtime")
+		}
+		o.Interval = interval
+		in := inputs[o.UserID]
+		in.overtimes = append(in.overtimes, o)
+		inputs[o.UserID] = in
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("error during overtime iteration")
+	}
+
+	rows, err = q.QueryContext(ctx, `SELECT user_id, id, reimbursement_amount, COALESCE(description, ''), created_at FROM reimbursement WHERE user_id = ANY($1) AND created_at BETWEEN $2 AND $3 ORDER BY user_id, created_at`, ids, start, end)
+	if err != nil {
+		return nil, errors.New("failed to query reimbursements")
+	}
+	for rows.Next() {
+		var r model.Reimbursement
+		if err := rows.Scan(&r.UserID, &r.ID, &r.ReimbursementAmount, &r.Description, &r.CreatedAt); err != nil {
+			rows.Close()
+			return nil, errors.New("failed to scan reimbursement")
+		}
+		in := inputs[r.UserID]
+		in.reimbursements = append(in.reimbursements, r)
+		inputs[r.UserID] = in
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("error during reimbursement iteration")
+	}
+
+	return inputs, nil
+}
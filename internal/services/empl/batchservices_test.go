@@ -0,0 +1,346 @@
+package empl
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/config"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
+)
+
+// a month of synthetic activity, every user gets a different mix of inputs
+var (
+	benchStart = time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	benchEnd   = time.Date(2025, time.March, 31, 23, 59, 59, 0, time.UTC)
+)
+
+var benchSizes = []int{100, 1_000, 10_000}
+
+func benchService(workers int) *emplImplementation {
+	return &emplImplementation{payroll: &config.Payroll{
+		Currency: money.Currency("IDR"),
+		Rounding: money.Rounding{Mode: money.HalfEven, Scope: money.PerLine},
+		Workers:  workers,
+	}}
+}
+
+func benchUserIDs(n int) []int64 {
+	ids := make([]int64, n)
+	for i := range ids {
+		ids[i] = int64(i + 1)
+	}
+	return ids
+}
+
+// 15 to 22 check-ins, up to three overtimes and two reimbursements per user
+func syntheticInputs(userIDs []int64) map[int64]payslipInputs {
+	inputs := make(map[int64]payslipInputs, len(userIDs))
+	for _, id := range userIDs {
+		in := payslipInputs{salary: money.FromInt(5_000_000 + id%50*100_000)}
+		var next int64 = id * 100
+		for d := range 15 + int(id%8) {
+			next++
+			in.attendance = append(in.attendance, model.Attendance{ID: next, UserID: id, CreatedAt: benchStart.AddDate(0, 0, d).Add(9 * time.Hour)})
+		}
+		for d := range int(id % 4) {
+			next++
+			in.overtimes = append(in.overtimes, model.Overtime{ID: next, UserID: id, Interval: time.Duration(d+1) * 50 * time.Minute, Date: benchStart.AddDate(0, 0, d).Add(18 * time.Hour)})
+		}
+		for d := range int(id % 3) {
+			next++
+			amount, _ := money.Parse(fmt.Sprintf("%d.50", 75_000+d*12_345))
+			in.reimbursements = append(in.reimbursements, model.Reimbursement{ID: next, UserID: id, ReimbursementAmount: amount, Description: "taxi", CreatedAt: benchStart.AddDate(0, 0, d)})
+		}
+		inputs[id] = in
+	}
+	return inputs
+}
+
+func TestComputePayslips(t *testing.T) {
+	userIDs := benchUserIDs(40)
+	inputs := syntheticInputs(userIDs)
+	inputs[7] = payslipInputs{err: ErrSalaryUndefined}
+	delete(inputs, 13)
+	db := openBenchDB(t, inputs)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		userID  int64
+		wantErr error
+	}{
+		{"attendance only", 4, nil},
+		{"overtime", 5, nil},
+		{"reimbursements", 2, nil},
+		{"overtime and reimbursements", 11, nil},
+		{"null salary", 7, ErrSalaryUndefined},
+		{"unknown user", 13, ErrUserNotFound},
+	}
+
+	for _, rounding := range []money.Rounding{
+		{Mode: money.HalfEven, Scope: money.PerLine},
+		{Mode: money.HalfUp, Scope: money.PerTotal},
+	} {
+		e := benchService(4)
+		e.payroll.Rounding = rounding
+
+		computed, err := e.ComputePayslips(userIDs, db, ctx, benchStart, benchEnd, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(computed) != len(userIDs) {
+			t.Fatalf("%d payslips for %d users", len(computed), len(userIDs))
+		}
+
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/%s/%s", rounding.Mode, rounding.Scope, tt.name), func(t *testing.T) {
+				got := computed[tt.userID-1]
+				if got.Payslip.UserID != tt.userID {
+					t.Fatalf("payslip of user %d in the place of user %d", got.Payslip.UserID, tt.userID)
+				}
+
+				want, wantItems, err := e.ComputePayslip(tt.userID, db, ctx, benchStart, benchEnd)
+				if tt.wantErr != nil {
+					if !errors.Is(got.Err, tt.wantErr) {
+						t.Fatalf("bulk error = %v, want %v", got.Err, tt.wantErr)
+					}
+					if err == nil {
+						t.Fatal("per-user computation succeeded where the bulk one failed")
+					}
+					return
+				}
+				if got.Err != nil || err != nil {
+					t.Fatalf("bulk error = %v, per-user error = %v", got.Err, err)
+				}
+
+				// computed at different instants
+				got.Payslip.CreatedAt, want.CreatedAt = time.Time{}, time.Time{}
+				if g, w := mustJSON(t, got.Payslip), mustJSON(t, want); g != w {
+					t.Errorf("bulk payslip\n%s\nper-user payslip\n%s", g, w)
+				}
+				if g, w := mustJSON(t, got.Items), mustJSON(t, wantItems); g != w {
+					t.Errorf("bulk items\n%s\nper-user items\n%s", g, w)
+				}
+			})
+		}
+	}
+}
+
+func TestComputeAllCancelled(t *testing.T) {
+	userIDs := benchUserIDs(10_000)
+	inputs := syntheticInputs(userIDs)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var done atomic.Int64
+	computed, err := benchService(4).computeAll(userIDs, inputs, ctx, benchStart, benchEnd, func(int, int) { done.Add(1) })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want context.Canceled", err)
+	}
+	if computed != nil {
+		t.Fatal("payslips returned from a cancelled computation")
+	}
+	if n := done.Load(); n == int64(len(userIDs)) {
+		t.Fatalf("all %d payslips were computed after the cancellation", n)
+	}
+}
+
+// values compare by their JSON, decimals of equal value can differ in their big.Int internals
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func BenchmarkComputePayslips(b *testing.B) {
+	ctx := context.Background()
+	for _, n := range benchSizes {
+		userIDs := benchUserIDs(n)
+		db := openBenchDB(b, syntheticInputs(userIDs))
+		e := benchService(runtime.GOMAXPROCS(0))
+
+		b.Run(fmt.Sprintf("users=%d/bulk", n), func(b *testing.B) {
+			for range b.N {
+				if _, err := e.ComputePayslips(userIDs, db, ctx, benchStart, benchEnd, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("users=%d/per-user", n), func(b *testing.B) {
+			for range b.N {
+				for _, id := range userIDs {
+					if _, _, err := e.ComputePayslip(id, db, ctx, benchStart, benchEnd); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkComputePool(b *testing.B) {
+	ctx := context.Background()
+	for _, n := range benchSizes {
+		userIDs := benchUserIDs(n)
+		inputs := syntheticInputs(userIDs)
+		days := workingDays(benchStart, benchEnd)
+
+		b.Run(fmt.Sprintf("users=%d/serial", n), func(b *testing.B) {
+			e := benchService(1)
+			for range b.N {
+				for _, id := range userIDs {
+					e.compute(id, inputs[id], days, benchStart, benchEnd)
+				}
+			}
+		})
+
+		for _, workers := range benchWorkers() {
+			b.Run(fmt.Sprintf("users=%d/workers=%d", n, workers), func(b *testing.B) {
+				e := benchService(workers)
+				for range b.N {
+					if _, err := e.computeAll(userIDs, inputs, ctx, benchStart, benchEnd, nil); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// powers of two up to 8 and every CPU, the default of PAYROLL_WORKERS
+func benchWorkers() []int {
+	workers := []int{1, 2, 4, 8}
+	if procs := runtime.GOMAXPROCS(0); !slices.Contains(workers, procs) {
+		workers = append(workers, procs)
+	}
+	return workers
+}
+
+// each query waits this long first, roughly a round trip to a database on the same network
+const benchRoundTrip = 100 * time.Microsecond
+
+// answers the payslip queries from synthetic inputs, one statement per round trip like postgres
+// users missing from inputs don't exist, inputs with ErrSalaryUndefined have a NULL salary
+type benchDriver struct {
+	inputs map[int64]payslipInputs
+}
+
+func openBenchDB(tb testing.TB, inputs map[int64]payslipInputs) *sql.DB {
+	tb.Helper()
+	db := sql.OpenDB(benchConnector{&benchDriver{inputs: inputs}})
+	tb.Cleanup(func() { db.Close() })
+	return db
+}
+
+type benchConnector struct {
+	d *benchDriver
+}
+
+func (c benchConnector) Connect(context.Context) (driver.Conn, error) { return &benchConn{c.d}, nil }
+func (c benchConnector) Driver() driver.Driver                        { return c }
+func (c benchConnector) Open(string) (driver.Conn, error)             { return &benchConn{c.d}, nil }
+
+type benchConn struct {
+	d *benchDriver
+}
+
+func (c *benchConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *benchConn) Close() error                        { return nil }
+func (c *benchConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *benchConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	time.Sleep(benchRoundTrip)
+
+	// bulk queries pass the ids as a postgres array literal
+	var ids []int64
+	bulk := strings.Contains(query, "ANY($1)")
+	if bulk {
+		for _, s := range strings.Split(strings.Trim(args[0].Value.(string), "{}"), ",") {
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+	} else {
+		ids = []int64{args[0].Value.(int64)}
+	}
+
+	rows := &benchRows{}
+	for _, id := range ids {
+		in, ok := c.d.inputs[id]
+		if !ok {
+			continue
+		}
+		var values [][]driver.Value
+		switch {
+		case strings.Contains(query, "FROM users"):
+			var salary driver.Value = in.salary.String()
+			if errors.Is(in.err, ErrSalaryUndefined) {
+				salary = nil
+			}
+			values = append(values, []driver.Value{salary})
+		case strings.Contains(query, "FROM attendance"):
+			for _, a := range in.attendance {
+				values = append(values, []driver.Value{a.ID, a.CreatedAt})
+			}
+		case strings.Contains(query, "FROM overtime"):
+			for _, o := range in.overtimes {
+				values = append(values, []driver.Value{o.ID, strconv.FormatFloat(o.Interval.Seconds(), 'f', -1, 64), o.Date})
+			}
+		case strings.Contains(query, "FROM reimbursement"):
+			for _, r := range in.reimbursements {
+				values = append(values, []driver.Value{r.ID, r.ReimbursementAmount.String(), r.Description, r.CreatedAt})
+			}
+		default:
+			return nil, fmt.Errorf("unexpected query %q", query)
+		}
+		// bulk queries lead with the user_id
+		for _, v := range values {
+			if bulk {
+				v = append([]driver.Value{id}, v...)
+			}
+			rows.columns = len(v)
+			rows.values = append(rows.values, v)
+		}
+	}
+	return rows, nil
+}
+
+type benchRows struct {
+	columns int
+	values  [][]driver.Value
+}
+
+// database/sql only needs the count, an empty result is never scanned
+func (r *benchRows) Columns() []string {
+	return make([]string, r.columns)
+}
+
+func (r *benchRows) Close() error { return nil }
+
+func (r *benchRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
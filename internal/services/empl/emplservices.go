@@ -4,138 +4,619 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"math/big"
 	"time"
 
 	"github.com/achsanalfitra/gopayslip/hlp"
 	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/config"
+	"github.com/achsanalfitra/gopayslip/internal/export"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
 )
 
+var (
+	ErrPayslipNotFound        = errors.New("no payslip was issued to this user for the run payroll")
+	ErrPayslipPendingApproval = errors.New("payslips for this period are waiting for approval")
+)
+
+// overtime is paid at twice the hourly rate
+const OvertimeMultiplier = 2
+
+// assume 9 to 5 is 8 hours workday
+const hoursPerWorkingDay = 8
+
+// hourly rates and hours are shown with this many decimals, amounts are computed from the exact values
+const quantityScale = 6
+
 type Empl interface {
 	GeneratePayslip(userID int64, ctx context.Context, start, end time.Time) (Payslip, error)
+	// computes from the live tables through q, RunPayroll passes its transaction to freeze the result
+	ComputePayslip(userID int64, q Querier, ctx context.Context, start, end time.Time) (model.Payslip, []model.PayslipItem, error)
+	// the same for many users, inputs are loaded with one query per table and computed on a bounded worker pool
+	ComputePayslips(userIDs []int64, q Querier, ctx context.Context, start, end time.Time, progress Progress) ([]ComputedPayslip, error)
+	// the snapshot the run took at the payroll's current revision, ErrPayslipNotFound when the user wasn't paid
+	IssuedPayslip(payroll model.Payroll, userID int64, q Querier, ctx context.Context) (model.Payslip, []model.PayslipItem, error)
+	// the approved run's payslip for the period as a PDF, locked with the employee's password when a key is configured
+	PayslipPDF(userID int64, ctx context.Context, start, end time.Time) (export.File, error)
+	// lays out an issued payslip without I/O, callers load it and audit the disclosure
+	RenderPayslip(p model.Payslip, items []model.PayslipItem, employee model.User) (export.File, error)
+	// what the employee's PDFs open with, ErrPDFUnprotected when no key is configured
+	PayslipPassword(userID int64) (string, error)
+	// signed code of an issued payslip, ErrVerificationDisabled when no key is configured
+	PayslipCode(p model.Payslip) (string, error)
+	// public check of a code, takeHome is the figure the verifier was shown and may be empty
+	VerifyPayslip(code, takeHome string, ctx context.Context) (Verification, error)
+}
+
+// satisfied by both *sql.DB and *sql.Tx
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
-type emplImplementation struct{}
+type emplImplementation struct {
+	reads   audit.ReadAuditor
+	payroll *config.Payroll
+}
 
-func NewEmplServices() Empl {
-	return &emplImplementation{}
+// a nil ReadAuditor generates payslips without READ entries, for callers that audit the read themselves
+func NewEmplServices(reads audit.ReadAuditor, payroll *config.Payroll) Empl {
+	return &emplImplementation{reads: reads, payroll: payroll}
 }
 
-// define payslip for easier payload distribution
+// JSON field names are part of the API, rename with care
 type Payslip struct {
-	UserID           int64
-	Attendance       int
-	Overtime         float64
-	TakeHomePay      float64
-	Salary           float64
-	AttendancePay    float64
-	OvertimePay      float64
-	ReimbursementPay float64
-	CreatedAt        time.Time
-	PayrollStart     time.Time
-	PayrollEnd       time.Time
+	UserID         int64                `json:"user_id"`
+	PayrollID      int64                `json:"payroll_id,omitempty"` // set once the payroll is run
+	Frozen         bool                 `json:"frozen"`               // served from the snapshot taken at the run
+	PeriodStart    time.Time            `json:"period_start"`
+	PeriodEnd      time.Time            `json:"period_end"`
+	Currency       money.Currency       `json:"currency"`
+	Rounding       money.Rounding       `json:"rounding"`
+	Attendance     AttendanceSection    `json:"attendance"`
+	Overtime       OvertimeSection      `json:"overtime"`
+	Reimbursements ReimbursementSection `json:"reimbursements"`
+	TakeHomePay    money.Money          `json:"take_home_pay"`
+	GeneratedAt    time.Time            `json:"generated_at"`
+	// frozen payslips only, anyone can check it at the public verification endpoint
+	VerificationCode string `json:"verification_code,omitempty"`
+}
+
+// salary prorated by the share of working days attended
+type AttendanceSection struct {
+	BaseSalary   money.Money `json:"base_salary"`
+	WorkingDays  int         `json:"working_days"`
+	AttendedDays int         `json:"attended_days"`
+	Dates        []time.Time `json:"dates"`
+	Proration    Formula     `json:"proration"`
+	Pay          money.Money `json:"pay"`
+}
+
+type OvertimeSection struct {
+	HourlyRate  money.Money     `json:"hourly_rate"`
+	RateFormula Formula         `json:"hourly_rate_formula"`
+	Multiplier  money.Decimal   `json:"multiplier"`
+	TotalHours  money.Decimal   `json:"total_hours"`
+	Entries     []OvertimeEntry `json:"entries"`
+	Pay         money.Money     `json:"pay"`
+}
+
+type OvertimeEntry struct {
+	OvertimeID int64         `json:"overtime_id"`
+	Date       time.Time     `json:"date"`
+	Hours      money.Decimal `json:"hours"`
+	Rate       money.Money   `json:"rate"`
+	Multiplier money.Decimal `json:"multiplier"`
+	Amount     money.Money   `json:"amount"`
+}
+
+type ReimbursementSection struct {
+	Entries []ReimbursementEntry `json:"entries"`
+	Total   money.Money          `json:"total"`
 }
 
+type ReimbursementEntry struct {
+	ReimbursementID int64       `json:"reimbursement_id"`
+	Date            time.Time   `json:"date"`
+	Description     string      `json:"description"`
+	Amount          money.Money `json:"amount"`
+}
+
+// the formula in variable names and the same formula with the payslip's numbers filled in
+type Formula struct {
+	Expression  string `json:"expression"`
+	Calculation string `json:"calculation"`
+}
+
+// run periods are served from the snapshot taken by RunPayroll, anything else is computed live
 func (e *emplImplementation) GeneratePayslip(userID int64, ctx context.Context, start, end time.Time) (Payslip, error) {
 	db, err := hlp.GetDB(ctx, app.PQ)
 	if err != nil {
 		return Payslip{}, err
 	}
 
-	// implement the private functions
-	attendance, totalWorkingDays, err := e.countAttendance(userID, db, ctx, start, end)
+	if _, err := payableRole(userID, db, ctx); err != nil {
+		return Payslip{}, err
+	}
+
+	var payroll model.Payroll
+	query := `SELECT id, status, revision FROM payroll WHERE start_period = $1 AND end_period = $2`
+	err = db.QueryRowContext(ctx, query, start, end).Scan(&payroll.ID, &payroll.Status, &payroll.Revision)
+	if err != nil && err != sql.ErrNoRows {
+		return Payslip{}, errors.New("failed to query payroll")
+	}
+
+	var snapshot model.Payslip
+	var items []model.PayslipItem
+	// issued payslips stay hidden until a second admin approved the run
+	isRun := payroll.IsRun()
+	if isRun && !payroll.IsApproved() {
+		return Payslip{}, ErrPayslipPendingApproval
+	}
+
+	frozen := isRun
+	if isRun {
+		snapshot, items, err = e.IssuedPayslip(payroll, userID, db, ctx)
+		if errors.Is(err, ErrPayslipNotFound) {
+			frozen = false
+			snapshot, items, err = e.unsnapshottedPayslip(payroll, userID, db, ctx, start, end)
+		}
+	} else {
+		snapshot, items, err = e.ComputePayslip(userID, db, ctx, start, end)
+	}
+	if err != nil {
+		return Payslip{}, err
+	}
+
+	// the payslip isn't handed out unless the disclosure is on record
+	if err := e.recordRead(userID, db, ctx, start, end); err != nil {
+		return Payslip{}, err
+	}
+
+	payslip := toPayslip(snapshot, items)
+	// a live payslip has no row for a code to point at
+	payslip.Frozen = frozen
+	if frozen && e.payroll.Verification != nil {
+		if payslip.VerificationCode, err = e.PayslipCode(snapshot); err != nil {
+			return Payslip{}, err
+		}
+	}
+
+	return payslip, nil
+}
+
+func (e *emplImplementation) ComputePayslip(userID int64, q Querier, ctx context.Context, start, end time.Time) (model.Payslip, []model.PayslipItem, error) {
+	var in payslipInputs
+	var err error
+
+	in.attendance, err = e.attendance(userID, q, ctx, start, end)
 	if err != nil {
-		return Payslip{}, errors.New("failed to count attendance")
+		return model.Payslip{}, nil, errors.New("failed to count attendance")
 	}
 
-	totalReimb, err := e.totalReimbursement(userID, db, ctx, start, end)
+	in.reimbursements, err = e.reimbursements(userID, q, ctx, start, end)
 	if err != nil {
-		return Payslip{}, errors.New("failed to get total reimbursement")
+		return model.Payslip{}, nil, errors.New("failed to get total reimbursement")
 	}
 
-	overtimeHrs, err := e.overtimeDuration(userID, db, ctx, start, end)
+	in.overtimes, err = e.overtimes(userID, q, ctx, start, end)
 	if err != nil {
-		return Payslip{}, errors.New("failed to get overtime duration")
+		return model.Payslip{}, nil, errors.New("failed to get overtime duration")
 	}
 
-	baseSalary, err := e.getUserSalary(userID, db, ctx)
+	in.salary, err = e.getUserSalary(userID, q, ctx)
 	if err != nil {
-		return Payslip{}, errors.New("failed to get user salary")
+		return model.Payslip{}, nil, errors.New("failed to get user salary")
 	}
 
-	// business logic calculation
-	hourlyRate := baseSalary / (float64(totalWorkingDays) * 8) // assume 9 to 5 is 8 hours workday
+	payslip, items := e.compute(userID, in, workingDays(start, end), start, end)
+	return payslip, items, nil
+}
+
+// everything a payslip is computed from, loaded per user or in bulk
+type payslipInputs struct {
+	salary         money.Decimal
+	attendance     []model.Attendance
+	overtimes      []model.Overtime
+	reimbursements []model.Reimbursement
+	err            error // the bulk load found nothing to pay this user from
+}
+
+// no I/O, safe to call from several goroutines
+func (e *emplImplementation) compute(userID int64, in payslipInputs, totalWorkingDays int, start, end time.Time) (model.Payslip, []model.PayslipItem) {
+	// business logic calculation, exact fractions until the rounding rules say otherwise
+	mode := e.payroll.Rounding.Mode
+	perLine := e.payroll.Rounding.Scope == money.PerLine
+	scale := e.payroll.Currency.Scale()
+	round := func(r *big.Rat) money.Decimal { return money.FromRat(r, scale, mode) }
 
-	attendancePay := baseSalary
+	salary := in.salary.Rat()
+	hourlyRate := new(big.Rat)
+	attendancePay := new(big.Rat).Set(salary)
 	if totalWorkingDays > 0 {
-		attendancePay = baseSalary * (float64(attendance) / float64(totalWorkingDays))
+		hourlyRate.Quo(salary, big.NewRat(int64(totalWorkingDays*hoursPerWorkingDay), 1))
+		attendancePay.Mul(salary, big.NewRat(int64(len(in.attendance)), int64(totalWorkingDays)))
+	}
+
+	items := make([]model.PayslipItem, 0, len(in.attendance)+len(in.overtimes)+len(in.reimbursements))
+
+	// attendance carries no amount of its own, it's the numerator of the proration
+	for _, a := range in.attendance {
+		items = append(items, model.PayslipItem{
+			Kind:     model.ATTENDANCEITEM,
+			SourceID: a.ID,
+			ItemDate: a.CreatedAt,
+		})
+	}
+
+	multiplier := big.NewRat(OvertimeMultiplier, 1)
+	overtimeHrs := new(big.Rat)
+	overtimeExact := new(big.Rat)
+	overtimeLines := money.Decimal{}
+	for _, o := range in.overtimes {
+		hours := big.NewRat(o.Interval.Nanoseconds(), int64(time.Hour))
+		amount := new(big.Rat).Mul(hourlyRate, multiplier)
+		amount.Mul(amount, hours)
+
+		overtimeHrs.Add(overtimeHrs, hours)
+		overtimeExact.Add(overtimeExact, amount)
+		overtimeLines = overtimeLines.Add(round(amount))
+
+		items = append(items, model.PayslipItem{
+			Kind:       model.OVERTIMEITEM,
+			SourceID:   o.ID,
+			ItemDate:   o.Date,
+			Hours:      money.NullDecimal{Decimal: money.FromRat(hours, quantityScale, mode), Valid: true},
+			Rate:       money.NullDecimal{Decimal: money.FromRat(hourlyRate, quantityScale, mode), Valid: true},
+			Multiplier: money.NullDecimal{Decimal: money.FromInt(OvertimeMultiplier), Valid: true},
+			Amount:     round(amount),
+		})
 	}
 
-	overtimePay := hourlyRate * 2 * overtimeHrs
+	reimbExact := new(big.Rat)
+	reimbLines := money.Decimal{}
+	for _, r := range in.reimbursements {
+		reimbExact.Add(reimbExact, r.ReimbursementAmount.Rat())
+		reimbLines = reimbLines.Add(round(r.ReimbursementAmount.Rat()))
+		items = append(items, model.PayslipItem{
+			Kind:        model.REIMBURSEMENTITEM,
+			SourceID:    r.ID,
+			ItemDate:    r.CreatedAt,
+			Description: sql.NullString{String: r.Description, Valid: r.Description != ""},
+			Amount:      round(r.ReimbursementAmount.Rat()),
+		})
+	}
 
-	takeHomePay := attendancePay + overtimePay + totalReimb
+	// per line the payslip adds up as printed, per total only the sums are rounded
+	var overtimePay, totalReimb, takeHomePay money.Decimal
+	if perLine {
+		overtimePay = overtimeLines.Rescale(scale)
+		totalReimb = reimbLines.Rescale(scale)
+		takeHomePay = round(attendancePay).Add(overtimePay).Add(totalReimb)
+	} else {
+		overtimePay = round(overtimeExact)
+		totalReimb = round(reimbExact)
+		exact := new(big.Rat).Add(attendancePay, overtimeExact)
+		takeHomePay = round(exact.Add(exact, reimbExact))
+	}
 
 	// populate payslip payload
+	payslip := model.Payslip{
+		UserID:             userID,
+		PeriodStart:        start,
+		PeriodEnd:          end,
+		Currency:           e.payroll.Currency,
+		RoundingMode:       mode,
+		RoundingScope:      e.payroll.Rounding.Scope,
+		BaseSalary:         in.salary,
+		WorkingDays:        totalWorkingDays,
+		AttendedDays:       len(in.attendance),
+		AttendancePay:      round(attendancePay),
+		HourlyRate:         money.FromRat(hourlyRate, quantityScale, mode),
+		OvertimeHours:      money.FromRat(overtimeHrs, quantityScale, mode),
+		OvertimeMultiplier: money.FromInt(OvertimeMultiplier),
+		OvertimePay:        overtimePay,
+		ReimbursementPay:   totalReimb,
+		TakeHomePay:        takeHomePay,
+		CreatedAt:          time.Now(),
+	}
+
+	return payslip, items
+}
+
+// never falls back to computing, a missing snapshot means the user wasn't paid in that run
+// only the current revision is served, earlier ones were superseded by a reopen
+func (e *emplImplementation) IssuedPayslip(payroll model.Payroll, userID int64, q Querier, ctx context.Context) (model.Payslip, []model.PayslipItem, error) {
+	var p model.Payslip
+	query := `SELECT id, payroll_id, revision, user_id, period_start, period_end, currency, rounding_mode, rounding_scope, base_salary, working_days, attended_days,
+                     attendance_pay, hourly_rate, overtime_hours, overtime_multiplier, overtime_pay, reimbursement_pay, take_home_pay, created_at, created_by
+              FROM payslip WHERE payroll_id = $1 AND revision = $2 AND user_id = $3`
+	err := q.QueryRowContext(ctx, query, payroll.ID, payroll.Revision, userID).Scan(
+		&p.ID,
+		&p.PayrollID,
+		&p.Revision,
+		&p.UserID,
+		&p.PeriodStart,
+		&p.PeriodEnd,
+		&p.Currency,
+		&p.RoundingMode,
+		&p.RoundingScope,
+		&p.BaseSalary,
+		&p.WorkingDays,
+		&p.AttendedDays,
+		&p.AttendancePay,
+		&p.HourlyRate,
+		&p.OvertimeHours,
+		&p.OvertimeMultiplier,
+		&p.OvertimePay,
+		&p.ReimbursementPay,
+		&p.TakeHomePay,
+		&p.CreatedAt,
+		&p.CreatedBy,
+	)
+	if err == sql.ErrNoRows {
+		return model.Payslip{}, nil, ErrPayslipNotFound
+	}
+	if err != nil {
+		return model.Payslip{}, nil, errors.New("failed to query payslip")
+	}
+
+	itemQuery := `SELECT id, payslip_id, kind, source_id, item_date, description, hours, rate, multiplier, amount
+                  FROM payslip_item WHERE payslip_id = $1 ORDER BY kind, item_date, id`
+	rows, err := q.QueryContext(ctx, itemQuery, p.ID)
+	if err != nil {
+		return model.Payslip{}, nil, errors.New("failed to query payslip items")
+	}
+	defer rows.Close()
+
+	var items []model.PayslipItem
+	for rows.Next() {
+		var item model.PayslipItem
+		err := rows.Scan(&item.ID, &item.PayslipID, &item.Kind, &item.SourceID, &item.ItemDate, &item.Description, &item.Hours, &item.Rate, &item.Multiplier, &item.Amount)
+		if err != nil {
+			return model.Payslip{}, nil, errors.New("failed to scan payslip item")
+		}
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return model.Payslip{}, nil, errors.New("error during payslip item iteration")
+	}
+
+	return p, items, nil
+}
+
+// runs from before payslips were snapshotted have no rows at all, those are still computed live
+// a run that wrote any payslip just didn't pay this user
+func (e *emplImplementation) unsnapshottedPayslip(payroll model.Payroll, userID int64, q Querier, ctx context.Context, start, end time.Time) (model.Payslip, []model.PayslipItem, error) {
+	var snapshotted bool
+	err := q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM payslip WHERE payroll_id = $1 AND revision = $2)`, payroll.ID, payroll.Revision).Scan(&snapshotted)
+	if err != nil {
+		return model.Payslip{}, nil, errors.New("failed to query payslips")
+	}
+	if snapshotted {
+		return model.Payslip{}, nil, ErrPayslipNotFound
+	}
+
+	p, items, err := e.ComputePayslip(userID, q, ctx, start, end)
+	if err != nil {
+		return model.Payslip{}, nil, err
+	}
+	p.PayrollID = payroll.ID
+
+	return p, items, nil
+}
+
+// lists are never null so clients can iterate without checking
+func toPayslip(p model.Payslip, items []model.PayslipItem) Payslip {
+	in := func(d money.Decimal) money.Money { return money.New(d, p.Currency) }
+
 	payslip := Payslip{
-		UserID:           userID,
-		Attendance:       attendance,
-		Overtime:         overtimeHrs,
-		TakeHomePay:      takeHomePay,
-		Salary:           baseSalary,
-		AttendancePay:    attendancePay,
-		OvertimePay:      overtimePay,
-		ReimbursementPay: totalReimb,
-		CreatedAt:        time.Now(),
-		PayrollStart:     start,
-		PayrollEnd:       end,
+		UserID:      p.UserID,
+		PayrollID:   p.PayrollID,
+		PeriodStart: p.PeriodStart,
+		PeriodEnd:   p.PeriodEnd,
+		Currency:    p.Currency,
+		Rounding:    money.Rounding{Mode: p.RoundingMode, Scope: p.RoundingScope},
+		Attendance: AttendanceSection{
+			BaseSalary:   in(p.BaseSalary),
+			WorkingDays:  p.WorkingDays,
+			AttendedDays: p.AttendedDays,
+			Dates:        []time.Time{},
+			Proration: Formula{
+				Expression:  "base_salary * attended_days / working_days",
+				Calculation: fmt.Sprintf("%s * %d / %d", p.BaseSalary, p.AttendedDays, p.WorkingDays),
+			},
+			Pay: in(p.AttendancePay),
+		},
+		Overtime: OvertimeSection{
+			HourlyRate: in(p.HourlyRate),
+			RateFormula: Formula{
+				Expression:  fmt.Sprintf("base_salary / (working_days * %d)", hoursPerWorkingDay),
+				Calculation: fmt.Sprintf("%s / (%d * %d)", p.BaseSalary, p.WorkingDays, hoursPerWorkingDay),
+			},
+			Multiplier: p.OvertimeMultiplier,
+			TotalHours: p.OvertimeHours,
+			Entries:    []OvertimeEntry{},
+			Pay:        in(p.OvertimePay),
+		},
+		Reimbursements: ReimbursementSection{
+			Entries: []ReimbursementEntry{},
+			Total:   in(p.ReimbursementPay),
+		},
+		TakeHomePay: in(p.TakeHomePay),
+		GeneratedAt: p.CreatedAt,
 	}
 
-	return payslip, nil
+	// a period without working days pays the full salary and no overtime
+	if p.WorkingDays == 0 {
+		payslip.Attendance.Proration = Formula{Expression: "base_salary", Calculation: p.BaseSalary.String()}
+		payslip.Overtime.RateFormula = Formula{Expression: "0", Calculation: "0"}
+	}
+
+	for _, item := range items {
+		switch item.Kind {
+		case model.ATTENDANCEITEM:
+			payslip.Attendance.Dates = append(payslip.Attendance.Dates, item.ItemDate)
+		case model.OVERTIMEITEM:
+			payslip.Overtime.Entries = append(payslip.Overtime.Entries, OvertimeEntry{
+				OvertimeID: item.SourceID,
+				Date:       item.ItemDate,
+				Hours:      item.Hours.Decimal,
+				Rate:       in(item.Rate.Decimal),
+				Multiplier: item.Multiplier.Decimal,
+				Amount:     in(item.Amount),
+			})
+		case model.REIMBURSEMENTITEM:
+			payslip.Reimbursements.Entries = append(payslip.Reimbursements.Entries, ReimbursementEntry{
+				ReimbursementID: item.SourceID,
+				Date:            item.ItemDate,
+				Description:     item.Description.String,
+				Amount:          in(item.Amount),
+			})
+		}
+	}
+
+	return payslip
 }
 
-func (e *emplImplementation) getUserSalary(userID int64, db *sql.DB, ctx context.Context) (salary float64, err error) {
+func (e *emplImplementation) recordRead(userID int64, db *sql.DB, ctx context.Context, start, end time.Time) error {
+	if e.reads == nil {
+		return nil
+	}
+
+	actor, _ := audit.ActorFrom(ctx)
+
+	err := e.reads.Record(db, audit.Entry{
+		EventType: audit.EventPayslipViewed,
+		RecordID:  userID,
+		NewData:   audit.PayslipRead{UserID: userID, PeriodStart: start, PeriodEnd: end},
+	}, ctx)
+	if err != nil {
+		return errors.New("failed to audit payslip read")
+	}
+
+	// service accounts and admins reading someone else's pay
+	if actor.UserID != userID {
+		err := e.reads.Record(db, audit.Entry{
+			EventType: audit.EventUserSalaryRead,
+			RecordID:  userID,
+			NewData:   audit.SalaryRead{UserID: userID},
+		}, ctx)
+		if err != nil {
+			return errors.New("failed to audit salary read")
+		}
+	}
+
+	return nil
+}
+
+func (e *emplImplementation) getUserSalary(userID int64, q Querier, ctx context.Context) (salary money.Decimal, err error) {
 	query := `SELECT salary FROM users WHERE id = $1`
-	err = db.QueryRowContext(ctx, query, userID).Scan(&salary)
+	err = q.QueryRowContext(ctx, query, userID).Scan(&salary)
 	if err == sql.ErrNoRows {
-		return 0, errors.New("user not found or salary not defined")
+		return money.Decimal{}, errors.New("user not found or salary not defined")
 	}
 	if err != nil {
-		return 0, errors.New("failed to query user salary")
+		return money.Decimal{}, errors.New("failed to query user salary")
 	}
 	return salary, nil
 }
 
-func (e *emplImplementation) countAttendance(userID int64, db *sql.DB, ctx context.Context, start, end time.Time) (attendance int, totalWorkingDays int, err error) {
-	query := `SELECT COUNT(*) FROM attendance WHERE user_id = $1 AND created_at BETWEEN $2 AND $3`
-	err = db.QueryRowContext(ctx, query, userID, start, end).Scan(&attendance)
+// calculate working days that doesn't include weekend
+func workingDays(start, end time.Time) int {
+	total := 0
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday {
+			total++
+		}
+	}
+	return total
+}
+
+func (e *emplImplementation) attendance(userID int64, q Querier, ctx context.Context, start, end time.Time) ([]model.Attendance, error) {
+	query := `SELECT id, created_at FROM attendance WHERE user_id = $1 AND created_at BETWEEN $2 AND $3 ORDER BY created_at`
+	rows, err := q.QueryContext(ctx, query, userID, start, end)
 	if err != nil {
-		return 0, 0, errors.New("failed to count attendance")
+		return nil, errors.New("failed to query attendance")
 	}
+	defer rows.Close()
 
-	// calculate working days that doesn't include weekend
-	totalWorkingDays = 0
-	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
-		if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday {
-			totalWorkingDays++
+	var attendance []model.Attendance
+	for rows.Next() {
+		var a model.Attendance
+		if err := rows.Scan(&a.ID, &a.CreatedAt); err != nil {
+			return nil, errors.New("failed to scan attendance")
 		}
+		attendance = append(attendance, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("error during attendance iteration")
 	}
 
-	return attendance, totalWorkingDays, nil
+	return attendance, nil
 }
 
-func (e *emplImplementation) totalReimbursement(userID int64, db *sql.DB, ctx context.Context, start, end time.Time) (total float64, err error) {
-	query := `SELECT COALESCE(SUM(reimbursement_amount), 0) FROM reimbursement WHERE user_id = $1 AND created_at BETWEEN $2 AND $3`
-	err = db.QueryRowContext(ctx, query, userID, start, end).Scan(&total)
+func (e *emplImplementation) reimbursements(userID int64, q Querier, ctx context.Context, start, end time.Time) ([]model.Reimbursement, error) {
+	query := `SELECT id, reimbursement_amount, COALESCE(description, ''), created_at FROM reimbursement WHERE user_id = $1 AND created_at BETWEEN $2 AND $3 ORDER BY created_at`
+	rows, err := q.QueryContext(ctx, query, userID, start, end)
 	if err != nil {
-		return 0, errors.New("failed to sum reimbursements")
+		return nil, errors.New("failed to query reimbursements")
 	}
-	return total, nil
+	defer rows.Close()
+
+	var reimbursements []model.Reimbursement
+	for rows.Next() {
+		var r model.Reimbursement
+		if err := rows.Scan(&r.ID, &r.ReimbursementAmount, &r.Description, &r.CreatedAt); err != nil {
+			return nil, errors.New("failed to scan reimbursement")
+		}
+		reimbursements = append(reimbursements, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("error during reimbursement iteration")
+	}
+
+	return reimbursements, nil
 }
 
-func (e *emplImplementation) overtimeDuration(userID int64, db *sql.DB, ctx context.Context, start, end time.Time) (totalHours float64, err error) {
-	query := `SELECT COALESCE(SUM(overtime_duration), 0) FROM overtime WHERE employee_id = $1 AND created_at BETWEEN $2 AND $3`
-	err = db.QueryRowContext(ctx, query, userID, start, end).Scan(&totalHours)
+func (e *emplImplementation) overtimes(userID int64, q Querier, ctx context.Context, start, end time.Time) ([]model.Overtime, error) {
+	query := `SELECT id, EXTRACT(EPOCH FROM overtime_duration), overtime_date FROM overtime WHERE user_id = $1 AND created_at BETWEEN $2 AND $3 ORDER BY overtime_date`
+	rows, err := q.QueryContext(ctx, query, userID, start, end)
 	if err != nil {
-		return 0, errors.New("failed to sum overtime duration")
+		return nil, errors.New("failed to query overtime")
 	}
-	return totalHours, nil
+	defer rows.Close()
+
+	var overtimes []model.Overtime
+	for rows.Next() {
+		var o model.Overtime
+		var seconds money.Decimal
+		if err := rows.Scan(&o.ID, &seconds, &o.Date); err != nil {
+			return nil, errors.New("failed to scan overtime")
+		}
+		interval, ok := overtimeInterval(seconds)
+		if !ok {
+			return nil, errors.New("failed to scan overtime")
+		}
+		o.Interval = interval
+		overtimes = append(overtimes, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("error during overtime iteration")
+	}
+
+	return overtimes, nil
+}
+
+// postgres keeps microseconds, so the nanoseconds are always whole
+func overtimeInterval(seconds money.Decimal) (time.Duration, bool) {
+	nanos, ok := seconds.MulInt(int64(time.Second)).Int64()
+	return time.Duration(nanos), ok
 }
@@ -0,0 +1,93 @@
+package empl
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/internal/config"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
+)
+
+func TestComputeRounding(t *testing.T) {
+	// a third of the salary is earned, three one hour overtimes at 2 x salary / 24 each
+	thirds := payslipInputs{
+		salary:     money.MustParse("1000"),
+		attendance: []model.Attendance{{ID: 1, CreatedAt: benchStart}},
+		overtimes: []model.Overtime{
+			{ID: 2, Interval: time.Hour, Date: benchStart},
+			{ID: 3, Interval: time.Hour, Date: benchStart},
+			{ID: 4, Interval: time.Hour, Date: benchStart},
+		},
+		reimbursements: []model.Reimbursement{
+			{ID: 5, ReimbursementAmount: money.MustParse("10.50"), CreatedAt: benchStart},
+			{ID: 6, ReimbursementAmount: money.MustParse("11.50"), CreatedAt: benchStart},
+		},
+	}
+	// half of 1000.01 is a tie at the cent
+	tie := payslipInputs{
+		salary:     money.MustParse("1000.01"),
+		attendance: []model.Attendance{{ID: 1, CreatedAt: benchStart}},
+	}
+
+	tests := []struct {
+		name       string
+		currency   money.Currency
+		rounding   money.Rounding
+		in         payslipInputs
+		days       int
+		attendance string
+		overtime   string
+		reimb      string
+		takeHome   string
+		lines      []string // overtime then reimbursement item amounts
+	}{
+		{"usd half even per line", "USD", money.Rounding{Mode: money.HalfEven, Scope: money.PerLine}, thirds, 3,
+			"333.33", "249.99", "22.00", "605.32", []string{"83.33", "83.33", "83.33", "10.50", "11.50"}},
+		{"usd half even per total", "USD", money.Rounding{Mode: money.HalfEven, Scope: money.PerTotal}, thirds, 3,
+			"333.33", "250.00", "22.00", "605.33", []string{"83.33", "83.33", "83.33", "10.50", "11.50"}},
+		{"jpy half even per line", "JPY", money.Rounding{Mode: money.HalfEven, Scope: money.PerLine}, thirds, 3,
+			"333", "249", "22", "604", []string{"83", "83", "83", "10", "12"}},
+		{"jpy half up per line", "JPY", money.Rounding{Mode: money.HalfUp, Scope: money.PerLine}, thirds, 3,
+			"333", "249", "23", "605", []string{"83", "83", "83", "11", "12"}},
+		{"jpy half even per total", "JPY", money.Rounding{Mode: money.HalfEven, Scope: money.PerTotal}, thirds, 3,
+			"333", "250", "22", "605", []string{"83", "83", "83", "10", "12"}},
+		{"jpy half up per total", "JPY", money.Rounding{Mode: money.HalfUp, Scope: money.PerTotal}, thirds, 3,
+			"333", "250", "22", "605", []string{"83", "83", "83", "11", "12"}},
+		{"tie half even", "USD", money.Rounding{Mode: money.HalfEven, Scope: money.PerLine}, tie, 2,
+			"500.00", "0.00", "0.00", "500.00", nil},
+		{"tie half up", "USD", money.Rounding{Mode: money.HalfUp, Scope: money.PerLine}, tie, 2,
+			"500.01", "0.00", "0.00", "500.01", nil},
+		{"tie half up per total", "USD", money.Rounding{Mode: money.HalfUp, Scope: money.PerTotal}, tie, 2,
+			"500.01", "0.00", "0.00", "500.01", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &emplImplementation{payroll: &config.Payroll{Currency: tt.currency, Rounding: tt.rounding}}
+			p, items := e.compute(7, tt.in, tt.days, benchStart, benchEnd)
+
+			for _, c := range []struct{ field, got, want string }{
+				{"attendance pay", p.AttendancePay.String(), tt.attendance},
+				{"overtime pay", p.OvertimePay.String(), tt.overtime},
+				{"reimbursement pay", p.ReimbursementPay.String(), tt.reimb},
+				{"take home pay", p.TakeHomePay.String(), tt.takeHome},
+			} {
+				if c.got != c.want {
+					t.Errorf("%s = %s, want %s", c.field, c.got, c.want)
+				}
+			}
+
+			var lines []string
+			for _, item := range items {
+				if item.Kind != model.ATTENDANCEITEM {
+					lines = append(lines, item.Amount.String())
+				}
+			}
+			if !slices.Equal(lines, tt.lines) {
+				t.Errorf("item amounts = %v, want %v", lines, tt.lines)
+			}
+		})
+	}
+}
@@ -0,0 +1,462 @@
+package empl
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/hlp"
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/config"
+	"github.com/achsanalfitra/gopayslip/internal/export"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
+	"github.com/achsanalfitra/gopayslip/internal/pdf"
+	"github.com/achsanalfitra/gopayslip/internal/qr"
+)
+
+var (
+	ErrPayslipNotIssued = errors.New("only payslips of a run payroll can be printed")
+	ErrPDFUnprotected   = errors.New("payslip PDFs are not password protected")
+)
+
+const pdfContentType = "application/pdf"
+
+// the approved run's payslip for the period, live computations are never printed
+func (e *emplImplementation) PayslipPDF(userID int64, ctx context.Context, start, end time.Time) (export.File, error) {
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return export.File{}, err
+	}
+
+	if _, err := payableRole(userID, db, ctx); err != nil {
+		return export.File{}, err
+	}
+
+	var payroll model.Payroll
+	query := `SELECT id, status, revision FROM payroll WHERE start_period = $1 AND end_period = $2`
+	err = db.QueryRowContext(ctx, query, start, end).Scan(&payroll.ID, &payroll.Status, &payroll.Revision)
+	if err == sql.ErrNoRows {
+		return export.File{}, ErrPayslipNotIssued
+	}
+	if err != nil {
+		return export.File{}, errors.New("failed to query payroll")
+	}
+
+	if !payroll.IsRun() {
+		return export.File{}, ErrPayslipNotIssued
+	}
+	if !payroll.IsApproved() {
+		return export.File{}, ErrPayslipPendingApproval
+	}
+
+	payslip, items, err := e.IssuedPayslip(payroll, userID, db, ctx)
+	if err != nil {
+		return export.File{}, err
+	}
+
+	employee, err := e.employee(userID, db, ctx)
+	if err != nil {
+		return export.File{}, err
+	}
+
+	file, err := e.RenderPayslip(payslip, items, employee)
+	if err != nil {
+		return export.File{}, err
+	}
+
+	if err := e.recordPrint(payslip, file, db, ctx); err != nil {
+		return export.File{}, err
+	}
+
+	return file, nil
+}
+
+// HMAC of the user id under the configured key, so nothing is stored and a new key replaces every password
+func (e *emplImplementation) PayslipPassword(userID int64) (string, error) {
+	key := e.payroll.PDF.PasswordKey
+	if key == nil {
+		return "", ErrPDFUnprotected
+	}
+
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "payslip-pdf:%d", userID)
+	// 80 bits in four groups, readable enough to type from a letter
+	return groupCode(codeEncoding.EncodeToString(mac.Sum(nil)[:10])), nil
+}
+
+func (e *emplImplementation) RenderPayslip(p model.Payslip, items []model.PayslipItem, employee model.User) (export.File, error) {
+	tmpl := e.payroll.PDF
+	l := &payslipLayout{doc: pdf.New(), tmpl: tmpl}
+	l.newPage()
+
+	l.header()
+	l.details(p, employee)
+
+	payslip := toPayslip(p, items)
+	l.attendance(payslip.Attendance)
+	l.overtime(payslip.Overtime)
+	l.reimbursements(payslip.Reimbursements)
+	l.takeHome(payslip.TakeHomePay)
+
+	if v := e.payroll.Verification; v != nil {
+		code, err := e.PayslipCode(p)
+		if err != nil {
+			return export.File{}, err
+		}
+		if err := l.verification(code, v.URL); err != nil {
+			return export.File{}, err
+		}
+	}
+
+	l.footers()
+
+	var enc *pdf.Encryption
+	if tmpl.PasswordKey != nil {
+		password, err := e.PayslipPassword(p.UserID)
+		if err != nil {
+			return export.File{}, err
+		}
+		enc = &pdf.Encryption{UserPassword: password}
+	}
+
+	data, err := l.doc.Bytes(enc)
+	if err != nil {
+		return export.File{}, errors.New("failed to render payslip PDF")
+	}
+
+	return export.NewFile(fmt.Sprintf("payroll-%d-r%d-payslip-%d.pdf", p.PayrollID, p.Revision, p.UserID), pdfContentType, data), nil
+}
+
+func (e *emplImplementation) employee(userID int64, q Querier, ctx context.Context) (model.User, error) {
+	u := model.User{ID: userID}
+	err := q.QueryRowContext(ctx, `SELECT username, department FROM users WHERE id = $1`, userID).Scan(&u.Username, &u.Department)
+	if err == sql.ErrNoRows {
+		return model.User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return model.User{}, errors.New("failed to query user")
+	}
+	return u, nil
+}
+
+// the PDF isn't handed out unless the disclosure is on record
+func (e *emplImplementation) recordPrint(p model.Payslip, file export.File, db *sql.DB, ctx context.Context) error {
+	if e.reads == nil {
+		return nil
+	}
+
+	err := e.reads.Record(db, audit.Entry{
+		EventType: audit.EventPayslipPrinted,
+		RecordID:  p.UserID,
+		NewData: audit.PrintRead{
+			PayrollID: p.PayrollID,
+			Revision:  p.Revision,
+			UserID:    p.UserID,
+			Protected: e.payroll.PDF.PasswordKey != nil,
+			Checksum:  file.SHA256,
+		},
+	}, ctx)
+	if err != nil {
+		return errors.New("failed to audit payslip print")
+	}
+
+	return nil
+}
+
+// A4 with the content between the margins, the footer sits below pdfBottom
+const (
+	pdfMargin = 50
+	pdfRight  = pdf.PageWidth - pdfMargin
+	pdfBottom = 60
+	pdfLine   = 14
+)
+
+type payslipLayout struct {
+	doc   *pdf.Document
+	pages []*pdf.Page
+	page  *pdf.Page
+	y     float64
+	tmpl  *config.PayslipPDF
+}
+
+func (l *payslipLayout) newPage() {
+	l.page = l.doc.AddPage()
+	l.pages = append(l.pages, l.page)
+	l.y = pdf.PageHeight - pdfMargin
+}
+
+// starts a new page unless h more points fit
+func (l *payslipLayout) need(h float64) {
+	if l.y-h < pdfBottom {
+		l.newPage()
+	}
+}
+
+func (l *payslipLayout) label(key string) string {
+	return l.tmpl.Labels[key]
+}
+
+func (l *payslipLayout) header() {
+	top := l.y
+	x := float64(pdfMargin)
+	bottom := top - 24
+
+	// the logo keeps its aspect ratio inside 140 by 48 points
+	if logo := l.tmpl.Logo; logo != nil {
+		h := 48.0
+		w := h * float64(logo.Width) / float64(logo.Height)
+		if w > 140 {
+			w = 140
+			h = w * float64(logo.Height) / float64(logo.Width)
+		}
+		l.page.Image(logo, x, top-h, w, h)
+		x += w + 12
+		bottom = min(bottom, top-h)
+	}
+
+	y := top - 12
+	if l.tmpl.CompanyName != "" {
+		l.page.Text(x, y, 13, pdf.Bold, l.tmpl.CompanyName)
+		y -= 14
+	}
+	for _, line := range l.tmpl.Address {
+		l.page.Text(x, y, 9, pdf.Regular, line)
+		y -= 11
+	}
+	bottom = min(bottom, y)
+
+	l.page.TextRight(pdfRight, top-16, 18, pdf.Bold, l.label("title"))
+
+	l.y = bottom - 6
+	l.page.Line(pdfMargin, l.y, pdfRight, l.y, 1, 0)
+	l.y -= 20
+}
+
+func (l *payslipLayout) details(p model.Payslip, employee model.User) {
+	const right = 320
+
+	field := func(x, y float64, key, value string) {
+		l.page.Text(x, y, 9, pdf.Regular, l.label(key))
+		l.page.Text(x+80, y, 9, pdf.Bold, value)
+	}
+
+	y := l.y
+	field(pdfMargin, y, "employee", fmt.Sprintf("%s (#%d)", employee.Username, employee.ID))
+	field(right, y, "period", p.PeriodStart.Format(time.DateOnly)+" - "+p.PeriodEnd.Format(time.DateOnly))
+	y -= pdfLine
+	if employee.Department != "" {
+		field(pdfMargin, y, "department", employee.Department)
+	}
+	field(right, y, "payroll", fmt.Sprintf("#%d r%d", p.PayrollID, p.Revision))
+	y -= pdfLine
+	field(right, y, "issued", p.CreatedAt.Format(time.DateOnly))
+
+	l.y = y - 10
+}
+
+func (l *payslipLayout) section(key string) {
+	l.need(3 * pdfLine)
+	l.y -= 8
+	l.page.Text(pdfMargin, l.y, 11, pdf.Bold, l.label(key))
+	l.y -= 5
+	l.page.Line(pdfMargin, l.y, pdfRight, l.y, 0.5, 0.6)
+	l.y -= pdfLine
+}
+
+func (l *payslipLayout) row(key, value string, font pdf.Font) {
+	l.need(pdfLine)
+	l.page.Text(pdfMargin, l.y, 10, font, l.label(key))
+	l.page.TextRight(pdfRight, l.y, 10, font, value)
+	l.y -= pdfLine
+}
+
+// cells are left aligned at x or, with a right edge, right aligned against it
+type tableColumn struct {
+	x     float64
+	right bool
+}
+
+func (l *payslipLayout) tableRow(cols []tableColumn, font pdf.Font, cells ...string) {
+	l.need(pdfLine)
+	for i, c := range cols {
+		if c.right {
+			l.page.TextRight(c.x, l.y, 9, font, cells[i])
+		} else {
+			l.page.Text(c.x, l.y, 9, font, cells[i])
+		}
+	}
+	l.y -= pdfLine - 2
+}
+
+func (l *payslipLayout) none() {
+	l.need(pdfLine)
+	l.page.Text(pdfMargin, l.y, 9, pdf.Regular, l.label("none"))
+	l.y -= pdfLine
+}
+
+func (l *payslipLayout) attendance(a AttendanceSection) {
+	l.section("attendance")
+	l.row("base_salary", l.money(a.BaseSalary), pdf.Regular)
+	l.row("working_days", strconv.Itoa(a.WorkingDays), pdf.Regular)
+	l.row("attended_days", strconv.Itoa(a.AttendedDays), pdf.Regular)
+	l.row("attendance_pay", l.money(a.Pay), pdf.Bold)
+}
+
+func (l *payslipLayout) overtime(o OvertimeSection) {
+	l.section("overtime")
+	l.row("hourly_rate", l.money(o.HourlyRate), pdf.Regular)
+
+	if len(o.Entries) == 0 {
+		l.none()
+	} else {
+		cols := []tableColumn{{x: pdfMargin}, {x: 260, right: true}, {x: 370, right: true}, {x: 440, right: true}, {x: pdfRight, right: true}}
+		l.tableRow(cols, pdf.Bold, l.label("date"), l.label("hours"), l.label("rate"), l.label("multiplier"), l.label("amount"))
+		for _, entry := range o.Entries {
+			l.tableRow(cols, pdf.Regular, entry.Date.Format(time.DateOnly), l.number(entry.Hours), l.money(entry.Rate), l.number(entry.Multiplier), l.money(entry.Amount))
+		}
+		l.y -= 2
+	}
+
+	l.row("overtime_pay", l.money(o.Pay), pdf.Bold)
+}
+
+func (l *payslipLayout) reimbursements(r ReimbursementSection) {
+	l.section("reimbursements")
+
+	if len(r.Entries) == 0 {
+		l.none()
+	} else {
+		const description = pdfMargin + 80
+		cols := []tableColumn{{x: pdfMargin}, {x: description}, {x: pdfRight, right: true}}
+		l.tableRow(cols, pdf.Bold, l.label("date"), l.label("description"), l.label("amount"))
+		for _, entry := range r.Entries {
+			amount := l.money(entry.Amount)
+			width := pdfRight - description - pdf.TextWidth(amount, 9, pdf.Regular) - 12
+			l.tableRow(cols, pdf.Regular, entry.Date.Format(time.DateOnly), truncate(entry.Description, width, 9), amount)
+		}
+		l.y -= 2
+	}
+
+	l.row("reimbursement_pay", l.money(r.Total), pdf.Bold)
+}
+
+func (l *payslipLayout) takeHome(m money.Money) {
+	l.need(3 * pdfLine)
+	l.y -= 6
+	l.page.Line(pdfMargin, l.y, pdfRight, l.y, 1, 0)
+	l.y -= 18
+	l.page.Text(pdfMargin, l.y, 12, pdf.Bold, l.label("take_home_pay"))
+	l.page.TextRight(pdfRight, l.y, 12, pdf.Bold, l.money(m))
+	l.y -= pdfLine
+}
+
+// the code in a QR symbol, pointing at the verification URL when one is configured
+func (l *payslipLayout) verification(code, url string) error {
+	content := code
+	if url != "" {
+		sep := "?"
+		if strings.Contains(url, "?") {
+			sep = "&"
+		}
+		content = url + sep + "code=" + code
+	}
+
+	symbol, err := qr.Encode([]byte(content))
+	if err != nil {
+		return err
+	}
+
+	// four modules of quiet zone around the symbol
+	const side = 84.0
+	module := side / float64(symbol.Size+8)
+
+	l.need(side + 20)
+	l.y -= 12
+	top := l.y
+	x0, y0 := float64(pdfMargin)+4*module, top-side+4*module
+	for y := range symbol.Size {
+		// one rectangle per run of dark modules
+		for x := 0; x < symbol.Size; x++ {
+			if !symbol.Dark(x, y) {
+				continue
+			}
+			run := 1
+			for x+run < symbol.Size && symbol.Dark(x+run, y) {
+				run++
+			}
+			l.page.Rect(x0+float64(x)*module, y0+float64(symbol.Size-1-y)*module, float64(run)*module, module)
+			x += run - 1
+		}
+	}
+
+	text := float64(pdfMargin) + side + 10
+	l.page.Text(text, top-24, 9, pdf.Regular, l.label("verification"))
+	l.page.Text(text, top-40, 12, pdf.Bold, code)
+	if url != "" {
+		l.page.Text(text, top-56, 8, pdf.Regular, truncate(url, pdfRight-text, 8))
+	}
+
+	l.y = top - side - 8
+	return nil
+}
+
+// page numbers are only known once everything is laid out
+func (l *payslipLayout) footers() {
+	for i, page := range l.pages {
+		page.Line(pdfMargin, pdfBottom-15, pdfRight, pdfBottom-15, 0.5, 0.6)
+		if l.tmpl.CompanyName != "" {
+			page.Text(pdfMargin, pdfBottom-27, 8, pdf.Regular, l.tmpl.CompanyName)
+		}
+		page.TextRight(pdfRight, pdfBottom-27, 8, pdf.Regular, fmt.Sprintf("%s %d / %d", l.label("page"), i+1, len(l.pages)))
+	}
+}
+
+func (l *payslipLayout) money(m money.Money) string {
+	return string(m.Currency) + " " + l.number(m.Amount)
+}
+
+// the stored digits with the locale's separators, nothing is rounded for display
+func (l *payslipLayout) number(d money.Decimal) string {
+	s := d.String()
+
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	whole, frac, hasFrac := strings.Cut(s, ".")
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, c := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(l.tmpl.ThousandsSeparator)
+		}
+		b.WriteRune(c)
+	}
+	if hasFrac {
+		b.WriteString(l.tmpl.DecimalSeparator)
+		b.WriteString(frac)
+	}
+
+	return b.String()
+}
+
+// cuts s to fit width, marking the cut with an ellipsis
+func truncate(s string, width, size float64) string {
+	if pdf.TextWidth(s, size, pdf.Regular) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && pdf.TextWidth(string(runes)+"...", size, pdf.Regular) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
@@ -8,52 +8,66 @@ import (
 
 	"github.com/achsanalfitra/gopayslip/hlp"
 	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
 	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
 	"github.com/google/uuid"
 )
 
+var (
+	ErrInputsFrozen = errors.New("the payroll period is locked, its inputs can't change")
+	ErrNotEmployee  = errors.New("only employees and admins are paid")
+)
+
 type User interface {
-	CheckIn(userID int64, requestID uuid.UUID, ctx context.Context) error
-	ProposeOvertime(userID int64, requestID uuid.UUID, overtimeDuration time.Duration, overtimeDate time.Time, ctx context.Context) error
-	ProposeReimbursement(userID int64, requestID uuid.UUID, amount float64, desc string, ctx context.Context) error
+	CheckIn(userID, actorID int64, requestID uuid.UUID, ctx context.Context) error
+	ProposeOvertime(userID, actorID int64, requestID uuid.UUID, overtimeDuration time.Duration, overtimeDate time.Time, ctx context.Context) error
+	ProposeReimbursement(userID, actorID int64, requestID uuid.UUID, amount money.Decimal, desc string, ctx context.Context) error
+}
+
+type userImplementation struct {
+	auditor audit.Writer
+}
+
+func NewUserServices(auditor audit.Writer) User {
+	return &userImplementation{auditor: auditor}
 }
 
-func CheckIn(userID int64, requestID uuid.UUID, ctx context.Context) error {
+// actorID differs from userID when an integration checks in on behalf of the employee
+func (u *userImplementation) CheckIn(userID, actorID int64, requestID uuid.UUID, ctx context.Context) error {
 	db, err := hlp.GetDB(ctx, app.PQ)
 	if err != nil {
 		return err
 	}
 
-	// scan for user role from user id
-	var userRole string
-	query := `SELECT role FROM users WHERE id = $1`
-	err = db.QueryRowContext(ctx, query, userID).Scan(&userRole)
-	if err == sql.ErrNoRows {
-		return errors.New("user not found for check-in")
-	}
+	assertedRole, err := payableRole(userID, db, ctx)
 	if err != nil {
-		return errors.New("user query error while check-in")
-	}
-
-	var assertedRole model.Role
-	if userRole != "employee" {
-		assertedRole = model.ADMIN
-	} else {
-		assertedRole = model.EMPLOYEE
+		return err
 	}
 
 	attendanceRecord := model.Attendance{
 		UserID:    userID,
-		CreatedBy: userID,
-		UpdatedBy: userID,
+		CreatedBy: actorID,
+		UpdatedBy: actorID,
 		RequestId: requestID,
 		UserRole:  assertedRole,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
-	insertQuery := `INSERT INTO attendance (user_id, created_by, updated_by, request_id, user_role, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`
-	_, err = db.ExecContext(ctx, insertQuery,
+	// the record and its audit entry are committed together
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	if err := inputsOpen(tx, attendanceRecord.CreatedAt, ctx); err != nil {
+		return err
+	}
+
+	insertQuery := `INSERT INTO attendance (user_id, created_by, updated_by, request_id, role, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	err = tx.QueryRowContext(ctx, insertQuery,
 		attendanceRecord.UserID,
 		attendanceRecord.CreatedBy,
 		attendanceRecord.UpdatedBy,
@@ -61,15 +75,30 @@ func CheckIn(userID int64, requestID uuid.UUID, ctx context.Context) error {
 		attendanceRecord.UserRole,
 		attendanceRecord.CreatedAt,
 		attendanceRecord.UpdatedAt,
-	)
+	).Scan(&attendanceRecord.ID)
 	if err != nil {
 		return errors.New("failed to insert attendance record")
 	}
 
+	err = u.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventAttendanceCheckedIn,
+		RecordID:  attendanceRecord.ID,
+		NewData:   attendanceRecord,
+		ActorID:   actorID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("commit failed")
+	}
+
 	return nil
 }
 
-func ProposeOvertime(userID int64, requestID uuid.UUID, overtimeDuration time.Duration, overtimeDate time.Time, ctx context.Context) error {
+// actorID differs from userID when an integration proposes on behalf of the employee
+func (u *userImplementation) ProposeOvertime(userID, actorID int64, requestID uuid.UUID, overtimeDuration time.Duration, overtimeDate time.Time, ctx context.Context) error {
 	// early exit when overtme duration > 3 hours
 	if overtimeDuration > 3*time.Hour {
 		return errors.New("maximum overtime is 3 hours")
@@ -80,9 +109,13 @@ func ProposeOvertime(userID int64, requestID uuid.UUID, overtimeDuration time.Du
 		return err
 	}
 
+	if _, err := payableRole(userID, db, ctx); err != nil {
+		return err
+	}
+
 	// validate after work
 	var latestAttendanceDate time.Time
-	query := `SELECT overtime_date FROM attendance WHERE user_id = $1 ORDER BY created_date DESC LIMIT 1`
+	query := `SELECT created_at FROM attendance WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1`
 	err = db.QueryRowContext(ctx, query, userID).Scan(&latestAttendanceDate)
 	if err == sql.ErrNoRows {
 		return errors.New("no attendance record found for user, cannot propose overtime")
@@ -99,8 +132,8 @@ func ProposeOvertime(userID int64, requestID uuid.UUID, overtimeDuration time.Du
 
 	// if overtime for that day already exists, prevent another overtime
 	var existingOvertimeID int64
-	queryExistingOvertime := `SELECT id FROM overtimes WHERE employee_id = $1 AND date = $2`
-	err = db.QueryRowContext(ctx, queryExistingOvertime, userID, time.Now().Truncate(24*time.Hour)).Scan(&existingOvertimeID)
+	queryExistingOvertime := `SELECT id FROM overtime WHERE user_id = $1 AND overtime_date::date = $2::date`
+	err = db.QueryRowContext(ctx, queryExistingOvertime, userID, overtimeDate).Scan(&existingOvertimeID)
 	if err != nil && err != sql.ErrNoRows {
 		return errors.New("failed to check existing overtime")
 	}
@@ -111,8 +144,8 @@ func ProposeOvertime(userID int64, requestID uuid.UUID, overtimeDuration time.Du
 	// post the overtime payload
 	overtimePayload := model.Overtime{
 		UserID:    userID,
-		CreatedBy: userID,
-		UpdatedBy: userID,
+		CreatedBy: actorID,
+		UpdatedBy: actorID,
 		RequestId: requestID,
 		Interval:  overtimeDuration,
 		Date:      overtimeDate,
@@ -120,41 +153,75 @@ func ProposeOvertime(userID int64, requestID uuid.UUID, overtimeDuration time.Du
 		UpdatedAt: time.Now(),
 	}
 
-	insertQuery := `INSERT INTO overtimes (user_id, created_by, updated_by, request_id, overtime_duration, overtime_date, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
-	_, err = db.ExecContext(ctx, insertQuery,
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	if err := inputsOpen(tx, overtimePayload.CreatedAt, ctx); err != nil {
+		return err
+	}
+
+	insertQuery := `INSERT INTO overtime (user_id, created_by, updated_by, request_id, overtime_duration, overtime_date, created_at, updated_at) VALUES ($1, $2, $3, $4, make_interval(secs => $5), $6, $7, $8) RETURNING id`
+	err = tx.QueryRowContext(ctx, insertQuery,
 		overtimePayload.UserID,
 		overtimePayload.CreatedBy,
 		overtimePayload.UpdatedBy,
 		overtimePayload.RequestId,
-		overtimePayload.Interval,
+		overtimePayload.Interval.Seconds(),
 		overtimePayload.Date,
 		overtimePayload.CreatedAt,
 		overtimePayload.UpdatedAt,
-	)
+	).Scan(&overtimePayload.ID)
 
 	if err != nil {
 		return errors.New("failed to insert overtime record")
 	}
 
+	err = u.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventOvertimeProposed,
+		RecordID:  overtimePayload.ID,
+		NewData:   overtimePayload,
+		ActorID:   actorID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("commit failed")
+	}
+
 	return nil
 }
 
-func ProposeReimbursement(userID int64, requestID uuid.UUID, amount float64, desc string, ctx context.Context) error {
+// actorID differs from userID when an integration proposes on behalf of the employee
+func (u *userImplementation) ProposeReimbursement(userID, actorID int64, requestID uuid.UUID, amount money.Decimal, desc string, ctx context.Context) error {
 	// invalidate minus amount, fail fast
-	if amount <= 0 {
+	if amount.Sign() <= 0 {
 		return errors.New("reimbursement can't be smaller than 0")
 	}
 
+	// the column would round it silently
+	if !amount.FitsScale(money.StorageScale) {
+		return errors.New("reimbursement can't have more than 2 decimals")
+	}
+
 	db, err := hlp.GetDB(ctx, app.PQ)
 	if err != nil {
 		return err
 	}
 
+	if _, err := payableRole(userID, db, ctx); err != nil {
+		return err
+	}
+
 	// post the whole payload immediately
 	reimbursementPayload := model.Reimbursement{
 		UserID:              userID,
-		CreatedBy:           userID,
-		UpdatedBy:           userID,
+		CreatedBy:           actorID,
+		UpdatedBy:           actorID,
 		ReimbursementAmount: amount,
 		RequestId:           requestID,
 		Description:         desc,
@@ -162,8 +229,18 @@ func ProposeReimbursement(userID int64, requestID uuid.UUID, amount float64, des
 		UpdatedAt:           time.Now(),
 	}
 
-	insertQuery := `INSERT INTO reimbursements (user_id, created_by, updated_by, amount, request_id, description, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
-	_, err = db.ExecContext(ctx, insertQuery,
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.New("failed starting the transaction")
+	}
+	defer tx.Rollback()
+
+	if err := inputsOpen(tx, reimbursementPayload.CreatedAt, ctx); err != nil {
+		return err
+	}
+
+	insertQuery := `INSERT INTO reimbursement (user_id, created_by, updated_by, reimbursement_amount, request_id, description, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+	err = tx.QueryRowContext(ctx, insertQuery,
 		reimbursementPayload.UserID,
 		reimbursementPayload.CreatedBy,
 		reimbursementPayload.UpdatedBy,
@@ -172,10 +249,61 @@ func ProposeReimbursement(userID int64, requestID uuid.UUID, amount float64, des
 		reimbursementPayload.Description,
 		reimbursementPayload.CreatedAt,
 		reimbursementPayload.UpdatedAt,
-	)
+	).Scan(&reimbursementPayload.ID)
 	if err != nil {
 		return errors.New("failed to insert reimbursement record")
 	}
 
+	err = u.auditor.Write(ctx, tx, audit.Entry{
+		EventType: audit.EventReimbursementProposed,
+		RecordID:  reimbursementPayload.ID,
+		NewData:   reimbursementPayload,
+		ActorID:   actorID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("commit failed")
+	}
+
+	return nil
+}
+
+// service accounts are never paid, anything recorded against one would be lost
+func payableRole(userID int64, q Querier, ctx context.Context) (model.Role, error) {
+	var role model.Role
+	err := q.QueryRowContext(ctx, `SELECT role FROM users WHERE id = $1`, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", errors.New("failed to query user role")
+	}
+
+	if role != model.ADMIN && role != model.EMPLOYEE {
+		return "", ErrNotEmployee
+	}
+
+	return role, nil
+}
+
+// inputs count towards the period their created_at falls in, the share lock waits out a concurrent lock of that payroll
+func inputsOpen(tx *sql.Tx, at time.Time, ctx context.Context) error {
+	var payroll model.Payroll
+	query := `SELECT status FROM payroll WHERE $1 BETWEEN start_period AND end_period ORDER BY id DESC LIMIT 1 FOR SHARE`
+	err := tx.QueryRowContext(ctx, query, at).Scan(&payroll.Status)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return errors.New("failed to query payroll status")
+	}
+
+	if payroll.InputsFrozen() {
+		return ErrInputsFrozen
+	}
+
 	return nil
 }
@@ -0,0 +1,214 @@
+package empl
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/achsanalfitra/gopayslip/hlp"
+	"github.com/achsanalfitra/gopayslip/internal/app"
+	"github.com/achsanalfitra/gopayslip/internal/audit"
+	"github.com/achsanalfitra/gopayslip/internal/config"
+	"github.com/achsanalfitra/gopayslip/internal/model"
+	"github.com/achsanalfitra/gopayslip/internal/money"
+)
+
+var (
+	ErrVerificationDisabled = errors.New("payslip verification is not configured")
+	// unknown, forged, tampered and unapproved payslips all look the same to the caller
+	ErrInvalidCode     = errors.New("the code does not belong to an issued payslip")
+	ErrInvalidTakeHome = errors.New("take_home must be a decimal amount")
+)
+
+// bytes of HMAC-SHA256 kept in a code, 80 bits can't be guessed online
+const codeMACSize = 10
+
+var codeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// what the public endpoint confirms, nothing in it names the employee
+type Verification struct {
+	Code        string         `json:"code"`
+	IssuedAt    time.Time      `json:"issued_at"`
+	PeriodStart time.Time      `json:"period_start"`
+	PeriodEnd   time.Time      `json:"period_end"`
+	Currency    money.Currency `json:"currency"`
+	// set when the take-home pay is disclosed as an amount
+	TakeHomePay *money.Decimal `json:"take_home_pay,omitempty"`
+	// otherwise the hex sha256 of "<code>:<currency> <amount>", e.g. "ABCD-...:IDR 1000000.00"
+	TakeHomeHash string `json:"take_home_sha256,omitempty"`
+	// when the verifier passed the figure they were shown
+	TakeHomeMatches *bool `json:"take_home_matches,omitempty"`
+	// false once the payroll was reopened or rerun, the employee has a newer payslip
+	Current bool `json:"current"`
+}
+
+// the payslip id followed by an HMAC over what the payslip states, signed with the first key
+func (e *emplImplementation) PayslipCode(p model.Payslip) (string, error) {
+	v := e.payroll.Verification
+	if v == nil {
+		return "", ErrVerificationDisabled
+	}
+
+	raw := binary.AppendUvarint(nil, uint64(p.ID))
+	raw = append(raw, codeMAC(p, v.Keys[0])...)
+
+	return groupCode(codeEncoding.EncodeToString(raw)), nil
+}
+
+// public, takeHome is optional and only compared
+func (e *emplImplementation) VerifyPayslip(code, takeHome string, ctx context.Context) (Verification, error) {
+	v := e.payroll.Verification
+	if v == nil {
+		return Verification{}, ErrVerificationDisabled
+	}
+
+	var claimed money.Decimal
+	if takeHome != "" {
+		var err error
+		if claimed, err = money.Parse(takeHome); err != nil {
+			return Verification{}, ErrInvalidTakeHome
+		}
+	}
+
+	// dashes, spaces and case are forgiven, people type these from paper
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	raw, err := codeEncoding.DecodeString(normalized)
+	if err != nil {
+		return Verification{}, ErrInvalidCode
+	}
+	id, n := binary.Uvarint(raw)
+	if n <= 0 || len(raw)-n != codeMACSize || id == 0 {
+		return Verification{}, ErrInvalidCode
+	}
+
+	db, err := hlp.GetDB(ctx, app.PQ)
+	if err != nil {
+		return Verification{}, err
+	}
+
+	var p model.Payslip
+	var payroll model.Payroll
+	var approved bool
+	query := `SELECT p.id, p.payroll_id, p.revision, p.user_id, p.period_start, p.period_end, p.currency, p.take_home_pay, p.created_at,
+                     r.revision, r.status,
+                     EXISTS (SELECT 1 FROM payroll_approval a WHERE a.payroll_id = p.payroll_id AND a.revision = p.revision AND a.decision = 'APPROVED')
+              FROM payslip p JOIN payroll r ON r.id = p.payroll_id WHERE p.id = $1`
+	err = db.QueryRowContext(ctx, query, int64(id)).Scan(
+		&p.ID,
+		&p.PayrollID,
+		&p.Revision,
+		&p.UserID,
+		&p.PeriodStart,
+		&p.PeriodEnd,
+		&p.Currency,
+		&p.TakeHomePay,
+		&p.CreatedAt,
+		&payroll.Revision,
+		&payroll.Status,
+		&approved,
+	)
+	if err == sql.ErrNoRows {
+		return Verification{}, ErrInvalidCode
+	}
+	if err != nil {
+		return Verification{}, errors.New("failed to query payslip")
+	}
+
+	if !validMAC(p, raw[n:], v.Keys) {
+		return Verification{}, ErrInvalidCode
+	}
+
+	// runs approved before approvals were recorded have no approval row but an approved status
+	current := p.Revision == payroll.Revision && payroll.IsApproved()
+	if !current && !approved {
+		return Verification{}, ErrInvalidCode
+	}
+
+	normalized = groupCode(normalized)
+	result := Verification{
+		Code:        normalized,
+		IssuedAt:    p.CreatedAt,
+		PeriodStart: p.PeriodStart,
+		PeriodEnd:   p.PeriodEnd,
+		Currency:    p.Currency,
+		Current:     current,
+	}
+	if v.Disclose == config.DiscloseAmount {
+		result.TakeHomePay = &p.TakeHomePay
+	} else {
+		sum := sha256.Sum256([]byte(normalized + ":" + string(p.Currency) + " " + p.TakeHomePay.String()))
+		result.TakeHomeHash = hex.EncodeToString(sum[:])
+	}
+	if takeHome != "" {
+		matches := claimed.Cmp(p.TakeHomePay) == 0
+		result.TakeHomeMatches = &matches
+	}
+
+	if err := e.recordVerification(p, v.Disclose, db, ctx); err != nil {
+		return Verification{}, err
+	}
+
+	return result, nil
+}
+
+// binds the code to the figures, a payslip edited in the database no longer verifies
+func codeMAC(p model.Payslip, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "payslip:%d:%d:%d:%d:%s:%s:%s", p.ID, p.PayrollID, p.Revision, p.UserID, p.Currency, p.TakeHomePay, p.CreatedAt.UTC().Format(time.RFC3339Nano))
+	return mac.Sum(nil)[:codeMACSize]
+}
+
+// every configured key is tried so codes survive a rotation
+func validMAC(p model.Payslip, mac []byte, keys [][]byte) bool {
+	valid := false
+	for _, key := range keys {
+		if hmac.Equal(mac, codeMAC(p, key)) {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// groups of four, easier to read out over the phone
+func groupCode(s string) string {
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// nobody is logged in, the entry carries the caller's address from the request
+func (e *emplImplementation) recordVerification(p model.Payslip, disclosed string, db *sql.DB, ctx context.Context) error {
+	if e.reads == nil {
+		return nil
+	}
+
+	err := e.reads.Record(db, audit.Entry{
+		EventType: audit.EventPayslipVerified,
+		RecordID:  p.ID,
+		NewData: audit.VerifyRead{
+			PayslipID: p.ID,
+			PayrollID: p.PayrollID,
+			Revision:  p.Revision,
+			UserID:    p.UserID,
+			Disclosed: disclosed,
+		},
+	}, ctx)
+	if err != nil {
+		return errors.New("failed to audit payslip verification")
+	}
+
+	return nil
+}